@@ -0,0 +1,852 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// adminServiceName is the full gRPC name of the admin service.
+	adminServiceName = "dnsseeder.Admin"
+
+	// jsonCodecName is the gRPC content subtype used by the admin
+	// service.
+	jsonCodecName = "json"
+
+	// adminAuthorizationKey is the metadata key carrying the admin and
+	// gossip tokens.
+	adminAuthorizationKey = "authorization"
+
+	// backupChunkSize is the size of the pieces a peers database snapshot
+	// is streamed in, well below gRPC's default message size limit.
+	backupChunkSize = 256 * 1024
+
+	// maxRestoreSize is the largest peers database snapshot RestoreDB
+	// accepts.
+	maxRestoreSize = 512 * 1024 * 1024
+)
+
+// jsonCodec lets the admin and gossip services exchange plain JSON messages over gRPC,
+// so its request and response types don't need generated protobuf code.
+// Clients must select it with grpc.CallContentSubtype(jsonCodecName).
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return jsonCodecName
+}
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// BanAddressRequest bans an IP address or CIDR network. A zero
+// DurationSeconds bans it permanently.
+type BanAddressRequest struct {
+	Address         string
+	Reason          string
+	DurationSeconds int64
+}
+
+// BanAddressResponse is the response to BanAddressRequest
+type BanAddressResponse struct {
+	RemovedNodes int
+}
+
+// UnbanAddressRequest lifts the ban on an IP address or CIDR network
+type UnbanAddressRequest struct {
+	Address string
+}
+
+// UnbanAddressResponse is the response to UnbanAddressRequest
+type UnbanAddressResponse struct {
+	WasBanned bool
+}
+
+// ForceCrawlRequest queues an address, optionally with a port, to be
+// crawled immediately
+type ForceCrawlRequest struct {
+	Address string
+}
+
+// ForceCrawlResponse is the response to ForceCrawlRequest
+type ForceCrawlResponse struct{}
+
+// CrawlNowRequest connects to an address, optionally with a port, of a
+// network right away and reports what the peer sent. Nothing is recorded
+// in the peers database. An empty Network selects the primary network.
+type CrawlNowRequest struct {
+	Network string
+	Address string
+}
+
+// CrawlNowResponse is the response to CrawlNowRequest. Tips holds the
+// hashes of the blocks the peer announced while connected.
+type CrawlNowResponse struct {
+	Network               string
+	Address               string
+	UserAgent             string
+	ProtocolVersion       uint32
+	Services              uint64
+	HandshakeMilliseconds int64
+	AddressesMilliseconds int64
+	AddressesReceived     int
+	Tips                  []string
+}
+
+// InjectPeerRequest adds an address, optionally with a port, to the peers of
+// a network and queues it to be crawled immediately. With Good set, it is
+// also served as a good peer for ExpirySeconds, whatever its crawl state.
+// An empty Network selects the primary network.
+type InjectPeerRequest struct {
+	Network       string
+	Address       string
+	Good          bool
+	ExpirySeconds int64
+}
+
+// InjectPeerResponse is the response to InjectPeerRequest
+type InjectPeerResponse struct{}
+
+// SetCrawlPausedRequest pauses or resumes all outbound crawling. Peers keep
+// being served from the existing pool while crawling is paused.
+type SetCrawlPausedRequest struct {
+	Paused bool
+}
+
+// SetCrawlPausedResponse is the response to SetCrawlPausedRequest
+type SetCrawlPausedResponse struct{}
+
+// SetMaintenanceRequest enables or disables maintenance mode, in which only
+// the always-served addresses are served.
+type SetMaintenanceRequest struct {
+	Enabled bool
+}
+
+// SetMaintenanceResponse is the response to SetMaintenanceRequest
+type SetMaintenanceResponse struct{}
+
+// SetStaticAnswersRequest makes every answer consist of the given IP
+// addresses instead of the crawled peers. An empty list serves the crawled
+// peers again.
+type SetStaticAnswersRequest struct {
+	Addresses []string
+}
+
+// SetStaticAnswersResponse is the response to SetStaticAnswersRequest
+type SetStaticAnswersResponse struct{}
+
+// SetLogLevelRequest changes the seeder's log levels. Level takes the same
+// form as the --loglevel flag.
+type SetLogLevelRequest struct {
+	Level string
+}
+
+// SetLogLevelResponse is the response to SetLogLevelRequest
+type SetLogLevelResponse struct{}
+
+// FlushDBRequest removes all known nodes
+type FlushDBRequest struct{}
+
+// FlushDBResponse is the response to FlushDBRequest
+type FlushDBResponse struct {
+	RemovedNodes int
+}
+
+// ReloadConfigRequest reloads the configuration, as on SIGHUP
+type ReloadConfigRequest struct{}
+
+// ReloadConfigResponse is the response to ReloadConfigRequest
+type ReloadConfigResponse struct{}
+
+// RegisterNetworkRequest defines a custom network while the seeder is
+// running, and starts crawling it from Peers and Seeder and serving it on
+// Zones. It is served until the seeder stops.
+type RegisterNetworkRequest struct {
+	Network CustomNetworkConfig
+	Zones   []ZoneConfig
+	Peers   []string
+	Seeder  string
+}
+
+// RegisterNetworkResponse is the response to RegisterNetworkRequest
+type RegisterNetworkResponse struct {
+	DefaultPort int
+}
+
+// BackupDBRequest streams a consistent snapshot of the peers database of a
+// network, in the format of the peers file, as BackupDBChunk messages. An
+// empty Network selects the primary network.
+type BackupDBRequest struct {
+	Network string
+}
+
+// BackupDBChunk is a piece of a peers database snapshot
+type BackupDBChunk struct {
+	Data []byte
+}
+
+// RestoreDBChunk is a piece of a peers database snapshot streamed to
+// RestoreDB. The network is taken from the first chunk; an empty Network
+// selects the primary network.
+type RestoreDBChunk struct {
+	Network string
+	Data    []byte
+}
+
+// RestoreDBResponse is the response to RestoreDB, which replaces the peers
+// database of a network with a snapshot streamed by BackupDB.
+type RestoreDBResponse struct {
+	Network       string
+	RestoredNodes int
+	RestoredBans  int
+}
+
+// AdminServer is the server API of the admin service
+type AdminServer interface {
+	BanAddress(context.Context, *BanAddressRequest) (*BanAddressResponse, error)
+	UnbanAddress(context.Context, *UnbanAddressRequest) (*UnbanAddressResponse, error)
+	ForceCrawl(context.Context, *ForceCrawlRequest) (*ForceCrawlResponse, error)
+	CrawlNow(context.Context, *CrawlNowRequest) (*CrawlNowResponse, error)
+	InjectPeer(context.Context, *InjectPeerRequest) (*InjectPeerResponse, error)
+	SetCrawlPaused(context.Context, *SetCrawlPausedRequest) (*SetCrawlPausedResponse, error)
+	SetMaintenance(context.Context, *SetMaintenanceRequest) (*SetMaintenanceResponse, error)
+	SetStaticAnswers(context.Context, *SetStaticAnswersRequest) (*SetStaticAnswersResponse, error)
+	SetLogLevel(context.Context, *SetLogLevelRequest) (*SetLogLevelResponse, error)
+	FlushDB(context.Context, *FlushDBRequest) (*FlushDBResponse, error)
+	ReloadConfig(context.Context, *ReloadConfigRequest) (*ReloadConfigResponse, error)
+	RegisterNetwork(context.Context, *RegisterNetworkRequest) (*RegisterNetworkResponse, error)
+	BackupDB(*BackupDBRequest, grpc.ServerStream) error
+	RestoreDB(grpc.ServerStream) error
+}
+
+// jsonMethod builds the gRPC method description of a single unary call of
+// a service exchanging JSON messages.
+func jsonMethod(serviceName, name string, newRequest func() interface{},
+	call func(srv interface{}, ctx context.Context, request interface{}) (interface{}, error)) grpc.MethodDesc {
+
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error,
+			interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+
+			request := newRequest()
+			if err := dec(request); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return call(srv, ctx, request)
+			}
+			info := &grpc.UnaryServerInfo{
+				Server:     srv,
+				FullMethod: "/" + serviceName + "/" + name,
+			}
+			return interceptor(ctx, request, info, func(ctx context.Context, request interface{}) (interface{}, error) {
+				return call(srv, ctx, request)
+			})
+		},
+	}
+}
+
+// adminMethod builds the gRPC method description of a single admin call.
+func adminMethod(name string, newRequest func() interface{},
+	call func(AdminServer, context.Context, interface{}) (interface{}, error)) grpc.MethodDesc {
+
+	return jsonMethod(adminServiceName, name, newRequest,
+		func(srv interface{}, ctx context.Context, request interface{}) (interface{}, error) {
+			return call(srv.(AdminServer), ctx, request)
+		})
+}
+
+// backupDBStreamDesc describes BackupDB, which receives a BackupDBRequest
+// and sends BackupDBChunk messages.
+var backupDBStreamDesc = grpc.StreamDesc{
+	StreamName: "BackupDB",
+	Handler: func(srv interface{}, stream grpc.ServerStream) error {
+		request := &BackupDBRequest{}
+		if err := stream.RecvMsg(request); err != nil {
+			return err
+		}
+		return srv.(AdminServer).BackupDB(request, stream)
+	},
+	ServerStreams: true,
+}
+
+// restoreDBStreamDesc describes RestoreDB, which receives RestoreDBChunk
+// messages and sends a single RestoreDBResponse.
+var restoreDBStreamDesc = grpc.StreamDesc{
+	StreamName: "RestoreDB",
+	Handler: func(srv interface{}, stream grpc.ServerStream) error {
+		return srv.(AdminServer).RestoreDB(stream)
+	},
+	ClientStreams: true,
+}
+
+var adminServiceDesc = grpc.ServiceDesc{
+	ServiceName: adminServiceName,
+	HandlerType: (*AdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		adminMethod("BanAddress", func() interface{} { return &BanAddressRequest{} },
+			func(s AdminServer, ctx context.Context, r interface{}) (interface{}, error) {
+				return s.BanAddress(ctx, r.(*BanAddressRequest))
+			}),
+		adminMethod("UnbanAddress", func() interface{} { return &UnbanAddressRequest{} },
+			func(s AdminServer, ctx context.Context, r interface{}) (interface{}, error) {
+				return s.UnbanAddress(ctx, r.(*UnbanAddressRequest))
+			}),
+		adminMethod("ForceCrawl", func() interface{} { return &ForceCrawlRequest{} },
+			func(s AdminServer, ctx context.Context, r interface{}) (interface{}, error) {
+				return s.ForceCrawl(ctx, r.(*ForceCrawlRequest))
+			}),
+		adminMethod("CrawlNow", func() interface{} { return &CrawlNowRequest{} },
+			func(s AdminServer, ctx context.Context, r interface{}) (interface{}, error) {
+				return s.CrawlNow(ctx, r.(*CrawlNowRequest))
+			}),
+		adminMethod("InjectPeer", func() interface{} { return &InjectPeerRequest{} },
+			func(s AdminServer, ctx context.Context, r interface{}) (interface{}, error) {
+				return s.InjectPeer(ctx, r.(*InjectPeerRequest))
+			}),
+		adminMethod("SetCrawlPaused", func() interface{} { return &SetCrawlPausedRequest{} },
+			func(s AdminServer, ctx context.Context, r interface{}) (interface{}, error) {
+				return s.SetCrawlPaused(ctx, r.(*SetCrawlPausedRequest))
+			}),
+		adminMethod("SetMaintenance", func() interface{} { return &SetMaintenanceRequest{} },
+			func(s AdminServer, ctx context.Context, r interface{}) (interface{}, error) {
+				return s.SetMaintenance(ctx, r.(*SetMaintenanceRequest))
+			}),
+		adminMethod("SetStaticAnswers", func() interface{} { return &SetStaticAnswersRequest{} },
+			func(s AdminServer, ctx context.Context, r interface{}) (interface{}, error) {
+				return s.SetStaticAnswers(ctx, r.(*SetStaticAnswersRequest))
+			}),
+		adminMethod("SetLogLevel", func() interface{} { return &SetLogLevelRequest{} },
+			func(s AdminServer, ctx context.Context, r interface{}) (interface{}, error) {
+				return s.SetLogLevel(ctx, r.(*SetLogLevelRequest))
+			}),
+		adminMethod("FlushDB", func() interface{} { return &FlushDBRequest{} },
+			func(s AdminServer, ctx context.Context, r interface{}) (interface{}, error) {
+				return s.FlushDB(ctx, r.(*FlushDBRequest))
+			}),
+		adminMethod("ReloadConfig", func() interface{} { return &ReloadConfigRequest{} },
+			func(s AdminServer, ctx context.Context, r interface{}) (interface{}, error) {
+				return s.ReloadConfig(ctx, r.(*ReloadConfigRequest))
+			}),
+		adminMethod("RegisterNetwork", func() interface{} { return &RegisterNetworkRequest{} },
+			func(s AdminServer, ctx context.Context, r interface{}) (interface{}, error) {
+				return s.RegisterNetwork(ctx, r.(*RegisterNetworkRequest))
+			}),
+	},
+	Streams:  []grpc.StreamDesc{backupDBStreamDesc, restoreDBStreamDesc},
+	Metadata: "admin.go",
+}
+
+// tokenAuthInterceptor rejects calls to the given services that don't carry
+// the token of the service they are made to. Calls to other services pass
+// through untouched.
+func tokenAuthInterceptor(tokens map[string]string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+
+		err := checkToken(ctx, tokens, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// tokenAuthStreamInterceptor is the counterpart of tokenAuthInterceptor for
+// streaming calls.
+func tokenAuthStreamInterceptor(tokens map[string]string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler) error {
+
+		err := checkToken(stream.Context(), tokens, info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, stream)
+	}
+}
+
+// checkToken returns an Unauthenticated error if the call to fullMethod
+// with the given context doesn't carry the token of its service.
+func checkToken(ctx context.Context, tokens map[string]string, fullMethod string) error {
+	serviceName := strings.SplitN(strings.TrimPrefix(fullMethod, "/"), "/", 2)[0]
+	token, ok := tokens[serviceName]
+	if !ok {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(adminAuthorizationKey)
+	expected := []byte("Bearer " + token)
+	if len(values) != 1 || subtle.ConstantTimeCompare([]byte(values[0]), expected) != 1 {
+		return status.Error(codes.Unauthenticated, "invalid token")
+	}
+	return nil
+}
+
+type adminServer struct {
+	amgr *Manager
+}
+
+func (s *adminServer) BanAddress(_ context.Context, req *BanAddressRequest) (*BanAddressResponse, error) {
+	ipNet, err := parseIPNet(req.Address)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if req.DurationSeconds < 0 {
+		return nil, status.Error(codes.InvalidArgument, "negative ban duration")
+	}
+
+	var removed int
+	for _, amgr := range networkManagers(s.amgr) {
+		removed += amgr.Ban(ipNet, req.Reason, time.Duration(req.DurationSeconds)*time.Second)
+	}
+	return &BanAddressResponse{RemovedNodes: removed}, nil
+}
+
+func (s *adminServer) UnbanAddress(_ context.Context, req *UnbanAddressRequest) (*UnbanAddressResponse, error) {
+	ipNet, err := parseIPNet(req.Address)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	var wasBanned bool
+	for _, amgr := range networkManagers(s.amgr) {
+		if amgr.Unban(ipNet) {
+			wasBanned = true
+		}
+	}
+	return &UnbanAddressResponse{WasBanned: wasBanned}, nil
+}
+
+func (s *adminServer) ForceCrawl(_ context.Context, req *ForceCrawlRequest) (*ForceCrawlResponse, error) {
+	addr, err := parsePeerAddress(req.Address, peersDefaultPort)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	err = s.amgr.ForceCrawl(addr)
+	if err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return &ForceCrawlResponse{}, nil
+}
+
+func (s *adminServer) CrawlNow(ctx context.Context, req *CrawlNowRequest) (*CrawlNowResponse, error) {
+	network := findNetwork(req.Network)
+	if network == nil {
+		return nil, status.Errorf(codes.NotFound, "network %s is not served", req.Network)
+	}
+	addr, err := parsePeerAddress(req.Address, network.defaultPort)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	netAdapter, err := network.crawlAdapter()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	response, err := probePeer(ctx, netAdapter, addr)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	response.Network = network.name()
+	rpcLog.Infof("Crawled %s on request", response.Address)
+	return response, nil
+}
+
+func (s *adminServer) InjectPeer(_ context.Context, req *InjectPeerRequest) (*InjectPeerResponse, error) {
+	amgr := s.amgr
+	if req.Network != "" {
+		amgr = networkManager(req.Network, s.amgr)
+		if amgr == nil {
+			return nil, status.Errorf(codes.NotFound, "network %s is not served", req.Network)
+		}
+	}
+	addr, err := parsePeerAddress(req.Address, int(amgr.defaultPort))
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if !req.Good {
+		err = amgr.ForceCrawl(addr)
+	} else {
+		if req.ExpirySeconds <= 0 {
+			return nil, status.Error(codes.InvalidArgument, "good peers need a positive expiry")
+		}
+		err = amgr.Inject(addr, time.Now().Add(time.Duration(req.ExpirySeconds)*time.Second))
+	}
+	if err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+
+	rpcLog.Infof("Injected peer %s", addr.TCPAddress())
+	return &InjectPeerResponse{}, nil
+}
+
+func (s *adminServer) SetCrawlPaused(_ context.Context, req *SetCrawlPausedRequest) (*SetCrawlPausedResponse, error) {
+	setCrawlPaused(req.Paused)
+	rpcLog.Infof("Crawl paused: %t", req.Paused)
+	return &SetCrawlPausedResponse{}, nil
+}
+
+func (s *adminServer) SetMaintenance(_ context.Context, req *SetMaintenanceRequest) (*SetMaintenanceResponse, error) {
+	setMaintenance(req.Enabled)
+	rpcLog.Infof("Maintenance mode: %t", req.Enabled)
+	return &SetMaintenanceResponse{}, nil
+}
+
+func (s *adminServer) SetStaticAnswers(_ context.Context, req *SetStaticAnswersRequest) (*SetStaticAnswersResponse, error) {
+	static, err := parseStaticAnswers(req.Addresses)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	setStaticAnswers(static)
+	if len(static) == 0 {
+		rpcLog.Infof("Serving the crawled peers")
+	} else {
+		rpcLog.Infof("Serving the static answers %s", strings.Join(req.Addresses, ","))
+	}
+	return &SetStaticAnswersResponse{}, nil
+}
+
+func (s *adminServer) SetLogLevel(_ context.Context, req *SetLogLevelRequest) (*SetLogLevelResponse, error) {
+	err := setLogLevels(req.Level)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	rpcLog.Infof("Log level set to %s", req.Level)
+	return &SetLogLevelResponse{}, nil
+}
+
+func (s *adminServer) FlushDB(_ context.Context, _ *FlushDBRequest) (*FlushDBResponse, error) {
+	var removed int
+	for _, amgr := range networkManagers(s.amgr) {
+		removed += amgr.Flush()
+	}
+	return &FlushDBResponse{RemovedNodes: removed}, nil
+}
+
+func (s *adminServer) ReloadConfig(_ context.Context, _ *ReloadConfigRequest) (*ReloadConfigResponse, error) {
+	err := reloadConfig(s.amgr)
+	if err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return &ReloadConfigResponse{}, nil
+}
+
+func (s *adminServer) RegisterNetwork(_ context.Context, req *RegisterNetworkRequest) (*RegisterNetworkResponse, error) {
+	cfg := ActiveConfig()
+	if cfg == nil || len(servedNetworks()) == 0 {
+		return nil, status.Error(codes.FailedPrecondition, "networks can only be registered while running")
+	}
+	_, err := req.Network.params()
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	network, err := registerNetwork(cfg, &req.Network, req.Zones, req.Peers, req.Seeder)
+	if err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	rpcLog.Infof("Registered network %s", network.name())
+	return &RegisterNetworkResponse{DefaultPort: network.defaultPort}, nil
+}
+
+func (s *adminServer) BackupDB(req *BackupDBRequest, stream grpc.ServerStream) error {
+	amgr := s.amgr
+	if req.Network != "" {
+		amgr = networkManager(req.Network, s.amgr)
+		if amgr == nil {
+			return status.Errorf(codes.NotFound, "network %s is not served", req.Network)
+		}
+	}
+
+	data, err := amgr.Backup()
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	for len(data) > 0 {
+		size := len(data)
+		if size > backupChunkSize {
+			size = backupChunkSize
+		}
+		err = stream.SendMsg(&BackupDBChunk{Data: data[:size]})
+		if err != nil {
+			return err
+		}
+		data = data[size:]
+	}
+
+	rpcLog.Infof("Backed up the peers database of %s", amgr.netParams.Name)
+	return nil
+}
+
+func (s *adminServer) RestoreDB(stream grpc.ServerStream) error {
+	var network string
+	var data []byte
+	for first := true; ; first = false {
+		chunk := &RestoreDBChunk{}
+		err := stream.RecvMsg(chunk)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if first {
+			network = chunk.Network
+		}
+		if len(data)+len(chunk.Data) > maxRestoreSize {
+			return status.Errorf(codes.ResourceExhausted, "snapshot is larger than %d bytes", maxRestoreSize)
+		}
+		data = append(data, chunk.Data...)
+	}
+
+	amgr := s.amgr
+	if network != "" {
+		amgr = networkManager(network, s.amgr)
+		if amgr == nil {
+			return status.Errorf(codes.NotFound, "network %s is not served", network)
+		}
+	}
+	restored, bans, err := amgr.Restore(data)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	rpcLog.Infof("Restored %d nodes and %d bans of %s", restored, bans, amgr.netParams.Name)
+	return stream.SendMsg(&RestoreDBResponse{Network: amgr.netParams.Name, RestoredNodes: restored,
+		RestoredBans: bans})
+}
+
+// parsePeerAddress parses an IP address, optionally followed by a port.
+// defaultPort is used when no port is given.
+func parsePeerAddress(address string, defaultPort int) (*appmessage.NetAddress, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+		portStr = strconv.Itoa(defaultPort)
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, errors.Errorf("invalid IP address: %s", host)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, errors.Errorf("invalid port: %s", portStr)
+	}
+
+	return appmessage.NewNetAddressIPPort(ip, uint16(port)), nil
+}
+
+// jsonClient calls a service exchanging JSON messages, authenticating with
+// a token
+type jsonClient struct {
+	conn        *grpc.ClientConn
+	serviceName string
+	token       string
+}
+
+func newJSONClient(address, serviceName, token string) (*jsonClient, error) {
+	conn, err := grpc.Dial(address,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype(jsonCodecName)))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &jsonClient{conn: conn, serviceName: serviceName, token: token}, nil
+}
+
+// Close closes the connection to the seeder
+func (c *jsonClient) Close() error {
+	return c.conn.Close()
+}
+
+func (c *jsonClient) invoke(ctx context.Context, method string, request, response interface{}) error {
+	ctx = metadata.AppendToOutgoingContext(ctx, adminAuthorizationKey, "Bearer "+c.token)
+	return c.conn.Invoke(ctx, "/"+c.serviceName+"/"+method, request, response)
+}
+
+func (c *jsonClient) newStream(ctx context.Context, desc *grpc.StreamDesc) (grpc.ClientStream, error) {
+	ctx = metadata.AppendToOutgoingContext(ctx, adminAuthorizationKey, "Bearer "+c.token)
+	return c.conn.NewStream(ctx, desc, "/"+c.serviceName+"/"+desc.StreamName)
+}
+
+// AdminClient calls the admin service of a running seeder
+type AdminClient struct {
+	*jsonClient
+}
+
+// NewAdminClient connects to the gRPC server at the given address, using the
+// given admin token
+func NewAdminClient(address, token string) (*AdminClient, error) {
+	client, err := newJSONClient(address, adminServiceName, token)
+	if err != nil {
+		return nil, err
+	}
+	return &AdminClient{client}, nil
+}
+
+// BanAddress bans an IP address or CIDR network
+func (c *AdminClient) BanAddress(ctx context.Context, req *BanAddressRequest) (*BanAddressResponse, error) {
+	response := &BanAddressResponse{}
+	return response, c.invoke(ctx, "BanAddress", req, response)
+}
+
+// UnbanAddress lifts the ban on an IP address or CIDR network
+func (c *AdminClient) UnbanAddress(ctx context.Context, req *UnbanAddressRequest) (*UnbanAddressResponse, error) {
+	response := &UnbanAddressResponse{}
+	return response, c.invoke(ctx, "UnbanAddress", req, response)
+}
+
+// ForceCrawl queues an address to be crawled immediately
+func (c *AdminClient) ForceCrawl(ctx context.Context, req *ForceCrawlRequest) (*ForceCrawlResponse, error) {
+	response := &ForceCrawlResponse{}
+	return response, c.invoke(ctx, "ForceCrawl", req, response)
+}
+
+// CrawlNow connects to an address right away and reports what the peer sent
+func (c *AdminClient) CrawlNow(ctx context.Context, req *CrawlNowRequest) (*CrawlNowResponse, error) {
+	response := &CrawlNowResponse{}
+	return response, c.invoke(ctx, "CrawlNow", req, response)
+}
+
+// InjectPeer adds an address to the peers of a network
+func (c *AdminClient) InjectPeer(ctx context.Context, req *InjectPeerRequest) (*InjectPeerResponse, error) {
+	response := &InjectPeerResponse{}
+	return response, c.invoke(ctx, "InjectPeer", req, response)
+}
+
+// SetCrawlPaused pauses or resumes crawling
+func (c *AdminClient) SetCrawlPaused(ctx context.Context, req *SetCrawlPausedRequest) (*SetCrawlPausedResponse, error) {
+	response := &SetCrawlPausedResponse{}
+	return response, c.invoke(ctx, "SetCrawlPaused", req, response)
+}
+
+// SetMaintenance enables or disables maintenance mode
+func (c *AdminClient) SetMaintenance(ctx context.Context, req *SetMaintenanceRequest) (*SetMaintenanceResponse, error) {
+	response := &SetMaintenanceResponse{}
+	return response, c.invoke(ctx, "SetMaintenance", req, response)
+}
+
+// SetStaticAnswers makes every answer consist of fixed addresses
+func (c *AdminClient) SetStaticAnswers(ctx context.Context, req *SetStaticAnswersRequest) (*SetStaticAnswersResponse, error) {
+	response := &SetStaticAnswersResponse{}
+	return response, c.invoke(ctx, "SetStaticAnswers", req, response)
+}
+
+// SetLogLevel changes the seeder's log level
+func (c *AdminClient) SetLogLevel(ctx context.Context, req *SetLogLevelRequest) (*SetLogLevelResponse, error) {
+	response := &SetLogLevelResponse{}
+	return response, c.invoke(ctx, "SetLogLevel", req, response)
+}
+
+// FlushDB removes all known nodes
+func (c *AdminClient) FlushDB(ctx context.Context, req *FlushDBRequest) (*FlushDBResponse, error) {
+	response := &FlushDBResponse{}
+	return response, c.invoke(ctx, "FlushDB", req, response)
+}
+
+// ReloadConfig makes the seeder reload its configuration
+func (c *AdminClient) ReloadConfig(ctx context.Context, req *ReloadConfigRequest) (*ReloadConfigResponse, error) {
+	response := &ReloadConfigResponse{}
+	return response, c.invoke(ctx, "ReloadConfig", req, response)
+}
+
+// RegisterNetwork defines a custom network while the seeder is running
+func (c *AdminClient) RegisterNetwork(ctx context.Context, req *RegisterNetworkRequest) (*RegisterNetworkResponse, error) {
+	response := &RegisterNetworkResponse{}
+	return response, c.invoke(ctx, "RegisterNetwork", req, response)
+}
+
+// BackupDB writes a snapshot of the peers database of a network to w
+func (c *AdminClient) BackupDB(ctx context.Context, req *BackupDBRequest, w io.Writer) error {
+	stream, err := c.newStream(ctx, &backupDBStreamDesc)
+	if err != nil {
+		return err
+	}
+	err = stream.SendMsg(req)
+	if err != nil {
+		return err
+	}
+	err = stream.CloseSend()
+	if err != nil {
+		return err
+	}
+	for {
+		chunk := &BackupDBChunk{}
+		err = stream.RecvMsg(chunk)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(chunk.Data)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+}
+
+// RestoreDB replaces the peers database of a network with the snapshot read
+// from r
+func (c *AdminClient) RestoreDB(ctx context.Context, network string, r io.Reader) (*RestoreDBResponse, error) {
+	stream, err := c.newStream(ctx, &restoreDBStreamDesc)
+	if err != nil {
+		return nil, err
+	}
+	buffer := make([]byte, backupChunkSize)
+	for first := true; ; first = false {
+		n, err := io.ReadFull(r, buffer)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, errors.WithStack(err)
+		}
+		if n > 0 || first {
+			// The seeder gave up on the call if sending fails with
+			// io.EOF, and the reason is returned by RecvMsg.
+			sendErr := stream.SendMsg(&RestoreDBChunk{Network: network, Data: buffer[:n]})
+			if sendErr == io.EOF {
+				break
+			}
+			if sendErr != nil {
+				return nil, sendErr
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	err = stream.CloseSend()
+	if err != nil {
+		return nil, err
+	}
+	response := &RestoreDBResponse{}
+	return response, stream.RecvMsg(response)
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/karlsen-network/karlsend/domain/dagconfig"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAdminService(t *testing.T) {
+	m := newTestManager(t, &dagconfig.MainnetParams, 1313)
+	for _, ip := range []string{"203.105.20.21", "203.105.20.22", "198.51.100.1"} {
+		m.nodes[ip] = &Node{Addr: appmessage.NewNetAddressIPPort(net.ParseIP(ip), 1313)}
+	}
+
+	host := "localhost:3738"
+	grpcServer := NewGRPCServer(m, "secret", "", "")
+	err := grpcServer.Start(host)
+	if err != nil {
+		t.Fatalf("Failed to start gRPC server: %s", err)
+	}
+	defer grpcServer.Stop()
+
+	unauthenticated, err := NewAdminClient(host, "wrong")
+	if err != nil {
+		t.Fatalf("NewAdminClient: %s", err)
+	}
+	defer unauthenticated.Close()
+	_, err = unauthenticated.FlushDB(context.Background(), &FlushDBRequest{})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated, got %v", err)
+	}
+
+	client, err := NewAdminClient(host, "secret")
+	if err != nil {
+		t.Fatalf("NewAdminClient: %s", err)
+	}
+	defer client.Close()
+
+	banResponse, err := client.BanAddress(context.Background(),
+		&BanAddressRequest{Address: "203.105.20.0/24", Reason: "test"})
+	if err != nil {
+		t.Fatalf("BanAddress: %s", err)
+	}
+	if banResponse.RemovedNodes != 2 {
+		t.Errorf("expected 2 removed nodes, got %d", banResponse.RemovedNodes)
+	}
+	if !m.bans.IsBanned(net.ParseIP("203.105.20.99")) {
+		t.Errorf("expected 203.105.20.99 to be banned")
+	}
+
+	err = m.ForceCrawl(appmessage.NewNetAddressIPPort(net.ParseIP("203.105.20.1"), 1313))
+	if err == nil {
+		t.Errorf("expected ForceCrawl of a banned address to fail")
+	}
+
+	unbanResponse, err := client.UnbanAddress(context.Background(),
+		&UnbanAddressRequest{Address: "203.105.20.0/24"})
+	if err != nil {
+		t.Fatalf("UnbanAddress: %s", err)
+	}
+	if !unbanResponse.WasBanned {
+		t.Errorf("expected 203.105.20.0/24 to have been banned")
+	}
+
+	_, err = client.ForceCrawl(context.Background(), &ForceCrawlRequest{Address: "192.0.2.1:1313"})
+	if err != nil {
+		t.Fatalf("ForceCrawl: %s", err)
+	}
+	if len(m.crawlQueue) != 1 || m.crawlQueue[0].IP.String() != "192.0.2.1" {
+		t.Errorf("expected 192.0.2.1 to be queued, got %v", m.crawlQueue)
+	}
+
+	_, err = client.InjectPeer(context.Background(), &InjectPeerRequest{Address: "192.0.2.2", Good: true})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for a good peer without expiry, got %v", err)
+	}
+	_, err = client.InjectPeer(context.Background(),
+		&InjectPeerRequest{Address: "192.0.2.2", Good: true, ExpirySeconds: 60})
+	if err != nil {
+		t.Fatalf("InjectPeer: %s", err)
+	}
+	node, ok := m.Node(net.ParseIP("192.0.2.2"))
+	if !ok || !node.isGood(time.Now()) || node.isGood(time.Now().Add(2*time.Minute)) {
+		t.Errorf("expected 192.0.2.2 to be good for a minute, got %+v", node)
+	}
+
+	_, err = client.CrawlNow(context.Background(), &CrawlNowRequest{Network: "othernet", Address: "192.0.2.3"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound for an unknown network, got %v", err)
+	}
+
+	_, err = client.SetCrawlPaused(context.Background(), &SetCrawlPausedRequest{Paused: true})
+	if err != nil {
+		t.Fatalf("SetCrawlPaused: %s", err)
+	}
+	if !isCrawlPaused() {
+		t.Errorf("expected crawling to be paused")
+	}
+	setCrawlPaused(false)
+}
+
+func TestAdminBackupRestore(t *testing.T) {
+	newManager := func() *Manager { return newTestManager(t, &dagconfig.MainnetParams, 0) }
+	source := newManager()
+	for i := 1; i <= 5000; i++ {
+		ip := net.IPv4(1, byte(i>>16), byte(i>>8), byte(i))
+		source.nodes[ip.String()] = &Node{
+			Addr:      appmessage.NewNetAddressIPPort(ip, 1313),
+			UserAgent: strings.Repeat("x", 100),
+		}
+	}
+	unroutable := net.IPv4(10, 0, 0, 1)
+	source.nodes[unroutable.String()] = &Node{Addr: appmessage.NewNetAddressIPPort(unroutable, 1313)}
+	_, abusive, _ := net.ParseCIDR("2.0.0.0/8")
+	source.bans.Ban(abusive, "abuse", time.Hour)
+
+	host := "localhost:3740"
+	grpcServer := NewGRPCServer(source, "secret", "", "")
+	err := grpcServer.Start(host)
+	if err != nil {
+		t.Fatalf("Failed to start gRPC server: %s", err)
+	}
+	defer grpcServer.Stop()
+
+	unauthenticated, err := NewAdminClient(host, "wrong")
+	if err != nil {
+		t.Fatalf("NewAdminClient: %s", err)
+	}
+	defer unauthenticated.Close()
+	err = unauthenticated.BackupDB(context.Background(), &BackupDBRequest{}, &bytes.Buffer{})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated, got %v", err)
+	}
+
+	client, err := NewAdminClient(host, "secret")
+	if err != nil {
+		t.Fatalf("NewAdminClient: %s", err)
+	}
+	defer client.Close()
+
+	var snapshot bytes.Buffer
+	err = client.BackupDB(context.Background(), &BackupDBRequest{}, &snapshot)
+	if err != nil {
+		t.Fatalf("BackupDB: %s", err)
+	}
+	if snapshot.Len() <= backupChunkSize {
+		t.Fatalf("expected a snapshot of several chunks, got %d bytes", snapshot.Len())
+	}
+
+	_, err = client.RestoreDB(context.Background(), "", strings.NewReader("not a snapshot"))
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for an invalid snapshot, got %v", err)
+	}
+
+	// Restore the snapshot into an empty seeder, without the bans of the
+	// snapshot and with one of the addresses banned.
+	source.nodes = make(map[string]*Node)
+	source.bans.Unban(abusive)
+	_, ipNet, _ := net.ParseCIDR("1.0.0.1/32")
+	source.bans.Ban(ipNet, "test", 0)
+	response, err := client.RestoreDB(context.Background(), "", &snapshot)
+	if err != nil {
+		t.Fatalf("RestoreDB: %s", err)
+	}
+	if response.RestoredNodes != 4999 || response.RestoredBans != 1 ||
+		response.Network != dagconfig.MainnetParams.Name {
+		t.Errorf("expected 4999 restored nodes and a ban of %s, got %+v", dagconfig.MainnetParams.Name, response)
+	}
+	if !source.bans.IsBanned(net.IPv4(2, 0, 0, 1)) {
+		t.Errorf("expected the ban of the snapshot to be restored")
+	}
+	node, ok := source.Node(net.IPv4(1, 0, 0, 2))
+	if !ok || node.UserAgent != strings.Repeat("x", 100) {
+		t.Errorf("expected 1.0.0.2 to be restored, got %+v", node)
+	}
+	if _, ok := source.Node(net.IPv4(1, 0, 0, 1)); ok {
+		t.Errorf("expected banned 1.0.0.1 not to be restored")
+	}
+	if _, ok := source.Node(unroutable); ok {
+		t.Errorf("expected unroutable %s not to be restored", unroutable)
+	}
+
+	saved, _, err := readPeersFile(source.peersFile)
+	if err != nil || len(saved.Nodes) != 4999 || len(saved.Bans) != 2 {
+		t.Errorf("expected the restored nodes and bans to be saved, got %v", err)
+	}
+}
+
+func TestAdminRegisterNetwork(t *testing.T) {
+	flags, err := builtinNetworkFlags("mainnet")
+	if err != nil {
+		t.Fatalf("builtinNetworkFlags: %s", err)
+	}
+	primary := &seederNetwork{
+		flags: flags,
+		amgr:  newTestManager(t, &dagconfig.MainnetParams, 0),
+		zones: []ZoneConfig{{Host: "seed.example.org", Nameserver: "ns.example.org"}},
+	}
+	previousNetworks, previousDNSServer := networks, dnsServer
+	networks = []*seederNetwork{primary}
+	setTestConfig(t, &ConfigFlags{AppDir: filepath.Join(t.TempDir(), "karlsen-mainnet")})
+	dnsServer = NewDNSServer(networks, "", nil, nil)
+	t.Cleanup(func() {
+		for _, network := range servedNetworks()[1:] {
+			close(network.amgr.quit)
+		}
+		networks, dnsServer = previousNetworks, previousDNSServer
+	})
+
+	host := "localhost:3745"
+	grpcServer := NewGRPCServer(primary.amgr, "secret", "", "")
+	err = grpcServer.Start(host)
+	if err != nil {
+		t.Fatalf("Failed to start gRPC server: %s", err)
+	}
+	defer grpcServer.Stop()
+	client, err := NewAdminClient(host, "secret")
+	if err != nil {
+		t.Fatalf("NewAdminClient: %s", err)
+	}
+	defer client.Close()
+
+	privnet := CustomNetworkConfig{Name: "karlsen-runtimenet", Magic: 0x4b4c5352, DefaultPort: "42612",
+		DNSSeeds: []string{}}
+	_, err = client.RegisterNetwork(context.Background(), &RegisterNetworkRequest{
+		Network: CustomNetworkConfig{Name: "karlsen-runtimenet"},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for a network without magic bytes, got %v", err)
+	}
+	_, err = client.RegisterNetwork(context.Background(), &RegisterNetworkRequest{
+		Network: privnet,
+		Zones:   []ZoneConfig{{Host: "Seed.example.org", Nameserver: "ns.example.org"}},
+	})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected FailedPrecondition for a zone already served, got %v", err)
+	}
+
+	response, err := client.RegisterNetwork(context.Background(), &RegisterNetworkRequest{
+		Network: privnet,
+		Zones:   []ZoneConfig{{Host: "runtime.example.org", Nameserver: "ns.example.org"}},
+	})
+	if err != nil {
+		t.Fatalf("RegisterNetwork: %s", err)
+	}
+	if response.DefaultPort != 42612 {
+		t.Errorf("expected the default port 42612, got %d", response.DefaultPort)
+	}
+	network := findNetwork("karlsen-runtimenet")
+	if network == nil {
+		t.Fatalf("expected the network to be served")
+	}
+	zone := dnsServer.findZone("runtime.example.org.")
+	if zone == nil || zone.amgr != network.amgr || zone.soa == nil {
+		t.Errorf("expected the zone of the network to be served, got %+v", zone)
+	}
+
+	_, err = client.RegisterNetwork(context.Background(), &RegisterNetworkRequest{Network: privnet})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected FailedPrecondition for a network already served, got %v", err)
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// alertTimeout is the timeout for a single webhook call.
+	alertTimeout = time.Second * 10
+
+	// alertClearMargin is how far past its threshold a value must recover,
+	// relative to the threshold, before a firing alert is resolved. It
+	// keeps alerts from flapping around the threshold.
+	alertClearMargin = 0.2
+
+	// alertMinSamples is the number of crawl attempts or DNS queries an
+	// interval needs before its failure rate is evaluated.
+	alertMinSamples = 20
+)
+
+const (
+	alertStatusFiring   = "firing"
+	alertStatusResolved = "resolved"
+)
+
+// alertRule tracks the state of a single alert condition.
+type alertRule struct {
+	name      string
+	threshold float64
+	// above is set for rules firing when the value rises above the
+	// threshold, and unset for rules firing when it drops below.
+	above  bool
+	firing bool
+}
+
+// evaluate updates the rule with a new value, and returns whether it
+// started firing or got resolved.
+func (r *alertRule) evaluate(value float64) bool {
+	if r.above {
+		if !r.firing && value > r.threshold {
+			r.firing = true
+			return true
+		}
+		if r.firing && value <= r.threshold*(1-alertClearMargin) {
+			r.firing = false
+			return true
+		}
+		return false
+	}
+
+	if !r.firing && value < r.threshold {
+		r.firing = true
+		return true
+	}
+	if r.firing && value >= r.threshold*(1+alertClearMargin) {
+		r.firing = false
+		return true
+	}
+	return false
+}
+
+// alertPayload is the JSON body posted to the webhook. Text makes it
+// compatible with Slack incoming webhooks.
+type alertPayload struct {
+	Text      string    `json:"text"`
+	Alert     string    `json:"alert"`
+	Status    string    `json:"status"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Time      time.Time `json:"time"`
+}
+
+// goodPeersRule is the good peer count rule of a single network.
+type goodPeersRule struct {
+	alertRule
+	amgr *Manager
+}
+
+// canaryRule is a rule on the state of a single canary.
+type canaryRule struct {
+	alertRule
+	canary *canary
+}
+
+// alerter periodically checks the good peer counts, the crawl and DNS
+// failure rates and the canaries, and posts to a webhook when they cross
+// their thresholds.
+type alerter struct {
+	webhook  string
+	interval time.Duration
+
+	goodPeers        []*goodPeersRule
+	crawlFailureRate *alertRule
+	dnsErrorRate     *alertRule
+	canaryFailures   []*canaryRule
+	canaryLatency    []*canaryRule
+
+	lastCrawlAttempts uint64
+	lastCrawlFailures uint64
+	lastDNSQueries    uint64
+	lastDNSErrors     uint64
+
+	wg   sync.WaitGroup
+	quit chan struct{}
+}
+
+// newAlerter returns a new alerter posting to webhook. Thresholds of zero
+// disable the matching alerts.
+func newAlerter(webhook string, interval time.Duration, minGoodPeers int,
+	crawlFailureRate, dnsErrorRate float64, canaryFailures int, canaryLatency time.Duration,
+	managers []*Manager) (*alerter, error) {
+
+	if interval <= 0 {
+		return nil, errors.New("alert interval must be positive")
+	}
+
+	a := &alerter{
+		webhook:  webhook,
+		interval: interval,
+		quit:     make(chan struct{}),
+	}
+	if minGoodPeers > 0 {
+		for _, amgr := range managers {
+			a.goodPeers = append(a.goodPeers, &goodPeersRule{
+				alertRule: alertRule{
+					name:      amgr.netParams.Name + " good peers",
+					threshold: float64(minGoodPeers),
+				},
+				amgr: amgr,
+			})
+		}
+	}
+	for _, amgr := range managers {
+		for _, c := range amgr.Canaries() {
+			name := amgr.netParams.Name + " canary " + c.name
+			if canaryFailures > 0 {
+				a.canaryFailures = append(a.canaryFailures, &canaryRule{
+					alertRule: alertRule{
+						name:      name + " consecutive failures",
+						threshold: float64(canaryFailures),
+						above:     true,
+					},
+					canary: c,
+				})
+			}
+			if canaryLatency > 0 {
+				a.canaryLatency = append(a.canaryLatency, &canaryRule{
+					alertRule: alertRule{
+						name:      name + " latency (ms)",
+						threshold: float64(canaryLatency.Milliseconds()),
+						above:     true,
+					},
+					canary: c,
+				})
+			}
+		}
+	}
+	if crawlFailureRate > 0 {
+		a.crawlFailureRate = &alertRule{name: "crawl failure rate", threshold: crawlFailureRate, above: true}
+	}
+	if dnsErrorRate > 0 {
+		a.dnsErrorRate = &alertRule{name: "DNS error rate", threshold: dnsErrorRate, above: true}
+	}
+	a.lastCrawlAttempts, a.lastCrawlFailures = rateCounters(&stats.crawlAttempts, &stats.crawlFailures)
+	a.lastDNSQueries, a.lastDNSErrors = rateCounters(&stats.dnsQueries, &stats.dnsErrors)
+	return a, nil
+}
+
+// Start starts checking the alert conditions in the background.
+func (a *alerter) Start() {
+	a.wg.Add(1)
+	spawn("alerter.alertHandler", a.alertHandler)
+}
+
+// Stop stops the alerter and waits for it to finish.
+func (a *alerter) Stop() {
+	close(a.quit)
+	a.wg.Wait()
+}
+
+func (a *alerter) alertHandler() {
+	defer a.wg.Done()
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.check()
+		case <-a.quit:
+			return
+		}
+	}
+}
+
+// check evaluates every rule and notifies the webhook of those that
+// changed state.
+func (a *alerter) check() {
+	for _, rule := range a.goodPeers {
+		_, good := rule.amgr.Counts()
+		a.evaluate(&rule.alertRule, float64(good))
+	}
+	for _, rule := range a.canaryFailures {
+		a.evaluate(&rule.alertRule, float64(rule.canary.status().ConsecutiveFailures))
+	}
+	for _, rule := range a.canaryLatency {
+		// The latency is only known while the canary is up; being down is
+		// covered by the failures rule.
+		status := rule.canary.status()
+		if status.Up {
+			a.evaluate(&rule.alertRule, float64(status.LatencyMilliseconds))
+		}
+	}
+
+	attempts, failures := rateCounters(&stats.crawlAttempts, &stats.crawlFailures)
+	if a.crawlFailureRate != nil && attempts-a.lastCrawlAttempts >= alertMinSamples {
+		rate := float64(failures-a.lastCrawlFailures) / float64(attempts-a.lastCrawlAttempts)
+		a.evaluate(a.crawlFailureRate, rate)
+	}
+	a.lastCrawlAttempts, a.lastCrawlFailures = attempts, failures
+
+	queries, dnsErrors := rateCounters(&stats.dnsQueries, &stats.dnsErrors)
+	if a.dnsErrorRate != nil && queries-a.lastDNSQueries >= alertMinSamples {
+		rate := float64(dnsErrors-a.lastDNSErrors) / float64(queries-a.lastDNSQueries)
+		a.evaluate(a.dnsErrorRate, rate)
+	}
+	a.lastDNSQueries, a.lastDNSErrors = queries, dnsErrors
+}
+
+func (a *alerter) evaluate(rule *alertRule, value float64) {
+	if !rule.evaluate(value) {
+		return
+	}
+	notifyAlert(a.webhook, rule, value)
+}
+
+// notifyAlert logs that rule changed state with value, and posts it to
+// webhook unless it is empty.
+func notifyAlert(webhook string, rule *alertRule, value float64) {
+	payload := alertPayload{
+		Alert:     rule.name,
+		Status:    alertStatusResolved,
+		Value:     value,
+		Threshold: rule.threshold,
+		Time:      time.Now(),
+	}
+	if rule.firing {
+		payload.Status = alertStatusFiring
+	}
+	payload.Text = fmt.Sprintf("[%s] %s is %g (threshold %g)", payload.Status, rule.name,
+		value, rule.threshold)
+
+	log.Warnf("Alert %s", payload.Text)
+	if webhook == "" {
+		return
+	}
+	err := postAlert(webhook, &payload)
+	if err != nil {
+		log.Warnf("Failed to post alert to %s: %v", webhook, err)
+	}
+}
+
+// rateCounters loads a total and a failure counter. The failure counter
+// is loaded first so it never exceeds the total.
+func rateCounters(total, failures *uint64) (uint64, uint64) {
+	failed := atomic.LoadUint64(failures)
+	return atomic.LoadUint64(total), failed
+}
+
+func postAlert(url string, payload *alertPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	client := http.Client{Timeout: alertTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/karlsen-network/karlsend/domain/dagconfig"
+	"github.com/pkg/errors"
+)
+
+func TestAlertRule(t *testing.T) {
+	below := alertRule{threshold: 10}
+	above := alertRule{threshold: 0.5, above: true}
+	tests := []struct {
+		rule    *alertRule
+		value   float64
+		changed bool
+		firing  bool
+	}{
+		{&below, 10, false, false},
+		{&below, 9, true, true},
+		{&below, 11, false, true},
+		{&below, 12, true, false},
+		{&below, 11, false, false},
+		{&above, 0.5, false, false},
+		{&above, 0.6, true, true},
+		{&above, 0.45, false, true},
+		{&above, 0.4, true, false},
+	}
+	for i, test := range tests {
+		changed := test.rule.evaluate(test.value)
+		if changed != test.changed || test.rule.firing != test.firing {
+			t.Errorf("test %d: value %g: expected changed %t firing %t, got %t %t",
+				i, test.value, test.changed, test.firing, changed, test.rule.firing)
+		}
+	}
+}
+
+func TestAlerter(t *testing.T) {
+	payloads := make(chan alertPayload, 10)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload alertPayload
+		err := json.NewDecoder(r.Body).Decode(&payload)
+		if err != nil {
+			t.Errorf("Decode: %s", err)
+		}
+		payloads <- payload
+	}))
+	defer webhook.Close()
+
+	bootstrap := &canary{name: "bootstrap", addr: appmessage.NewNetAddressIPPort(net.ParseIP("1.0.0.9"), 1313)}
+	m := newTestManager(t, &dagconfig.MainnetParams, 0)
+	m.canaries = []*canary{bootstrap}
+	a, err := newAlerter(webhook.URL, time.Minute, 1, 0.5, 0, 2, 500*time.Millisecond, []*Manager{m})
+	if err != nil {
+		t.Fatalf("newAlerter: %s", err)
+	}
+
+	expect := func(alert, status string) {
+		select {
+		case payload := <-payloads:
+			if payload.Alert != alert || payload.Status != status {
+				t.Errorf("expected %s %s, got %+v", alert, status, payload)
+			}
+		default:
+			t.Errorf("expected %s %s, got no alert", alert, status)
+		}
+	}
+
+	a.check()
+	expect("karlsen-mainnet good peers", alertStatusFiring)
+
+	for _, ip := range []string{"1.0.0.1", "1.0.0.2"} {
+		m.nodes[ip] = &Node{
+			Addr:        appmessage.NewNetAddressIPPort(net.ParseIP(ip), 1313),
+			LastSeen:    time.Now(),
+			LastSuccess: time.Now(),
+		}
+	}
+	atomic.AddUint64(&stats.crawlAttempts, alertMinSamples)
+	atomic.AddUint64(&stats.crawlFailures, alertMinSamples)
+	a.check()
+	expect("karlsen-mainnet good peers", alertStatusResolved)
+	expect("crawl failure rate", alertStatusFiring)
+
+	a.check()
+	if len(payloads) != 0 {
+		t.Errorf("expected no more alerts, got %d", len(payloads))
+	}
+
+	for i := 0; i < 3; i++ {
+		bootstrap.record(time.Now(), 0, errors.New("connection refused"))
+	}
+	a.check()
+	expect("karlsen-mainnet canary bootstrap consecutive failures", alertStatusFiring)
+
+	bootstrap.record(time.Now(), time.Second, nil)
+	a.check()
+	expect("karlsen-mainnet canary bootstrap consecutive failures", alertStatusResolved)
+	expect("karlsen-mainnet canary bootstrap latency (ms)", alertStatusFiring)
+}
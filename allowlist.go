@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	allowOnlyMtx sync.RWMutex
+	// allowOnly holds the networks the seeder is restricted to, for
+	// private deployments. Addresses outside of them are never crawled,
+	// learned or served. Empty means no restriction.
+	allowOnly []*net.IPNet
+)
+
+// setAllowOnly restricts the seeder to the given networks, or lifts the
+// restriction if there are none.
+func setAllowOnly(networks []*net.IPNet) {
+	allowOnlyMtx.Lock()
+	defer allowOnlyMtx.Unlock()
+	allowOnly = networks
+}
+
+// allowOnlyEnabled returns whether the seeder is restricted to a list of
+// networks.
+func allowOnlyEnabled() bool {
+	allowOnlyMtx.RLock()
+	defer allowOnlyMtx.RUnlock()
+	return len(allowOnly) > 0
+}
+
+// isAllowed returns whether ip may be crawled and served: whether it is in
+// one of the allowed networks, or there is no restriction.
+func isAllowed(ip net.IP) bool {
+	allowOnlyMtx.RLock()
+	defer allowOnlyMtx.RUnlock()
+	if len(allowOnly) == 0 {
+		return true
+	}
+	for _, ipNet := range allowOnly {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAllowOnly parses the allowed addresses and networks in CIDR
+// notation.
+func parseAllowOnly(addresses []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(addresses))
+	for _, address := range addresses {
+		ipNet, err := parseIPNet(strings.TrimSpace(address))
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid allowed network")
+		}
+		networks = append(networks, ipNet)
+	}
+	return networks, nil
+}
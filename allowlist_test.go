@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/karlsen-network/karlsend/infrastructure/config"
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+func TestAllowOnly(t *testing.T) {
+	cfg := setTestConfig(t, &ConfigFlags{NetworkFlags: config.NetworkFlags{Devnet: true}})
+	err := cfg.ResolveNetwork(nil)
+	if err != nil {
+		t.Fatalf("ResolveNetwork: %s", err)
+	}
+	allowed, err := parseAllowOnly([]string{"10.0.0.0/8", " 192.0.2.5"})
+	if err != nil {
+		t.Fatalf("parseAllowOnly: %s", err)
+	}
+	_, err = parseAllowOnly([]string{"10.0.0.0/33"})
+	if err == nil {
+		t.Errorf("expected an invalid network to be rejected")
+	}
+
+	// Nodes learned before the restriction are forgotten on restart.
+	m := newTestManager(t, cfg.NetParams(), 1313)
+	m.AddAddresses([]*appmessage.NetAddress{
+		appmessage.NewNetAddressIPPort(net.ParseIP("10.1.2.3"), 1313),
+		appmessage.NewNetAddressIPPort(net.ParseIP("203.0.113.1"), 1313),
+	})
+	m.savePeers()
+
+	setAllowOnly(allowed)
+	defer setAllowOnly(nil)
+	peersFile := m.peersFile
+	m = newTestManager(t, cfg.NetParams(), 1313)
+	m.peersFile = peersFile
+	err = m.deserializePeers()
+	if err != nil {
+		t.Fatalf("deserializePeers: %s", err)
+	}
+	if _, ok := m.Node(net.ParseIP("203.0.113.1")); ok || m.AddressCount() != 1 {
+		t.Errorf("expected the node outside of the allowed networks to be forgotten, got %d nodes", m.AddressCount())
+	}
+
+	m.AddAddresses([]*appmessage.NetAddress{
+		appmessage.NewNetAddressIPPort(net.ParseIP("192.0.2.5"), 1313),
+		appmessage.NewNetAddressIPPort(net.ParseIP("192.0.2.6"), 1313),
+	})
+	if _, ok := m.Node(net.ParseIP("192.0.2.6")); ok || m.AddressCount() != 2 {
+		t.Errorf("expected only allowed addresses to be learned, got %d nodes", m.AddressCount())
+	}
+	outside := appmessage.NewNetAddressIPPort(net.ParseIP("203.0.113.2"), 1313)
+	if m.Inject(outside, time.Now().Add(time.Hour)) == nil || m.ForceCrawl(outside) == nil {
+		t.Errorf("expected addresses outside of the allowed networks not to be injected or crawled")
+	}
+	if m.MergeVerified(outside, nil, time.Now()) || m.MergeSnapshot(outside, nil, time.Now()) {
+		t.Errorf("expected addresses outside of the allowed networks not to be merged")
+	}
+	err = pollPeer(m, nil, outside)
+	if !errors.Is(err, errBanned) {
+		t.Errorf("expected addresses outside of the allowed networks never to be contacted, got %v", err)
+	}
+
+	m.SetAlwaysServe([]net.IP{net.ParseIP("192.0.2.5"), net.ParseIP("203.0.113.3")})
+	for _, addr := range m.GoodAddresses(dns.TypeA, true, nil, false, 0, nil, defaultMaxAddresses) {
+		if !isAllowed(addr.IP) {
+			t.Errorf("served %s, outside of the allowed networks", addr.IP)
+		}
+	}
+}
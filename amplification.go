@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+const (
+	// clientCookieSize and serverCookieSize are the sizes in bytes of
+	// the two parts of a DNS cookie, as in RFC 7873.
+	clientCookieSize = 8
+	serverCookieSize = 8
+
+	// cookieSecretSize is the size in bytes of the secret the server
+	// cookies are derived from.
+	cookieSecretSize = 32
+)
+
+// amplificationGuard keeps the seeder from being abused as a reflection
+// amplifier: the answers to queries from addresses that could be spoofed
+// are trimmed to at most maxFactor times the size of the query. Clients
+// prove they own their address by returning the DNS server cookie they
+// were given in an earlier answer, which exempts them.
+type amplificationGuard struct {
+	maxFactor float64
+	secret    []byte
+}
+
+// newAmplificationGuard returns a guard limiting answers to maxFactor times
+// the size of the query, or nil if maxFactor is zero.
+func newAmplificationGuard(maxFactor float64) (*amplificationGuard, error) {
+	if maxFactor <= 0 {
+		return nil, nil
+	}
+	secret := make([]byte, cookieSecretSize)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate the DNS cookie secret")
+	}
+	return &amplificationGuard{maxFactor: maxFactor, secret: secret}, nil
+}
+
+// serverCookie returns the server cookie of the client at ip sending
+// clientCookie.
+func (g *amplificationGuard) serverCookie(clientCookie []byte, ip net.IP) []byte {
+	mac := hmac.New(sha256.New, g.secret)
+	mac.Write(clientCookie)
+	mac.Write(ip.To16())
+	return mac.Sum(nil)[:serverCookieSize]
+}
+
+// limit gives the client its server cookie in response, and trims the
+// answer records of response to the allowed size unless query carries a
+// valid server cookie. A trimmed response isn't marked truncated, since the
+// seeder doesn't serve DNS over TCP to retry on: the addresses left are a
+// smaller sample of the peers, and the client gets the full answer by
+// returning the cookie. It returns whether response was trimmed. A nil
+// guard leaves response unchanged.
+func (g *amplificationGuard) limit(addr *net.UDPAddr, query *dns.Msg, response *dns.Msg) bool {
+	if g == nil {
+		return false
+	}
+
+	verified := false
+	if opt := response.IsEdns0(); opt != nil {
+		for _, option := range opt.Option {
+			cookie, ok := option.(*dns.EDNS0_COOKIE)
+			if !ok {
+				continue
+			}
+			received, err := hex.DecodeString(cookie.Cookie)
+			if err != nil || len(received) < clientCookieSize {
+				continue
+			}
+			clientCookie := received[:clientCookieSize]
+			serverCookie := g.serverCookie(clientCookie, addr.IP)
+			verified = hmac.Equal(received[clientCookieSize:], serverCookie)
+			cookie.Cookie = hex.EncodeToString(append(clientCookie, serverCookie...))
+		}
+	}
+	if verified {
+		return false
+	}
+
+	maxSize := int(g.maxFactor * float64(query.Len()))
+	if response.Len() <= maxSize || len(response.Answer) == 0 {
+		return false
+	}
+	for response.Len() > maxSize && len(response.Answer) > 1 {
+		response.Answer = response.Answer[:len(response.Answer)-1]
+	}
+	return true
+}
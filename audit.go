@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jrick/logrotate/rotator"
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/pkg/errors"
+)
+
+// auditEntry records the addresses handed out in a single DNS answer.
+type auditEntry struct {
+	Time      time.Time `json:"time"`
+	Client    string    `json:"client"`
+	Zone      string    `json:"zone"`
+	Type      string    `json:"type"`
+	Addresses []string  `json:"addresses"`
+}
+
+// auditLog records the answers sent to resolvers, in a ring buffer, a
+// rolled file, or both.
+type auditLog struct {
+	hashClients bool
+	salt        string
+
+	mtx     sync.Mutex
+	entries []auditEntry
+	next    int
+	full    bool
+	file    io.WriteCloser
+}
+
+// answerAudit is the audit log of the running seeder, or nil if auditing is
+// disabled.
+var answerAudit *auditLog
+
+// newAuditLog returns an audit log keeping the last ringSize answers in
+// memory and, if path is not empty, writing all of them to path as JSON
+// lines, rolled like the log files. With hashClients set, client addresses
+// are replaced by their salted SHA-256 hash.
+func newAuditLog(ringSize int, path string, maxSizeMB int64, maxRolls int,
+	hashClients bool, salt string) (*auditLog, error) {
+
+	a := &auditLog{
+		hashClients: hashClients,
+		salt:        salt,
+		entries:     make([]auditEntry, ringSize),
+	}
+	if path != "" {
+		err := os.MkdirAll(filepath.Dir(path), 0700)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create audit log directory")
+		}
+		a.file, err = rotator.New(path, maxSizeMB*1000, false, maxRolls)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create audit log rotator")
+		}
+	}
+	return a, nil
+}
+
+// clientID returns how the given client address is recorded.
+func (a *auditLog) clientID(ip net.IP) string {
+	if !a.hashClients {
+		return ip.String()
+	}
+	hash := sha256.Sum256([]byte(a.salt + ip.String()))
+	return hex.EncodeToString(hash[:])
+}
+
+// record records the addresses answered to client. A nil audit log records
+// nothing.
+func (a *auditLog) record(client net.IP, zone, qtype string, addrs []*appmessage.NetAddress) {
+	if a == nil {
+		return
+	}
+
+	entry := auditEntry{
+		Time:      time.Now(),
+		Client:    a.clientID(client),
+		Zone:      zone,
+		Type:      qtype,
+		Addresses: make([]string, len(addrs)),
+	}
+	for i, addr := range addrs {
+		entry.Addresses[i] = addr.IP.String()
+	}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	if len(a.entries) > 0 {
+		a.entries[a.next] = entry
+		a.next = (a.next + 1) % len(a.entries)
+		if a.next == 0 {
+			a.full = true
+		}
+	}
+	if a.file != nil {
+		line, err := json.Marshal(entry)
+		if err == nil {
+			_, err = a.file.Write(append(line, '\n'))
+		}
+		if err != nil {
+			dnsLog.Warnf("Failed to write audit log: %v", err)
+		}
+	}
+}
+
+// Entries returns the kept entries for the given client, or for all clients
+// if client is nil, newest first.
+func (a *auditLog) Entries(client net.IP) []auditEntry {
+	var clientID string
+	if client != nil {
+		clientID = a.clientID(client)
+	}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	count := a.next
+	if a.full {
+		count = len(a.entries)
+	}
+	entries := make([]auditEntry, 0, count)
+	for i := 1; i <= count; i++ {
+		entry := a.entries[(a.next-i+len(a.entries))%len(a.entries)]
+		if clientID == "" || entry.Client == clientID {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Close closes the audit log file. Closing a nil audit log does nothing.
+func (a *auditLog) Close() error {
+	if a == nil || a.file == nil {
+		return nil
+	}
+	return a.file.Close()
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/karlsen-network/karlsend/app/appmessage"
+)
+
+func TestAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	a, err := newAuditLog(2, path, 1, 1, true, "salt")
+	if err != nil {
+		t.Fatalf("newAuditLog: %s", err)
+	}
+
+	answer := []*appmessage.NetAddress{appmessage.NewNetAddressIPPort(net.ParseIP("192.0.2.1"), 1313)}
+	for _, client := range []string{"198.51.100.1", "198.51.100.2", "198.51.100.1"} {
+		a.record(net.ParseIP(client), "seed.example.org.", "A", answer)
+	}
+
+	entries := a.Entries(nil)
+	if len(entries) != 2 {
+		t.Fatalf("expected the ring to keep 2 entries, got %d", len(entries))
+	}
+	if entries[0].Client != a.clientID(net.ParseIP("198.51.100.1")) || entries[0].Client == "198.51.100.1" {
+		t.Errorf("expected the newest entry first with a hashed client, got %+v", entries[0])
+	}
+	if entries := a.Entries(net.ParseIP("198.51.100.2")); len(entries) != 1 {
+		t.Errorf("expected 1 entry for 198.51.100.2, got %d", len(entries))
+	}
+
+	err = a.Close()
+	if err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer file.Close()
+	var lines int
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry auditEntry
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil || len(entry.Addresses) != 1 || entry.Addresses[0] != "192.0.2.1" {
+			t.Errorf("unexpected audit line %s: %v", scanner.Text(), err)
+		}
+		lines++
+	}
+	if lines != 3 {
+		t.Errorf("expected 3 audit lines, got %d", lines)
+	}
+}
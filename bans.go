@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// banListSource is the source of the bans loaded from the ban list file.
+const banListSource = "ban list"
+
+// Ban describes a single banned address or network
+type Ban struct {
+	Network *net.IPNet
+	Reason  string
+	// Expiry is the time the ban is lifted. A zero Expiry means the ban
+	// never expires.
+	Expiry time.Time
+
+	// source is the ban list file or blocklist feed the ban was loaded
+	// from, and is empty for bans set through the admin service. Bans
+	// loaded from a source are replaced as a whole whenever it is
+	// reloaded.
+	source string
+}
+
+func (b *Ban) expired(now time.Time) bool {
+	return !b.Expiry.IsZero() && now.After(b.Expiry)
+}
+
+// BanManager keeps track of banned addresses and networks
+type BanManager struct {
+	mtx  sync.RWMutex
+	bans map[string]*Ban
+
+	// masks holds the distinct masks of the banned networks, so an
+	// address is looked up once per mask rather than matched against
+	// every ban.
+	masks []net.IPMask
+}
+
+// NewBanManager returns a new, empty, ban manager
+func NewBanManager() *BanManager {
+	return &BanManager{bans: make(map[string]*Ban)}
+}
+
+// parseIPNet parses either a single IP address or a network in CIDR
+// notation. Single addresses are returned as a host network.
+func parseIPNet(address string) (*net.IPNet, error) {
+	_, ipNet, err := net.ParseCIDR(address)
+	if err == nil {
+		return ipNet, nil
+	}
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return nil, errors.Errorf("invalid address or network: %s", address)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// Ban bans the given network for the given duration. A zero duration bans
+// it permanently. Banning an already banned network replaces the old ban.
+func (bm *BanManager) Ban(ipNet *net.IPNet, reason string, duration time.Duration) {
+	ban := &Ban{Network: ipNet, Reason: reason}
+	if duration > 0 {
+		ban.Expiry = time.Now().Add(duration)
+	}
+
+	bm.mtx.Lock()
+	bm.bans[ipNet.String()] = ban
+	bm.updateMasks()
+	bm.mtx.Unlock()
+}
+
+// updateMasks updates the masks of the banned networks after bans were
+// added or removed.
+//
+// This function MUST be called with the ban manager lock held.
+func (bm *BanManager) updateMasks() {
+	seen := make(map[string]struct{})
+	bm.masks = bm.masks[:0]
+	for _, ban := range bm.bans {
+		mask := ban.Network.Mask
+		if _, ok := seen[string(mask)]; ok {
+			continue
+		}
+		seen[string(mask)] = struct{}{}
+		bm.masks = append(bm.masks, mask)
+	}
+}
+
+// setBanList replaces all bans previously loaded from the given source with
+// the given ones. Bans set through the admin service, or loaded from another
+// source, take precedence over the entries for the same network.
+func (bm *BanManager) setBanList(source string, bans []*Ban) {
+	bm.mtx.Lock()
+	defer bm.mtx.Unlock()
+
+	for key, ban := range bm.bans {
+		if ban.source == source {
+			delete(bm.bans, key)
+		}
+	}
+	for _, ban := range bans {
+		key := ban.Network.String()
+		if _, exists := bm.bans[key]; exists {
+			continue
+		}
+		bm.bans[key] = &Ban{Network: ban.Network, Reason: ban.Reason, Expiry: ban.Expiry, source: source}
+	}
+	bm.updateMasks()
+}
+
+// Unban lifts the ban on the given network, and returns whether it was
+// banned.
+func (bm *BanManager) Unban(ipNet *net.IPNet) bool {
+	bm.mtx.Lock()
+	defer bm.mtx.Unlock()
+
+	_, exists := bm.bans[ipNet.String()]
+	delete(bm.bans, ipNet.String())
+	bm.updateMasks()
+	return exists
+}
+
+// IsBanned returns whether the given IP falls in any active ban. A nil ban
+// manager bans nothing.
+func (bm *BanManager) IsBanned(ip net.IP) bool {
+	if bm == nil {
+		return false
+	}
+
+	now := time.Now()
+	bm.mtx.RLock()
+	defer bm.mtx.RUnlock()
+
+	for _, mask := range bm.masks {
+		network := &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+		if network.IP == nil {
+			continue
+		}
+		ban, ok := bm.bans[network.String()]
+		if ok && !ban.expired(now) && ban.Network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Bans returns a copy of all active bans, sorted by network.
+func (bm *BanManager) Bans() []Ban {
+	now := time.Now()
+	bm.mtx.RLock()
+	bans := make([]Ban, 0, len(bm.bans))
+	for _, ban := range bm.bans {
+		if !ban.expired(now) {
+			bans = append(bans, *ban)
+		}
+	}
+	bm.mtx.RUnlock()
+
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].Network.String() < bans[j].Network.String()
+	})
+	return bans
+}
+
+// savedBan is a ban as saved in the peers file.
+type savedBan struct {
+	Network string
+	Reason  string
+	Expiry  time.Time
+}
+
+// savedBans returns the active bans set through the admin service, in the
+// format of the peers file.
+func (bm *BanManager) savedBans() []savedBan {
+	var saved []savedBan
+	for _, ban := range bm.Bans() {
+		if ban.source == "" {
+			saved = append(saved, savedBan{Network: ban.Network.String(), Reason: ban.Reason, Expiry: ban.Expiry})
+		}
+	}
+	return saved
+}
+
+// loadSavedBans adds the bans saved in the peers file, leaving out those
+// that expired since and invalid ones, and returns how many were loaded.
+func (bm *BanManager) loadSavedBans(saved []savedBan) int {
+	now := time.Now()
+	bans := make([]*Ban, 0, len(saved))
+	for _, entry := range saved {
+		ipNet, err := parseIPNet(entry.Network)
+		if err != nil {
+			amgrLog.Warnf("Ignoring saved ban: %v", err)
+			continue
+		}
+		ban := &Ban{Network: ipNet, Reason: entry.Reason, Expiry: entry.Expiry}
+		if !ban.expired(now) {
+			bans = append(bans, ban)
+		}
+	}
+
+	bm.mtx.Lock()
+	defer bm.mtx.Unlock()
+	for _, ban := range bans {
+		bm.bans[ban.Network.String()] = ban
+	}
+	bm.updateMasks()
+	return len(bans)
+}
+
+// removeExpired removes all expired bans, and returns how many were
+// removed.
+func (bm *BanManager) removeExpired() int {
+	now := time.Now()
+	bm.mtx.Lock()
+	defer bm.mtx.Unlock()
+
+	var count int
+	for key, ban := range bm.bans {
+		if ban.expired(now) {
+			delete(bm.bans, key)
+			count++
+		}
+	}
+	if count > 0 {
+		bm.updateMasks()
+	}
+	return count
+}
+
+// readBanList reads a ban list file. Each non-empty line holds an address or
+// a network in CIDR notation, optionally followed by the ban reason. Lines
+// starting with # are ignored. Ban list entries never expire.
+func readBanList(path string) ([]*Ban, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open ban list")
+	}
+	defer file.Close()
+
+	return parseBanList(file, path, "ban list")
+}
+
+// parseBanList parses a ban list in the format of readBanList, read from r.
+// name identifies the list in errors, and defaultReason is the reason of
+// entries that don't give one.
+func parseBanList(r io.Reader, name, defaultReason string) ([]*Ban, error) {
+	var bans []*Ban
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		ipNet, err := parseIPNet(fields[0])
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s:%d", name, lineNumber)
+		}
+		reason := strings.Join(fields[1:], " ")
+		if reason == "" {
+			reason = defaultReason
+		}
+		bans = append(bans, &Ban{Network: ipNet, Reason: reason})
+	}
+	err := scanner.Err()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", name)
+	}
+	return bans, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBanList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "banlist.txt")
+	err := os.WriteFile(path, []byte("# comment\n\n10.0.0.0/8 abusive network\n192.0.2.1\n"), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+
+	bans, err := readBanList(path)
+	if err != nil {
+		t.Fatalf("readBanList: %s", err)
+	}
+	if len(bans) != 2 || bans[0].Reason != "abusive network" || bans[1].Network.String() != "192.0.2.1/32" {
+		t.Fatalf("unexpected bans: %+v", bans)
+	}
+
+	bm := NewBanManager()
+	adminBan, _ := parseIPNet("198.51.100.0/24")
+	bm.Ban(adminBan, "admin", 0)
+	bm.setBanList(banListSource, bans)
+	if !bm.IsBanned(net.ParseIP("10.1.2.3")) {
+		t.Errorf("expected ban list entry to be banned")
+	}
+
+	// Reloading an empty list lifts the ban list entries only.
+	bm.setBanList(banListSource, nil)
+	if bm.IsBanned(net.ParseIP("10.1.2.3")) {
+		t.Errorf("expected ban list entry to be lifted")
+	}
+	if !bm.IsBanned(net.ParseIP("198.51.100.7")) {
+		t.Errorf("expected admin ban to be kept")
+	}
+}
+
+func TestIsBanned(t *testing.T) {
+	bm := NewBanManager()
+	var bans []*Ban
+	for _, address := range []string{"10.0.0.0/8", "192.0.2.1", "198.51.100.0/24", "2001:db8::/32", "2001:db8:1::1"} {
+		ipNet, err := parseIPNet(address)
+		if err != nil {
+			t.Fatalf("parseIPNet: %s", err)
+		}
+		bans = append(bans, &Ban{Network: ipNet, Reason: "test"})
+	}
+	bm.setBanList(banListSource, bans)
+
+	tests := []struct {
+		ip     string
+		banned bool
+	}{
+		{"10.255.0.1", true},
+		{"11.0.0.1", false},
+		{"192.0.2.1", true},
+		{"192.0.2.2", false},
+		{"198.51.100.200", true},
+		{"::ffff:198.51.100.200", true},
+		{"2001:db8:ffff::1", true},
+		{"2001:db9::1", false},
+	}
+	for _, test := range tests {
+		if banned := bm.IsBanned(net.ParseIP(test.ip)); banned != test.banned {
+			t.Errorf("IsBanned(%s): got %t, want %t", test.ip, banned, test.banned)
+		}
+	}
+
+	ipNet, _ := parseIPNet("10.0.0.0/8")
+	bm.Unban(ipNet)
+	if bm.IsBanned(net.ParseIP("10.255.0.1")) {
+		t.Errorf("expected the unbanned network to be lifted")
+	}
+	bm.Ban(ipNet, "admin", 0)
+	if !bm.IsBanned(net.ParseIP("10.255.0.1")) {
+		t.Errorf("expected the banned network to be banned again")
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// blocklistTimeout is the timeout for fetching a single blocklist.
+	blocklistTimeout = time.Second * 30
+
+	// blocklistMaxSize is the maximum size of a fetched blocklist. Larger
+	// lists are rejected rather than truncated, which would silently drop
+	// their last entries.
+	blocklistMaxSize = 16 * 1024 * 1024
+)
+
+// blocklistFeed is a remote blocklist, periodically fetched and merged
+// into the ban managers.
+type blocklistFeed struct {
+	url string
+
+	// etag and lastModified are those of the last successful fetch, sent
+	// back so unchanged lists aren't downloaded again.
+	etag         string
+	lastModified string
+}
+
+// blocklistEntry is a single entry of a JSON blocklist.
+type blocklistEntry struct {
+	Network string `json:"network"`
+	Reason  string `json:"reason"`
+}
+
+// fetch downloads the blocklist. It returns the bans it lists, or false if
+// the list didn't change since the previous fetch.
+func (f *blocklistFeed) fetch(client *http.Client) ([]*Ban, bool, error) {
+	req, err := http.NewRequest(http.MethodGet, f.url, nil)
+	if err != nil {
+		return nil, false, errors.WithStack(err)
+	}
+	if f.etag != "" {
+		req.Header.Set("If-None-Match", f.etag)
+	}
+	if f.lastModified != "" {
+		req.Header.Set("If-Modified-Since", f.lastModified)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, errors.Errorf("unexpected status %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, blocklistMaxSize+1))
+	if err != nil {
+		return nil, false, errors.WithStack(err)
+	}
+	if len(body) > blocklistMaxSize {
+		return nil, false, errors.Errorf("blocklist is larger than %d bytes", blocklistMaxSize)
+	}
+	bans, err := parseBlocklist(body, f.url, resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, false, err
+	}
+
+	f.etag = resp.Header.Get("ETag")
+	f.lastModified = resp.Header.Get("Last-Modified")
+	return bans, true, nil
+}
+
+// parseBlocklist parses a fetched blocklist. JSON lists hold an array of
+// either addresses and CIDR networks, or objects with a network and a
+// reason. Any other list is parsed like the ban list file.
+func parseBlocklist(body []byte, url, contentType string) ([]*Ban, error) {
+	defaultReason := "blocklist " + url
+	trimmed := bytes.TrimSpace(body)
+	if !strings.HasPrefix(contentType, "application/json") && !bytes.HasPrefix(trimmed, []byte("[")) {
+		return parseBanList(bytes.NewReader(body), url, defaultReason)
+	}
+
+	var rawEntries []json.RawMessage
+	err := json.Unmarshal(trimmed, &rawEntries)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid JSON blocklist %s", url)
+	}
+
+	bans := make([]*Ban, 0, len(rawEntries))
+	for _, rawEntry := range rawEntries {
+		var entry blocklistEntry
+		if json.Unmarshal(rawEntry, &entry.Network) != nil {
+			err := json.Unmarshal(rawEntry, &entry)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid entry in blocklist %s", url)
+			}
+		}
+
+		ipNet, err := parseIPNet(entry.Network)
+		if err != nil {
+			return nil, errors.Wrapf(err, "blocklist %s", url)
+		}
+		if entry.Reason == "" {
+			entry.Reason = defaultReason
+		}
+		bans = append(bans, &Ban{Network: ipNet, Reason: entry.Reason})
+	}
+	return bans, nil
+}
+
+// fetchBlocklists fetches the blocklists at the given URLs every interval,
+// and merges them into the ban managers of every network, until quit is
+// closed. A list that fails to be fetched keeps its previous bans.
+func fetchBlocklists(amgr *Manager, urls []string, interval time.Duration, quit <-chan struct{}) {
+	feeds := make([]*blocklistFeed, len(urls))
+	for i, url := range urls {
+		feeds[i] = &blocklistFeed{url: url}
+	}
+	client := &http.Client{Timeout: blocklistTimeout}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, feed := range feeds {
+			bans, changed, err := feed.fetch(client)
+			if err != nil {
+				log.Warnf("Failed to fetch blocklist %s: %v", feed.url, err)
+				continue
+			}
+			if !changed {
+				continue
+			}
+			for _, amgr := range networkManagers(amgr) {
+				amgr.SetBanList(feed.url, bans)
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-quit:
+			return
+		}
+	}
+}
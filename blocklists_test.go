@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBlocklistFeed(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`["192.0.2.1", {"network": "10.0.0.0/8", "reason": "abuse"}]`))
+	}))
+	defer server.Close()
+
+	feed := &blocklistFeed{url: server.URL}
+	bans, changed, err := feed.fetch(server.Client())
+	if err != nil {
+		t.Fatalf("fetch: %s", err)
+	}
+	if !changed || len(bans) != 2 || bans[0].Network.String() != "192.0.2.1/32" ||
+		bans[0].Reason != "blocklist "+server.URL || bans[1].Reason != "abuse" {
+		t.Fatalf("unexpected bans: %+v", bans)
+	}
+
+	_, changed, err = feed.fetch(server.Client())
+	if err != nil {
+		t.Fatalf("fetch: %s", err)
+	}
+	if changed || requests != 2 {
+		t.Errorf("expected an unchanged list on the second fetch")
+	}
+
+	bans, err = parseBlocklist([]byte("# comment\n198.51.100.0/24 spam\n"), "feed", "text/plain")
+	if err != nil {
+		t.Fatalf("parseBlocklist: %s", err)
+	}
+	if len(bans) != 1 || bans[0].Reason != "spam" {
+		t.Errorf("unexpected bans: %+v", bans)
+	}
+
+	oversized := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(bytes.Repeat([]byte("# padding\n"), blocklistMaxSize/10+1))
+	}))
+	defer oversized.Close()
+
+	_, _, err = (&blocklistFeed{url: oversized.URL}).fetch(oversized.Client())
+	if err == nil {
+		t.Errorf("expected an oversized blocklist to be rejected")
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultBootstrapCount is the number of peers in a bootstrap list
+	// when no count is requested.
+	defaultBootstrapCount = 8
+
+	// maxBootstrapCount is the maximum number of peers in a bootstrap
+	// list.
+	maxBootstrapCount = 64
+
+	// bootstrapValidity is how long clients should consider a bootstrap
+	// list valid for.
+	bootstrapValidity = time.Hour
+)
+
+// bootstrapList is a list of good peers for clients that can't use DNS to
+// bootstrap. Services are the services every peer advertised.
+type bootstrapList struct {
+	Network       string   `json:"network"`
+	Services      uint64   `json:"services"`
+	CreatedMillis int64    `json:"createdMillis"`
+	ExpiresMillis int64    `json:"expiresMillis"`
+	Peers         []string `json:"peers"`
+}
+
+// signedBootstrapList is a bootstrap list as served: List is the JSON
+// encoding of a bootstrapList, and Signature its Ed25519 signature by
+// PublicKey. The key and signature are hex encoded.
+type signedBootstrapList struct {
+	PublicKey string `json:"publicKey"`
+	Signature string `json:"signature"`
+	List      []byte `json:"list"`
+}
+
+var (
+	bootstrapKeyMtx sync.RWMutex
+	bootstrapKey    ed25519.PrivateKey
+)
+
+// setBootstrapKey sets the key signing the bootstrap lists, enabling the
+// bootstrap endpoint.
+func setBootstrapKey(key ed25519.PrivateKey) {
+	bootstrapKeyMtx.Lock()
+	defer bootstrapKeyMtx.Unlock()
+	bootstrapKey = key
+}
+
+// getBootstrapKey returns the key signing the bootstrap lists, or nil if
+// the bootstrap endpoint is disabled.
+func getBootstrapKey() ed25519.PrivateKey {
+	bootstrapKeyMtx.RLock()
+	defer bootstrapKeyMtx.RUnlock()
+	return bootstrapKey
+}
+
+// bootstrapPeers returns up to count good peers advertising all of
+// services, alternating between the A and AAAA answers a zone requiring
+// those services would give source for the peers of every subnetwork, so
+// the list is selected as DNS answers are.
+func (m *Manager) bootstrapPeers(count int, services appmessage.ServiceFlag, source net.IP) []*appmessage.NetAddress {
+	ipv4 := m.GoodAddresses(dns.TypeA, true, nil, false, services, source, count)
+	ipv6 := m.GoodAddresses(dns.TypeAAAA, true, nil, false, services, source, count)
+
+	addrs := make([]*appmessage.NetAddress, 0, count)
+	for i := 0; len(addrs) < count && (i < len(ipv4) || i < len(ipv6)); i++ {
+		if i < len(ipv4) {
+			addrs = append(addrs, ipv4[i])
+		}
+		if i < len(ipv6) && len(addrs) < count {
+			addrs = append(addrs, ipv6[i])
+		}
+	}
+	return addrs
+}
+
+// newBootstrapList returns the bootstrap list of the given peers of amgr,
+// as of now.
+func newBootstrapList(amgr *Manager, services appmessage.ServiceFlag, addrs []*appmessage.NetAddress,
+	now time.Time) *bootstrapList {
+
+	list := &bootstrapList{
+		Network:       amgr.netParams.Name,
+		Services:      uint64(services),
+		CreatedMillis: now.UnixMilli(),
+		ExpiresMillis: now.Add(bootstrapValidity).UnixMilli(),
+		Peers:         make([]string, len(addrs)),
+	}
+	for i, addr := range addrs {
+		list.Peers[i] = net.JoinHostPort(addr.IP.String(), strconv.Itoa(int(addr.Port)))
+	}
+	return list
+}
+
+// signBootstrapList encodes and signs the list with key.
+func signBootstrapList(list *bootstrapList, key ed25519.PrivateKey) (*signedBootstrapList, error) {
+	data, err := json.Marshal(list)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &signedBootstrapList{
+		PublicKey: hex.EncodeToString(key.Public().(ed25519.PublicKey)),
+		Signature: hex.EncodeToString(ed25519.Sign(key, data)),
+		List:      data,
+	}, nil
+}
+
+// handleBootstrap serves a signed list of good peers. Supported query
+// parameters are network (the primary one by default), count and services,
+// a bit field in decimal or 0x-prefixed hexadecimal that every peer must
+// advertise.
+func (s *HTTPServer) handleBootstrap(w http.ResponseWriter, r *http.Request) {
+	key := getBootstrapKey()
+	if key == nil {
+		writeError(w, http.StatusNotFound, "the bootstrap endpoint is disabled")
+		return
+	}
+
+	query := r.URL.Query()
+	amgr := s.amgr
+	if network := query.Get("network"); network != "" {
+		amgr = networkManager(network, s.amgr)
+		if amgr == nil {
+			writeError(w, http.StatusNotFound, "network "+network+" is not served")
+			return
+		}
+	}
+	count, err := intQueryParam(query.Get("count"), defaultBootstrapCount)
+	if err != nil || count <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid count")
+		return
+	}
+	if count > maxBootstrapCount {
+		count = maxBootstrapCount
+	}
+	var services uint64
+	if value := query.Get("services"); value != "" {
+		services, err = strconv.ParseUint(value, 0, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid services")
+			return
+		}
+	}
+
+	var source net.IP
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		source = net.ParseIP(host)
+	}
+	now := time.Now()
+	addrs := amgr.bootstrapPeers(count, appmessage.ServiceFlag(services), source)
+	signed, err := signBootstrapList(newBootstrapList(amgr, appmessage.ServiceFlag(services), addrs, now), key)
+	if err != nil {
+		rpcLog.Errorf("Failed to sign the bootstrap list: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to sign the bootstrap list")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, signed)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/karlsen-network/karlsend/infrastructure/config"
+)
+
+func TestBootstrap(t *testing.T) {
+	cfg := setTestConfig(t, &ConfigFlags{NetworkFlags: config.NetworkFlags{Devnet: true}})
+	err := cfg.ResolveNetwork(nil)
+	if err != nil {
+		t.Fatalf("ResolveNetwork: %s", err)
+	}
+
+	now := time.Now()
+	m := newTestManager(t, cfg.NetParams(), 1313)
+	for _, ip := range []string{"203.0.113.1", "203.0.114.2", "2001:db8::1", "203.0.113.3", "203.0.113.4"} {
+		m.nodes[ip] = &Node{
+			Addr:            appmessage.NewNetAddressIPPort(net.ParseIP(ip), 1313),
+			LastSuccess:     now,
+			ProtocolVersion: 5,
+			Services:        appmessage.SFNodeNetwork,
+		}
+	}
+	m.nodes["203.0.114.2"].Services |= appmessage.SFNodeBloom
+	m.nodes["203.0.113.3"].LastSuccess = time.Time{}
+	// Peers left out of DNS answers are left out of the bootstrap list
+	// too: partial nodes, and those not on the default port.
+	m.nodes["203.0.113.4"].Addr.Port = 1
+	m.nodes["203.0.118.1"] = &Node{
+		Addr:            appmessage.NewNetAddressIPPort(net.ParseIP("203.0.118.1"), 1313),
+		LastSuccess:     now,
+		ProtocolVersion: 5,
+	}
+	m.alwaysServe = []net.IP{net.ParseIP("192.0.2.1")}
+
+	server := NewHTTPServer(m)
+	get := func(url string, expectedStatus int) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		server.mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, url, nil))
+		if recorder.Code != expectedStatus {
+			t.Fatalf("%s: expected status %d, got %d", url, expectedStatus, recorder.Code)
+		}
+		return recorder
+	}
+	get("/v1/bootstrap", http.StatusNotFound)
+
+	_, key, _ := ed25519.GenerateKey(nil)
+	setBootstrapKey(key)
+	defer setBootstrapKey(nil)
+	get("/v1/bootstrap?count=0", http.StatusBadRequest)
+	get("/v1/bootstrap?services=nope", http.StatusBadRequest)
+
+	tests := []struct {
+		url   string
+		peers []string
+	}{
+		{"/v1/bootstrap", []string{"192.0.2.1:1313", "203.0.113.1:1313", "203.0.114.2:1313", "[2001:db8::1]:1313"}},
+		{"/v1/bootstrap?services=0x4", []string{"192.0.2.1:1313", "203.0.114.2:1313"}},
+		{"/v1/bootstrap?services=1&count=1", nil},
+	}
+	for _, test := range tests {
+		var signed signedBootstrapList
+		err := json.Unmarshal(get(test.url, http.StatusOK).Body.Bytes(), &signed)
+		if err != nil {
+			t.Fatalf("%s: Unmarshal: %s", test.url, err)
+		}
+		signature, _ := hex.DecodeString(signed.Signature)
+		if signed.PublicKey != hex.EncodeToString(key.Public().(ed25519.PublicKey)) ||
+			!ed25519.Verify(key.Public().(ed25519.PublicKey), signed.List, signature) {
+			t.Fatalf("%s: invalid signature", test.url)
+		}
+		var list bootstrapList
+		err = json.Unmarshal(signed.List, &list)
+		if err != nil {
+			t.Fatalf("%s: Unmarshal list: %s", test.url, err)
+		}
+		if list.Network != m.netParams.Name || list.ExpiresMillis <= list.CreatedMillis {
+			t.Errorf("%s: unexpected list %+v", test.url, list)
+		}
+		if test.peers == nil {
+			if len(list.Peers) != 1 {
+				t.Errorf("%s: expected a single peer, got %v", test.url, list.Peers)
+			}
+			continue
+		}
+		sort.Strings(list.Peers)
+		if len(list.Peers) != len(test.peers) {
+			t.Errorf("%s: expected peers %v, got %v", test.url, test.peers, list.Peers)
+			continue
+		}
+		for i := range list.Peers {
+			if list.Peers[i] != test.peers[i] {
+				t.Errorf("%s: expected peers %v, got %v", test.url, test.peers, list.Peers)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/karlsen-network/karlsend/app/protocol/common"
+	"github.com/pkg/errors"
+)
+
+// canaryNamePattern is what canary names may consist of, so they can be used
+// in metric names.
+var canaryNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// canary is a node checked on a tight schedule, such as a bootstrap node of
+// the network, so its availability and latency can be monitored.
+type canary struct {
+	name string
+	addr *appmessage.NetAddress
+
+	mtx                 sync.Mutex
+	checks              uint64
+	failures            uint64
+	consecutiveFailures int
+	up                  bool
+	latency             time.Duration
+	lastCheck           time.Time
+	lastError           string
+}
+
+// canaryStatus is the state of a canary as reported by the HTTP API.
+type canaryStatus struct {
+	Name                string    `json:"name"`
+	Address             string    `json:"address"`
+	Up                  bool      `json:"up"`
+	LatencyMilliseconds int64     `json:"latencyMilliseconds"`
+	Checks              uint64    `json:"checks"`
+	Failures            uint64    `json:"failures"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	LastCheck           time.Time `json:"lastCheck"`
+	LastError           string    `json:"lastError,omitempty"`
+}
+
+// parseCanaries parses canary addresses, each optionally with a port and
+// prefixed with name=. defaultPort is used when no port is given, and
+// canaries without a name are named after their address.
+func parseCanaries(entries []string, defaultPort int) ([]*canary, error) {
+	canaries := make([]*canary, 0, len(entries))
+	names := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		name, address := "", entry
+		if i := strings.Index(entry, "="); i >= 0 {
+			name, address = entry[:i], entry[i+1:]
+		}
+		addr, err := parsePeerAddress(address, defaultPort)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid canary %s", entry)
+		}
+		if name == "" {
+			name = strings.NewReplacer(".", "_", ":", "_").Replace(addr.IP.String())
+		}
+		if !canaryNamePattern.MatchString(name) {
+			return nil, errors.Errorf("invalid canary name %s: it may only contain letters, digits, _ and -", name)
+		}
+		if names[name] {
+			return nil, errors.Errorf("canary %s is configured more than once", name)
+		}
+		names[name] = true
+		canaries = append(canaries, &canary{name: name, addr: addr})
+	}
+	return canaries, nil
+}
+
+// address returns the host:port address of the canary.
+func (c *canary) address() string {
+	return net.JoinHostPort(c.addr.IP.String(), strconv.Itoa(int(c.addr.Port)))
+}
+
+// check connects to the canary and requests its addresses, and records
+// whether it answered and how long it took, leaving out the wait for the
+// connections of the crawler. Checks that couldn't be made because the
+// crawler is connected to the canary are not recorded.
+func (c *canary) check(ctx context.Context, netAdapter *crawlAdapter) {
+	now := time.Now()
+	routes, started, err := netAdapter.connectTimed(ctx, c.address())
+	if err == nil {
+		_, err = routes.RequestAddresses(common.DefaultTimeout)
+		routes.Disconnect()
+	}
+	if errors.Is(err, errHostBusy) {
+		return
+	}
+	var latency time.Duration
+	if !started.IsZero() {
+		latency = time.Since(started)
+	}
+	c.record(now, latency, err)
+}
+
+// record records the result of a check started at now.
+func (c *canary) record(now time.Time, latency time.Duration, err error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.checks++
+	c.lastCheck = now
+	if err != nil {
+		if c.up || c.checks == 1 {
+			crawlLog.Warnf("Canary %s (%s) is down: %v", c.name, c.address(), err)
+		}
+		c.failures++
+		c.consecutiveFailures++
+		c.up = false
+		c.lastError = err.Error()
+		return
+	}
+	if !c.up && c.checks > 1 {
+		crawlLog.Infof("Canary %s (%s) is up again", c.name, c.address())
+	}
+	c.consecutiveFailures = 0
+	c.up = true
+	c.latency = latency
+	c.lastError = ""
+}
+
+// status returns the current state of the canary.
+func (c *canary) status() canaryStatus {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return canaryStatus{
+		Name:                c.name,
+		Address:             c.address(),
+		Up:                  c.up,
+		LatencyMilliseconds: c.latency.Milliseconds(),
+		Checks:              c.checks,
+		Failures:            c.failures,
+		ConsecutiveFailures: c.consecutiveFailures,
+		LastCheck:           c.lastCheck,
+		LastError:           c.lastError,
+	}
+}
+
+// metrics returns the state of the canary as stats metrics. The latency is
+// that of the last successful check.
+func (c *canary) metrics() []metric {
+	status := c.status()
+	var up uint64
+	if status.Up {
+		up = 1
+	}
+	prefix := "canary_" + c.name + "_"
+	return []metric{
+		{prefix + "up", up},
+		{prefix + "latency_ms", uint64(status.LatencyMilliseconds)},
+		{prefix + "failures", status.Failures},
+	}
+}
+
+// monitorCanaries checks all canaries of network every interval, until quit
+// is closed.
+func monitorCanaries(network *seederNetwork, interval time.Duration, quit <-chan struct{}) {
+	defer wg.Done()
+
+	netAdapter, err := network.crawlAdapter()
+	if err != nil {
+		crawlLog.Errorf("Could not monitor the %s canaries: %v", network.name(), err)
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		var checks sync.WaitGroup
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		for _, c := range network.amgr.Canaries() {
+			c := c
+			checks.Add(1)
+			spawn("monitorCanaries-check", func() {
+				defer checks.Done()
+				c.check(ctx, netAdapter)
+			})
+		}
+		checks.Wait()
+		cancel()
+
+		select {
+		case <-ticker.C:
+		case <-quit:
+			return
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestParseCanaries(t *testing.T) {
+	canaries, err := parseCanaries([]string{"bootstrap=203.0.113.1", " 203.0.113.2:1414", "2001:db8::1"}, 1313)
+	if err != nil {
+		t.Fatalf("parseCanaries: %s", err)
+	}
+	expected := []struct {
+		name    string
+		address string
+	}{
+		{"bootstrap", "203.0.113.1:1313"},
+		{"203_0_113_2", "203.0.113.2:1414"},
+		{"2001_db8__1", "[2001:db8::1]:1313"},
+	}
+	for i, c := range canaries {
+		if c.name != expected[i].name || c.address() != expected[i].address {
+			t.Errorf("canary %d: expected %s at %s, got %s at %s", i,
+				expected[i].name, expected[i].address, c.name, c.address())
+		}
+	}
+
+	for _, entries := range [][]string{
+		{"bootstrap=not-an-address"},
+		{"boot.strap=203.0.113.1"},
+		{"a=203.0.113.1", "a=203.0.113.2"},
+	} {
+		_, err := parseCanaries(entries, 1313)
+		if err == nil {
+			t.Errorf("expected %v to be rejected", entries)
+		}
+	}
+}
+
+func TestCanaryRecord(t *testing.T) {
+	canaries, err := parseCanaries([]string{"bootstrap=203.0.113.1"}, 1313)
+	if err != nil {
+		t.Fatalf("parseCanaries: %s", err)
+	}
+	c := canaries[0]
+
+	c.record(time.Now(), 120*time.Millisecond, nil)
+	c.record(time.Now(), time.Second, errors.New("connection refused"))
+	c.record(time.Now(), time.Second, errors.New("connection refused"))
+	status := c.status()
+	if status.Up || status.Checks != 3 || status.Failures != 2 || status.ConsecutiveFailures != 2 ||
+		status.LatencyMilliseconds != 120 || status.LastError != "connection refused" {
+		t.Errorf("unexpected status after two failures: %+v", status)
+	}
+
+	c.record(time.Now(), 80*time.Millisecond, nil)
+	metrics := c.metrics()
+	expected := []metric{
+		{"canary_bootstrap_up", 1},
+		{"canary_bootstrap_latency_ms", 80},
+		{"canary_bootstrap_failures", 2},
+	}
+	for i, m := range expected {
+		if metrics[i] != m {
+			t.Errorf("expected metric %v, got %v", m, metrics[i])
+		}
+	}
+	if status := c.status(); status.ConsecutiveFailures != 0 || status.LastError != "" {
+		t.Errorf("expected the failures to be cleared, got %+v", status)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// Config check statuses
+const (
+	configCheckOK      = "ok"
+	configCheckWarning = "warning"
+	configCheckError   = "error"
+)
+
+// configLookupTimeout is the time allowed to each DNS lookup of check-config.
+const configLookupTimeout = 5 * time.Second
+
+// redactedSecret replaces the secrets of the configuration printed by
+// check-config.
+const redactedSecret = "REDACTED"
+
+// configCheck is the result of a check of check-config.
+type configCheck struct {
+	Check   string
+	Status  string
+	Message string `json:",omitempty"`
+}
+
+// configReport is printed by check-config.
+type configReport struct {
+	Network string
+	Config  *ConfigFlags
+	Checks  []configCheck
+}
+
+// checkConfig runs the checks parseConfig leaves out because they touch the
+// file system or the network. The zones are looked up only if lookups is
+// set; lookup failures are warnings, since the delegation of a zone may be
+// set up after the seeder is deployed.
+func checkConfig(cfg *ConfigFlags, lookups bool) []configCheck {
+	var checks []configCheck
+	add := func(check string, err error) {
+		if err != nil {
+			checks = append(checks, configCheck{Check: check, Status: configCheckError, Message: err.Error()})
+			return
+		}
+		checks = append(checks, configCheck{Check: check, Status: configCheckOK})
+	}
+	warn := func(check, message string) {
+		checks = append(checks, configCheck{Check: check, Status: configCheckWarning, Message: message})
+	}
+
+	networks := []string{cfg.NetParams().Name}
+	for _, networkCfg := range cfg.Networks {
+		networks = append(networks, networkCfg.Network)
+	}
+	checks = append(checks, configCheck{Check: "networks", Status: configCheckOK, Message: strings.Join(networks, ", ")})
+
+	if !cfg.CrawlOnly {
+		zones := cfg.AllZones()
+		for _, networkCfg := range cfg.Networks {
+			zones = append(zones, networkCfg.Zones...)
+		}
+		for _, zone := range zones {
+			check := "zone " + zone.Host
+			err := checkZoneNames(zone)
+			if err != nil || !lookups {
+				add(check, err)
+				continue
+			}
+			warning := lookupZone(zone)
+			if warning != "" {
+				warn(check, warning)
+				continue
+			}
+			add(check, nil)
+		}
+	}
+
+	listeners := []struct{ name, address string }{
+		{"listen", cfg.Listen},
+		{"grpclisten", cfg.GRPCListen},
+		{"httplisten", cfg.HTTPListen},
+	}
+	for _, listener := range listeners {
+		if listener.address == "" {
+			continue
+		}
+		_, _, err := net.SplitHostPort(listener.address)
+		add("listener "+listener.name, errors.Wrapf(err, "invalid address %s", listener.address))
+	}
+
+	add("appdir "+cfg.AppDir, checkWritableDir(cfg.AppDir))
+
+	if cfg.HTTPTLSCert != "" {
+		_, err := tls.LoadX509KeyPair(cfg.HTTPTLSCert, cfg.HTTPTLSKey)
+		add("httptlscert "+cfg.HTTPTLSCert, errors.Wrap(err, "failed to load the certificate"))
+	}
+	signingKeys := []struct{ name, path string }{
+		{"bootstrapkey", cfg.BootstrapKey},
+		{"snapshotkey", cfg.SnapshotKey},
+	}
+	for _, key := range signingKeys {
+		if key.path == "" {
+			continue
+		}
+		check := key.name + " " + key.path
+		generated, err := checkSigningKey(key.path)
+		if err == nil && generated {
+			warn(check, "missing, will be generated")
+			continue
+		}
+		add(check, err)
+	}
+	for _, path := range []string{cfg.GeoIPCity, cfg.GeoIPASN} {
+		if path == "" {
+			continue
+		}
+		db, err := openGeoIPDatabase(path)
+		if err == nil {
+			db.close()
+		}
+		add("geoip "+path, errors.Wrap(err, "failed to open the GeoIP database"))
+	}
+	if cfg.HostingRanges != "" {
+		_, err := readHostingRanges(cfg.HostingRanges)
+		add("hostingranges "+cfg.HostingRanges, err)
+	}
+	if cfg.BanList != "" {
+		_, err := readBanList(cfg.BanList)
+		add("banlist "+cfg.BanList, err)
+	}
+	if cfg.ServingIndex != "" {
+		add("servingindex "+cfg.ServingIndex, checkWritableDir(filepath.Dir(cfg.ServingIndex)))
+	}
+	if cfg.ServeFrom != "" {
+		index, err := openServingIndex(cfg.ServeFrom)
+		if err == nil {
+			index.close()
+		}
+		add("servefrom "+cfg.ServeFrom, err)
+	}
+
+	return checks
+}
+
+// checkZoneNames checks that the host and nameserver of zone are valid
+// domain names.
+func checkZoneNames(zone ZoneConfig) error {
+	for _, name := range []string{zone.Host, zone.Nameserver} {
+		if _, ok := dns.IsDomainName(name); !ok {
+			return errors.Errorf("%s is not a valid domain name", name)
+		}
+	}
+	return nil
+}
+
+// lookupZone checks that the nameserver of zone resolves and that the zone
+// is delegated to it, and returns a description of the problem if not.
+func lookupZone(zone ZoneConfig) string {
+	ctx, cancel := context.WithTimeout(context.Background(), configLookupTimeout)
+	defer cancel()
+
+	_, err := net.DefaultResolver.LookupHost(ctx, zone.Nameserver)
+	if err != nil {
+		return fmt.Sprintf("nameserver %s doesn't resolve: %s", zone.Nameserver, err)
+	}
+	nameservers, err := net.DefaultResolver.LookupNS(ctx, zone.Host)
+	if err != nil {
+		return fmt.Sprintf("failed to look up the NS records: %s", err)
+	}
+	for _, nameserver := range nameservers {
+		if strings.EqualFold(dns.Fqdn(nameserver.Host), dns.Fqdn(zone.Nameserver)) {
+			return ""
+		}
+	}
+	return fmt.Sprintf("the zone is not delegated to %s", zone.Nameserver)
+}
+
+// checkWritableDir checks that files can be created in the directory at
+// path.
+func checkWritableDir(path string) error {
+	file, err := os.CreateTemp(path, ".check-config-")
+	if err != nil {
+		return errors.Wrap(err, "the directory is not writable")
+	}
+	file.Close()
+	return errors.WithStack(os.Remove(file.Name()))
+}
+
+// checkSigningKey checks the signing key file at path without generating
+// it, and returns whether it is missing and would be generated.
+func checkSigningKey(path string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return true, checkWritableDir(filepath.Dir(path))
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "failed to read the signing key")
+	}
+	_, err = parseSigningKey(path, data)
+	return false, err
+}
+
+// redactedConfig returns a copy of cfg fit for printing, without its
+// secrets and network parameters. The alert webhook and stats backend
+// addresses are redacted too, since their URLs commonly embed a token or
+// credentials.
+func redactedConfig(cfg *ConfigFlags) *ConfigFlags {
+	redacted := *cfg
+	redacted.ActiveNetParams = nil
+	secrets := []*string{&redacted.AdminToken, &redacted.GossipToken, &redacted.PeerFeedToken, &redacted.AuditSalt,
+		&redacted.AlertWebhook, &redacted.StatsAddress}
+	for _, secret := range secrets {
+		if *secret != "" {
+			*secret = redactedSecret
+		}
+	}
+	return &redacted
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// churnMagicSpike is how many times its usual count the bad magic
+	// failures of an interval must reach to be reported.
+	churnMagicSpike = 3.0
+
+	// churnMagicSmoothing is the weight of the last interval in the usual
+	// count of bad magic failures.
+	churnMagicSmoothing = 0.2
+
+	// churnSuspicionIntervals is the number of analyses after which a
+	// suspected partition that didn't recover is resolved, and the level
+	// it settled at taken as the new baseline, so a lasting change of the
+	// network doesn't stay reported forever.
+	churnSuspicionIntervals = 6
+)
+
+// churnEvent is a suspected partition of the network: a group of peers,
+// by origin AS or country, that mostly disappeared from the good peers at
+// once, or a spike of peers answering with the magic of another network.
+type churnEvent struct {
+	Group    string    `json:"group"`
+	Since    time.Time `json:"since"`
+	Baseline int       `json:"baseline"`
+	Current  int       `json:"current"`
+}
+
+// churnSuspicion is a churn event under way, resolved by its alert rule.
+type churnSuspicion struct {
+	event churnEvent
+	rule  alertRule
+}
+
+// churnState is the churn analysis of a single network.
+type churnState struct {
+	amgr *Manager
+
+	// previous holds the number of good peers of each group at the last
+	// analysis.
+	previous map[string]int
+
+	lastBadMagic    uint64
+	badMagicAverage float64
+	badMagicStarted bool
+
+	mtx       sync.Mutex
+	suspected map[string]*churnSuspicion
+
+	// events counts the suspected partitions so far. It must be accessed
+	// atomically.
+	events uint64
+}
+
+// churnDetector periodically compares the good peers of each network,
+// grouped by origin AS and country, with those of the previous interval,
+// and the crawl failures for bad magic with their usual count. Groups
+// losing at least threshold of their peers at once, and spikes of bad
+// magic, are reported as suspected partitions through the stats, the
+// status API and the alert webhook, if any, as early warnings of network
+// incidents.
+type churnDetector struct {
+	webhook   string
+	interval  time.Duration
+	threshold float64
+	minPeers  int
+	networks  []*churnState
+
+	wg   sync.WaitGroup
+	quit chan struct{}
+}
+
+// newChurnDetector returns a churn detector for the networks of managers,
+// posting to webhook unless it is empty. Only groups of at least minPeers
+// good peers are considered.
+func newChurnDetector(webhook string, interval time.Duration, threshold float64, minPeers int,
+	managers []*Manager) *churnDetector {
+
+	d := &churnDetector{
+		webhook:   webhook,
+		interval:  interval,
+		threshold: threshold,
+		minPeers:  minPeers,
+		quit:      make(chan struct{}),
+	}
+	for _, amgr := range managers {
+		state := &churnState{amgr: amgr, suspected: make(map[string]*churnSuspicion)}
+		amgr.SetChurn(state)
+		d.networks = append(d.networks, state)
+	}
+	return d
+}
+
+// Start starts the analysis in the background.
+func (d *churnDetector) Start() {
+	d.wg.Add(1)
+	spawn("churnDetector.churnHandler", d.churnHandler)
+}
+
+// Stop stops the analysis and waits for it to finish.
+func (d *churnDetector) Stop() {
+	close(d.quit)
+	d.wg.Wait()
+}
+
+func (d *churnDetector) churnHandler() {
+	defer d.wg.Done()
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now()
+			for _, state := range d.networks {
+				badMagic := state.amgr.failures.Counts()[failureBadMagic]
+				d.analyze(state, state.amgr.goodPeerGroups(now), badMagic, now)
+			}
+		case <-d.quit:
+			return
+		}
+	}
+}
+
+// analyze compares the good peers by group, and the total of bad magic
+// failures, of a network at now with those of the previous analysis.
+func (d *churnDetector) analyze(state *churnState, groups map[string]int, badMagic uint64, now time.Time) {
+	name := state.amgr.netParams.Name
+	state.mtx.Lock()
+	defer state.mtx.Unlock()
+
+	for group, suspicion := range state.suspected {
+		if group == failureBadMagic {
+			continue
+		}
+		suspicion.event.Current = groups[group]
+		if d.evaluate(name, group, &suspicion.rule, float64(groups[group])) ||
+			d.expire(name, group, suspicion, float64(groups[group]), now) {
+			delete(state.suspected, group)
+		}
+	}
+	for group, previous := range state.previous {
+		if previous < d.minPeers || state.suspected[group] != nil {
+			continue
+		}
+		current := groups[group]
+		if float64(current) >= float64(previous)*(1-d.threshold) {
+			continue
+		}
+		suspicion := &churnSuspicion{
+			event: churnEvent{Group: group, Since: now, Baseline: previous, Current: current},
+			rule:  alertRule{threshold: float64(previous) * (1 - d.threshold)},
+		}
+		d.evaluate(name, group, &suspicion.rule, float64(current))
+		state.suspected[group] = suspicion
+		atomic.AddUint64(&state.events, 1)
+	}
+	state.previous = groups
+
+	d.analyzeBadMagic(state, badMagic, now)
+}
+
+// analyzeBadMagic reports a spike of bad magic failures since the previous
+// analysis, and keeps track of their usual count. The caller must hold the
+// state lock.
+func (d *churnDetector) analyzeBadMagic(state *churnState, badMagic uint64, now time.Time) {
+	count := float64(badMagic - state.lastBadMagic)
+	state.lastBadMagic = badMagic
+	if !state.badMagicStarted {
+		// The first count covers the whole time before the analysis
+		// started.
+		state.badMagicStarted = true
+		return
+	}
+
+	name := state.amgr.netParams.Name
+	if suspicion := state.suspected[failureBadMagic]; suspicion != nil {
+		suspicion.event.Current = int(count)
+		if d.evaluate(name, failureBadMagic, &suspicion.rule, count) {
+			delete(state.suspected, failureBadMagic)
+		} else if d.expire(name, failureBadMagic, suspicion, count, now) {
+			delete(state.suspected, failureBadMagic)
+			state.badMagicAverage = count
+		}
+		return
+	}
+	threshold := state.badMagicAverage * churnMagicSpike
+	if count >= float64(d.minPeers) && count > threshold {
+		suspicion := &churnSuspicion{
+			event: churnEvent{Group: failureBadMagic, Since: now, Baseline: int(state.badMagicAverage),
+				Current: int(count)},
+			rule: alertRule{threshold: threshold, above: true},
+		}
+		d.evaluate(name, failureBadMagic, &suspicion.rule, count)
+		state.suspected[failureBadMagic] = suspicion
+		atomic.AddUint64(&state.events, 1)
+		// Spikes don't count toward the usual count.
+		return
+	}
+	state.badMagicAverage += churnMagicSmoothing * (count - state.badMagicAverage)
+}
+
+// evaluate updates the alert rule of a suspected partition of group on the
+// network with the given name, notifies the webhook if it changed state,
+// and returns whether it got resolved.
+func (d *churnDetector) evaluate(network string, group string, rule *alertRule, value float64) bool {
+	rule.name = fmt.Sprintf("%s suspected partition (%s)", network, group)
+	if !rule.evaluate(value) {
+		return false
+	}
+	notifyAlert(d.webhook, rule, value)
+	return !rule.firing
+}
+
+// expire resolves a suspected partition of group on the network with the
+// given name that has lasted churnSuspicionIntervals analyses by now,
+// notifying the webhook, and returns whether it did.
+func (d *churnDetector) expire(network string, group string, suspicion *churnSuspicion, value float64,
+	now time.Time) bool {
+
+	if now.Sub(suspicion.event.Since) < churnSuspicionIntervals*d.interval {
+		return false
+	}
+	log.Infof("Suspected partition of %s (%s) lasted %s, taking its level as the new baseline",
+		network, group, now.Sub(suspicion.event.Since))
+	if suspicion.rule.firing {
+		suspicion.rule.firing = false
+		notifyAlert(d.webhook, &suspicion.rule, value)
+	}
+	return true
+}
+
+// Events returns the suspected partitions under way, by group.
+func (s *churnState) Events() []churnEvent {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	events := make([]churnEvent, 0, len(s.suspected))
+	for _, suspicion := range s.suspected {
+		events = append(events, suspicion.event)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Group < events[j].Group })
+	return events
+}
+
+// metrics returns the number of suspected partitions so far and under way.
+func (s *churnState) metrics() []metric {
+	s.mtx.Lock()
+	suspected := len(s.suspected)
+	s.mtx.Unlock()
+	return []metric{
+		{"churn_events", atomic.LoadUint64(&s.events)},
+		{"churn_suspected", uint64(suspected)},
+	}
+}
+
+// goodPeerGroups returns the number of good peers at now by origin AS, as
+// AS<number>, and by country, as country:<code>, as far as the GeoIP
+// databases know them.
+func (m *Manager) goodPeerGroups(now time.Time) map[string]int {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	groups := make(map[string]int)
+	for _, node := range m.nodes {
+		if !node.isGood(now) {
+			continue
+		}
+		location := lookupLocation(node.Addr.IP)
+		if location == nil {
+			continue
+		}
+		if location.ASN != 0 {
+			groups[fmt.Sprintf("AS%d", location.ASN)]++
+		}
+		if location.Country != "" {
+			groups["country:"+location.Country]++
+		}
+	}
+	return groups
+}
+
+// SetChurn sets the churn analysis of the network.
+func (m *Manager) SetChurn(state *churnState) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.churn = state
+}
+
+// Churn returns the churn analysis of the network, or nil if there is none.
+func (m *Manager) Churn() *churnState {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	return m.churn
+}
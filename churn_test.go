@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/karlsen-network/karlsend/domain/dagconfig"
+)
+
+func TestChurnDetector(t *testing.T) {
+	payloads := make(chan alertPayload, 10)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload alertPayload
+		err := json.NewDecoder(r.Body).Decode(&payload)
+		if err != nil {
+			t.Errorf("Decode: %s", err)
+		}
+		payloads <- payload
+	}))
+	defer webhook.Close()
+
+	m := newTestManager(t, &dagconfig.MainnetParams, 0)
+	d := newChurnDetector(webhook.URL, time.Minute, 0.5, 10, []*Manager{m})
+	state := m.Churn()
+	if state == nil {
+		t.Fatalf("expected the churn analysis to be set on the network")
+	}
+	// expectAlerts checks that the alerts posted so far are those of the
+	// given groups, all with the given status.
+	expectAlerts := func(status string, groups ...string) {
+		alerts := make(map[string]string)
+		for len(payloads) > 0 {
+			payload := <-payloads
+			alerts[payload.Alert] = payload.Status
+		}
+		if len(alerts) != len(groups) {
+			t.Errorf("expected %d alerts, got %v", len(groups), alerts)
+		}
+		for _, group := range groups {
+			alert := "karlsen-mainnet suspected partition (" + group + ")"
+			if alerts[alert] != status {
+				t.Errorf("expected %s to be %s, got %v", alert, status, alerts)
+			}
+		}
+	}
+
+	now := time.Now()
+	steps := []struct {
+		groups   map[string]int
+		badMagic uint64
+	}{
+		{map[string]int{"AS64500": 40, "AS64501": 5, "country:DE": 60}, 100},
+		{map[string]int{"AS64500": 38, "AS64501": 5, "country:DE": 58}, 103},
+		{map[string]int{"AS64500": 38, "AS64501": 5, "country:DE": 58}, 105},
+		// AS64500 disappears, along with most of the peers in Germany; the
+		// small AS64501 too, which is too small to be reported.
+		{map[string]int{"country:DE": 20}, 130},
+	}
+	for i, step := range steps {
+		d.analyze(state, step.groups, step.badMagic, now.Add(time.Duration(i)*time.Minute))
+	}
+	expectAlerts(alertStatusFiring, "AS64500", "country:DE", failureBadMagic)
+
+	events := state.Events()
+	if len(events) != 3 || events[0].Group != "AS64500" || events[0].Baseline != 38 || events[0].Current != 0 ||
+		events[1].Group != failureBadMagic || events[2].Group != "country:DE" {
+		t.Errorf("unexpected suspected partitions %+v", events)
+	}
+	metrics := state.metrics()
+	if metrics[0].value != 3 || metrics[1].value != 3 {
+		t.Errorf("unexpected churn metrics %v", metrics)
+	}
+
+	// The peers come back, and the bad magic spike is over.
+	d.analyze(state, map[string]int{"AS64500": 37, "country:DE": 57}, 131, now.Add(5*time.Minute))
+	expectAlerts(alertStatusResolved, "AS64500", "country:DE", failureBadMagic)
+	if events := state.Events(); len(events) != 0 {
+		t.Errorf("expected no suspected partitions, got %+v", events)
+	}
+	if metrics := state.metrics(); metrics[0].value != 3 || metrics[1].value != 0 {
+		t.Errorf("unexpected churn metrics %v", metrics)
+	}
+
+	// A lasting drop is resolved after churnSuspicionIntervals analyses,
+	// and its level becomes the new baseline.
+	lasting := map[string]int{"AS64500": 10, "country:DE": 57}
+	since := now.Add(6 * time.Minute)
+	d.analyze(state, lasting, 131, since)
+	expectAlerts(alertStatusFiring, "AS64500")
+	for i := 1; i < churnSuspicionIntervals; i++ {
+		d.analyze(state, lasting, 131, since.Add(time.Duration(i)*time.Minute))
+	}
+	if events := state.Events(); len(events) != 1 {
+		t.Errorf("expected the drop to be suspected until it expires, got %+v", events)
+	}
+	d.analyze(state, lasting, 131, since.Add(churnSuspicionIntervals*time.Minute))
+	expectAlerts(alertStatusResolved, "AS64500")
+	d.analyze(state, lasting, 131, since.Add((churnSuspicionIntervals+1)*time.Minute))
+	if events := state.Events(); len(events) != 0 {
+		t.Errorf("expected the drop to be taken as the new baseline, got %+v", events)
+	}
+}
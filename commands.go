@@ -0,0 +1,676 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/karlsen-network/karlsend/infrastructure/os/signal"
+	"github.com/pkg/errors"
+)
+
+const (
+	runCommandName         = "run"
+	dumpCommandName        = "dump"
+	banCommandName         = "ban"
+	injectCommandName      = "inject"
+	crawlNowCommandName    = "crawl-now"
+	maintenanceCommandName = "maintenance"
+	backupDBCommandName    = "backup-db"
+	restoreDBCommandName   = "restore-db"
+	statsCommandName       = "stats"
+	simulateCommandName    = "simulate"
+	loadgenCommandName     = "loadgen"
+	watchPeersCommandName  = "watch-peers"
+	checkConfigCommandName = "check-config"
+
+	// commandTimeout is the timeout for commands that talk to a running
+	// seeder.
+	commandTimeout = time.Second * 30
+)
+
+// runCommand runs the seeder. It is the default command.
+type runCommand struct{}
+
+// dumpCommand prints the peers database.
+type dumpCommand struct {
+	Good    bool `long:"good" description:"Only dump good peers"`
+	History bool `long:"history" description:"Include the recent crawl attempts of each peer, newest first"`
+}
+
+// banCommand bans or unbans an address on a running seeder.
+type banCommand struct {
+	Reason   string        `long:"reason" description:"Reason for the ban"`
+	Duration time.Duration `long:"duration" description:"Ban duration (permanent if not set)"`
+	Unban    bool          `long:"unban" description:"Lift the ban instead of adding it"`
+	Args     struct {
+		Address string `positional-arg-name:"address|cidr" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+// injectCommand adds a peer to a running seeder.
+type injectCommand struct {
+	Network string        `long:"network" description:"Network to add the peer to (the primary network if not set)"`
+	Good    time.Duration `long:"good" description:"Serve the peer as good for this long, whatever its crawl state"`
+	Args    struct {
+		Address string `positional-arg-name:"address[:port]" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+// crawlNowCommand connects to a peer through a running seeder and prints
+// what it sent.
+type crawlNowCommand struct {
+	Network string `long:"network" description:"Network of the peer (the primary network if not set)"`
+	Args    struct {
+		Address string `positional-arg-name:"address[:port]" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+// maintenanceCommand pauses or resumes crawling, and switches maintenance
+// mode, on a running seeder.
+type maintenanceCommand struct {
+	Crawl string `long:"crawl" description:"Pause or resume outbound crawling" choice:"pause" choice:"resume"`
+	Serve string `long:"serve" description:"Serve only the always-served addresses (static), or all good peers (normal)" choice:"static" choice:"normal"`
+}
+
+// backupDBCommand saves a snapshot of the peers database of a running
+// seeder to a file.
+type backupDBCommand struct {
+	Network string `long:"network" description:"Network to back up (the primary network if not set)"`
+	Args    struct {
+		File string `positional-arg-name:"file" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+// restoreDBCommand replaces the peers database of a running seeder with a
+// snapshot saved by backupDBCommand.
+type restoreDBCommand struct {
+	Network string `long:"network" description:"Network to restore (the primary network if not set)"`
+	Args    struct {
+		File string `positional-arg-name:"file" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+// statsCommand prints the status of a running seeder, or merges the status
+// dumps of several sites.
+type statsCommand struct {
+	Args struct {
+		Files []string `positional-arg-name:"file"`
+	} `positional-args:"yes"`
+}
+
+// simulateCommand replays recorded crawl attempts through the address
+// manager and answer selection, and prints what was served.
+type simulateCommand struct {
+	QueryInterval time.Duration `long:"queryinterval" default:"1m" description:"Simulated time between queries, each answered for IPv4 and IPv6"`
+	Top           int           `long:"top" default:"10" description:"Number of most served peers to report"`
+	Args          struct {
+		Files []string `positional-arg-name:"file" required:"1"`
+	} `positional-args:"yes"`
+}
+
+// loadgenCommand sends DNS queries to a seeder and runs fake nodes for it to
+// crawl, and prints the throughput and latency it measured.
+type loadgenCommand struct {
+	Target      string        `long:"target" description:"DNS address:port to query (the configured --listen address if empty)"`
+	Zone        string        `long:"zone" description:"Name to query (the configured --host if empty)"`
+	QPS         float64       `long:"qps" default:"1000" description:"DNS queries per second (no DNS load if 0)"`
+	Concurrency int           `long:"concurrency" default:"64" description:"Maximum number of DNS queries in flight"`
+	Nodes       int           `long:"nodes" default:"0" description:"Number of fake P2P nodes to run for the seeder to crawl"`
+	NodeBase    string        `long:"nodebase" description:"Local, non-loopback address the first fake node listens on, the others listening on the following ones"`
+	Duration    time.Duration `long:"duration" default:"1m" description:"Time to generate load for"`
+}
+
+// watchPeersCommand prints the changes of the good peers of a running
+// seeder as they happen.
+type watchPeersCommand struct {
+	Network string `long:"network" description:"Network to watch (every served network if empty)"`
+}
+
+// checkConfigCommand validates the configuration and prints the effective
+// one.
+type checkConfigCommand struct {
+	Offline bool `long:"offline" description:"Don't look up the zones in the DNS"`
+}
+
+// addCommands registers all subcommands on the given parser. Commands are
+// not executed by the parser; instead the chosen one is stored in chosen,
+// which is set to the run command when none is given.
+func addCommands(parser *flags.Parser, chosen *flags.Commander) error {
+	commands := []struct {
+		name, short, long string
+		data              interface{}
+	}{
+		{runCommandName, "Run the seeder (default)", "Crawl the network and serve DNS requests.", &runCommand{}},
+		{dumpCommandName, "Dump the peers database", "Print the peers database as one JSON record per line.", &dumpCommand{}},
+		{banCommandName, "Ban an address on a running seeder", "Ban or unban an IP address or CIDR network through the admin API.", &banCommand{}},
+		{injectCommandName, "Add a peer to a running seeder", "Queue an address to be crawled immediately through the admin API, optionally serving it as good for a while.", &injectCommand{}},
+		{crawlNowCommandName, "Crawl an address on a running seeder", "Connect to an address right away through the admin API and print what the peer sent, without recording it.", &crawlNowCommand{}},
+		{maintenanceCommandName, "Control crawling and serving of a running seeder", "Pause or resume crawling, and enter or leave maintenance mode, through the admin API.", &maintenanceCommand{}},
+		{backupDBCommandName, "Back up the peers database of a running seeder", "Save a consistent snapshot of the peers database to a file through the admin API.", &backupDBCommand{}},
+		{restoreDBCommandName, "Restore the peers database of a running seeder", "Replace the peers database with a snapshot saved by backup-db through the admin API.", &restoreDBCommand{}},
+		{statsCommandName, "Show the status of a running seeder", "Print the status reported by the HTTP API, or merge status dumps saved from several sites, such as the points of presence of an anycast deployment, into one view of the network.", &statsCommand{}},
+		{simulateCommandName, "Replay recorded crawls offline", "Replay crawl records or a synthetic topology through the address manager and answer selection, without any network I/O, and print what was served.", &simulateCommand{}},
+		{loadgenCommandName, "Generate load against a seeder", "Send DNS queries at a fixed rate to a seeder and/or run fake P2P nodes for it to crawl, then print throughput and latency percentiles.", &loadgenCommand{}},
+		{watchPeersCommandName, "Follow the good peers of a running seeder", "Print the good peers, then every peer becoming good or bad, as JSON lines through the gRPC peer feed, until interrupted.", &watchPeersCommand{}},
+		{checkConfigCommandName, "Validate the configuration", "Load and validate the configuration, then exit.", &checkConfigCommand{}},
+	}
+	for _, command := range commands {
+		_, err := parser.AddCommand(command.name, command.short, command.long, command.data)
+		if err != nil {
+			return err
+		}
+	}
+	parser.SubcommandsOptional = true
+	parser.CommandHandler = func(command flags.Commander, _ []string) error {
+		if command == nil {
+			command = &runCommand{}
+		}
+		*chosen = command
+		return nil
+	}
+	return nil
+}
+
+// Execute runs the seeder.
+func (c *runCommand) Execute(_ []string) error {
+	return run(ActiveConfig())
+}
+
+// Execute prints the peers database.
+func (c *dumpCommand) Execute(_ []string) error {
+	filePath := filepath.Join(ActiveConfig().AppDir, peersFilename)
+	file, _, err := readPeersFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	err = initGeoIP(ActiveConfig().GeoIPCity, ActiveConfig().GeoIPASN)
+	if err != nil {
+		return err
+	}
+	defer closeGeoIP()
+
+	if ActiveConfig().HostingRanges != "" {
+		ranges, err := readHostingRanges(ActiveConfig().HostingRanges)
+		if err != nil {
+			return err
+		}
+		setHostingRanges(ranges)
+	}
+
+	now := time.Now()
+	nodes := (&Manager{nodes: file.Nodes}).Nodes()
+	enc := json.NewEncoder(os.Stdout)
+	for _, node := range nodes {
+		if c.Good && !node.isGood(now) {
+			continue
+		}
+		record := newPeerRecord(&node, now)
+		if c.History {
+			record.History = newCrawlAttemptRecords(node.History)
+		}
+		err := enc.Encode(record)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Execute bans or unbans an address through the admin API.
+func (c *banCommand) Execute(_ []string) error {
+	cfg := ActiveConfig()
+	if cfg.AdminToken == "" {
+		return errors.New("the admin token must be configured (--admintoken)")
+	}
+
+	client, err := NewAdminClient(cfg.GRPCListen, cfg.AdminToken)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	if c.Unban {
+		response, err := client.UnbanAddress(ctx, &UnbanAddressRequest{Address: c.Args.Address})
+		if err != nil {
+			return err
+		}
+		if !response.WasBanned {
+			fmt.Printf("%s was not banned\n", c.Args.Address)
+			return nil
+		}
+		fmt.Printf("Unbanned %s\n", c.Args.Address)
+		return nil
+	}
+
+	response, err := client.BanAddress(ctx, &BanAddressRequest{
+		Address:         c.Args.Address,
+		Reason:          c.Reason,
+		DurationSeconds: int64(c.Duration / time.Second),
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Banned %s: %d nodes removed\n", c.Args.Address, response.RemovedNodes)
+	return nil
+}
+
+// Execute adds a peer through the admin API.
+func (c *injectCommand) Execute(_ []string) error {
+	cfg := ActiveConfig()
+	if cfg.AdminToken == "" {
+		return errors.New("the admin token must be configured (--admintoken)")
+	}
+
+	client, err := NewAdminClient(cfg.GRPCListen, cfg.AdminToken)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	_, err = client.InjectPeer(ctx, &InjectPeerRequest{
+		Network:       c.Network,
+		Address:       c.Args.Address,
+		Good:          c.Good > 0,
+		ExpirySeconds: int64(c.Good / time.Second),
+	})
+	if err != nil {
+		return err
+	}
+	if c.Good > 0 {
+		fmt.Printf("Injected %s as good for %s\n", c.Args.Address, c.Good)
+		return nil
+	}
+	fmt.Printf("Queued %s to be crawled\n", c.Args.Address)
+	return nil
+}
+
+// Execute crawls a peer through the admin API and prints the result as
+// JSON.
+func (c *crawlNowCommand) Execute(_ []string) error {
+	cfg := ActiveConfig()
+	if cfg.AdminToken == "" {
+		return errors.New("the admin token must be configured (--admintoken)")
+	}
+
+	client, err := NewAdminClient(cfg.GRPCListen, cfg.AdminToken)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	response, err := client.CrawlNow(ctx, &CrawlNowRequest{Network: c.Network, Address: c.Args.Address})
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(response)
+}
+
+// Execute pauses or resumes crawling, and switches maintenance mode,
+// through the admin API.
+func (c *maintenanceCommand) Execute(_ []string) error {
+	cfg := ActiveConfig()
+	if cfg.AdminToken == "" {
+		return errors.New("the admin token must be configured (--admintoken)")
+	}
+	if c.Crawl == "" && c.Serve == "" {
+		return errors.New("nothing to do: pass --crawl or --serve")
+	}
+
+	client, err := NewAdminClient(cfg.GRPCListen, cfg.AdminToken)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	if c.Crawl != "" {
+		_, err = client.SetCrawlPaused(ctx, &SetCrawlPausedRequest{Paused: c.Crawl == "pause"})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Crawling %sd\n", c.Crawl)
+	}
+	if c.Serve != "" {
+		_, err = client.SetMaintenance(ctx, &SetMaintenanceRequest{Enabled: c.Serve == "static"})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Serving %s answers\n", c.Serve)
+	}
+	return nil
+}
+
+// Execute saves a snapshot of the peers database through the admin API.
+func (c *backupDBCommand) Execute(_ []string) error {
+	cfg := ActiveConfig()
+	if cfg.AdminToken == "" {
+		return errors.New("the admin token must be configured (--admintoken)")
+	}
+
+	client, err := NewAdminClient(cfg.GRPCListen, cfg.AdminToken)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	// Write to a temporary file so an interrupted backup doesn't replace
+	// an earlier one.
+	tmpFile := c.Args.File + ".new"
+	file, err := os.Create(tmpFile)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	err = client.BackupDB(ctx, &BackupDBRequest{Network: c.Network}, file)
+	closeErr := file.Close()
+	if err == nil && closeErr != nil {
+		err = errors.WithStack(closeErr)
+	}
+	if err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+	return errors.WithStack(os.Rename(tmpFile, c.Args.File))
+}
+
+// Execute replaces the peers database with a snapshot through the admin
+// API.
+func (c *restoreDBCommand) Execute(_ []string) error {
+	cfg := ActiveConfig()
+	if cfg.AdminToken == "" {
+		return errors.New("the admin token must be configured (--admintoken)")
+	}
+
+	file, err := os.Open(c.Args.File)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer file.Close()
+
+	client, err := NewAdminClient(cfg.GRPCListen, cfg.AdminToken)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	response, err := client.RestoreDB(ctx, c.Network, file)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Restored %d nodes and %d bans of %s\n", response.RestoredNodes, response.RestoredBans,
+		response.Network)
+	return nil
+}
+
+// Execute prints the status reported by the HTTP API, or the merged status
+// dumps if any are given.
+func (c *statsCommand) Execute(_ []string) error {
+	if len(c.Args.Files) > 0 {
+		statuses := make([]*statusResponse, len(c.Args.Files))
+		for i, path := range c.Args.Files {
+			status, err := readStatusDump(path)
+			if err != nil {
+				return err
+			}
+			statuses[i] = status
+		}
+		merged, err := mergeStatuses(statuses)
+		if err != nil {
+			return err
+		}
+		return printJSON(os.Stdout, merged)
+	}
+
+	cfg := ActiveConfig()
+	if cfg.HTTPListen == "" {
+		return errors.New("the HTTP API must be enabled (--httplisten)")
+	}
+
+	client := http.Client{Timeout: commandTimeout}
+	resp, err := client.Get("http://" + cfg.HTTPListen + "/v1/status")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var status statusResponse
+	err = json.NewDecoder(resp.Body).Decode(&status)
+	if err != nil {
+		return err
+	}
+	return printJSON(os.Stdout, status)
+}
+
+// Execute replays the crawl records with the configured scoring, diversity
+// and garbage collection settings.
+func (c *simulateCommand) Execute(_ []string) error {
+	cfg := ActiveConfig()
+	if c.QueryInterval <= 0 {
+		return errors.New("the query interval must be positive")
+	}
+
+	netParams := cfg.NetParams()
+	defaultPort, err := strconv.Atoi(netParams.DefaultPort)
+	if err != nil {
+		return errors.Wrapf(err, "invalid default port of %s", netParams.Name)
+	}
+	scorer, err := lookupScorer(cfg.Scorer)
+	if err != nil {
+		return err
+	}
+
+	err = initGeoIP(cfg.GeoIPCity, cfg.GeoIPASN)
+	if err != nil {
+		return err
+	}
+	defer closeGeoIP()
+
+	if cfg.HostingRanges != "" {
+		ranges, err := readHostingRanges(cfg.HostingRanges)
+		if err != nil {
+			return err
+		}
+		setHostingRanges(ranges)
+	}
+
+	events, err := readCrawlEvents(c.Args.Files, netParams.Name)
+	if err != nil {
+		return err
+	}
+	if len(events) == 0 {
+		return errors.Errorf("no crawl records of %s", netParams.Name)
+	}
+
+	sim := newSimulation(netParams, uint16(defaultPort), scorer, c.QueryInterval,
+		cfg.GCDemoteAfter, cfg.GCGoodRetention, cfg.GCUnreachableRetention)
+	for _, event := range events {
+		err := sim.replay(event)
+		if err != nil {
+			return err
+		}
+	}
+	return printJSON(os.Stdout, sim.finish(c.Top))
+}
+
+// Execute generates DNS and crawl load for the configured duration, or
+// until interrupted, and prints what was measured as JSON.
+func (c *loadgenCommand) Execute(_ []string) error {
+	cfg := ActiveConfig()
+	if c.QPS < 0 || c.Nodes < 0 {
+		return errors.New("the query rate and number of nodes must not be negative")
+	}
+	if c.QPS == 0 && c.Nodes == 0 {
+		return errors.New("nothing to do: set --qps and/or --nodes")
+	}
+	if c.Concurrency <= 0 {
+		return errors.New("the concurrency must be positive")
+	}
+	if c.Duration <= 0 {
+		return errors.New("the duration must be positive")
+	}
+	target := c.Target
+	if target == "" {
+		target = cfg.Listen
+	}
+	zone := c.Zone
+	if zone == "" {
+		zone = cfg.Host
+	}
+	if c.QPS > 0 && zone == "" {
+		return errors.New("please specify the name to query (--zone or --host)")
+	}
+
+	report := struct {
+		Duration string          `json:"duration"`
+		DNS      *dnsLoadReport  `json:"dns,omitempty"`
+		Nodes    *nodeLoadReport `json:"nodes,omitempty"`
+	}{}
+
+	var nodes *fakeNodeNetwork
+	if c.Nodes > 0 {
+		base := net.ParseIP(c.NodeBase)
+		if base == nil {
+			return errors.New("please specify the local address of the first fake node (--nodebase)")
+		}
+		// Seeders never learn loopback addresses, so the nodes couldn't be
+		// crawled there.
+		if base.IsLoopback() {
+			return errors.Errorf("the fake nodes can't listen on the loopback address %s", base)
+		}
+		if ip4 := base.To4(); ip4 != nil {
+			base = ip4
+		}
+		hosts := make([]net.IP, c.Nodes)
+		for i := range hosts {
+			hosts[i] = base
+			base = nextIP(base)
+		}
+		var err error
+		nodes, err = startFakeNodeNetwork(cfg.NetParams(), hosts)
+		if err != nil {
+			return err
+		}
+		defer nodes.stop()
+		fmt.Fprintf(os.Stderr, "Started %d fake nodes, point the seeder at %s (--peers)\n",
+			c.Nodes, nodes.nodes[0].Address)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.Duration)
+	defer cancel()
+	interrupt := signal.InterruptListener()
+	spawn("loadgenCommand-interrupt", func() {
+		select {
+		case <-interrupt:
+			cancel()
+		case <-ctx.Done():
+		}
+	})
+
+	start := time.Now()
+	if c.QPS > 0 {
+		report.DNS = runDNSLoad(ctx, target, zone, c.QPS, c.Concurrency)
+	} else {
+		<-ctx.Done()
+	}
+	report.Duration = time.Since(start).Round(time.Millisecond).String()
+	if nodes != nil {
+		report.Nodes = nodes.report()
+	}
+	return printJSON(os.Stdout, report)
+}
+
+// Execute prints the events of the peer feed as JSON lines until
+// interrupted.
+func (c *watchPeersCommand) Execute(_ []string) error {
+	cfg := ActiveConfig()
+	client, err := NewPeerFeedClient(cfg.GRPCListen, cfg.PeerFeedToken)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupt := signal.InterruptListener()
+	spawn("watchPeersCommand-interrupt", func() {
+		select {
+		case <-interrupt:
+			cancel()
+		case <-ctx.Done():
+		}
+	})
+
+	encoder := json.NewEncoder(os.Stdout)
+	err = client.SubscribePeers(ctx, &SubscribePeersRequest{Network: c.Network}, func(event *PeerEvent) error {
+		return encoder.Encode(event)
+	})
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// Execute checks what loadConfig, which already parsed and validated the
+// configuration, leaves out, and prints the effective configuration along
+// with the result of every check. It fails if any check does.
+func (c *checkConfigCommand) Execute(_ []string) error {
+	cfg := ActiveConfig()
+	checks := checkConfig(cfg, !c.Offline)
+	err := printJSON(os.Stdout, &configReport{
+		Network: cfg.NetParams().Name,
+		Config:  redactedConfig(cfg),
+		Checks:  checks,
+	})
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, check := range checks {
+		if check.Status == configCheckError {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return errors.Errorf("%d configuration checks failed", failed)
+	}
+	fmt.Fprintln(os.Stderr, "Configuration OK")
+	return nil
+}
+
+func printJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -11,9 +11,12 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/karlsen-network/karlsend/infrastructure/config"
 
+	"github.com/karlsen-network/dnsseeder/seeddns"
 	"github.com/karlsen-network/dnsseeder/version"
 	"github.com/pkg/errors"
 
@@ -22,12 +25,49 @@ import (
 )
 
 const (
-	defaultConfigFilename = "dnsseeder.conf"
-	defaultLogFilename    = "dnsseeder.log"
-	defaultErrLogFilename = "dnsseeder_err.log"
-	defaultListenPort     = "5354"
-	defaultGrpcListenPort = "3737"
-	defaultLogLevel       = "info"
+	defaultConfigFilename    = "dnsseeder.conf"
+	defaultLogFilename       = "dnsseeder.log"
+	defaultErrLogFilename    = "dnsseeder_err.log"
+	defaultListenPort        = "5354"
+	defaultGrpcListenPort    = "3737"
+	defaultLogLevel          = "info"
+	defaultLogMaxSize        = 100
+	defaultLogMaxRolls       = 8
+	defaultStatsPrefix       = "dnsseeder"
+	defaultStatsInterval     = time.Minute
+	defaultCrawlInterval     = time.Hour
+	defaultGossipInterval    = time.Minute * 5
+	defaultSnapshotInterval  = time.Minute * 10
+	defaultMinReadyPeers     = 1
+	defaultAlertInterval     = time.Minute
+	defaultChurnThreshold    = 0.5
+	defaultChurnMinPeers     = 10
+	defaultCanaryInterval    = time.Second * 30
+	defaultKeepaliveInterval = time.Minute
+	defaultDNSTTL            = seeddns.DefaultTTL
+	defaultDNSRateBurst      = 20
+	defaultDNSAmplification  = 20
+	defaultMaxAnswerCount    = seeddns.DefaultMaxAnswerCount
+	defaultMaxPerNetgroup    = 1
+	defaultCrawlMaxPerSubnet = 4
+	defaultCrawlWarmup       = time.Minute * 10
+	defaultCrawlWarmupRate   = 20
+	defaultCrawlHistory      = 10
+
+	defaultExperimentShare = 0.1
+
+	defaultReliabilityPromote = 0.6
+	defaultReliabilityDemote  = 0.3
+
+	defaultBlocklistInterval = time.Hour
+
+	defaultServingIndexInterval = time.Second * 10
+
+	defaultTraceSampleRatio = 1.0
+
+	defaultGCDemoteAfter          = time.Hour * 8
+	defaultGCGoodRetention        = time.Hour * 24 * 7
+	defaultGCUnreachableRetention = time.Hour * 8
 )
 
 var (
@@ -37,27 +77,248 @@ var (
 	defaultConfigFile = filepath.Join(DefaultAppDir, defaultConfigFilename)
 )
 
-var activeConfig *ConfigFlags
+var (
+	activeConfig    *ConfigFlags
+	activeConfigMtx sync.RWMutex
+)
 
-// ActiveConfig returns the active configuration struct
+// ActiveConfig returns the active configuration struct. The returned struct
+// must not be modified; a reload replaces it as a whole.
 func ActiveConfig() *ConfigFlags {
+	activeConfigMtx.RLock()
+	defer activeConfigMtx.RUnlock()
+
 	return activeConfig
 }
 
+func setActiveConfig(cfg *ConfigFlags) {
+	activeConfigMtx.Lock()
+	defer activeConfigMtx.Unlock()
+
+	activeConfig = cfg
+}
+
+// ZoneConfig describes a DNS zone served by the seeder
+type ZoneConfig struct {
+	Host       string `yaml:"host"`
+	Nameserver string `yaml:"nameserver"`
+
+	// Family restricts the peers served to an address family: zoneFamilyIPv4
+	// or zoneFamilyIPv6. Both families are served if it is empty or
+	// zoneFamilyMixed.
+	Family string `yaml:"family"`
+
+	// Services is the mask of the service flags a peer must advertise to be
+	// served, outside of the partial nodes subdomain. Peers never reached,
+	// whose services are unknown, are served whatever it is.
+	Services uint64 `yaml:"services"`
+
+	// QPS, if not zero, is the maximum number of queries per second
+	// answered for the zone, whatever their sources.
+	QPS float64 `yaml:"qps"`
+}
+
+const (
+	zoneFamilyIPv4  = "ipv4"
+	zoneFamilyIPv6  = "ipv6"
+	zoneFamilyMixed = "mixed"
+)
+
 // ConfigFlags holds the configurations set by the command line argument
 type ConfigFlags struct {
+	ConfigFile  string `short:"C" long:"configfile" description:"Path to configuration file (YAML if it ends with .yaml or .yml, INI otherwise)"`
 	AppDir      string `short:"b" long:"appdir" description:"Directory to store data"`
 	KnownPeers  string `short:"p" long:"peers" description:"List of already known peer addresses"`
 	ShowVersion bool   `short:"V" long:"version" description:"Display version information and exit"`
 	Host        string `short:"H" long:"host" description:"Seed DNS address"`
 	Listen      string `long:"listen" short:"l" description:"Listen on address:port"`
 	Nameserver  string `short:"n" long:"nameserver" description:"hostname of nameserver"`
+	HostFamily  string `long:"hostfamily" description:"Address family of the peers served for the seed DNS address (both if not set)" choice:"ipv4" choice:"ipv6" choice:"mixed"`
 	Seeder      string `short:"s" long:"default-seeder" description:"IP address of a working node, optionally with a port specifier"`
+	AlwaysServe string `long:"alwaysserve" description:"Comma separated IP addresses of trusted nodes included in every answer, whatever their crawl state"`
 	Profile     string `long:"profile" description:"Enable HTTP profiling on given port -- NOTE port must be between 1024 and 65536"`
 	GRPCListen  string `long:"grpclisten" description:"Listen gRPC requests on address:port"`
+	AdminToken  string `long:"admintoken" description:"Token required to call the gRPC admin service (admin service disabled if empty)"`
+	GossipPeers string `long:"gossippeers" description:"Comma separated gRPC addresses (host:port) of cooperating seeders to exchange good peers with"`
+	GossipToken string `long:"gossiptoken" description:"Token shared by cooperating seeders to authenticate peer exchange (gossip disabled if empty)"`
+	HTTPListen  string `long:"httplisten" description:"Listen for HTTP API requests on address:port (disabled if empty)"`
+	HTTPTLSCert string `long:"httptlscert" description:"PEM certificate file to serve the HTTP API over HTTPS with, requires --httptlskey"`
+	HTTPTLSKey  string `long:"httptlskey" description:"PEM key file of --httptlscert"`
 	NoLogFiles  bool   `long:"nologfiles" description:"Disable logging to file"`
-	LogLevel    string `long:"loglevel" description:"Loglevel for stdout (console). Default: info"`
+	LogLevel    string `long:"loglevel" description:"Logging level for all subsystems {trace, debug, info, warn, error, critical} -- You may also specify <subsystem>=<level>,<subsystem2>=<level>,... to set the log level for individual subsystems (SEED, DNS, CRWL, AMGR, RPC)"`
+	BanList     string `long:"banlist" description:"File listing banned addresses or CIDR networks, one per line, optionally followed by a reason"`
+	Blocklists  string `long:"blocklists" description:"Comma separated URLs of blocklists merged into the bans, either one address or CIDR network per line or a JSON array"`
+	Harvest     bool   `long:"harvest" description:"Accept inbound P2P connections on the default port of each network, and crawl the nodes that connect"`
+	CrawlOnly   bool   `long:"crawl-only" description:"Crawl the network and record the peers without serving DNS, e.g. to collect census data"`
+	CheckDB     bool   `long:"check-db" description:"Check the peers database, report whether it needs migrating, and exit"`
+
+	HostServices uint64 `long:"hostservices" description:"Mask of the service flags peers must advertise to be served for the seed DNS address, such as 1 for full nodes (any if not set)"`
+
+	Site     string `long:"site" description:"Label of the site, such as the anycast point of presence, the seeder runs at, carried through the stats, JSON logs, gossip and the CHAOS identity answer"`
+	Instance string `long:"instance" description:"Label of the seeder among those of its site, carried along with --site"`
+
+	HostQPS float64 `long:"hostqps" description:"Maximum number of queries per second answered for the seed DNS address, whatever their sources, so a busy zone can't starve the others (0 for no limit)"`
+
+	BlocklistInterval time.Duration `long:"blocklistinterval" description:"Interval between fetches of the blocklists"`
+
+	LogFormat   string        `long:"logformat" description:"Format of the log output" choice:"text" choice:"json"`
+	LogMaxSize  int64         `long:"logmaxsize" description:"Size in megabytes at which log files are rolled"`
+	LogMaxRolls int           `long:"logmaxrolls" description:"Number of rolled log files to keep"`
+	LogMaxAge   time.Duration `long:"logmaxage" description:"Remove rolled log files older than this (0 keeps them until the roll limit is reached)"`
+
+	MinReadyPeers int `long:"min-ready-peers" description:"Number of good peers each network needs before the seeder reports itself ready on /readyz"`
+
+	GossipInterval time.Duration `long:"gossipinterval" description:"Interval between peer exchanges with each cooperating seeder"`
+
+	PeerFeedToken string `long:"peerfeedtoken" description:"Token required to subscribe to the gRPC peer feed (open to all if empty)"`
+
+	BootstrapKey string `long:"bootstrapkey" description:"File holding the Ed25519 key signing the peer lists served on the HTTP API at /v1/bootstrap, generated if missing (endpoint disabled if empty)"`
+
+	SnapshotKey         string        `long:"snapshotkey" description:"File holding the Ed25519 key signing the snapshots of good peers published on the HTTP API, generated if missing (publishing disabled if empty)"`
+	SnapshotSources     string        `long:"snapshotsources" description:"Comma separated snapshot URLs (http://host:port/v1/snapshot) of other seeders to merge good peers from"`
+	SnapshotTrustedKeys string        `long:"snapshottrustedkeys" description:"Comma separated hex encoded Ed25519 public keys of the seeders whose snapshots are trusted"`
+	SnapshotInterval    time.Duration `long:"snapshotinterval" description:"Interval between snapshots published, and between fetches of each snapshot source"`
+	SnapshotWeight      float64       `long:"snapshotweight" description:"Weight in answers of the peers only known from snapshots, relative to those reached by the seeder itself, between 0 and 1"`
+
+	CrawlInterval time.Duration `long:"crawlinterval" description:"Interval between crawls of the same node; nodes not reached within it are not served"`
+	DNSTTL        uint32        `long:"ttl" description:"TTL of the address records served"`
+	Membership    bool          `long:"membership" description:"Answer DNSBL-style queries for <reversed address>.known.<zone> with 127.0.0.2 if the address is a good peer"`
+	PeerStatus    bool          `long:"peerstatus" description:"Serve the status of each known peer as TXT records under <address>.status.<zone>, with dashes instead of dots or colons"`
+
+	PublicResolvers string `long:"publicresolvers" description:"Comma separated addresses or CIDR networks the big public resolvers query from, whose answers get --resolverttl"`
+	ResolverTTL     uint32 `long:"resolverttl" description:"TTL of the address records served to the public resolvers, whose caches are shared by many clients (--ttl if 0)"`
+	ECSTTL          uint32 `long:"ecsttl" description:"TTL of the address records served to queries with an EDNS client subnet option, whoever sends them (the TTL of the client otherwise if 0)"`
+
+	MaxServedAge time.Duration `long:"maxservedage" description:"Only serve peers reached within this long, crawling good peers again within half of it (0 for no limit)"`
+
+	CrawlRate         float64       `long:"crawlrate" description:"Maximum number of new outbound crawl connections per second, across all networks (0 for no limit)"`
+	CrawlNetgroupRate float64       `long:"crawlnetgrouprate" description:"Maximum number of new outbound crawl connections per second to the same /16 (IPv4) or /32 (IPv6) network (0 for no limit)"`
+	CrawlRecontact    time.Duration `long:"crawlrecontact" description:"Minimum interval between outbound crawl connections to the same host (0 for no limit)"`
+	CrawlMaxPerSubnet int           `long:"crawlmaxpersubnet" description:"Maximum number of simultaneous crawl connections to the same /24 (IPv4) or /48 (IPv6) network; hosts never get more than one (0 for no limit)"`
+
+	CrawlWarmup     time.Duration `long:"crawlwarmup" description:"Time after startup over which the rate of new crawl connections ramps up, with the peers that were good crawled first (0 disables)"`
+	CrawlWarmupRate float64       `long:"crawlwarmuprate" description:"Rate of new crawl connections per second reached at the end of the warm-up, unless --crawlrate is set"`
+
+	KeepalivePeers    int           `long:"keepalivepeers" description:"Number of the most served peers of each network kept connected and pinged every --keepaliveinterval instead of being crawled again (0 disables)"`
+	KeepaliveInterval time.Duration `long:"keepaliveinterval" description:"Interval between pings of the kept peers"`
+
+	BootstrapProxy string `long:"bootstrapproxy" description:"SOCKS5 proxy, such as Tor, the DNS seeds and --seeder are resolved through instead of the host resolver, with the RESOLVE extension of Tor unless --bootstrapdoh is set; crawl connections don't go through it (eg. 127.0.0.1:9050)"`
+	BootstrapDoH   string `long:"bootstrapdoh" description:"DNS-over-HTTPS resolver URL the DNS seeds and --seeder are resolved with instead of the host resolver, reached through --bootstrapproxy if set"`
+
+	PeerGraph          bool `long:"peergraph" description:"Record which peers advertised which addresses, served as an edge list on the HTTP API at /v1/graph"`
+	PeerGraphAnonymize bool `long:"peergraphanonymize" description:"Only export the peer graph with the addresses replaced by salted hashes"`
+
+	CrawlWorkers int `long:"crawlworkers" description:"Maximum number of simultaneous crawls of the primary network (0 for no limit)"`
+	MaxPeers     int `long:"maxpeers" description:"Maximum number of peers known for the primary network; addresses learned beyond it are dropped until old peers are removed (0 for no limit)"`
+
+	CrawlHistory int `long:"crawlhistory" description:"Number of recent crawl attempts kept for each peer, with their outcome, latency and advertised tip, served on /v1/nodes/<ip> and by dump --history (0 disables)"`
+
+	ReliabilityHalfLife time.Duration `long:"reliabilityhalflife" description:"Decide which peers are good from a reliability estimate of their crawls, each weighing half as much after this long, instead of their last success alone (0 disables)"`
+	ReliabilityPromote  float64       `long:"reliabilitypromote" description:"Reliability estimate, between 0 and 1, above which a peer becomes good, requires --reliabilityhalflife"`
+	ReliabilityDemote   float64       `long:"reliabilitydemote" description:"Reliability estimate, below the promotion threshold, under which a good peer stops being good, requires --reliabilityhalflife"`
+
+	Canaries       string        `long:"canaries" description:"Comma separated addresses, optionally with a port and prefixed with name=, of nodes whose availability and latency are checked every canary interval and exported as canary_<name>_* stats"`
+	CanaryInterval time.Duration `long:"canaryinterval" description:"Interval between checks of the canaries"`
+
+	CrawlRecord string `long:"crawlrecord" description:"Write every crawl attempt, with the addresses received, to this file as JSON lines for replay with the simulate command (disabled if empty)"`
+
+	AuditRingSize    int    `long:"auditringsize" description:"Number of DNS answers kept in memory for auditing, served on /v1/audit (0 disables)"`
+	AuditLog         string `long:"auditlog" description:"Write every DNS answer, with the addresses handed out, to this file as JSON lines (disabled if empty)"`
+	AuditHashClients bool   `long:"audithashclients" description:"Record a salted hash of the resolver address instead of the address itself"`
+	AuditSalt        string `long:"auditsalt" description:"Salt of the resolver address hashes"`
+
+	DNSRateLimit       float64 `long:"dnsratelimit" description:"Maximum number of DNS queries per second answered for each source address (0 for no limit)"`
+	DNSRateBurst       int     `long:"dnsrateburst" description:"Number of DNS queries a source address may send in a burst above the rate limit"`
+	DNSRateLimitExempt string  `long:"dnsratelimitexempt" description:"Comma separated addresses or CIDR networks exempted from the DNS rate limit, or given their own rate with network=rate"`
+
+	DNSMaxAmplification float64 `long:"dnsmaxamplification" description:"Trim the answers to queries without a valid DNS server cookie to this many times the size of the query (0 for no limit)"`
+
+	MaxAnswerCount int `long:"maxanswercount" description:"Maximum number of addresses clients can request per answer with the answer count EDNS option (0 to ignore the option)"`
+
+	StatsExport   string        `long:"statsexport" description:"Push stats to a metrics backend" choice:"influx" choice:"graphite"`
+	StatsAddress  string        `long:"statsaddress" description:"Address of the metrics backend: host:port (UDP for influx, TCP for graphite) or an http(s) write URL for influx"`
+	StatsPrefix   string        `long:"statsprefix" description:"Measurement name (influx) or metric path prefix (graphite)"`
+	StatsInterval time.Duration `long:"statsinterval" description:"Interval between stats pushes"`
+
+	GeoIPCity string `long:"geoipcity" description:"MaxMind GeoLite2 City or Country database, adding the location of peers to the API and dump"`
+	GeoIPASN  string `long:"geoipasn" description:"MaxMind GeoLite2 ASN database, adding the origin AS of peers to the API and dump"`
+
+	MaxPerNetgroup  int `long:"maxpernetgroup" description:"Maximum number of peers from the same /16 (IPv4) or /32 (IPv6) network in a single answer (0 for no limit)"`
+	MaxPerCountry   int `long:"maxpercountry" description:"Maximum number of peers from the same country in a single answer, requires --geoipcity (0 for no limit)"`
+	MaxPerContinent int `long:"maxpercontinent" description:"Maximum number of peers from the same continent in a single answer, requires --geoipcity (0 for no limit)"`
+	MaxPerASN       int `long:"maxperasn" description:"Maximum number of peers from the same origin AS in a single answer, requires --geoipasn (0 for no limit)"`
+
+	ASNNetgroups bool `long:"asnnetgroups" description:"Group peers by origin AS rather than by network for the per netgroup crawl and answer limits, falling back to their network if their AS is unknown; requires --geoipasn"`
+
+	HostingRanges string `long:"hostingranges" description:"File listing the networks of cloud and hosting providers, one address or CIDR network per line optionally followed by the provider name; peers in them are tagged as hosted"`
+	MaxHosted     int    `long:"maxhosted" description:"Maximum number of hosted peers in a single answer, requires --hostingranges (0 for no limit)"`
+	ExcludeHosted bool   `long:"excludehosted" description:"Never serve hosted peers, requires --hostingranges"`
+
+	Scorer string `long:"scorer" description:"Scoring policy deciding which good peers are served, and how often"`
+
+	ExperimentScorer string  `long:"experimentscorer" description:"Scoring policy tried on a share of the clients, by a hash of their address, with the metrics of both policies recorded as experiment_<scorer>_* stats (no experiment if empty)"`
+	ExperimentShare  float64 `long:"experimentshare" description:"Share of the clients, between 0 and 1, served by --experimentscorer"`
+
+	NAT64Prefix string `long:"nat64prefix" description:"NAT64 prefix, such as 64:ff9b::/96, in which the IPv4 peers are embedded to answer AAAA queries for dns64.<zone>, for IPv6-only clients behind NAT64 (disabled if empty)"`
+
+	StaticAnswers string `long:"staticanswers" description:"Comma separated IP addresses served in every answer instead of the crawled peers, to test client bootstrap deterministically"`
+
+	AllowOnly string `long:"allowonly" description:"Comma separated addresses or CIDR networks the seeder is restricted to, for private networks: no other address is ever crawled, learned or served, and the DNS seeds of the network are not looked up (no restriction if empty)"`
+
+	ServingIndex         string        `long:"servingindex" description:"File the peers served are written to every --servingindexinterval, for read-only replicas to serve from with --servefrom (not written if empty)"`
+	ServingIndexInterval time.Duration `long:"servingindexinterval" description:"Interval between writes of the serving index"`
+	ServeFrom            string        `long:"servefrom" description:"Run as a read-only replica serving DNS from the serving index written by a crawling seeder with --servingindex, without crawling or keeping a peers database"`
+
+	AlertWebhook          string        `long:"alertwebhook" description:"Post alerts as JSON, compatible with Slack incoming webhooks, to this URL (disabled if empty)"`
+	AlertInterval         time.Duration `long:"alertinterval" description:"Interval between checks of the alert conditions"`
+	AlertMinGoodPeers     int           `long:"alertmingoodpeers" description:"Alert when a network has fewer good peers than this (0 disables)"`
+	AlertCrawlFailureRate float64       `long:"alertcrawlfailurerate" description:"Alert when the fraction of failed crawl attempts in an interval exceeds this (0 disables)"`
+	AlertDNSErrorRate     float64       `long:"alertdnserrorrate" description:"Alert when the fraction of DNS queries answered with an error in an interval exceeds this (0 disables)"`
+	AlertCanaryFailures   int           `long:"alertcanaryfailures" description:"Alert when a canary failed more than this many consecutive checks (0 disables)"`
+	AlertCanaryLatency    time.Duration `long:"alertcanarylatency" description:"Alert when the latency of a canary exceeds this (0 disables)"`
+
+	ChurnInterval  time.Duration `long:"churninterval" description:"Interval between analyses of the churn of the good peers, reporting groups of peers that disappear at once and spikes of bad magic as suspected partitions (disabled if 0, the default; eg. 10m)"`
+	ChurnThreshold float64       `long:"churnthreshold" description:"Fraction of the good peers of an origin AS or country, between 0 and 1, whose disappearance within a churn interval is reported as a suspected partition"`
+	ChurnMinPeers  int           `long:"churnminpeers" description:"Minimum number of good peers of an origin AS or country, or of bad magic failures in a churn interval, for their churn to be reported"`
+
+	OTLPEndpoint     string  `long:"otlpendpoint" description:"Export traces of the crawl and DNS paths to the OTLP/gRPC collector at host:port (disabled if empty)"`
+	OTLPInsecure     bool    `long:"otlpinsecure" description:"Connect to the OTLP collector without TLS"`
+	TraceSampleRatio float64 `long:"tracesampleratio" description:"Fraction of crawls and DNS requests traced, between 0 and 1"`
+
+	GCDemoteAfter          time.Duration `long:"gcdemoteafter" description:"Demote once-good peers that have not been reached successfully for this long"`
+	GCGoodRetention        time.Duration `long:"gcgoodretention" description:"Delete once-good peers that have not been reached successfully for this long"`
+	GCUnreachableRetention time.Duration `long:"gcunreachableretention" description:"Delete never-reachable peers that have not been advertised for this long"`
 	config.NetworkFlags
+
+	// Zones holds the zones served in addition to the one given by Host
+	// and Nameserver. It can only be set from a YAML config file.
+	Zones []ZoneConfig
+
+	// Networks holds the networks served in addition to the primary one.
+	// It can only be set from a YAML config file.
+	Networks []NetworkConfig
+
+	// CustomNetworks defines networks unknown to karlsend, which can be
+	// selected as the primary network or in Networks. They can only be set
+	// from a YAML config file.
+	CustomNetworks []CustomNetworkConfig
+
+	// CustomNetwork is the name of the custom network selected as the
+	// primary network, if any.
+	CustomNetwork string
+}
+
+// AllZones returns every zone the seeder serves, starting with the one
+// given by Host and Nameserver.
+func (cfg *ConfigFlags) AllZones() []ZoneConfig {
+	zones := []ZoneConfig{{Host: cfg.Host, Nameserver: cfg.Nameserver, Family: cfg.HostFamily, Services: cfg.HostServices,
+		QPS: cfg.HostQPS}}
+	for _, zone := range cfg.Zones {
+		if !strings.EqualFold(zone.Host, cfg.Host) {
+			zones = append(zones, zone)
+		}
+	}
+	return zones
 }
 
 // cleanAndExpandPath expands environment variables and leading ~ in the
@@ -97,46 +358,166 @@ func createPathIfNeeded(path string) error {
 	return nil
 }
 
-func loadConfig() (*ConfigFlags, error) {
-	// Default config.
-	activeConfig = &ConfigFlags{
+// newConfigParser returns a parser for the given config, with all
+// subcommands registered. The subcommand chosen when parsing is stored in
+// command.
+func newConfigParser(cfg *ConfigFlags, command *flags.Commander) (*flags.Parser, error) {
+	parser := flags.NewParser(cfg, flags.Default)
+	err := addCommands(parser, command)
+	if err != nil {
+		return nil, err
+	}
+	return parser, nil
+}
+
+// newDefaultConfig returns a config holding the default values.
+func newDefaultConfig() *ConfigFlags {
+	return &ConfigFlags{
+		ConfigFile: defaultConfigFile,
 		AppDir:     DefaultAppDir,
 		Listen:     normalizeAddress("localhost", defaultListenPort),
 		GRPCListen: normalizeAddress("localhost", defaultGrpcListenPort),
 		LogLevel:   defaultLogLevel,
+
+		LogFormat:   logFormatText,
+		LogMaxSize:  defaultLogMaxSize,
+		LogMaxRolls: defaultLogMaxRolls,
+
+		CrawlInterval:       defaultCrawlInterval,
+		CrawlMaxPerSubnet:   defaultCrawlMaxPerSubnet,
+		CrawlWarmup:         defaultCrawlWarmup,
+		CrawlWarmupRate:     defaultCrawlWarmupRate,
+		CrawlHistory:        defaultCrawlHistory,
+		GossipInterval:      defaultGossipInterval,
+		SnapshotInterval:    defaultSnapshotInterval,
+		SnapshotWeight:      defaultSnapshotWeight,
+		MinReadyPeers:       defaultMinReadyPeers,
+		DNSTTL:              defaultDNSTTL,
+		DNSRateBurst:        defaultDNSRateBurst,
+		DNSMaxAmplification: defaultDNSAmplification,
+		MaxAnswerCount:      defaultMaxAnswerCount,
+		MaxPerNetgroup:      defaultMaxPerNetgroup,
+		Scorer:              defaultScorerName,
+		ExperimentShare:     defaultExperimentShare,
+
+		StatsPrefix:   defaultStatsPrefix,
+		StatsInterval: defaultStatsInterval,
+
+		AlertInterval: defaultAlertInterval,
+
+		ChurnThreshold: defaultChurnThreshold,
+		ChurnMinPeers:  defaultChurnMinPeers,
+
+		CanaryInterval: defaultCanaryInterval,
+
+		KeepaliveInterval: defaultKeepaliveInterval,
+
+		ReliabilityPromote: defaultReliabilityPromote,
+		ReliabilityDemote:  defaultReliabilityDemote,
+
+		BlocklistInterval: defaultBlocklistInterval,
+
+		ServingIndexInterval: defaultServingIndexInterval,
+
+		TraceSampleRatio: defaultTraceSampleRatio,
+
+		GCDemoteAfter:          defaultGCDemoteAfter,
+		GCGoodRetention:        defaultGCGoodRetention,
+		GCUnreachableRetention: defaultGCUnreachableRetention,
+	}
+}
+
+// loadConfig parses the configuration file and command line, and returns
+// the resulting configuration along with the subcommand to execute.
+func loadConfig() (*ConfigFlags, flags.Commander, error) {
+	cfg, command, err := parseConfig(true)
+	if err != nil {
+		return nil, nil, err
 	}
+	setActiveConfig(cfg)
 
-	preCfg := activeConfig
-	preParser := flags.NewParser(preCfg, flags.Default)
-	_, err := preParser.Parse()
+	appLogFile := filepath.Join(cfg.AppDir, defaultLogFilename)
+	appErrLogFile := filepath.Join(cfg.AppDir, defaultErrLogFilename)
+
+	err = createPathIfNeeded(cfg.AppDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	initLog(logOptions{
+		noLogFiles: cfg.NoLogFiles,
+		format:     cfg.LogFormat,
+		levels:     cfg.LogLevel,
+		maxSizeMB:  cfg.LogMaxSize,
+		maxRolls:   cfg.LogMaxRolls,
+		logFile:    appLogFile,
+		errLogFile: appErrLogFile,
+		labels:     siteLabels{site: cfg.Site, instance: cfg.Instance},
+	})
+
+	return cfg, command, nil
+}
+
+// parseConfig builds and validates the configuration from the defaults, the
+// configuration file and the command line, in increasing precedence. When
+// interactive is set, help and version requests are served and terminate
+// the process, and parse errors are reported with usage information.
+func parseConfig(interactive bool) (*ConfigFlags, flags.Commander, error) {
+	cfg := newDefaultConfig()
+
+	var command flags.Commander
+	preCfg := cfg
+	preParser, err := newConfigParser(preCfg, &command)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !interactive {
+		preParser.Options = flags.None
+	}
+	_, err = preParser.Parse()
 	if err != nil {
 		var flagsErr *flags.Error
-		if errors.As(err, &flagsErr) && flagsErr.Type == flags.ErrHelp {
+		if interactive && errors.As(err, &flagsErr) && flagsErr.Type == flags.ErrHelp {
 			os.Exit(0)
 		}
-		preParser.WriteHelp(os.Stderr)
-		return nil, err
+		if interactive {
+			preParser.WriteHelp(os.Stderr)
+		}
+		return nil, nil, err
 	}
 
 	appName := filepath.Base(os.Args[0])
 	appName = strings.TrimSuffix(appName, filepath.Ext(appName))
 
 	// Show the version and exit if the version flag was specified.
-	if preCfg.ShowVersion {
+	if interactive && preCfg.ShowVersion {
 		fmt.Println(appName, "version", version.Version())
 		os.Exit(0)
 	}
 
 	// Load additional config from file.
-	parser := flags.NewParser(activeConfig, flags.Default)
-	err = flags.NewIniParser(parser).ParseFile(defaultConfigFile)
+	parser, err := newConfigParser(cfg, &command)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !interactive {
+		parser.Options = flags.None
+	}
+	configFile := cleanAndExpandPath(preCfg.ConfigFile)
+	if isYAMLConfigFile(configFile) {
+		err = loadYAMLConfigFile(configFile, cfg)
+	} else {
+		err = flags.NewIniParser(parser).ParseFile(configFile)
+	}
 	if err != nil {
 		var pathErr *os.PathError
-		if !errors.As(err, &pathErr) {
-			fmt.Fprintf(os.Stderr, "Error parsing ConfigFlags "+
-				"file: %v\n", err)
-			fmt.Fprintf(os.Stderr, "Use `%s -h` to show usage\n", appName)
-			return nil, err
+		if !errors.As(err, &pathErr) || preCfg.ConfigFile != defaultConfigFile {
+			if interactive {
+				fmt.Fprintf(os.Stderr, "Error parsing ConfigFlags "+
+					"file: %v\n", err)
+				fmt.Fprintf(os.Stderr, "Use `%s -h` to show usage\n", appName)
+			}
+			return nil, nil, err
 		}
 	}
 
@@ -144,59 +525,350 @@ func loadConfig() (*ConfigFlags, error) {
 	_, err = parser.Parse()
 	if err != nil {
 		var flagsErr *flags.Error
-		if errors.As(err, &flagsErr) && flagsErr.Type != flags.ErrHelp {
+		if interactive && errors.As(err, &flagsErr) && flagsErr.Type != flags.ErrHelp {
 			parser.WriteHelp(os.Stderr)
 		}
-		return nil, err
+		return nil, nil, err
 	}
 
-	if len(activeConfig.Host) == 0 {
-		str := "Please specify a hostname"
-		err := errors.Errorf(str)
-		fmt.Fprintln(os.Stderr, err)
-		return nil, err
+	_, isRun := command.(*runCommand)
+	_, isCheckConfig := command.(*checkConfigCommand)
+	requiresServing := ((isRun && !cfg.CheckDB) || isCheckConfig) && !cfg.CrawlOnly
+
+	if len(cfg.Host) == 0 && requiresServing {
+		return nil, nil, errors.New("Please specify a hostname")
 	}
 
-	if len(activeConfig.Nameserver) == 0 {
-		str := "Please specify a nameserver"
-		err := errors.Errorf(str)
-		fmt.Fprintln(os.Stderr, err)
-		return nil, err
+	if len(cfg.Nameserver) == 0 && requiresServing {
+		return nil, nil, errors.New("Please specify a nameserver")
 	}
 
-	activeConfig.Listen = normalizeAddress(activeConfig.Listen, defaultListenPort)
+	cfg.Listen = normalizeAddress(cfg.Listen, defaultListenPort)
 
-	err = activeConfig.ResolveNetwork(parser)
+	err = cfg.ResolveNetwork(parser)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	err = validateCustomNetworks(cfg.CustomNetworks)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cfg.CustomNetwork != "" {
+		if cfg.Testnet || cfg.Simnet || cfg.Devnet {
+			return nil, nil, errors.Errorf("The custom network %s can't be combined with --testnet, --simnet or --devnet", cfg.CustomNetwork)
+		}
+		flags, err := networkFlagsFromName(cfg.CustomNetwork, cfg.CustomNetworks)
+		if err != nil {
+			return nil, nil, err
+		}
+		cfg.ActiveNetParams = flags.ActiveNetParams
 	}
 
-	activeConfig.AppDir = cleanAndExpandPath(activeConfig.AppDir)
+	cfg.AppDir = cleanAndExpandPath(cfg.AppDir)
 	// Append the network type to the app directory so it is "namespaced"
 	// per network.
 	// All data is specific to a network, so namespacing the data directory
 	// means each individual piece of serialized data does not have to
 	// worry about changing names per network and such.
-	activeConfig.AppDir = filepath.Join(activeConfig.AppDir, activeConfig.NetParams().Name)
-
-	appLogFile := filepath.Join(activeConfig.AppDir, defaultLogFilename)
-	appErrLogFile := filepath.Join(activeConfig.AppDir, defaultErrLogFilename)
+	cfg.AppDir = filepath.Join(cfg.AppDir, cfg.NetParams().Name)
 
-	err = createPathIfNeeded(activeConfig.AppDir)
+	err = validateNetworks(cfg)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	if activeConfig.Profile != "" {
-		profilePort, err := strconv.Atoi(activeConfig.Profile)
+	if cfg.Profile != "" {
+		profilePort, err := strconv.Atoi(cfg.Profile)
 		if err != nil || profilePort < 1024 || profilePort > 65535 {
-			return nil, errors.New("The profile port must be between 1024 and 65535")
+			return nil, nil, errors.New("The profile port must be between 1024 and 65535")
 		}
 	}
 
-	initLog(activeConfig.NoLogFiles, activeConfig.LogLevel, appLogFile, appErrLogFile)
+	if cfg.GossipPeers != "" && cfg.GossipToken == "" {
+		return nil, nil, errors.New("A gossip token must be specified when gossip peers are")
+	}
+	if cfg.GossipInterval <= 0 {
+		return nil, nil, errors.New("The gossip interval must be positive")
+	}
+
+	if (cfg.HTTPTLSCert == "") != (cfg.HTTPTLSKey == "") {
+		return nil, nil, errors.New("Both --httptlscert and --httptlskey must be specified to serve the HTTP API over HTTPS")
+	}
+	if cfg.HTTPTLSCert != "" {
+		cfg.HTTPTLSCert = cleanAndExpandPath(cfg.HTTPTLSCert)
+		cfg.HTTPTLSKey = cleanAndExpandPath(cfg.HTTPTLSKey)
+	}
+	if cfg.BootstrapKey != "" {
+		if cfg.HTTPListen == "" {
+			return nil, nil, errors.New("The bootstrap endpoint requires the HTTP API (--httplisten)")
+		}
+		cfg.BootstrapKey = cleanAndExpandPath(cfg.BootstrapKey)
+	}
+
+	if cfg.SnapshotKey != "" {
+		if cfg.HTTPListen == "" {
+			return nil, nil, errors.New("Publishing snapshots requires the HTTP API (--httplisten)")
+		}
+		cfg.SnapshotKey = cleanAndExpandPath(cfg.SnapshotKey)
+	}
+	if cfg.SnapshotSources != "" && cfg.SnapshotTrustedKeys == "" {
+		return nil, nil, errors.New("Trusted snapshot keys must be specified when snapshot sources are")
+	}
+	if cfg.SnapshotTrustedKeys != "" {
+		_, err := parseSnapshotKeys(strings.Split(cfg.SnapshotTrustedKeys, ","))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if cfg.SnapshotInterval <= 0 {
+		return nil, nil, errors.New("The snapshot interval must be positive")
+	}
+	if cfg.SnapshotWeight <= 0 || cfg.SnapshotWeight > 1 {
+		return nil, nil, errors.New("The snapshot weight must be greater than 0 and at most 1")
+	}
+
+	if cfg.MinReadyPeers < 0 {
+		return nil, nil, errors.New("The minimum number of ready peers must not be negative")
+	}
+
+	if cfg.CrawlInterval <= 0 {
+		return nil, nil, errors.New("The crawl interval must be positive")
+	}
+	if cfg.CrawlRate < 0 || cfg.CrawlNetgroupRate < 0 || cfg.CrawlRecontact < 0 || cfg.CrawlMaxPerSubnet < 0 {
+		return nil, nil, errors.New("The crawl connection limits must not be negative")
+	}
+	if cfg.CrawlWarmup < 0 || cfg.CrawlWarmupRate <= 0 {
+		return nil, nil, errors.New("The crawl warm-up must not be negative, and its rate must be positive")
+	}
+	if cfg.MaxServedAge < 0 {
+		return nil, nil, errors.New("The maximum served age must not be negative")
+	}
+	if cfg.KeepalivePeers < 0 || cfg.KeepaliveInterval <= 0 {
+		return nil, nil, errors.New("The number of kept peers must not be negative, and the keepalive interval must be positive")
+	}
+	if cfg.CrawlWorkers < 0 || cfg.MaxPeers < 0 {
+		return nil, nil, errors.New("The crawl worker and peer quotas must not be negative")
+	}
+	if cfg.CrawlHistory < 0 {
+		return nil, nil, errors.New("The crawl history size must not be negative")
+	}
+	if cfg.ReliabilityHalfLife < 0 {
+		return nil, nil, errors.New("The reliability half-life must not be negative")
+	}
+	if cfg.ReliabilityDemote < 0 || cfg.ReliabilityPromote > 1 || cfg.ReliabilityDemote >= cfg.ReliabilityPromote {
+		return nil, nil, errors.New("The reliability thresholds must be between 0 and 1, the demotion one below the promotion one")
+	}
+	if cfg.ReliabilityHalfLife == 0 && (cfg.ReliabilityPromote != defaultReliabilityPromote ||
+		cfg.ReliabilityDemote != defaultReliabilityDemote) {
+		return nil, nil, errors.New("The reliability thresholds require the reliability half-life")
+	}
+	// The estimate decays between crawls, so a half-life shorter than the
+	// crawl interval would demote every peer before it is crawled again.
+	if interval := cfg.CrawlInterval; cfg.ReliabilityHalfLife > 0 && interval > 0 &&
+		cfg.ReliabilityHalfLife < interval {
+		return nil, nil, errors.Errorf("The reliability half-life must not be shorter than the crawl interval (%s)",
+			interval)
+	}
+
+	if cfg.GCDemoteAfter <= 0 || cfg.GCGoodRetention <= 0 ||
+		cfg.GCUnreachableRetention <= 0 {
+		return nil, nil, errors.New("The gc durations must be positive")
+	}
+	if cfg.GCGoodRetention < cfg.GCDemoteAfter {
+		return nil, nil, errors.New("The gc good retention must not be shorter than the gc demote interval")
+	}
+
+	if err := validateBootstrapResolver(cfg.BootstrapProxy, cfg.BootstrapDoH); err != nil {
+		return nil, nil, err
+	}
+
+	if !validSiteLabel(cfg.Site) || !validSiteLabel(cfg.Instance) {
+		return nil, nil, errors.New("The site and instance labels may only hold letters, digits, dashes and underscores")
+	}
+
+	if cfg.StatsExport != "" && cfg.StatsAddress == "" {
+		return nil, nil, errors.New("The stats address must be specified when stats export is enabled")
+	}
+
+	if cfg.AlwaysServe != "" {
+		_, err := parseAlwaysServe(strings.Split(cfg.AlwaysServe, ","))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if cfg.StaticAnswers != "" {
+		_, err := parseStaticAnswers(strings.Split(cfg.StaticAnswers, ","))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if cfg.AllowOnly != "" {
+		_, err := parseAllowOnly(strings.Split(cfg.AllowOnly, ","))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if cfg.ServingIndex != "" {
+		if cfg.ServingIndexInterval <= 0 {
+			return nil, nil, errors.New("The serving index interval must be positive")
+		}
+		cfg.ServingIndex = cleanAndExpandPath(cfg.ServingIndex)
+	}
+	if cfg.ServeFrom != "" {
+		if cfg.ServingIndex != "" || cfg.CrawlOnly {
+			return nil, nil, errors.New("A read-only replica (--servefrom) neither crawls nor writes a serving index")
+		}
+		cfg.ServeFrom = cleanAndExpandPath(cfg.ServeFrom)
+	}
+
+	if cfg.Canaries != "" {
+		_, err := parseCanaries(strings.Split(cfg.Canaries, ","), 0)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if cfg.CanaryInterval <= 0 {
+		return nil, nil, errors.New("The canary interval must be positive")
+	}
+
+	if cfg.CrawlRecord != "" {
+		cfg.CrawlRecord = cleanAndExpandPath(cfg.CrawlRecord)
+	}
+
+	if cfg.AuditRingSize < 0 {
+		return nil, nil, errors.New("The audit ring size must not be negative")
+	}
+	if cfg.AuditLog != "" {
+		cfg.AuditLog = cleanAndExpandPath(cfg.AuditLog)
+	}
+
+	if cfg.DNSRateLimit < 0 || cfg.DNSRateBurst < 1 {
+		return nil, nil, errors.New("The DNS rate limit must not be negative, and the burst must be at least 1")
+	}
+	if _, err := parseRateExemptions(cfg.DNSRateLimitExempt); err != nil {
+		return nil, nil, err
+	}
+	if _, err := parsePublicResolvers(cfg.PublicResolvers); err != nil {
+		return nil, nil, err
+	}
+	if cfg.ResolverTTL > 0 && cfg.PublicResolvers == "" {
+		return nil, nil, errors.New("The public resolvers must be specified when their TTL is set")
+	}
+	if cfg.DNSMaxAmplification < 0 {
+		return nil, nil, errors.New("The DNS amplification limit must not be negative")
+	}
+	if cfg.MaxAnswerCount != 0 && (cfg.MaxAnswerCount < defaultMaxAddresses || cfg.MaxAnswerCount > maxAnswerCountLimit) {
+		return nil, nil, errors.Errorf("The maximum requested answer count must be 0, or between %d and %d",
+			defaultMaxAddresses, maxAnswerCountLimit)
+	}
+
+	if cfg.MaxPerNetgroup < 0 || cfg.MaxPerCountry < 0 || cfg.MaxPerContinent < 0 || cfg.MaxPerASN < 0 {
+		return nil, nil, errors.New("The per netgroup, per country, per continent and per AS answer limits must not be negative")
+	}
+	if (cfg.MaxPerCountry > 0 || cfg.MaxPerContinent > 0) && cfg.GeoIPCity == "" {
+		return nil, nil, errors.New("The per country and per continent answer limits require a GeoIP city or country database (--geoipcity)")
+	}
+	if cfg.MaxPerASN > 0 && cfg.GeoIPASN == "" {
+		return nil, nil, errors.New("The per AS answer limit requires a GeoIP ASN database (--geoipasn)")
+	}
+	if cfg.ASNNetgroups && cfg.GeoIPASN == "" {
+		return nil, nil, errors.New("Grouping peers by AS requires a GeoIP ASN database (--geoipasn)")
+	}
+
+	if cfg.MaxHosted < 0 {
+		return nil, nil, errors.New("The hosted peers answer limit must not be negative")
+	}
+	if (cfg.MaxHosted > 0 || cfg.ExcludeHosted) && cfg.HostingRanges == "" {
+		return nil, nil, errors.New("The hosted peers answer limit and exclusion require a hosting ranges file (--hostingranges)")
+	}
+	if cfg.HostingRanges != "" {
+		cfg.HostingRanges = cleanAndExpandPath(cfg.HostingRanges)
+	}
+
+	if cfg.NAT64Prefix != "" {
+		_, prefix, err := net.ParseCIDR(cfg.NAT64Prefix)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "invalid NAT64 prefix")
+		}
+		if err := seeddns.ValidNAT64Prefix(prefix); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if _, err := lookupScorer(cfg.Scorer); err != nil {
+		return nil, nil, err
+	}
+	if cfg.ExperimentScorer != "" {
+		if _, err := lookupScorer(cfg.ExperimentScorer); err != nil {
+			return nil, nil, err
+		}
+		if cfg.ExperimentScorer == cfg.Scorer {
+			return nil, nil, errors.New("The experiment scorer must differ from the scorer")
+		}
+		if cfg.ExperimentShare < 0 || cfg.ExperimentShare > 1 {
+			return nil, nil, errors.New("The experiment share must be between 0 and 1")
+		}
+		if cfg.ServeFrom != "" {
+			return nil, nil, errors.New("Answer experiments can't run on read-only replicas (--servefrom)")
+		}
+	}
+
+	if cfg.AlertWebhook != "" {
+		if cfg.AlertInterval <= 0 {
+			return nil, nil, errors.New("The alert interval must be positive")
+		}
+		if cfg.AlertMinGoodPeers < 0 || cfg.AlertCrawlFailureRate < 0 || cfg.AlertCrawlFailureRate > 1 ||
+			cfg.AlertDNSErrorRate < 0 || cfg.AlertDNSErrorRate > 1 {
+			return nil, nil, errors.New("The alert good peers threshold must not be negative, and the alert rates must be between 0 and 1")
+		}
+		if cfg.AlertCanaryFailures < 0 || cfg.AlertCanaryLatency < 0 {
+			return nil, nil, errors.New("The alert canary thresholds must not be negative")
+		}
+	}
+
+	if cfg.ChurnInterval < 0 || cfg.ChurnThreshold <= 0 || cfg.ChurnThreshold >= 1 || cfg.ChurnMinPeers < 1 {
+		return nil, nil, errors.New("The churn interval must not be negative, the churn threshold must be between 0 and 1, and the churn minimum number of peers must be positive")
+	}
+
+	if cfg.TraceSampleRatio < 0 || cfg.TraceSampleRatio > 1 {
+		return nil, nil, errors.New("The trace sample ratio must be between 0 and 1")
+	}
+
+	if _, err := parseLogLevels(cfg.LogLevel); err != nil {
+		return nil, nil, err
+	}
+
+	if cfg.LogMaxSize <= 0 || cfg.LogMaxRolls < 0 || cfg.LogMaxAge < 0 {
+		return nil, nil, errors.New("The log rotation limits must not be negative, and the log size must be positive")
+	}
+
+	for _, path := range []*string{&cfg.GeoIPCity, &cfg.GeoIPASN} {
+		if *path != "" {
+			*path = cleanAndExpandPath(*path)
+		}
+	}
+
+	if cfg.Blocklists != "" {
+		if cfg.BlocklistInterval <= 0 {
+			return nil, nil, errors.New("The blocklist interval must be positive")
+		}
+		for _, blocklist := range strings.Split(cfg.Blocklists, ",") {
+			if !strings.HasPrefix(blocklist, "http://") && !strings.HasPrefix(blocklist, "https://") {
+				return nil, nil, errors.Errorf("The blocklist %s must be an http(s) URL", blocklist)
+			}
+		}
+	}
+
+	if cfg.BanList != "" {
+		cfg.BanList = cleanAndExpandPath(cfg.BanList)
+		_, err := readBanList(cfg.BanList)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
 
-	return activeConfig, nil
+	return cfg, command, nil
 }
 
 // normalizeAddress returns addr with the passed default port appended if
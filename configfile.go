@@ -0,0 +1,597 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// fileConfig is the layout of a YAML configuration file. Every value is
+// optional; values that are present override the defaults, and are in turn
+// overridden by command line flags.
+type fileConfig struct {
+	Network *string      `yaml:"network"`
+	AppDir  *string      `yaml:"appdir"`
+	Profile *string      `yaml:"profile"`
+	Zones   []ZoneConfig `yaml:"zones"`
+	BanList *string      `yaml:"banlist"`
+
+	// Site and Instance label the seeder among those serving the same
+	// seed domain from several sites.
+	Site     *string `yaml:"site"`
+	Instance *string `yaml:"instance"`
+
+	// CrawlOnly disables DNS serving; zones are then optional.
+	CrawlOnly *bool `yaml:"crawlOnly"`
+
+	Blocklists struct {
+		URLs     []string       `yaml:"urls"`
+		Interval *time.Duration `yaml:"interval"`
+	} `yaml:"blocklists"`
+
+	// Networks are served in addition to the primary network described
+	// by the settings above.
+	Networks []fileNetworkConfig `yaml:"networks"`
+
+	// CustomNetworks define networks unknown to karlsend, selected by
+	// name like the built-in ones.
+	CustomNetworks []CustomNetworkConfig `yaml:"customNetworks"`
+
+	Listeners struct {
+		DNS         *string `yaml:"dns"`
+		GRPC        *string `yaml:"grpc"`
+		HTTP        *string `yaml:"http"`
+		HTTPTLSCert *string `yaml:"httpTLSCert"`
+		HTTPTLSKey  *string `yaml:"httpTLSKey"`
+	} `yaml:"listeners"`
+
+	Crawler struct {
+		Peers    []string       `yaml:"peers"`
+		Seeder   *string        `yaml:"seeder"`
+		Interval *time.Duration `yaml:"interval"`
+		Harvest  *bool          `yaml:"harvest"`
+
+		Politeness struct {
+			Rate         *float64       `yaml:"rate"`
+			NetgroupRate *float64       `yaml:"netgroupRate"`
+			Recontact    *time.Duration `yaml:"recontact"`
+			MaxPerSubnet *int           `yaml:"maxPerSubnet"`
+		} `yaml:"politeness"`
+
+		Warmup struct {
+			Duration *time.Duration `yaml:"duration"`
+			Rate     *float64       `yaml:"rate"`
+		} `yaml:"warmup"`
+
+		Canaries       []string       `yaml:"canaries"`
+		CanaryInterval *time.Duration `yaml:"canaryInterval"`
+		Record         *string        `yaml:"record"`
+		History        *int           `yaml:"history"`
+		AllowOnly      []string       `yaml:"allowOnly"`
+
+		Keepalive struct {
+			Peers    *int           `yaml:"peers"`
+			Interval *time.Duration `yaml:"interval"`
+		} `yaml:"keepalive"`
+
+		Graph struct {
+			Enabled   *bool `yaml:"enabled"`
+			Anonymize *bool `yaml:"anonymize"`
+		} `yaml:"graph"`
+
+		Quota struct {
+			Workers  *int `yaml:"workers"`
+			MaxPeers *int `yaml:"maxPeers"`
+		} `yaml:"quota"`
+
+		Bootstrap struct {
+			Proxy *string `yaml:"proxy"`
+			DoH   *string `yaml:"doh"`
+		} `yaml:"bootstrap"`
+
+		Reliability struct {
+			HalfLife *time.Duration `yaml:"halfLife"`
+			Promote  *float64       `yaml:"promote"`
+			Demote   *float64       `yaml:"demote"`
+		} `yaml:"reliability"`
+	} `yaml:"crawler"`
+
+	DNS struct {
+		TTL             *uint32  `yaml:"ttl"`
+		PeerStatus      *bool    `yaml:"peerStatus"`
+		Membership      *bool    `yaml:"membership"`
+		MaxPerNetgroup  *int     `yaml:"maxPerNetgroup"`
+		MaxPerCountry   *int     `yaml:"maxPerCountry"`
+		MaxPerContinent *int     `yaml:"maxPerContinent"`
+		MaxPerASN       *int     `yaml:"maxPerASN"`
+		AlwaysServe     []string `yaml:"alwaysServe"`
+		Scorer          *string  `yaml:"scorer"`
+		StaticAnswers   []string `yaml:"staticAnswers"`
+		NAT64Prefix     *string  `yaml:"nat64Prefix"`
+
+		MaxServedAge *time.Duration `yaml:"maxServedAge"`
+
+		ClientTTL struct {
+			PublicResolvers []string `yaml:"publicResolvers"`
+			Resolver        *uint32  `yaml:"resolver"`
+			ECS             *uint32  `yaml:"ecs"`
+		} `yaml:"clientTTL"`
+
+		Experiment struct {
+			Scorer *string  `yaml:"scorer"`
+			Share  *float64 `yaml:"share"`
+		} `yaml:"experiment"`
+
+		Hosting struct {
+			Ranges       *string `yaml:"ranges"`
+			MaxPerAnswer *int    `yaml:"maxPerAnswer"`
+			Exclude      *bool   `yaml:"exclude"`
+		} `yaml:"hosting"`
+
+		RateLimit struct {
+			Rate   *float64 `yaml:"rate"`
+			Burst  *int     `yaml:"burst"`
+			Exempt []string `yaml:"exempt"`
+		} `yaml:"rateLimit"`
+		MaxAmplification *float64 `yaml:"maxAmplification"`
+		MaxAnswerCount   *int     `yaml:"maxAnswerCount"`
+	} `yaml:"dns"`
+
+	Storage struct {
+		GC struct {
+			DemoteAfter          *time.Duration `yaml:"demoteAfter"`
+			GoodRetention        *time.Duration `yaml:"goodRetention"`
+			UnreachableRetention *time.Duration `yaml:"unreachableRetention"`
+		} `yaml:"gc"`
+
+		ServingIndex struct {
+			File     *string        `yaml:"file"`
+			Interval *time.Duration `yaml:"interval"`
+		} `yaml:"servingIndex"`
+		ServeFrom *string `yaml:"serveFrom"`
+	} `yaml:"storage"`
+
+	Stats struct {
+		Export   *string        `yaml:"export"`
+		Address  *string        `yaml:"address"`
+		Prefix   *string        `yaml:"prefix"`
+		Interval *time.Duration `yaml:"interval"`
+	} `yaml:"stats"`
+
+	Audit struct {
+		RingSize    *int    `yaml:"ringSize"`
+		File        *string `yaml:"file"`
+		HashClients *bool   `yaml:"hashClients"`
+		Salt        *string `yaml:"salt"`
+	} `yaml:"audit"`
+
+	GeoIP struct {
+		City *string `yaml:"city"`
+		ASN  *string `yaml:"asn"`
+
+		ASNNetgroups *bool `yaml:"asnNetgroups"`
+	} `yaml:"geoip"`
+
+	Alerts struct {
+		Webhook          *string        `yaml:"webhook"`
+		Interval         *time.Duration `yaml:"interval"`
+		MinGoodPeers     *int           `yaml:"minGoodPeers"`
+		CrawlFailureRate *float64       `yaml:"crawlFailureRate"`
+		DNSErrorRate     *float64       `yaml:"dnsErrorRate"`
+		CanaryFailures   *int           `yaml:"canaryFailures"`
+		CanaryLatency    *time.Duration `yaml:"canaryLatency"`
+
+		Churn struct {
+			Interval  *time.Duration `yaml:"interval"`
+			Threshold *float64       `yaml:"threshold"`
+			MinPeers  *int           `yaml:"minPeers"`
+		} `yaml:"churn"`
+	} `yaml:"alerts"`
+
+	Tracing struct {
+		Endpoint    *string  `yaml:"endpoint"`
+		Insecure    *bool    `yaml:"insecure"`
+		SampleRatio *float64 `yaml:"sampleRatio"`
+	} `yaml:"tracing"`
+
+	Health struct {
+		MinReadyPeers *int `yaml:"minReadyPeers"`
+	} `yaml:"health"`
+
+	Admin struct {
+		Token *string `yaml:"token"`
+	} `yaml:"admin"`
+
+	Gossip struct {
+		Peers    []string       `yaml:"peers"`
+		Token    *string        `yaml:"token"`
+		Interval *time.Duration `yaml:"interval"`
+	} `yaml:"gossip"`
+
+	PeerFeed struct {
+		Token *string `yaml:"token"`
+	} `yaml:"peerFeed"`
+
+	Bootstrap struct {
+		Key *string `yaml:"key"`
+	} `yaml:"bootstrap"`
+
+	Snapshots struct {
+		Key         *string        `yaml:"key"`
+		Sources     []string       `yaml:"sources"`
+		TrustedKeys []string       `yaml:"trustedKeys"`
+		Interval    *time.Duration `yaml:"interval"`
+		Weight      *float64       `yaml:"weight"`
+	} `yaml:"snapshots"`
+
+	Log struct {
+		Level      *string        `yaml:"level"`
+		Format     *string        `yaml:"format"`
+		NoLogFiles *bool          `yaml:"nologfiles"`
+		MaxSize    *int64         `yaml:"maxSize"`
+		MaxRolls   *int           `yaml:"maxRolls"`
+		MaxAge     *time.Duration `yaml:"maxAge"`
+	} `yaml:"log"`
+}
+
+// fileNetworkConfig is the layout of an additional network in a YAML
+// configuration file.
+type fileNetworkConfig struct {
+	Network string       `yaml:"network"`
+	Zones   []ZoneConfig `yaml:"zones"`
+	Crawler struct {
+		Peers    []string `yaml:"peers"`
+		Seeder   string   `yaml:"seeder"`
+		Canaries []string `yaml:"canaries"`
+
+		Quota struct {
+			Workers  int `yaml:"workers"`
+			MaxPeers int `yaml:"maxPeers"`
+		} `yaml:"quota"`
+	} `yaml:"crawler"`
+	DNS struct {
+		AlwaysServe []string `yaml:"alwaysServe"`
+	} `yaml:"dns"`
+}
+
+// isYAMLConfigFile returns whether the given config file should be parsed
+// as YAML rather than INI.
+func isYAMLConfigFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// loadYAMLConfigFile reads the YAML config file at the given path into cfg.
+func loadYAMLConfigFile(path string, cfg *ConfigFlags) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var file fileConfig
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	err = dec.Decode(&file)
+	if err != nil {
+		return errors.Wrapf(err, "error parsing %s", path)
+	}
+
+	return file.apply(cfg)
+}
+
+// apply copies all values present in the file into cfg.
+func (file *fileConfig) apply(cfg *ConfigFlags) error {
+	if file.Network != nil {
+		switch *file.Network {
+		case "mainnet":
+		case "testnet":
+			cfg.Testnet = true
+		case "simnet":
+			cfg.Simnet = true
+		case "devnet":
+			cfg.Devnet = true
+		default:
+			if findCustomNetwork(file.CustomNetworks, *file.Network) == nil {
+				return errors.Errorf("unknown network %s", *file.Network)
+			}
+			cfg.CustomNetwork = *file.Network
+		}
+	}
+	cfg.CustomNetworks = file.CustomNetworks
+	setString(&cfg.AppDir, file.AppDir)
+	setString(&cfg.Profile, file.Profile)
+	setString(&cfg.Site, file.Site)
+	setString(&cfg.Instance, file.Instance)
+
+	if len(file.Zones) > 0 {
+		for _, zone := range file.Zones {
+			if zone.Host == "" || zone.Nameserver == "" {
+				return errors.New("every zone must have a host and a nameserver")
+			}
+		}
+		cfg.Host = file.Zones[0].Host
+		cfg.Nameserver = file.Zones[0].Nameserver
+		cfg.HostFamily = file.Zones[0].Family
+		cfg.HostServices = file.Zones[0].Services
+		cfg.HostQPS = file.Zones[0].QPS
+		cfg.Zones = file.Zones[1:]
+	}
+
+	for _, network := range file.Networks {
+		cfg.Networks = append(cfg.Networks, NetworkConfig{
+			Network:     network.Network,
+			Zones:       network.Zones,
+			Peers:       network.Crawler.Peers,
+			Seeder:      network.Crawler.Seeder,
+			AlwaysServe: network.DNS.AlwaysServe,
+			Canaries:    network.Crawler.Canaries,
+
+			CrawlWorkers: network.Crawler.Quota.Workers,
+			MaxPeers:     network.Crawler.Quota.MaxPeers,
+		})
+	}
+
+	setString(&cfg.Listen, file.Listeners.DNS)
+	setString(&cfg.GRPCListen, file.Listeners.GRPC)
+	setString(&cfg.HTTPListen, file.Listeners.HTTP)
+	setString(&cfg.HTTPTLSCert, file.Listeners.HTTPTLSCert)
+	setString(&cfg.HTTPTLSKey, file.Listeners.HTTPTLSKey)
+
+	if len(file.Crawler.Peers) > 0 {
+		cfg.KnownPeers = strings.Join(file.Crawler.Peers, ",")
+	}
+	setString(&cfg.Seeder, file.Crawler.Seeder)
+	setDuration(&cfg.CrawlInterval, file.Crawler.Interval)
+	if file.Crawler.Harvest != nil {
+		cfg.Harvest = *file.Crawler.Harvest
+	}
+	if file.Crawler.Politeness.Rate != nil {
+		cfg.CrawlRate = *file.Crawler.Politeness.Rate
+	}
+	if file.Crawler.Politeness.NetgroupRate != nil {
+		cfg.CrawlNetgroupRate = *file.Crawler.Politeness.NetgroupRate
+	}
+	setDuration(&cfg.CrawlRecontact, file.Crawler.Politeness.Recontact)
+	if file.Crawler.Politeness.MaxPerSubnet != nil {
+		cfg.CrawlMaxPerSubnet = *file.Crawler.Politeness.MaxPerSubnet
+	}
+	setDuration(&cfg.CrawlWarmup, file.Crawler.Warmup.Duration)
+	if file.Crawler.Warmup.Rate != nil {
+		cfg.CrawlWarmupRate = *file.Crawler.Warmup.Rate
+	}
+	if len(file.Crawler.Canaries) > 0 {
+		cfg.Canaries = strings.Join(file.Crawler.Canaries, ",")
+	}
+	setDuration(&cfg.CanaryInterval, file.Crawler.CanaryInterval)
+	if len(file.Crawler.AllowOnly) > 0 {
+		cfg.AllowOnly = strings.Join(file.Crawler.AllowOnly, ",")
+	}
+	setDuration(&cfg.ReliabilityHalfLife, file.Crawler.Reliability.HalfLife)
+	if file.Crawler.Reliability.Promote != nil {
+		cfg.ReliabilityPromote = *file.Crawler.Reliability.Promote
+	}
+	if file.Crawler.Reliability.Demote != nil {
+		cfg.ReliabilityDemote = *file.Crawler.Reliability.Demote
+	}
+	setString(&cfg.CrawlRecord, file.Crawler.Record)
+	if file.Crawler.History != nil {
+		cfg.CrawlHistory = *file.Crawler.History
+	}
+	if file.Crawler.Keepalive.Peers != nil {
+		cfg.KeepalivePeers = *file.Crawler.Keepalive.Peers
+	}
+	setDuration(&cfg.KeepaliveInterval, file.Crawler.Keepalive.Interval)
+	if file.Crawler.Graph.Enabled != nil {
+		cfg.PeerGraph = *file.Crawler.Graph.Enabled
+	}
+	if file.Crawler.Graph.Anonymize != nil {
+		cfg.PeerGraphAnonymize = *file.Crawler.Graph.Anonymize
+	}
+	if file.Crawler.Quota.Workers != nil {
+		cfg.CrawlWorkers = *file.Crawler.Quota.Workers
+	}
+	if file.Crawler.Quota.MaxPeers != nil {
+		cfg.MaxPeers = *file.Crawler.Quota.MaxPeers
+	}
+	setString(&cfg.BootstrapProxy, file.Crawler.Bootstrap.Proxy)
+	setString(&cfg.BootstrapDoH, file.Crawler.Bootstrap.DoH)
+	if file.DNS.TTL != nil {
+		cfg.DNSTTL = *file.DNS.TTL
+	}
+	if file.DNS.PeerStatus != nil {
+		cfg.PeerStatus = *file.DNS.PeerStatus
+	}
+	if len(file.DNS.ClientTTL.PublicResolvers) > 0 {
+		cfg.PublicResolvers = strings.Join(file.DNS.ClientTTL.PublicResolvers, ",")
+	}
+	if file.DNS.ClientTTL.Resolver != nil {
+		cfg.ResolverTTL = *file.DNS.ClientTTL.Resolver
+	}
+	if file.DNS.ClientTTL.ECS != nil {
+		cfg.ECSTTL = *file.DNS.ClientTTL.ECS
+	}
+	if file.DNS.Membership != nil {
+		cfg.Membership = *file.DNS.Membership
+	}
+	if file.DNS.MaxAmplification != nil {
+		cfg.DNSMaxAmplification = *file.DNS.MaxAmplification
+	}
+	if file.DNS.MaxAnswerCount != nil {
+		cfg.MaxAnswerCount = *file.DNS.MaxAnswerCount
+	}
+	if file.DNS.RateLimit.Rate != nil {
+		cfg.DNSRateLimit = *file.DNS.RateLimit.Rate
+	}
+	if file.DNS.RateLimit.Burst != nil {
+		cfg.DNSRateBurst = *file.DNS.RateLimit.Burst
+	}
+	if len(file.DNS.RateLimit.Exempt) > 0 {
+		cfg.DNSRateLimitExempt = strings.Join(file.DNS.RateLimit.Exempt, ",")
+	}
+	if len(file.DNS.AlwaysServe) > 0 {
+		cfg.AlwaysServe = strings.Join(file.DNS.AlwaysServe, ",")
+	}
+	if file.DNS.MaxPerNetgroup != nil {
+		cfg.MaxPerNetgroup = *file.DNS.MaxPerNetgroup
+	}
+	if file.DNS.MaxPerCountry != nil {
+		cfg.MaxPerCountry = *file.DNS.MaxPerCountry
+	}
+	if file.DNS.MaxPerContinent != nil {
+		cfg.MaxPerContinent = *file.DNS.MaxPerContinent
+	}
+	if file.DNS.MaxPerASN != nil {
+		cfg.MaxPerASN = *file.DNS.MaxPerASN
+	}
+	setString(&cfg.Scorer, file.DNS.Scorer)
+	setString(&cfg.NAT64Prefix, file.DNS.NAT64Prefix)
+	setDuration(&cfg.MaxServedAge, file.DNS.MaxServedAge)
+	setString(&cfg.ExperimentScorer, file.DNS.Experiment.Scorer)
+	if file.DNS.Experiment.Share != nil {
+		cfg.ExperimentShare = *file.DNS.Experiment.Share
+	}
+	if len(file.DNS.StaticAnswers) > 0 {
+		cfg.StaticAnswers = strings.Join(file.DNS.StaticAnswers, ",")
+	}
+	setString(&cfg.HostingRanges, file.DNS.Hosting.Ranges)
+	if file.DNS.Hosting.MaxPerAnswer != nil {
+		cfg.MaxHosted = *file.DNS.Hosting.MaxPerAnswer
+	}
+	if file.DNS.Hosting.Exclude != nil {
+		cfg.ExcludeHosted = *file.DNS.Hosting.Exclude
+	}
+	setString(&cfg.BanList, file.BanList)
+	if file.CrawlOnly != nil {
+		cfg.CrawlOnly = *file.CrawlOnly
+	}
+	if len(file.Blocklists.URLs) > 0 {
+		cfg.Blocklists = strings.Join(file.Blocklists.URLs, ",")
+	}
+	setDuration(&cfg.BlocklistInterval, file.Blocklists.Interval)
+
+	setDuration(&cfg.GCDemoteAfter, file.Storage.GC.DemoteAfter)
+	setDuration(&cfg.GCGoodRetention, file.Storage.GC.GoodRetention)
+	setDuration(&cfg.GCUnreachableRetention, file.Storage.GC.UnreachableRetention)
+	setString(&cfg.ServingIndex, file.Storage.ServingIndex.File)
+	setDuration(&cfg.ServingIndexInterval, file.Storage.ServingIndex.Interval)
+	setString(&cfg.ServeFrom, file.Storage.ServeFrom)
+
+	if file.Stats.Export != nil && *file.Stats.Export != statsExportInflux &&
+		*file.Stats.Export != statsExportGraphite {
+		return errors.Errorf("unknown stats export format %s", *file.Stats.Export)
+	}
+	setString(&cfg.StatsExport, file.Stats.Export)
+	setString(&cfg.StatsAddress, file.Stats.Address)
+	setString(&cfg.StatsPrefix, file.Stats.Prefix)
+	setDuration(&cfg.StatsInterval, file.Stats.Interval)
+
+	if file.Audit.RingSize != nil {
+		cfg.AuditRingSize = *file.Audit.RingSize
+	}
+	setString(&cfg.AuditLog, file.Audit.File)
+	if file.Audit.HashClients != nil {
+		cfg.AuditHashClients = *file.Audit.HashClients
+	}
+	setString(&cfg.AuditSalt, file.Audit.Salt)
+
+	setString(&cfg.GeoIPCity, file.GeoIP.City)
+	setString(&cfg.GeoIPASN, file.GeoIP.ASN)
+	if file.GeoIP.ASNNetgroups != nil {
+		cfg.ASNNetgroups = *file.GeoIP.ASNNetgroups
+	}
+
+	setString(&cfg.AlertWebhook, file.Alerts.Webhook)
+	setDuration(&cfg.AlertInterval, file.Alerts.Interval)
+	if file.Alerts.MinGoodPeers != nil {
+		cfg.AlertMinGoodPeers = *file.Alerts.MinGoodPeers
+	}
+	if file.Alerts.CrawlFailureRate != nil {
+		cfg.AlertCrawlFailureRate = *file.Alerts.CrawlFailureRate
+	}
+	if file.Alerts.DNSErrorRate != nil {
+		cfg.AlertDNSErrorRate = *file.Alerts.DNSErrorRate
+	}
+	if file.Alerts.CanaryFailures != nil {
+		cfg.AlertCanaryFailures = *file.Alerts.CanaryFailures
+	}
+	setDuration(&cfg.AlertCanaryLatency, file.Alerts.CanaryLatency)
+	setDuration(&cfg.ChurnInterval, file.Alerts.Churn.Interval)
+	if file.Alerts.Churn.Threshold != nil {
+		cfg.ChurnThreshold = *file.Alerts.Churn.Threshold
+	}
+	if file.Alerts.Churn.MinPeers != nil {
+		cfg.ChurnMinPeers = *file.Alerts.Churn.MinPeers
+	}
+
+	setString(&cfg.OTLPEndpoint, file.Tracing.Endpoint)
+	if file.Tracing.Insecure != nil {
+		cfg.OTLPInsecure = *file.Tracing.Insecure
+	}
+	if file.Tracing.SampleRatio != nil {
+		cfg.TraceSampleRatio = *file.Tracing.SampleRatio
+	}
+
+	if file.Health.MinReadyPeers != nil {
+		cfg.MinReadyPeers = *file.Health.MinReadyPeers
+	}
+
+	setString(&cfg.AdminToken, file.Admin.Token)
+
+	if len(file.Gossip.Peers) > 0 {
+		cfg.GossipPeers = strings.Join(file.Gossip.Peers, ",")
+	}
+	setString(&cfg.GossipToken, file.Gossip.Token)
+	setDuration(&cfg.GossipInterval, file.Gossip.Interval)
+
+	setString(&cfg.PeerFeedToken, file.PeerFeed.Token)
+
+	setString(&cfg.BootstrapKey, file.Bootstrap.Key)
+
+	setString(&cfg.SnapshotKey, file.Snapshots.Key)
+	if len(file.Snapshots.Sources) > 0 {
+		cfg.SnapshotSources = strings.Join(file.Snapshots.Sources, ",")
+	}
+	if len(file.Snapshots.TrustedKeys) > 0 {
+		cfg.SnapshotTrustedKeys = strings.Join(file.Snapshots.TrustedKeys, ",")
+	}
+	setDuration(&cfg.SnapshotInterval, file.Snapshots.Interval)
+	if file.Snapshots.Weight != nil {
+		cfg.SnapshotWeight = *file.Snapshots.Weight
+	}
+
+	setString(&cfg.LogLevel, file.Log.Level)
+	if file.Log.Format != nil && *file.Log.Format != logFormatText &&
+		*file.Log.Format != logFormatJSON {
+		return errors.Errorf("unknown log format %s", *file.Log.Format)
+	}
+	setString(&cfg.LogFormat, file.Log.Format)
+	if file.Log.NoLogFiles != nil {
+		cfg.NoLogFiles = *file.Log.NoLogFiles
+	}
+	if file.Log.MaxSize != nil {
+		cfg.LogMaxSize = *file.Log.MaxSize
+	}
+	if file.Log.MaxRolls != nil {
+		cfg.LogMaxRolls = *file.Log.MaxRolls
+	}
+	setDuration(&cfg.LogMaxAge, file.Log.MaxAge)
+
+	return nil
+}
+
+func setString(target *string, value *string) {
+	if value != nil {
+		*target = *value
+	}
+}
+
+func setDuration(target *time.Duration, value *time.Duration) {
+	if value != nil {
+		*target = *value
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoadYAMLConfigFile(t *testing.T) {
+	cfg := &ConfigFlags{GCDemoteAfter: time.Hour}
+	err := loadYAMLConfigFile("sample-dnsseeder.yaml", cfg)
+	if err != nil {
+		t.Fatalf("loadYAMLConfigFile: %s", err)
+	}
+
+	if cfg.Host != "seed.example.org" || cfg.Nameserver != "ns.example.org" {
+		t.Errorf("unexpected primary zone: %s %s", cfg.Host, cfg.Nameserver)
+	}
+	zones := cfg.AllZones()
+	if len(zones) != 2 || zones[1].Host != "seed.example.net" {
+		t.Errorf("unexpected zones: %+v", zones)
+	}
+	if cfg.Listen != "0.0.0.0:5354" {
+		t.Errorf("unexpected DNS listener: %s", cfg.Listen)
+	}
+	if cfg.GCDemoteAfter != 8*time.Hour || cfg.GCGoodRetention != 168*time.Hour {
+		t.Errorf("unexpected gc durations: %s %s", cfg.GCDemoteAfter, cfg.GCGoodRetention)
+	}
+	if cfg.StatsInterval != time.Minute {
+		t.Errorf("unexpected stats interval: %s", cfg.StatsInterval)
+	}
+	if cfg.Testnet || cfg.Devnet || cfg.Simnet {
+		t.Errorf("expected mainnet")
+	}
+}
+
+func TestValidateNetworks(t *testing.T) {
+	cfg := &ConfigFlags{Host: "seed.example.org", Nameserver: "ns.example.org"}
+	err := cfg.ResolveNetwork(nil)
+	if err != nil {
+		t.Fatalf("ResolveNetwork: %s", err)
+	}
+
+	testnet := NetworkConfig{
+		Network: "testnet",
+		Zones:   []ZoneConfig{{Host: "testnet-seed.example.org", Nameserver: "ns.example.org"}},
+	}
+	cfg.Networks = []NetworkConfig{testnet}
+	err = validateNetworks(cfg)
+	if err != nil {
+		t.Errorf("validateNetworks: %s", err)
+	}
+
+	cfg.Networks = []NetworkConfig{testnet, testnet}
+	if validateNetworks(cfg) == nil {
+		t.Errorf("expected an error for a duplicate network")
+	}
+
+	cfg.Networks = []NetworkConfig{{Network: "mainnet", Zones: testnet.Zones}}
+	if validateNetworks(cfg) == nil {
+		t.Errorf("expected an error for a network duplicating the primary one")
+	}
+
+	cfg.Networks = []NetworkConfig{{
+		Network: "testnet",
+		Zones:   []ZoneConfig{{Host: "SEED.example.org", Nameserver: "ns.example.org"}},
+	}}
+	if validateNetworks(cfg) == nil {
+		t.Errorf("expected an error for a zone served for two networks")
+	}
+
+	cfg.Networks = []NetworkConfig{{
+		Network: "testnet",
+		Zones:   []ZoneConfig{{Host: "ipv6.testnet-seed.example.org", Nameserver: "ns.example.org", Family: "ipx"}},
+	}}
+	if validateNetworks(cfg) == nil {
+		t.Errorf("expected an error for an unknown zone address family")
+	}
+
+	cfg.Networks = []NetworkConfig{{Network: "testnet"}}
+	if validateNetworks(cfg) == nil {
+		t.Errorf("expected an error for a network without zones")
+	}
+	cfg.CrawlOnly = true
+	err = validateNetworks(cfg)
+	if err != nil {
+		t.Errorf("expected networks without zones in crawl-only mode, got %s", err)
+	}
+}
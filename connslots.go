@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// errHostBusy is returned when dialing a host that already has a crawl
+// connection open, or whose subnet has as many as allowed.
+var errHostBusy = errors.New("too many crawl connections to the host or its subnet")
+
+// connectionSlots keeps track of the open crawl connections, so no host
+// gets more than one at a time and no subnet more than maxPerSubnet, even
+// when a retry races with a duplicate entry of the same host.
+type connectionSlots struct {
+	maxPerSubnet int
+
+	mtx     sync.Mutex
+	hosts   map[string]bool
+	subnets map[string]int
+}
+
+// newConnectionSlots returns connection slots allowing maxPerSubnet
+// simultaneous connections to the same subnet, or any number if it is zero.
+func newConnectionSlots(maxPerSubnet int) *connectionSlots {
+	return &connectionSlots{
+		maxPerSubnet: maxPerSubnet,
+		hosts:        make(map[string]bool),
+		subnets:      make(map[string]int),
+	}
+}
+
+// connections are the connection slots shared by the crawlers of all the
+// networks. run replaces them according to the configuration.
+var connections = newConnectionSlots(defaultCrawlMaxPerSubnet)
+
+// subnetKey returns the /24 network of an IPv4 address, or the /48 network
+// of an IPv6 one.
+func subnetKey(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// acquire takes a slot for a connection to ip, and returns the function
+// releasing it. It returns errHostBusy if there is no slot left.
+func (s *connectionSlots) acquire(ip net.IP) (func(), error) {
+	host := ip.String()
+	subnet := subnetKey(ip)
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.hosts[host] || (s.maxPerSubnet > 0 && s.subnets[subnet] >= s.maxPerSubnet) {
+		return nil, errors.Wrapf(errHostBusy, "not dialing %s", host)
+	}
+	s.hosts[host] = true
+	s.subnets[subnet]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mtx.Lock()
+			defer s.mtx.Unlock()
+
+			delete(s.hosts, host)
+			s.subnets[subnet]--
+			if s.subnets[subnet] == 0 {
+				delete(s.subnets, subnet)
+			}
+		})
+	}, nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestConnectionSlots(t *testing.T) {
+	s := newConnectionSlots(2)
+	release, err := s.acquire(net.ParseIP("203.0.113.5"))
+	if err != nil {
+		t.Fatalf("acquire: %s", err)
+	}
+	if _, err := s.acquire(net.ParseIP("203.0.113.5")); !errors.Is(err, errHostBusy) {
+		t.Errorf("expected a second connection to the host to be refused, got %v", err)
+	}
+	if _, err := s.acquire(net.ParseIP("203.0.113.6")); err != nil {
+		t.Errorf("expected a second connection to the subnet to be allowed, got %v", err)
+	}
+	if _, err := s.acquire(net.ParseIP("203.0.113.7")); !errors.Is(err, errHostBusy) {
+		t.Errorf("expected a third connection to the subnet to be refused, got %v", err)
+	}
+	if _, err := s.acquire(net.ParseIP("203.0.114.7")); err != nil {
+		t.Errorf("expected another subnet to have its own slots, got %v", err)
+	}
+
+	release()
+	release()
+	if _, err := s.acquire(net.ParseIP("203.0.113.7")); err != nil {
+		t.Errorf("expected the released slot to be reused, got %v", err)
+	}
+	if _, err := s.acquire(net.ParseIP("203.0.113.8")); !errors.Is(err, errHostBusy) {
+		t.Errorf("expected releasing twice to free a single slot, got %v", err)
+	}
+}
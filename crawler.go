@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/karlsen-network/karlsend/app/protocol/common"
+	"github.com/karlsen-network/karlsend/infrastructure/config"
+	"github.com/karlsen-network/karlsend/infrastructure/network/netadapter"
+	"github.com/karlsen-network/karlsend/infrastructure/network/netadapter/router"
+	"github.com/karlsen-network/karlsend/util/mstime"
+	"github.com/pkg/errors"
+)
+
+// crawlAdapter is a minimal network adapter used by the crawler. It is
+// modeled after karlsend's standalone.MinimalNetAdapter, but keeps the
+// version message each peer sent during the handshake so the crawler can
+// record what the peer is running.
+type crawlAdapter struct {
+	cfg        *config.Config
+	lock       sync.Mutex
+	netAdapter *netadapter.NetAdapter
+	routesChan <-chan *peerRoutes
+
+	// protocolVersion, if not zero, is the only protocol version accepted
+	// from peers.
+	protocolVersion uint32
+}
+
+// peerRoutes holds the routes of a single crawl connection together with the
+// version message the peer sent during the handshake.
+type peerRoutes struct {
+	netConnection  *netadapter.NetConnection
+	outgoingRoute  *router.Route
+	handshakeRoute *router.Route
+	addressesRoute *router.Route
+	pingRoute      *router.Route
+	otherRoute     *router.Route
+
+	version *appmessage.MsgVersion
+
+	// release frees the connection slot of outbound connections.
+	release func()
+}
+
+// newCrawlAdapter creates and starts a new crawlAdapter. If protocolVersion
+// is not zero, peers using any other protocol version are rejected.
+func newCrawlAdapter(cfg *config.Config, protocolVersion uint32) (*crawlAdapter, error) {
+	netAdapter, err := netadapter.NewNetAdapter(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error starting netAdapter")
+	}
+
+	routerInitializer, routesChan := generateCrawlRouteInitializer()
+	netAdapter.SetP2PRouterInitializer(routerInitializer)
+	netAdapter.SetRPCRouterInitializer(func(_ *router.Router, _ *netadapter.NetConnection) {})
+
+	err = netAdapter.Start()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error starting netAdapter")
+	}
+
+	return &crawlAdapter{
+		cfg:             cfg,
+		protocolVersion: protocolVersion,
+		netAdapter:      netAdapter,
+		routesChan:      routesChan,
+	}, nil
+}
+
+// Connect opens a connection to the given address and performs the
+// handshake. It returns errHostBusy, without dialing, if the host already
+// has a crawl connection open or its subnet has too many.
+func (ca *crawlAdapter) Connect(ctx context.Context, address string) (*peerRoutes, error) {
+	routes, _, err := ca.connectTimed(ctx, address)
+	return routes, err
+}
+
+// connectTimed is Connect, also returning when the dial started. Since
+// connections are established one at a time, it is only once the adapter
+// lock is taken, so timing the connection from then leaves out the wait for
+// other connections. It is zero if the dial never started.
+func (ca *crawlAdapter) connectTimed(ctx context.Context, address string) (*peerRoutes, time.Time, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, time.Time{}, errors.Errorf("invalid peer address %s", address)
+	}
+	release, err := connections.acquire(ip)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	// The dial span includes the wait for the adapter lock, since
+	// connections are established one at a time.
+	_, dialSpan := tracer.Start(ctx, "crawl.dial")
+	ca.lock.Lock()
+	defer ca.lock.Unlock()
+	dialSpan.AddEvent("lock acquired")
+	started := time.Now()
+
+	err = ca.netAdapter.P2PConnect(address)
+	if err != nil {
+		release()
+		endSpan(dialSpan, err)
+		return nil, started, reject(rejectedUnreachable, nil, err)
+	}
+
+	routes := <-ca.routesChan
+	routes.release = release
+	dialSpan.End()
+
+	_, handshakeSpan := tracer.Start(ctx, "crawl.handshake")
+	err = ca.handleHandshake(routes)
+	if err != nil {
+		endSpan(handshakeSpan, err)
+		routes.Disconnect()
+		return nil, started, &handshakeError{err: err}
+	}
+	handshakeSpan.End()
+
+	spawn("crawlAdapter-handlePingPong", func() {
+		err := routes.handlePingPong()
+		if err != nil {
+			crawlLog.Debugf("Error from ping-pong with %s: %v", address, err)
+		}
+	})
+
+	return routes, started, nil
+}
+
+func (ca *crawlAdapter) handleHandshake(routes *peerRoutes) error {
+	msg, err := routes.handshakeRoute.DequeueWithTimeout(common.DefaultTimeout)
+	if err != nil {
+		return err
+	}
+	versionMessage, ok := msg.(*appmessage.MsgVersion)
+	if !ok {
+		return errors.Errorf("expected first message to be of type %s, but got %s", appmessage.CmdVersion, msg.Command())
+	}
+	routes.version = versionMessage
+	if versionMessage.Network != ca.cfg.ActiveNetParams.Name {
+		return reject(rejectedWrongNetwork, versionMessage, errors.Errorf("peer is on network %s, expected %s",
+			versionMessage.Network, ca.cfg.ActiveNetParams.Name))
+	}
+	if ca.protocolVersion != 0 && versionMessage.ProtocolVersion != ca.protocolVersion {
+		return reject(rejectedProtocolVersion, versionMessage, errors.Errorf("peer uses protocol version %d, expected %d",
+			versionMessage.ProtocolVersion, ca.protocolVersion))
+	}
+
+	err = routes.outgoingRoute.Enqueue(&appmessage.MsgVersion{
+		ProtocolVersion: versionMessage.ProtocolVersion,
+		Network:         ca.cfg.ActiveNetParams.Name,
+		Services:        versionMessage.Services,
+		Timestamp:       mstime.Now(),
+		Address:         nil,
+		ID:              ca.netAdapter.ID(),
+		UserAgent:       "/dnsseeder/",
+		DisableRelayTx:  true,
+		SubnetworkID:    nil,
+	})
+	if err != nil {
+		return err
+	}
+
+	msg, err = routes.handshakeRoute.DequeueWithTimeout(common.DefaultTimeout)
+	if err != nil {
+		return err
+	}
+	if _, ok := msg.(*appmessage.MsgVerAck); !ok {
+		return errors.Errorf("expected second message to be of type %s, but got %s", appmessage.CmdVerAck, msg.Command())
+	}
+	err = routes.outgoingRoute.Enqueue(&appmessage.MsgVerAck{})
+	if err != nil {
+		return err
+	}
+
+	msg, err = routes.addressesRoute.DequeueWithTimeout(common.DefaultTimeout)
+	if err != nil {
+		return err
+	}
+	if _, ok := msg.(*appmessage.MsgRequestAddresses); !ok {
+		return errors.Errorf("expected third message to be of type %s, but got %s", appmessage.CmdRequestAddresses, msg.Command())
+	}
+	return routes.outgoingRoute.Enqueue(&appmessage.MsgAddresses{
+		AddressList: []*appmessage.NetAddress{},
+	})
+}
+
+// handlePingPong answers the peer's pings so it doesn't disconnect us.
+func (r *peerRoutes) handlePingPong() error {
+	for {
+		message, err := r.pingRoute.Dequeue()
+		if err != nil {
+			if errors.Is(err, router.ErrRouteClosed) {
+				return nil
+			}
+			return err
+		}
+
+		pingMessage := message.(*appmessage.MsgPing)
+		err = r.outgoingRoute.Enqueue(&appmessage.MsgPong{Nonce: pingMessage.Nonce})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// RequestAddresses asks the peer for its known addresses and waits up to
+// timeout for the answer.
+func (r *peerRoutes) RequestAddresses(timeout time.Duration) (*appmessage.MsgAddresses, error) {
+	err := r.outgoingRoute.Enqueue(appmessage.NewMsgRequestAddresses(true, nil))
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		message, err := r.addressesRoute.DequeueWithTimeout(time.Until(deadline))
+		if err != nil {
+			return nil, errors.Wrapf(err, "error waiting for message of type %s", appmessage.CmdAddresses)
+		}
+		if msgAddresses, ok := message.(*appmessage.MsgAddresses); ok {
+			return msgAddresses, nil
+		}
+	}
+}
+
+// AnnouncedBlocks waits for wait and returns the hashes of the blocks the
+// peer announced meanwhile.
+func (r *peerRoutes) AnnouncedBlocks(wait time.Duration) []string {
+	var hashes []string
+	deadline := time.Now().Add(wait)
+	for {
+		message, err := r.otherRoute.DequeueWithTimeout(time.Until(deadline))
+		if err != nil {
+			return hashes
+		}
+		if inv, ok := message.(*appmessage.MsgInvRelayBlock); ok {
+			hashes = append(hashes, inv.Hash.String())
+		}
+	}
+}
+
+// Disconnect closes the connection behind the routes.
+func (r *peerRoutes) Disconnect() {
+	r.netConnection.Disconnect()
+	if r.release != nil {
+		r.release()
+	}
+}
+
+func generateCrawlRouteInitializer() (netadapter.RouterInitializer, <-chan *peerRoutes) {
+	cmdsWithBuiltInRoutes := []appmessage.MessageCommand{
+		appmessage.CmdVersion,
+		appmessage.CmdVerAck,
+		appmessage.CmdRequestAddresses,
+		appmessage.CmdAddresses,
+		appmessage.CmdPing}
+
+	everythingElse := make([]appmessage.MessageCommand, 0, len(appmessage.ProtocolMessageCommandToString)-len(cmdsWithBuiltInRoutes))
+outerLoop:
+	for command := range appmessage.ProtocolMessageCommandToString {
+		for _, cmdWithBuiltInRoute := range cmdsWithBuiltInRoutes {
+			if command == cmdWithBuiltInRoute {
+				continue outerLoop
+			}
+		}
+		everythingElse = append(everythingElse, command)
+	}
+
+	routesChan := make(chan *peerRoutes)
+
+	routeInitializer := func(router *router.Router, netConnection *netadapter.NetConnection) {
+		handshakeRoute, err := router.AddIncomingRoute("handshake", []appmessage.MessageCommand{appmessage.CmdVersion, appmessage.CmdVerAck})
+		if err != nil {
+			panic(errors.Wrap(err, "error registering handshake route"))
+		}
+		addressesRoute, err := router.AddIncomingRoute("addresses", []appmessage.MessageCommand{appmessage.CmdRequestAddresses, appmessage.CmdAddresses})
+		if err != nil {
+			panic(errors.Wrap(err, "error registering addresses route"))
+		}
+		pingRoute, err := router.AddIncomingRoute("ping", []appmessage.MessageCommand{appmessage.CmdPing})
+		if err != nil {
+			panic(errors.Wrap(err, "error registering ping route"))
+		}
+		otherRoute, err := router.AddIncomingRoute("everything else", everythingElse)
+		if err != nil {
+			panic(errors.Wrap(err, "error registering everythingElseRoute"))
+		}
+
+		err = router.OutgoingRoute().Enqueue(appmessage.NewMsgReady())
+		if err != nil {
+			panic(errors.Wrap(err, "error sending ready message"))
+		}
+
+		spawn("crawlAdapter-routeInitializer-sendRoutesToChan", func() {
+			routesChan <- &peerRoutes{
+				netConnection:  netConnection,
+				outgoingRoute:  router.OutgoingRoute(),
+				handshakeRoute: handshakeRoute,
+				addressesRoute: addressesRoute,
+				pingRoute:      pingRoute,
+				otherRoute:     otherRoute,
+			}
+		})
+	}
+
+	return routeInitializer, routesChan
+}
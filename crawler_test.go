@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/karlsen-network/dnsseeder/fakenode"
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/karlsen-network/karlsend/domain/dagconfig"
+	"github.com/karlsen-network/karlsend/infrastructure/config"
+)
+
+// newTestCrawlAdapter returns a crawl adapter of the network with the given
+// parameters, requiring peers to announce protocolVersion unless it is 0.
+func newTestCrawlAdapter(t *testing.T, params *dagconfig.Params, protocolVersion uint32) *crawlAdapter {
+	netAdapter, err := newCrawlAdapter(&config.Config{Flags: &config.Flags{
+		NetworkFlags: config.NetworkFlags{ActiveNetParams: params},
+	}}, protocolVersion)
+	if err != nil {
+		t.Fatalf("newCrawlAdapter: %v", err)
+	}
+	t.Cleanup(func() { netAdapter.netAdapter.Stop() })
+	return netAdapter
+}
+
+func TestCrawlFakeNodes(t *testing.T) {
+	params := &dagconfig.DevnetParams
+	netAdapter := newTestCrawlAdapter(t, params, 0)
+	strictAdapter := newTestCrawlAdapter(t, params, fakenode.DefaultProtocolVersion)
+
+	known := []*appmessage.NetAddress{
+		appmessage.NewNetAddressIPPort(net.ParseIP("203.0.113.1"), 42611),
+		appmessage.NewNetAddressIPPort(net.ParseIP("203.0.113.2"), 42611),
+	}
+	tests := []struct {
+		name       string
+		behavior   fakenode.Behavior
+		netAdapter *crawlAdapter
+		reason     string
+		learned    int
+	}{
+		{"good", fakenode.Behavior{Addresses: known, UserAgent: "/karlsend:1.0.0/"}, netAdapter, "", 2},
+		{"slow", fakenode.Behavior{HandshakeDelay: 200 * time.Millisecond}, netAdapter, "", 0},
+		{"spammy", fakenode.Behavior{Addresses: known, Spam: 100}, netAdapter, "", 2},
+		{"wrong network", fakenode.Behavior{Network: dagconfig.MainnetParams.Name}, netAdapter, failureBadMagic, 0},
+		{"protocol version", fakenode.Behavior{ProtocolVersion: 4}, strictAdapter, failureProtocolError, 0},
+		{"disconnect on connect", fakenode.Behavior{DisconnectOnConnect: true}, netAdapter, failureDisconnected, 0},
+		{"disconnect after handshake", fakenode.Behavior{DisconnectAfterHandshake: true}, netAdapter,
+			failureProtocolError, 0},
+	}
+	for _, test := range tests {
+		node, err := fakenode.Start(params, test.behavior)
+		if err != nil {
+			t.Fatalf("%s: Start: %v", test.name, err)
+		}
+
+		addr := appmessage.NewNetAddressIPPort(net.ParseIP("127.0.0.1"), node.Port())
+		m := newTestManager(t, params, node.Port())
+		m.nodes["127.0.0.1"] = &Node{Addr: addr, LastSeen: time.Now()}
+		err = pollPeer(m, test.netAdapter, addr)
+		node.Stop()
+
+		reason := ""
+		if err != nil {
+			reason = classifyCrawlFailure(err)
+		}
+		if reason != test.reason {
+			t.Errorf("%s: expected failure reason %q, got %q (%v)", test.name, test.reason, reason, err)
+			continue
+		}
+		crawled, _ := m.Node(addr.IP)
+		if (test.reason == "") != !crawled.LastSuccess.IsZero() {
+			t.Errorf("%s: unexpected last success %s", test.name, crawled.LastSuccess)
+		}
+		if m.AddressCount() != 1+test.learned {
+			t.Errorf("%s: expected %d addresses to be learned, got %d", test.name, test.learned, m.AddressCount()-1)
+		}
+		if node.Connections() != 1 {
+			t.Errorf("%s: expected a single connection, got %d", test.name, node.Connections())
+		}
+
+		switch test.name {
+		case "good":
+			if crawled.UserAgent != "/karlsend:1.0.0/" || crawled.ProtocolVersion != fakenode.DefaultProtocolVersion {
+				t.Errorf("%s: unexpected version details %q %d", test.name, crawled.UserAgent, crawled.ProtocolVersion)
+			}
+		case "slow":
+			if crawled.Latency < 200*time.Millisecond {
+				t.Errorf("%s: expected the handshake latency to be measured, got %s", test.name, crawled.Latency)
+			}
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jrick/logrotate/rotator"
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/pkg/errors"
+)
+
+// crawlEvent records a single crawl attempt, in the format replayed by the
+// simulate command. Synthetic topologies are written in the same format.
+type crawlEvent struct {
+	Time                time.Time `json:"time"`
+	Network             string    `json:"network,omitempty"`
+	Address             string    `json:"address"`
+	Reached             bool      `json:"reached"`
+	LatencyMilliseconds int64     `json:"latencyMilliseconds,omitempty"`
+	UserAgent           string    `json:"userAgent,omitempty"`
+	ProtocolVersion     uint32    `json:"protocolVersion,omitempty"`
+	Services            uint64    `json:"services,omitempty"`
+	SubnetworkID        string    `json:"subnetworkId,omitempty"`
+	Addresses           []string  `json:"addresses,omitempty"`
+}
+
+// newCrawlEvent returns the event of a crawl attempt of addr on network,
+// which ended at now. version and received are those the peer sent if it
+// was reached.
+func newCrawlEvent(now time.Time, network string, addr *appmessage.NetAddress, reached bool,
+	latency time.Duration, version *appmessage.MsgVersion, received []*appmessage.NetAddress) *crawlEvent {
+
+	event := &crawlEvent{
+		Time:    now,
+		Network: network,
+		Address: net.JoinHostPort(addr.IP.String(), strconv.Itoa(int(addr.Port))),
+		Reached: reached,
+	}
+	if !reached {
+		return event
+	}
+	event.LatencyMilliseconds = latency.Milliseconds()
+	if version != nil {
+		event.UserAgent = version.UserAgent
+		event.ProtocolVersion = version.ProtocolVersion
+		event.Services = uint64(version.Services)
+		if version.SubnetworkID != nil {
+			event.SubnetworkID = version.SubnetworkID.String()
+		}
+	}
+	event.Addresses = make([]string, len(received))
+	for i, receivedAddr := range received {
+		event.Addresses[i] = net.JoinHostPort(receivedAddr.IP.String(), strconv.Itoa(int(receivedAddr.Port)))
+	}
+	return event
+}
+
+// crawlRecorder writes every crawl attempt to a rolled file as JSON lines.
+type crawlRecorder struct {
+	mtx  sync.Mutex
+	file io.WriteCloser
+}
+
+// crawlRecord is the crawl recorder of the running seeder, or nil if
+// recording is disabled. It is set before any crawl starts, so every attempt
+// is recorded, and never changes afterwards.
+var crawlRecord *crawlRecorder
+
+// newCrawlRecorder returns a crawl recorder writing to path, rolled like the
+// log files.
+func newCrawlRecorder(path string, maxSizeMB int64, maxRolls int) (*crawlRecorder, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create crawl record directory")
+	}
+	file, err := rotator.New(path, maxSizeMB*1000, false, maxRolls)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create crawl record rotator")
+	}
+	return &crawlRecorder{file: file}, nil
+}
+
+// record writes event. A nil crawl recorder records nothing.
+func (r *crawlRecorder) record(event *crawlEvent) {
+	if r == nil {
+		return
+	}
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		crawlLog.Warnf("Failed to write crawl record: %v", err)
+		return
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	_, err = r.file.Write(append(line, '\n'))
+	if err != nil {
+		crawlLog.Warnf("Failed to write crawl record: %v", err)
+	}
+}
+
+// Close closes the crawl record file. Closing a nil crawl recorder does
+// nothing.
+func (r *crawlRecorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	return r.file.Close()
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"path/filepath"
+	"strconv"
+
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/karlsen-network/karlsend/domain/dagconfig"
+	"github.com/karlsen-network/karlsend/infrastructure/config"
+	"github.com/karlsen-network/karlsend/util"
+	"github.com/pkg/errors"
+)
+
+// builtinNetworks are the names of the networks known to karlsend, as
+// selected in the configuration.
+var builtinNetworks = []string{"mainnet", "testnet", "simnet", "devnet"}
+
+// CustomNetworkConfig describes a network unknown to karlsend, such as a
+// private devnet, by the parameters it changes from a built-in network.
+type CustomNetworkConfig struct {
+	Name string `yaml:"name"`
+
+	// Base is the built-in network the parameters are copied from,
+	// devnet if empty.
+	Base string `yaml:"base"`
+
+	Magic       uint32   `yaml:"magic"`
+	DefaultPort string   `yaml:"defaultPort"`
+	DNSSeeds    []string `yaml:"dnsSeeds"`
+	Prefix      string   `yaml:"prefix"`
+
+	// ProtocolVersion, if set, is the only protocol version accepted
+	// from the crawled peers.
+	ProtocolVersion uint32 `yaml:"protocolVersion"`
+}
+
+// params returns the parameters of the custom network.
+func (c *CustomNetworkConfig) params() (*dagconfig.Params, error) {
+	if c.Name == "" {
+		return nil, errors.New("every custom network must have a name")
+	}
+	for _, name := range builtinNetworks {
+		if c.Name == name {
+			return nil, errors.Errorf("custom network %s has the name of a built-in network", c.Name)
+		}
+	}
+
+	base := c.Base
+	if base == "" {
+		base = "devnet"
+	}
+	flags, err := builtinNetworkFlags(base)
+	if err != nil {
+		return nil, errors.Wrapf(err, "custom network %s", c.Name)
+	}
+	params := *flags.NetParams()
+	params.Name = c.Name
+
+	if c.Magic == 0 {
+		return nil, errors.Errorf("custom network %s must have magic bytes", c.Name)
+	}
+	params.Net = appmessage.KaspaNet(c.Magic)
+	for _, name := range builtinNetworks {
+		flags, _ := builtinNetworkFlags(name)
+		if flags.NetParams().Net == params.Net {
+			return nil, errors.Errorf("custom network %s has the magic bytes of %s", c.Name, name)
+		}
+	}
+
+	if c.DefaultPort != "" {
+		port, err := strconv.Atoi(c.DefaultPort)
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, errors.Errorf("invalid default port %s of custom network %s", c.DefaultPort, c.Name)
+		}
+		params.DefaultPort = c.DefaultPort
+	}
+	if c.DNSSeeds != nil {
+		params.DNSSeeds = c.DNSSeeds
+	}
+	if c.Prefix != "" {
+		params.Prefix, err = util.ParsePrefix(c.Prefix)
+		if err != nil {
+			return nil, errors.Wrapf(err, "custom network %s", c.Name)
+		}
+	}
+	return &params, nil
+}
+
+// findCustomNetwork returns the custom network with the given name, or nil
+// if there is none.
+func findCustomNetwork(customNetworks []CustomNetworkConfig, name string) *CustomNetworkConfig {
+	for i := range customNetworks {
+		if customNetworks[i].Name == name {
+			return &customNetworks[i]
+		}
+	}
+	return nil
+}
+
+// validateCustomNetworks checks the parameters of the custom networks, and
+// that their names and magic bytes are distinct.
+func validateCustomNetworks(customNetworks []CustomNetworkConfig) error {
+	names := make(map[string]bool)
+	magics := make(map[uint32]bool)
+	for i := range customNetworks {
+		customNetwork := &customNetworks[i]
+		_, err := customNetwork.params()
+		if err != nil {
+			return err
+		}
+		if names[customNetwork.Name] {
+			return errors.Errorf("custom network %s is defined more than once", customNetwork.Name)
+		}
+		names[customNetwork.Name] = true
+		if magics[customNetwork.Magic] {
+			return errors.Errorf("custom network %s has the magic bytes of another custom network", customNetwork.Name)
+		}
+		magics[customNetwork.Magic] = true
+	}
+	return nil
+}
+
+// registerCustomNetworks registers the parameters of the custom networks
+// with karlsend, so its packages look them up like the built-in ones. It
+// must be called once, at startup.
+func registerCustomNetworks(customNetworks []CustomNetworkConfig) error {
+	for i := range customNetworks {
+		_, err := customNetworks[i].register()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// register registers the parameters of the custom network with karlsend,
+// and returns the network flags selecting it.
+func (c *CustomNetworkConfig) register() (config.NetworkFlags, error) {
+	flags, err := builtinNetworkFlags("mainnet")
+	if err != nil {
+		return flags, err
+	}
+	flags.ActiveNetParams, err = c.params()
+	if err != nil {
+		return flags, err
+	}
+	err = dagconfig.Register(flags.ActiveNetParams)
+	if err != nil {
+		return flags, errors.Wrapf(err, "failed to register custom network %s", c.Name)
+	}
+	return flags, nil
+}
+
+// registerNetwork registers the custom network while the seeder is running,
+// and starts crawling it and serving its zones. Its peer pool is kept in
+// its own directory next to that of the primary network, as for the
+// networks of the configuration. The network is served until the seeder
+// stops; it has to be added to the configuration to be served after a
+// restart.
+func registerNetwork(cfg *ConfigFlags, customNetwork *CustomNetworkConfig, zones []ZoneConfig,
+	knownPeers []string, seeder string) (*seederNetwork, error) {
+
+	for _, zone := range zones {
+		if zone.Host == "" || zone.Nameserver == "" {
+			return nil, errors.New("every zone must have a host and a nameserver")
+		}
+		err := validateZone(zone)
+		if err != nil {
+			return nil, err
+		}
+		if dnsServer != nil && dnsServer.servesZone(zone.Host) {
+			return nil, errors.Errorf("zone %s is already served", zone.Host)
+		}
+	}
+
+	networksMtx.Lock()
+	defer networksMtx.Unlock()
+	for _, network := range networks {
+		if network.name() == customNetwork.Name {
+			return nil, errors.Errorf("network %s is already served", customNetwork.Name)
+		}
+	}
+	flags, err := customNetwork.register()
+	if err != nil {
+		return nil, err
+	}
+
+	network, err := newSeederNetwork(flags, filepath.Dir(cfg.AppDir), zones, knownPeers, seeder)
+	if err != nil {
+		return nil, err
+	}
+	network.protocolVersion = customNetwork.ProtocolVersion
+	if dnsServer != nil {
+		err = dnsServer.AddNetwork(network)
+		if err != nil {
+			close(network.amgr.quit)
+			return nil, err
+		}
+	}
+	networks = append(networks, network)
+
+	wg.Add(1)
+	spawn("registerNetwork-creep", func() { creep(network) })
+	spawn("registerNetwork-watchPeers", func() { peerFeed.watch(network.amgr, network.amgr.quit) })
+	return network, nil
+}
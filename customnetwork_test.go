@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/karlsen-network/karlsend/domain/dagconfig"
+	"github.com/karlsen-network/karlsend/util"
+)
+
+func TestCustomNetworkParams(t *testing.T) {
+	privnet := CustomNetworkConfig{
+		Name:        "karlsen-privnet",
+		Magic:       0x4b4c5350,
+		DefaultPort: "42611",
+		DNSSeeds:    []string{"privnet-seed.example.org"},
+		Prefix:      "karlsendev",
+	}
+	params, err := privnet.params()
+	if err != nil {
+		t.Fatalf("params: %s", err)
+	}
+	if params.Name != "karlsen-privnet" || params.Net != appmessage.KaspaNet(0x4b4c5350) ||
+		params.DefaultPort != "42611" || params.Prefix != util.Bech32PrefixKaspaDev {
+		t.Errorf("unexpected params: %s %x %s %s", params.Name, params.Net, params.DefaultPort, params.Prefix)
+	}
+	if len(params.DNSSeeds) != 1 || params.DNSSeeds[0] != "privnet-seed.example.org" {
+		t.Errorf("unexpected DNS seeds: %v", params.DNSSeeds)
+	}
+	if params.K != dagconfig.DevnetParams.K || dagconfig.DevnetParams.Name == params.Name {
+		t.Errorf("expected the other params to be copied from devnet")
+	}
+
+	flags, err := networkFlagsFromName("karlsen-privnet", []CustomNetworkConfig{privnet})
+	if err != nil {
+		t.Fatalf("networkFlagsFromName: %s", err)
+	}
+	if flags.NetParams().Name != "karlsen-privnet" {
+		t.Errorf("unexpected network %s", flags.NetParams().Name)
+	}
+
+	invalid := []CustomNetworkConfig{
+		{Magic: 1},
+		{Name: "devnet", Magic: 1},
+		{Name: "privnet"},
+		{Name: "privnet", Magic: uint32(appmessage.Mainnet)},
+		{Name: "privnet", Magic: 1, Base: "othernet"},
+		{Name: "privnet", Magic: 1, DefaultPort: "port"},
+		{Name: "privnet", Magic: 1, Prefix: "bitcoin"},
+	}
+	for _, customNetwork := range invalid {
+		if _, err := customNetwork.params(); err == nil {
+			t.Errorf("expected an error for %+v", customNetwork)
+		}
+	}
+
+	other := privnet
+	other.Name = "karlsen-othernet"
+	if validateCustomNetworks([]CustomNetworkConfig{privnet, other}) == nil {
+		t.Errorf("expected an error for custom networks sharing magic bytes")
+	}
+	other.Magic++
+	err = validateCustomNetworks([]CustomNetworkConfig{privnet, other})
+	if err != nil {
+		t.Errorf("validateCustomNetworks: %s", err)
+	}
+}
+
+func TestYAMLCustomNetwork(t *testing.T) {
+	network := "karlsen-privnet"
+	file := fileConfig{
+		Network:        &network,
+		CustomNetworks: []CustomNetworkConfig{{Name: network, Magic: 0x4b4c5350}},
+	}
+	cfg := &ConfigFlags{}
+	err := file.apply(cfg)
+	if err != nil {
+		t.Fatalf("apply: %s", err)
+	}
+	if cfg.CustomNetwork != network || len(cfg.CustomNetworks) != 1 {
+		t.Errorf("unexpected custom network %q %v", cfg.CustomNetwork, cfg.CustomNetworks)
+	}
+
+	unknown := "othernet"
+	file.Network = &unknown
+	if file.apply(&ConfigFlags{}) == nil {
+		t.Errorf("expected an error for an unknown network")
+	}
+}
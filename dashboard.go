@@ -0,0 +1,94 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+	"sort"
+	"time"
+)
+
+//go:embed dashboard/index.html
+var dashboardHTML []byte
+
+// nodeLocator returns the geographic coordinates of a node. It is nil unless
+// a geolocation source is configured, in which case the dashboard map is
+// populated.
+var nodeLocator func(node *Node) (latitude, longitude float64, ok bool)
+
+type versionCount struct {
+	UserAgent string `json:"userAgent"`
+	Count     int    `json:"count"`
+}
+
+type peerLocation struct {
+	IP        string  `json:"ip"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+type dashboardResponse struct {
+	Network   string            `json:"network"`
+	History   []peerCountSample `json:"history"`
+	Versions  []versionCount    `json:"versions"`
+	Locations []peerLocation    `json:"locations"`
+	Failures  []crawlFailure    `json:"failures"`
+}
+
+func (s *HTTPServer) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, err := w.Write(dashboardHTML)
+	if err != nil {
+		log.Warnf("Failed to write dashboard: %v", err)
+	}
+}
+
+// handleDashboardData serves the data displayed by the dashboard. Version
+// distribution and locations only cover good peers.
+func (s *HTTPServer) handleDashboardData(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	response := dashboardResponse{
+		Network:   ActiveConfig().NetParams().Name,
+		History:   history.Samples(),
+		Versions:  []versionCount{},
+		Locations: []peerLocation{},
+		Failures:  recentFailures.Failures(),
+	}
+
+	versions := make(map[string]int)
+	for _, node := range s.amgr.Nodes() {
+		if !node.isGood(now) {
+			continue
+		}
+		userAgent := node.UserAgent
+		if userAgent == "" {
+			userAgent = "unknown"
+		}
+		versions[userAgent]++
+
+		if nodeLocator != nil {
+			latitude, longitude, ok := nodeLocator(&node)
+			if ok {
+				response.Locations = append(response.Locations, peerLocation{
+					IP:        node.Addr.IP.String(),
+					Latitude:  latitude,
+					Longitude: longitude,
+				})
+			}
+		}
+	}
+	for userAgent, count := range versions {
+		response.Versions = append(response.Versions, versionCount{UserAgent: userAgent, Count: count})
+	}
+	sort.Slice(response.Versions, func(i, j int) bool {
+		if response.Versions[i].Count != response.Versions[j].Count {
+			return response.Versions[i].Count > response.Versions[j].Count
+		}
+		return response.Versions[i].UserAgent < response.Versions[j].UserAgent
+	})
+
+	writeJSON(w, http.StatusOK, response)
+}
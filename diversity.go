@@ -0,0 +1,149 @@
+package main
+
+import (
+	"net"
+	"sync"
+
+	"github.com/karlsen-network/dnsseeder/netgroup"
+)
+
+var (
+	grouperMtx sync.RWMutex
+	// grouper groups the peers for the per netgroup limits of crawls and
+	// answers. The nil grouper groups them by network.
+	grouper *netgroup.Grouper
+)
+
+// setGrouper sets the grouper of the per netgroup limits.
+func setGrouper(g *netgroup.Grouper) {
+	grouperMtx.Lock()
+	defer grouperMtx.Unlock()
+	grouper = g
+}
+
+// diversityFilter caps the number of addresses sharing a netgroup, a
+// country, a continent or an origin AS in a single answer. Addresses
+// without a known location or AS are only capped by netgroup. Addresses in
+// the networks of cloud and hosting providers can be capped as well, or
+// left out.
+type diversityFilter struct {
+	locate func(ip net.IP) *NodeLocation
+
+	maxPerNetgroup  int
+	maxPerCountry   int
+	maxPerContinent int
+	maxPerASN       int
+	maxHosted       int
+	excludeHosted   bool
+
+	netgroups  map[string]int
+	countries  map[string]int
+	continents map[string]int
+	asns       map[uint]int
+	hosted     int
+}
+
+// newDiversityFilter returns a filter enforcing the limits of the active
+// configuration, or nil if there are none. The location limits only apply
+// when a GeoIP database is configured.
+func newDiversityFilter() *diversityFilter {
+	cfg := ActiveConfig()
+	if cfg == nil {
+		return nil
+	}
+	f := &diversityFilter{
+		maxPerNetgroup: cfg.MaxPerNetgroup,
+		maxHosted:      cfg.MaxHosted,
+		excludeHosted:  cfg.ExcludeHosted,
+		netgroups:      make(map[string]int),
+	}
+	if len(geoIPDatabases) > 0 {
+		f.locate = lookupLocation
+		f.maxPerCountry = cfg.MaxPerCountry
+		f.maxPerContinent = cfg.MaxPerContinent
+		f.maxPerASN = cfg.MaxPerASN
+		f.countries = make(map[string]int)
+		f.continents = make(map[string]int)
+		f.asns = make(map[uint]int)
+	}
+	if f.maxPerNetgroup <= 0 && f.maxPerCountry <= 0 && f.maxPerContinent <= 0 && f.maxPerASN <= 0 &&
+		f.maxHosted <= 0 && !f.excludeHosted {
+		return nil
+	}
+	return f
+}
+
+// netgroupKey returns the netgroup of ip: its origin AS if grouping by AS
+// and it is known, and otherwise its /16 network for IPv4 and its /32
+// network for IPv6.
+func netgroupKey(ip net.IP) string {
+	grouperMtx.RLock()
+	g := grouper
+	grouperMtx.RUnlock()
+	return g.Group(ip)
+}
+
+// allow returns whether ip can be added to the answer, and counts it if so.
+// A nil filter allows every address.
+func (f *diversityFilter) allow(ip net.IP) bool {
+	return f.check(ip) == ""
+}
+
+// check returns the limit keeping ip out of the answer: netgroup, hosted,
+// country, continent or asn. If there is none, it returns an empty string
+// and counts ip.
+func (f *diversityFilter) check(ip net.IP) string {
+	if f == nil {
+		return ""
+	}
+
+	netgroup := netgroupKey(ip)
+	if f.maxPerNetgroup > 0 && f.netgroups[netgroup] >= f.maxPerNetgroup {
+		return "netgroup"
+	}
+	hosted := false
+	if f.maxHosted > 0 || f.excludeHosted {
+		hosted = lookupHosting(ip) != ""
+		if hosted && (f.excludeHosted || f.hosted >= f.maxHosted) {
+			return "hosted"
+		}
+	}
+	var location *NodeLocation
+	if f.locate != nil {
+		location = f.locate(ip)
+	}
+	if location == nil {
+		f.netgroups[netgroup]++
+		if hosted {
+			f.hosted++
+		}
+		return ""
+	}
+
+	if f.maxPerCountry > 0 && location.Country != "" &&
+		f.countries[location.Country] >= f.maxPerCountry {
+		return "country"
+	}
+	if f.maxPerContinent > 0 && location.Continent != "" &&
+		f.continents[location.Continent] >= f.maxPerContinent {
+		return "continent"
+	}
+	if f.maxPerASN > 0 && location.ASN != 0 && f.asns[location.ASN] >= f.maxPerASN {
+		return "asn"
+	}
+
+	f.netgroups[netgroup]++
+	if hosted {
+		f.hosted++
+	}
+	if location.Country != "" {
+		f.countries[location.Country]++
+	}
+	if location.Continent != "" {
+		f.continents[location.Continent]++
+	}
+	if location.ASN != 0 {
+		f.asns[location.ASN]++
+	}
+	return ""
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestDiversityFilter(t *testing.T) {
+	locations := map[string]*NodeLocation{
+		"1.0.0.1": {Country: "DE", Continent: "EU"},
+		"1.0.0.2": {Country: "DE", Continent: "EU"},
+		"1.0.0.3": {Country: "FR", Continent: "EU"},
+		"1.0.0.4": {Country: "NL", Continent: "EU"},
+		"1.0.0.5": {Country: "US", Continent: "NA", ASN: 64500},
+		"1.0.0.7": {Country: "CA", Continent: "NA", ASN: 64500},
+		"1.0.0.8": {ASN: 64501},
+	}
+	f := &diversityFilter{
+		locate:          func(ip net.IP) *NodeLocation { return locations[ip.String()] },
+		maxPerCountry:   1,
+		maxPerContinent: 2,
+		maxPerASN:       1,
+		countries:       make(map[string]int),
+		continents:      make(map[string]int),
+		asns:            make(map[uint]int),
+		netgroups:       make(map[string]int),
+	}
+
+	tests := []struct {
+		ip      string
+		allowed bool
+	}{
+		{"1.0.0.1", true},
+		{"1.0.0.2", false}, // second peer from DE
+		{"1.0.0.3", true},
+		{"1.0.0.4", false}, // third peer from EU
+		{"1.0.0.5", true},
+		{"1.0.0.6", true},  // unknown location
+		{"1.0.0.7", false}, // second peer from AS64500
+		{"1.0.0.8", true},
+	}
+	for _, test := range tests {
+		allowed := f.allow(net.ParseIP(test.ip))
+		if allowed != test.allowed {
+			t.Errorf("%s: expected allowed %t, got %t", test.ip, test.allowed, allowed)
+		}
+	}
+
+	f = &diversityFilter{maxPerNetgroup: 1, netgroups: make(map[string]int)}
+	tests = []struct {
+		ip      string
+		allowed bool
+	}{
+		{"1.0.0.1", true},
+		{"1.0.255.2", false}, // same /16
+		{"1.1.0.1", true},
+		{"2001:db8::1", true},
+		{"2001:db8:ffff::1", false}, // same /32
+		{"2001:db9::1", true},
+	}
+	for _, test := range tests {
+		allowed := f.allow(net.ParseIP(test.ip))
+		if allowed != test.allowed {
+			t.Errorf("%s: expected allowed %t, got %t", test.ip, test.allowed, allowed)
+		}
+	}
+
+	var nilFilter *diversityFilter
+	if !nilFilter.allow(net.ParseIP("1.0.0.1")) {
+		t.Errorf("expected a nil filter to allow every address")
+	}
+}
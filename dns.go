@@ -5,60 +5,138 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/karlsen-network/karlsend/app/appmessage"
 	"net"
 	"os"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/karlsen-network/dnsseeder/seeddns"
 	"github.com/karlsen-network/karlsend/domain/consensus/model/externalapi"
-	"github.com/karlsen-network/karlsend/domain/consensus/utils/subnetworks"
-
-	"github.com/karlsen-network/karlsend/infrastructure/network/dnsseed"
 	"github.com/pkg/errors"
 
 	"github.com/miekg/dns"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // DNSServer struct
 type DNSServer struct {
+	zonesMtx sync.RWMutex
+	zones    []*dnsZone
+
+	listen  string
+	limiter *rateLimiter
+	guard   *amplificationGuard
+
+	// nat64Prefix, when set, is the prefix the IPv4 peers are embedded in
+	// to answer AAAA queries for the DNS64 subdomain of each zone.
+	nat64Prefix *net.IPNet
+}
+
+// dnsZone is a single zone the DNS server is authoritative for, serving
+// the peers of the network it belongs to
+type dnsZone struct {
 	hostname   string
-	listen     string
 	nameserver string
+	family     string
+	services   appmessage.ServiceFlag
+	authority  dns.RR
+	soa        *dns.SOA
+	amgr       *Manager
+
+	// usage counts the queries of the zone and enforces its quota.
+	usage *zoneUsage
+}
+
+// servesType returns whether the zone serves the peers of the address
+// family queried by qtype.
+func (z *dnsZone) servesType(qtype uint16) bool {
+	switch z.family {
+	case zoneFamilyIPv4:
+		return qtype == dns.TypeA
+	case zoneFamilyIPv6:
+		return qtype == dns.TypeAAAA
+	}
+	return true
+}
+
+// answerServices returns the services the peers served for a query must
+// advertise. The partial nodes subdomain serves nodes that lack the full
+// node service by definition, so the services required by the zone don't
+// apply to it.
+func (z *dnsZone) answerServices(partial bool) appmessage.ServiceFlag {
+	if partial {
+		return 0
+	}
+	return z.services
+}
+
+// newZoneSOA returns the SOA record of the zone hostname served by
+// nameserver. Its serial is the time the server started, since the records
+// change continuously anyway.
+func newZoneSOA(hostname string, nameserver string, started time.Time) *dns.SOA {
+	ttl := uint32(defaultDNSTTL)
+	if cfg := ActiveConfig(); cfg != nil {
+		ttl = cfg.DNSTTL
+	}
+	return &dns.SOA{
+		Hdr: dns.RR_Header{
+			Name:   hostname,
+			Rrtype: dns.TypeSOA,
+			Class:  dns.ClassINET,
+			Ttl:    ttl,
+		},
+		Ns:      nameserver,
+		Mbox:    "hostmaster." + hostname,
+		Serial:  uint32(started.Unix()),
+		Refresh: 86400,
+		Retry:   3600,
+		Expire:  604800,
+		Minttl:  ttl,
+	}
 }
 
 // Start - starts server
 func (d *DNSServer) Start() {
 	defer wg.Done()
 
-	rr := fmt.Sprintf("%s 86400 IN NS %s", d.hostname, d.nameserver)
-	authority, err := dns.NewRR(rr)
-	if err != nil {
-		log.Infof("NewRR: %v", err)
-		return
+	d.zonesMtx.Lock()
+	for _, zone := range d.zones {
+		err := zone.prepare(time.Now())
+		if err != nil {
+			d.zonesMtx.Unlock()
+			dnsLog.Infof("NewRR: %v", err)
+			return
+		}
 	}
+	d.zonesMtx.Unlock()
 
 	udpAddr, err := net.ResolveUDPAddr("udp4", d.listen)
 	if err != nil {
-		log.Infof("ResolveUDPAddr: %v", err)
+		dnsLog.Infof("ResolveUDPAddr: %v", err)
 		return
 	}
 
 	udpListen, err := net.ListenUDP("udp", udpAddr)
 	if err != nil {
-		log.Infof("ListenUDP: %v", err)
+		dnsLog.Infof("ListenUDP: %v", err)
 		return
 	}
 	defer udpListen.Close()
+	listeners.setDNSListening(true)
+	defer listeners.setDNSListening(false)
 
 	for {
 		b := make([]byte, 512)
 	mainLoop:
 		err := udpListen.SetReadDeadline(time.Now().Add(time.Second))
 		if err != nil {
-			log.Infof("SetReadDeadline: %v", err)
+			dnsLog.Infof("SetReadDeadline: %v", err)
 			os.Exit(1)
 		}
 		_, addr, err := udpListen.ReadFromUDP(b)
@@ -69,14 +147,14 @@ func (d *DNSServer) Start() {
 					// use goto in order to do not re-allocate 'b' buffer
 					goto mainLoop
 				}
-				log.Infof("DNS server shutdown")
+				dnsLog.Infof("DNS server shutdown")
 				return
 			}
 			var opErr *net.OpError
 			if errors.As(err, &opErr) {
-				log.Infof("Read: %T", opErr.Err)
+				dnsLog.Infof("Read: %T", opErr.Err)
 			} else {
-				log.Errorf("Unknown error: %s", err)
+				dnsLog.Errorf("Unknown error: %s", err)
 			}
 			continue
 		}
@@ -84,158 +162,582 @@ func (d *DNSServer) Start() {
 		wg.Add(1)
 
 		spawn("DNSServer.Start-DNSServer.handleDNSRequest",
-			func() { d.handleDNSRequest(addr, authority, udpListen, b) })
+			func() { d.handleDNSRequest(addr, udpListen, b) })
 	}
 }
 
-// NewDNSServer - create DNS server
-func NewDNSServer(hostname, nameserver, listen string) *DNSServer {
-	if hostname[len(hostname)-1] != '.' {
-		hostname = hostname + "."
+// NewDNSServer - create DNS server, authoritative for the zones of the
+// given networks. limiter may be nil to answer every query.
+func NewDNSServer(networks []*seederNetwork, listen string, limiter *rateLimiter,
+	guard *amplificationGuard) *DNSServer {
+
+	d := &DNSServer{listen: listen, limiter: limiter, guard: guard}
+	for _, network := range networks {
+		d.zones = append(d.zones, newDNSZones(network)...)
 	}
-	if nameserver[len(nameserver)-1] != '.' {
-		nameserver = nameserver + "."
+	return d
+}
+
+// newDNSZones returns the zones serving the peers of network.
+func newDNSZones(network *seederNetwork) []*dnsZone {
+	zones := make([]*dnsZone, 0, len(network.zones))
+	for _, zone := range network.zones {
+		zones = append(zones, &dnsZone{
+			hostname:   dns.Fqdn(strings.ToLower(zone.Host)),
+			nameserver: dns.Fqdn(zone.Nameserver),
+			family:     zone.Family,
+			services:   appmessage.ServiceFlag(zone.Services),
+			amgr:       network.amgr,
+			usage:      network.amgr.zoneUsage(zone.Host, zone.QPS),
+		})
 	}
+	return zones
+}
 
-	return &DNSServer{
-		hostname:   hostname,
-		listen:     listen,
-		nameserver: nameserver,
+// prepare builds the NS and SOA records of the zone.
+func (z *dnsZone) prepare(now time.Time) error {
+	rr := fmt.Sprintf("%s 86400 IN NS %s", z.hostname, z.nameserver)
+	authority, err := dns.NewRR(rr)
+	if err != nil {
+		return err
 	}
+	z.authority = authority
+	z.soa = newZoneSOA(z.hostname, z.nameserver, now)
+	return nil
 }
 
-func (d *DNSServer) extractSubnetworkID(addr *net.UDPAddr, domainName string) (*externalapi.DomainSubnetworkID, bool, error) {
-	// Domain name may be in following format:
-	//   [n[subnetwork].]hostname
-	// where connmgr.SubnetworkIDPrefixChar is a prefix
-	var subnetworkID *externalapi.DomainSubnetworkID
-	includeAllSubnetworks := true
-	if d.hostname != domainName {
-		labels := dns.SplitDomainName(domainName)
-		if labels[0][0] == dnsseed.SubnetworkIDPrefixChar {
-			includeAllSubnetworks = false
-			if len(labels[0]) > 1 {
-				subnetworkID, err := subnetworks.FromString(labels[0][1:])
-				if err != nil {
-					log.Infof("%s: subnetworkid.NewFromStr: %v", addr, err)
-					return subnetworkID, includeAllSubnetworks, err
-				}
+// AddNetwork starts serving the zones of network, registered while the
+// server is running. None of its zones may already be served.
+func (d *DNSServer) AddNetwork(network *seederNetwork) error {
+	zones := newDNSZones(network)
+	for _, zone := range zones {
+		err := zone.prepare(time.Now())
+		if err != nil {
+			return errors.Wrapf(err, "invalid zone %s", zone.hostname)
+		}
+	}
+
+	d.zonesMtx.Lock()
+	defer d.zonesMtx.Unlock()
+	for _, zone := range zones {
+		for _, served := range d.zones {
+			if served.hostname == zone.hostname {
+				return errors.Errorf("zone %s is already served", zone.hostname)
 			}
 		}
 	}
-	return subnetworkID, includeAllSubnetworks, nil
+	d.zones = append(d.zones, zones...)
+	return nil
+}
+
+// servesZone returns whether the zone with the given host is served.
+func (d *DNSServer) servesZone(host string) bool {
+	d.zonesMtx.RLock()
+	defer d.zonesMtx.RUnlock()
+	hostname := dns.Fqdn(strings.ToLower(host))
+	for _, zone := range d.zones {
+		if zone.hostname == hostname {
+			return true
+		}
+	}
+	return false
+}
+
+// findZone returns the most specific zone the given domain name belongs
+// to, or nil if it belongs to none.
+func (d *DNSServer) findZone(domainName string) *dnsZone {
+	d.zonesMtx.RLock()
+	defer d.zonesMtx.RUnlock()
+
+	var found *dnsZone
+	for _, zone := range d.zones {
+		if !dns.IsSubDomain(zone.hostname, domainName) {
+			continue
+		}
+		if found == nil || len(zone.hostname) > len(found.hostname) {
+			found = zone
+		}
+	}
+	return found
+}
+
+func (d *DNSServer) extractSubnetworkID(addr *net.UDPAddr, zone *dnsZone, domainName string) (*externalapi.DomainSubnetworkID, bool, error) {
+	subnetworkID, includeAllSubnetworks, err := seeddns.ParseSubnetworkName(zone.hostname, domainName)
+	if err != nil {
+		dnsLog.Infof("%s: subnetworkid.NewFromStr: %v", addr, err)
+	}
+	return subnetworkID, includeAllSubnetworks, err
 }
 
-func (d *DNSServer) validateDNSRequest(addr *net.UDPAddr, b []byte) (dnsMsg *dns.Msg, domainName string, atype string, err error) {
+func (d *DNSServer) validateDNSRequest(addr *net.UDPAddr, b []byte) (dnsMsg *dns.Msg, zone *dnsZone, domainName string, atype string, err error) {
 	dnsMsg = new(dns.Msg)
 	err = dnsMsg.Unpack(b[:])
 	if err != nil {
-		log.Infof("%s: invalid dns message: %v", addr, err)
-		return nil, "", "", err
+		dnsLog.Infof("%s: invalid dns message: %v", addr, err)
+		return nil, nil, "", "", err
 	}
 	if len(dnsMsg.Question) != 1 {
 		str := fmt.Sprintf("%s sent more than 1 question: %d", addr, len(dnsMsg.Question))
-		log.Infof("%s", str)
-		return nil, "", "", errors.Errorf("%s", str)
+		dnsLog.Infof("%s", str)
+		return nil, nil, "", "", errors.Errorf("%s", str)
 	}
 	domainName = strings.ToLower(dnsMsg.Question[0].Name)
-	ff := strings.LastIndex(domainName, d.hostname)
-	if ff < 0 {
+	if isIdentityQuery(dnsMsg.Question[0]) {
+		// The identity of the seeder belongs to no zone.
+		return dnsMsg, nil, domainName, translateDNSQuestion(dnsMsg), nil
+	}
+	zone = d.findZone(domainName)
+	if zone == nil {
 		str := fmt.Sprintf("invalid name: %s", dnsMsg.Question[0].Name)
-		log.Infof("%s", str)
-		return nil, "", "", errors.Errorf("%s", str)
+		dnsLog.Infof("%s", str)
+		return nil, nil, "", "", errors.Errorf("%s", str)
 	}
-	atype, err = translateDNSQuestion(addr, dnsMsg)
-	return dnsMsg, domainName, atype, err
+	atype = translateDNSQuestion(dnsMsg)
+	return dnsMsg, zone, domainName, atype, nil
 }
 
-func translateDNSQuestion(addr *net.UDPAddr, dnsMsg *dns.Msg) (string, error) {
-	var atype string
+// translateDNSQuestion returns the name of the type of the question.
+// Types without a name are reported as TYPE<number>, as in RFC 3597.
+func translateDNSQuestion(dnsMsg *dns.Msg) string {
 	qtype := dnsMsg.Question[0].Qtype
-	switch qtype {
-	case dns.TypeA:
-		atype = "A"
-	case dns.TypeAAAA:
-		atype = "AAAA"
-	case dns.TypeNS:
-		atype = "NS"
-	default:
-		str := fmt.Sprintf("%s: invalid qtype: %d", addr, dnsMsg.Question[0].Qtype)
-		log.Infof("%s", str)
-		return "", errors.Errorf("%s", str)
+	if atype, ok := dns.TypeToString[qtype]; ok {
+		return atype
 	}
-	return atype, nil
+	return fmt.Sprintf("TYPE%d", qtype)
 }
 
-func (d *DNSServer) buildDNSResponse(addr *net.UDPAddr, authority dns.RR, dnsMsg *dns.Msg, includeAllSubnetworks bool,
-	subnetworkID *externalapi.DomainSubnetworkID, atype string) ([]byte, error) {
+// servesQtype returns whether the seeder answers queries of type qtype
+// outside of the status and membership subdomains.
+func servesQtype(qtype uint16) bool {
+	return qtype == dns.TypeA || qtype == dns.TypeAAAA || qtype == dns.TypeNS
+}
 
-	respMsg := dnsMsg.Copy()
-	respMsg.Authoritative = true
-	respMsg.Response = true
+func (d *DNSServer) buildDNSResponse(addr *net.UDPAddr, zone *dnsZone, dnsMsg *dns.Msg, includeAllSubnetworks bool,
+	subnetworkID *externalapi.DomainSubnetworkID, partial bool, atype string) ([]byte, error) {
 
 	qtype := dnsMsg.Question[0].Qtype
+	var respMsg *dns.Msg
 	if qtype != dns.TypeNS {
-		respMsg.Ns = append(respMsg.Ns, authority)
-		addrs := amgr.GoodAddresses(qtype, includeAllSubnetworks, subnetworkID)
-		log.Infof("%s: Sending %d addresses", addr, len(addrs))
-		if len(addrs) == 0 && qtype == dns.TypeAAAA {
-			// Musl (Alpine) requires non-empty result (work-around):
-			addrs = append(addrs, appmessage.NewNetAddressIPPort(net.ParseIP("100::"), uint16(0)))
-		}
-		for _, a := range addrs {
-			rr := fmt.Sprintf("%s 30 IN %s %s", dnsMsg.Question[0].Name, atype, a.IP.String())
-			newRR, err := dns.NewRR(rr)
-			if err != nil {
-				log.Infof("%s: NewRR: %v", addr, err)
-				return nil, err
-			}
+		// The DNS64 subdomain answers AAAA queries with the IPv4 peers,
+		// and A queries with nothing.
+		peersType := qtype
+		dns64 := d.nat64Prefix != nil && zone.isDNS64Query(strings.ToLower(dnsMsg.Question[0].Name))
+		if dns64 {
+			peersType = dns.TypeA
+		}
 
-			respMsg.Answer = append(respMsg.Answer, newRR)
+		var addrs []*appmessage.NetAddress
+		count, _ := answerCount(dnsMsg)
+		if zone.servesType(peersType) && (!dns64 || qtype == dns.TypeAAAA) {
+			addrs = zone.amgr.GoodAddresses(peersType, includeAllSubnetworks, subnetworkID, partial,
+				zone.answerServices(partial), addr.IP, count)
+		}
+		answerAudit.record(addr.IP, zone.hostname, atype, addrs)
+		zone.usage.recordAnswer(len(addrs))
+		dnsLog.Infof("%s: Sending %d addresses", addr, len(addrs))
+		atomic.AddUint64(&stats.dnsAddrsServed, uint64(len(addrs)))
+		var ttlClass string
+		respMsg, ttlClass = d.addressResponse(addr, zone, dnsMsg, addrs, dns64)
+		switch ttlClass {
+		case ttlClassResolver:
+			atomic.AddUint64(&stats.dnsResolverAnswers, 1)
+		case ttlClassECS:
+			atomic.AddUint64(&stats.dnsECSAnswers, 1)
 		}
 	} else {
-		rr := fmt.Sprintf("%s 86400 IN NS %s", dnsMsg.Question[0].Name, d.nameserver)
+		respMsg = dnsMsg.Copy()
+		respMsg.Authoritative = true
+		respMsg.Response = true
+
+		rr := fmt.Sprintf("%s 86400 IN NS %s", dnsMsg.Question[0].Name, zone.nameserver)
 		newRR, err := dns.NewRR(rr)
 		if err != nil {
-			log.Infof("%s: NewRR: %v", addr, err)
+			dnsLog.Infof("%s: NewRR: %v", addr, err)
 			return nil, err
 		}
 
 		respMsg.Answer = append(respMsg.Answer, newRR)
 	}
 
-	sendBytes, err := respMsg.Pack()
-	if err != nil {
-		log.Infof("%s: failed to pack response: %v", addr, err)
-		return nil, err
+	return d.pack(addr, dnsMsg, respMsg)
+}
+
+// addressResponse returns the response to query, an A or AAAA query sent
+// from addr, answering addrs, and the TTL class of the client. The
+// addresses of a DNS64 query are synthesized in the NAT64 prefix.
+func (d *DNSServer) addressResponse(addr *net.UDPAddr, zone *dnsZone, query *dns.Msg,
+	addrs []*appmessage.NetAddress, dns64 bool) (*dns.Msg, string) {
+
+	ttlClass, ttl := answerTTL(addr.IP, query)
+	ips := make([]net.IP, len(addrs))
+	for i, a := range addrs {
+		ips[i] = a.IP
+		if dns64 {
+			ips[i] = seeddns.SynthesizeNAT64(d.nat64Prefix, a.IP)
+		}
 	}
-	return sendBytes, nil
+	response := seeddns.NewAddressResponse(query, zone.authority, ttl, ips)
+	if ttlClass == ttlClassECS {
+		scopeClientSubnet(response)
+	}
+	// The response copies the options of the query, so the answer count
+	// option is taken out unless it was honored.
+	if count, honored := answerCount(query); honored {
+		seeddns.SetAnswerCount(response, count)
+	} else {
+		seeddns.RemoveAnswerCount(response)
+	}
+	return response, ttlClass
 }
 
-func (d *DNSServer) handleDNSRequest(addr *net.UDPAddr, authority dns.RR, udpListen *net.UDPConn, b []byte) {
+func (d *DNSServer) handleDNSRequest(addr *net.UDPAddr, udpListen *net.UDPConn, b []byte) {
 	defer wg.Done()
 
-	dnsMsg, domainName, atype, err := d.validateDNSRequest(addr, b)
+	ctx, span := tracer.Start(context.Background(), "dns.request",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("client.address", addr.String())))
+	var err error
+	defer func() { endSpan(span, err) }()
+
+	atomic.AddUint64(&stats.dnsQueries, 1)
+	if !d.limiter.allow(addr.IP, time.Now()) {
+		atomic.AddUint64(&stats.dnsRateLimited, 1)
+		span.SetAttributes(attribute.Bool("dns.rate_limited", true))
+		dnsLog.Debugf("%s: rate limited", addr)
+		return
+	}
+
+	_, parseSpan := tracer.Start(ctx, "dns.parse")
+	dnsMsg, zone, domainName, atype, err := d.validateDNSRequest(addr, b)
 	if err != nil {
+		endSpan(parseSpan, err)
+		atomic.AddUint64(&stats.dnsErrors, 1)
+		return
+	}
+	queryTypes.add(dnsMsg.Question[0].Qtype)
+	if zone == nil {
+		parseSpan.End()
+		span.SetAttributes(attribute.String("dns.qtype", atype))
+		d.respond(ctx, addr, udpListen, func() ([]byte, error) {
+			return d.buildIdentityResponse(addr, dnsMsg)
+		})
+		return
+	}
+	if !zone.usage.admit(time.Now()) {
+		parseSpan.End()
+		span.SetAttributes(attribute.String("dns.zone", zone.hostname),
+			attribute.Bool("dns.zone_throttled", true))
+		dnsLog.Debugf("%s: zone %s over its query quota", addr, zone.hostname)
 		return
 	}
 
-	subnetworkID, includeAllSubnetworks, err := d.extractSubnetworkID(addr, domainName)
+	if atype == "ANY" {
+		parseSpan.End()
+		span.SetAttributes(attribute.String("dns.zone", zone.hostname),
+			attribute.String("dns.qtype", atype))
+		d.respond(ctx, addr, udpListen, func() ([]byte, error) {
+			return d.buildANYResponse(addr, zone, dnsMsg)
+		})
+		return
+	}
+	if ip, ok := zone.statusQueryIP(domainName); ok && peerStatusEnabled() {
+		parseSpan.End()
+		span.SetAttributes(attribute.String("dns.zone", zone.hostname),
+			attribute.String("dns.qtype", atype))
+		d.respond(ctx, addr, udpListen, func() ([]byte, error) {
+			return d.buildStatusResponse(addr, zone, dnsMsg, ip)
+		})
+		return
+	}
+	if ip, ok := zone.membershipQueryIP(domainName); ok && membershipEnabled() {
+		parseSpan.End()
+		span.SetAttributes(attribute.String("dns.zone", zone.hostname),
+			attribute.String("dns.qtype", atype))
+		d.respond(ctx, addr, udpListen, func() ([]byte, error) {
+			return d.buildMembershipResponse(addr, zone, dnsMsg, ip)
+		})
+		return
+	}
+	if !servesQtype(dnsMsg.Question[0].Qtype) {
+		parseSpan.End()
+		span.SetAttributes(attribute.String("dns.zone", zone.hostname),
+			attribute.String("dns.qtype", atype))
+		d.respond(ctx, addr, udpListen, func() ([]byte, error) {
+			return d.buildNoDataResponse(addr, zone, dnsMsg, atype)
+		})
+		return
+	}
+
+	subnetworkID, includeAllSubnetworks, err := d.extractSubnetworkID(addr, zone, domainName)
+	endSpan(parseSpan, err)
 	if err != nil {
+		atomic.AddUint64(&stats.dnsErrors, 1)
 		return
 	}
+	span.SetAttributes(attribute.String("dns.zone", zone.hostname),
+		attribute.String("dns.qtype", atype))
+
+	partial := zone.isPartialQuery(domainName)
+	dnsLog.Infof("%s: query %d for subnetwork ID %v (partial nodes: %t)",
+		addr, dnsMsg.Question[0].Qtype, subnetworkID, partial)
+
+	d.respond(ctx, addr, udpListen, func() ([]byte, error) {
+		return d.buildDNSResponse(addr, zone, dnsMsg, includeAllSubnetworks, subnetworkID, partial, atype)
+	})
+}
+
+// answerCount returns the maximum number of addresses answered to query:
+// the number requested with the answer count option, within
+// --maxanswercount, or defaultMaxAddresses. It also returns whether the
+// option was honored, so the answer only carries it back then.
+func answerCount(query *dns.Msg) (int, bool) {
+	requested, ok := seeddns.AnswerCount(query)
+	cfg := ActiveConfig()
+	honored := ok && requested > 0 && cfg != nil && cfg.MaxAnswerCount > 0
+	return limitAnswerCount(requested), honored
+}
+
+// limitAnswerCount returns the maximum number of addresses answered to a
+// query requesting the given number of them with the answer count option,
+// zero if it requested none.
+func limitAnswerCount(requested int) int {
+	cfg := ActiveConfig()
+	if requested == 0 || cfg == nil || cfg.MaxAnswerCount == 0 {
+		return defaultMaxAddresses
+	}
+	if requested > cfg.MaxAnswerCount {
+		return cfg.MaxAnswerCount
+	}
+	return requested
+}
+
+// pack packs the response to query, within the buffer of the client and the
+// limits of the amplification guard.
+func (d *DNSServer) pack(addr *net.UDPAddr, query *dns.Msg, respMsg *dns.Msg) ([]byte, error) {
+	_, clamped := d.fit(addr, query, respMsg)
+	if clamped {
+		atomic.AddUint64(&stats.dnsClamped, 1)
+	}
+	sendBytes, err := respMsg.Pack()
+	if err != nil {
+		dnsLog.Infof("%s: failed to pack response: %v", addr, err)
+		return nil, err
+	}
+	return sendBytes, nil
+}
+
+// fit trims the answer records of the response to query sent from addr so
+// it fits the buffer of the client and the limits of the amplification
+// guard. It returns the number of records trimmed to fit the buffer, and
+// whether the guard limited the response.
+func (d *DNSServer) fit(addr *net.UDPAddr, query *dns.Msg, respMsg *dns.Msg) (int, bool) {
+	answered := len(respMsg.Answer)
+	seeddns.FitUDPSize(query, respMsg)
+	trimmed := answered - len(respMsg.Answer)
+	return trimmed, d.guard.limit(addr, query, respMsg)
+}
+
+// buildNoDataResponse answers a query of a type the seeder doesn't serve
+// with no records and the SOA of the zone, so resolvers cache the absence
+// of records of that type as RFC 2308 describes.
+func (d *DNSServer) buildNoDataResponse(addr *net.UDPAddr, zone *dnsZone, dnsMsg *dns.Msg,
+	atype string) ([]byte, error) {
+
+	respMsg := dnsMsg.Copy()
+	respMsg.Authoritative = true
+	respMsg.Response = true
+	respMsg.Ns = append(respMsg.Ns, zone.soa)
+	dnsLog.Infof("%s: Sending no records for %s query", addr, atype)
+
+	return d.pack(addr, dnsMsg, respMsg)
+}
+
+// buildANYResponse answers an ANY query with a single HINFO record, as
+// RFC 8482 recommends, so it can't be used for amplification.
+func (d *DNSServer) buildANYResponse(addr *net.UDPAddr, zone *dnsZone, dnsMsg *dns.Msg) ([]byte, error) {
+	respMsg := dnsMsg.Copy()
+	respMsg.Authoritative = true
+	respMsg.Response = true
+
+	ttl := uint32(defaultDNSTTL)
+	if cfg := ActiveConfig(); cfg != nil {
+		ttl = cfg.DNSTTL
+	}
+	respMsg.Answer = append(respMsg.Answer, &dns.HINFO{
+		Hdr: dns.RR_Header{
+			Name:   dnsMsg.Question[0].Name,
+			Rrtype: dns.TypeHINFO,
+			Class:  dns.ClassINET,
+			Ttl:    ttl,
+		},
+		Cpu: "RFC8482",
+	})
+	dnsLog.Infof("%s: Sending minimal answer to ANY query for %s", addr, zone.hostname)
+
+	return d.pack(addr, dnsMsg, respMsg)
+}
 
-	log.Infof("%s: query %d for subnetwork ID %v",
-		addr, dnsMsg.Question[0].Qtype, subnetworkID)
+// respond builds a response with build and sends it to addr.
+func (d *DNSServer) respond(ctx context.Context, addr *net.UDPAddr, udpListen *net.UDPConn,
+	build func() ([]byte, error)) {
 
-	sendBytes, err := d.buildDNSResponse(addr, authority, dnsMsg, includeAllSubnetworks, subnetworkID, atype)
+	_, lookupSpan := tracer.Start(ctx, "dns.lookup")
+	sendBytes, err := build()
+	endSpan(lookupSpan, err)
 	if err != nil {
+		atomic.AddUint64(&stats.dnsErrors, 1)
 		return
 	}
 
+	_, writeSpan := tracer.Start(ctx, "dns.write")
 	_, err = udpListen.WriteToUDP(sendBytes, addr)
+	endSpan(writeSpan, err)
 	if err != nil {
-		log.Infof("%s: failed to write response: %v", addr, err)
+		atomic.AddUint64(&stats.dnsErrors, 1)
+		dnsLog.Infof("%s: failed to write response: %v", addr, err)
 		return
 	}
+	atomic.AddUint64(&stats.dnsResponses, 1)
+}
+
+// membershipEnabled returns whether the membership subdomains are served.
+func membershipEnabled() bool {
+	cfg := ActiveConfig()
+	return cfg != nil && cfg.Membership
+}
+
+// peerStatusEnabled returns whether the status subdomains are served.
+func peerStatusEnabled() bool {
+	cfg := ActiveConfig()
+	return cfg != nil && cfg.PeerStatus
+}
+
+// isPartialQuery returns whether domainName is the subdomain of the zone
+// serving the partial nodes.
+func (z *dnsZone) isPartialQuery(domainName string) bool {
+	return seeddns.IsPartialName(z.hostname, domainName)
+}
+
+// isDNS64Query returns whether domainName is the subdomain of the zone
+// serving the IPv4 peers as synthesized IPv6 addresses.
+func (z *dnsZone) isDNS64Query(domainName string) bool {
+	return seeddns.IsDNS64Name(z.hostname, domainName)
+}
+
+// statusQueryIP returns the peer address queried by a name of the form
+// <address>.status.<zone>, in which the dots of an IPv4 address or the
+// colons of an IPv6 address are replaced by dashes.
+func (z *dnsZone) statusQueryIP(domainName string) (net.IP, bool) {
+	return seeddns.ParseStatusName(z.hostname, domainName)
+}
+
+// membershipQueryIP returns the peer address queried by a name of the form
+// <reversed address>.known.<zone>. As in DNSBLs, IPv4 addresses are given
+// by their four octets and IPv6 addresses by their 32 nibbles, in reverse
+// order.
+func (z *dnsZone) membershipQueryIP(domainName string) (net.IP, bool) {
+	return seeddns.ParseMembershipName(z.hostname, domainName)
+}
+
+// peerStatusTXT returns the TXT strings describing node.
+func peerStatusTXT(node *Node, now time.Time) []string {
+	state := "known"
+	if node.isGood(now) {
+		state = "good"
+	}
+	txt := []string{
+		"status=" + state,
+		fmt.Sprintf("protocol=%d", node.ProtocolVersion),
+		fmt.Sprintf("services=%d", node.Services),
+	}
+	if node.isPartial() {
+		txt = append(txt, "partial=true")
+	}
+	if node.UserAgent != "" {
+		userAgent := node.UserAgent
+		// A TXT string holds at most 255 bytes.
+		if len(userAgent) > 252 {
+			userAgent = userAgent[:252]
+		}
+		txt = append(txt, "ua="+userAgent)
+	}
+	if !node.LastSuccess.IsZero() {
+		txt = append(txt, "lastsuccess="+node.LastSuccess.UTC().Format(time.RFC3339))
+	}
+	if !node.LastSeen.IsZero() {
+		txt = append(txt, "lastseen="+node.LastSeen.UTC().Format(time.RFC3339))
+	}
+	return txt
+}
+
+// buildMembershipResponse answers a membership query for ip: A queries get
+// membershipAnswer if ip is a good peer, and addresses that aren't good
+// peers don't exist, with the SOA of the zone so resolvers cache their
+// NXDOMAIN as RFC 2308 describes.
+func (d *DNSServer) buildMembershipResponse(addr *net.UDPAddr, zone *dnsZone, dnsMsg *dns.Msg,
+	ip net.IP) ([]byte, error) {
+
+	respMsg := dnsMsg.Copy()
+	respMsg.Authoritative = true
+	respMsg.Response = true
+	respMsg.Ns = append(respMsg.Ns, zone.authority)
+
+	node, ok := zone.amgr.Node(ip)
+	if !ok || !node.isGood(time.Now()) {
+		respMsg.Rcode = dns.RcodeNameError
+		respMsg.Ns = append(respMsg.Ns, zone.soa)
+	} else if dnsMsg.Question[0].Qtype == dns.TypeA {
+		ttl := uint32(defaultDNSTTL)
+		if cfg := ActiveConfig(); cfg != nil {
+			ttl = cfg.DNSTTL
+		}
+		respMsg.Answer = append(respMsg.Answer, &dns.A{
+			Hdr: dns.RR_Header{
+				Name:   dnsMsg.Question[0].Name,
+				Rrtype: dns.TypeA,
+				Class:  dns.ClassINET,
+				Ttl:    ttl,
+			},
+			A: seeddns.MembershipAnswer,
+		})
+	}
+	dnsLog.Infof("%s: Sending membership of %s", addr, ip)
+
+	return d.pack(addr, dnsMsg, respMsg)
+}
+
+// buildStatusResponse answers a query for the status of the peer at ip.
+// Only TXT records are served; peers the seeder doesn't know don't exist,
+// and their NXDOMAIN carries the SOA of the zone so resolvers cache it as
+// RFC 2308 describes.
+func (d *DNSServer) buildStatusResponse(addr *net.UDPAddr, zone *dnsZone, dnsMsg *dns.Msg,
+	ip net.IP) ([]byte, error) {
+
+	respMsg := dnsMsg.Copy()
+	respMsg.Authoritative = true
+	respMsg.Response = true
+	respMsg.Ns = append(respMsg.Ns, zone.authority)
+
+	node, ok := zone.amgr.Node(ip)
+	if !ok {
+		respMsg.Rcode = dns.RcodeNameError
+		respMsg.Ns = append(respMsg.Ns, zone.soa)
+	} else if dnsMsg.Question[0].Qtype == dns.TypeTXT {
+		ttl := uint32(defaultDNSTTL)
+		if cfg := ActiveConfig(); cfg != nil {
+			ttl = cfg.DNSTTL
+		}
+		respMsg.Answer = append(respMsg.Answer, &dns.TXT{
+			Hdr: dns.RR_Header{
+				Name:   dnsMsg.Question[0].Name,
+				Rrtype: dns.TypeTXT,
+				Class:  dns.ClassINET,
+				Ttl:    ttl,
+			},
+			Txt: peerStatusTXT(&node, time.Now()),
+		})
+	}
+	dnsLog.Infof("%s: Sending status of %s", addr, ip)
+
+	return d.pack(addr, dnsMsg, respMsg)
 }
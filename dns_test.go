@@ -0,0 +1,459 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/karlsen-network/dnsseeder/seeddns"
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/karlsen-network/karlsend/domain/dagconfig"
+	"github.com/miekg/dns"
+)
+
+func TestPeerStatus(t *testing.T) {
+	m := newTestManager(t, &dagconfig.MainnetParams, 0)
+	m.nodes["203.0.113.5"] = &Node{
+		Addr:            appmessage.NewNetAddressIPPort(net.ParseIP("203.0.113.5"), 42111),
+		LastSuccess:     time.Now(),
+		UserAgent:       "/karlsend:1.0.0/",
+		ProtocolVersion: 5,
+		Services:        1,
+	}
+	authority, err := dns.NewRR("seed.example.org. 86400 IN NS ns.example.org.")
+	if err != nil {
+		t.Fatalf("NewRR: %s", err)
+	}
+	zone := &dnsZone{hostname: "seed.example.org.", authority: authority, amgr: m}
+	zone.soa = newZoneSOA(zone.hostname, "ns.example.org.", time.Now())
+
+	tests := []struct {
+		name string
+		ip   string
+	}{
+		{"203-0-113-5.status.seed.example.org.", "203.0.113.5"},
+		{"2001-db8--1.status.seed.example.org.", "2001:db8::1"},
+		{"status.seed.example.org.", ""},
+		{"a.203-0-113-5.status.seed.example.org.", ""},
+		{"203-0-113-5.seed.example.org.", ""},
+		{"not-an-ip.status.seed.example.org.", ""},
+	}
+	for _, test := range tests {
+		ip, ok := zone.statusQueryIP(test.name)
+		if ok != (test.ip != "") || (ok && !ip.Equal(net.ParseIP(test.ip))) {
+			t.Errorf("statusQueryIP(%s): got %s %t, expected %s", test.name, ip, ok, test.ip)
+		}
+	}
+
+	d := &DNSServer{}
+	client := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53}
+	query := func(name string, ip string) *dns.Msg {
+		msg := new(dns.Msg)
+		msg.SetQuestion(name, dns.TypeTXT)
+		packed, err := d.buildStatusResponse(client, zone, msg, net.ParseIP(ip))
+		if err != nil {
+			t.Fatalf("buildStatusResponse: %s", err)
+		}
+		response := new(dns.Msg)
+		err = response.Unpack(packed)
+		if err != nil {
+			t.Fatalf("Unpack: %s", err)
+		}
+		return response
+	}
+
+	response := query("203-0-113-5.status.seed.example.org.", "203.0.113.5")
+	if response.Rcode != dns.RcodeSuccess || len(response.Answer) != 1 {
+		t.Fatalf("unexpected response: %s", response)
+	}
+	txt := response.Answer[0].(*dns.TXT).Txt
+	if len(txt) < 4 || txt[0] != "status=good" || txt[1] != "protocol=5" || txt[3] != "ua=/karlsend:1.0.0/" {
+		t.Errorf("unexpected TXT strings: %q", txt)
+	}
+
+	response = query("203-0-113-6.status.seed.example.org.", "203.0.113.6")
+	if response.Rcode != dns.RcodeNameError || len(response.Answer) != 0 {
+		t.Errorf("expected NXDOMAIN for an unknown peer, got %s", response)
+	}
+	if len(response.Ns) != 2 || response.Ns[1].Header().Rrtype != dns.TypeSOA {
+		t.Errorf("expected the NXDOMAIN to carry the SOA of the zone, got %v", response.Ns)
+	}
+}
+
+func TestMembership(t *testing.T) {
+	m := newTestManager(t, &dagconfig.MainnetParams, 0)
+	for _, ip := range []string{"203.0.113.5", "2001:db8::1"} {
+		m.nodes[ip] = &Node{
+			Addr:        appmessage.NewNetAddressIPPort(net.ParseIP(ip), 42111),
+			LastSuccess: time.Now(),
+		}
+	}
+	m.nodes["203.0.113.6"] = &Node{Addr: appmessage.NewNetAddressIPPort(net.ParseIP("203.0.113.6"), 42111)}
+	authority, err := dns.NewRR("seed.example.org. 86400 IN NS ns.example.org.")
+	if err != nil {
+		t.Fatalf("NewRR: %s", err)
+	}
+	zone := &dnsZone{hostname: "seed.example.org.", authority: authority, amgr: m}
+	zone.soa = newZoneSOA(zone.hostname, "ns.example.org.", time.Now())
+
+	ipv6Name := "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.known.seed.example.org."
+	tests := []struct {
+		name  string
+		ip    string
+		rcode int
+	}{
+		{"5.113.0.203.known.seed.example.org.", "203.0.113.5", dns.RcodeSuccess},
+		{ipv6Name, "2001:db8::1", dns.RcodeSuccess},
+		{"6.113.0.203.known.seed.example.org.", "203.0.113.6", dns.RcodeNameError},
+		{"7.113.0.203.known.seed.example.org.", "203.0.113.7", dns.RcodeNameError},
+		{"113.0.203.known.seed.example.org.", "", 0},
+		{"5.113.0.203.seed.example.org.", "", 0},
+	}
+
+	d := &DNSServer{}
+	client := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53}
+	for _, test := range tests {
+		ip, ok := zone.membershipQueryIP(test.name)
+		if ok != (test.ip != "") || (ok && !ip.Equal(net.ParseIP(test.ip))) {
+			t.Errorf("membershipQueryIP(%s): got %s %t, expected %s", test.name, ip, ok, test.ip)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		msg := new(dns.Msg)
+		msg.SetQuestion(test.name, dns.TypeA)
+		packed, err := d.buildMembershipResponse(client, zone, msg, ip)
+		if err != nil {
+			t.Fatalf("buildMembershipResponse: %s", err)
+		}
+		response := new(dns.Msg)
+		err = response.Unpack(packed)
+		if err != nil {
+			t.Fatalf("Unpack: %s", err)
+		}
+		if response.Rcode != test.rcode {
+			t.Errorf("%s: expected rcode %d, got %d", test.name, test.rcode, response.Rcode)
+		}
+		if test.rcode == dns.RcodeSuccess &&
+			(len(response.Answer) != 1 || !response.Answer[0].(*dns.A).A.Equal(seeddns.MembershipAnswer)) {
+			t.Errorf("%s: expected %s, got %v", test.name, seeddns.MembershipAnswer, response.Answer)
+		}
+		if test.rcode == dns.RcodeNameError &&
+			(len(response.Ns) != 2 || response.Ns[1].Header().Rrtype != dns.TypeSOA) {
+			t.Errorf("%s: expected the NXDOMAIN to carry the SOA of the zone, got %v", test.name, response.Ns)
+		}
+	}
+}
+
+func TestZoneFamily(t *testing.T) {
+	tests := []struct {
+		family  string
+		a, aaaa bool
+	}{
+		{"", true, true},
+		{zoneFamilyMixed, true, true},
+		{zoneFamilyIPv4, true, false},
+		{zoneFamilyIPv6, false, true},
+	}
+	for _, test := range tests {
+		zone := &dnsZone{family: test.family}
+		if zone.servesType(dns.TypeA) != test.a || zone.servesType(dns.TypeAAAA) != test.aaaa {
+			t.Errorf("%q: expected A %t and AAAA %t", test.family, test.a, test.aaaa)
+		}
+	}
+}
+
+func TestAmplificationGuard(t *testing.T) {
+	guard, err := newAmplificationGuard(3)
+	if err != nil {
+		t.Fatalf("newAmplificationGuard: %s", err)
+	}
+	client := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53}
+	answer := func(query *dns.Msg) *dns.Msg {
+		response := query.Copy()
+		response.Response = true
+		for i := 1; i <= 16; i++ {
+			rr, err := dns.NewRR(fmt.Sprintf("seed.example.org. 30 IN A 203.0.113.%d", i))
+			if err != nil {
+				t.Fatalf("NewRR: %s", err)
+			}
+			response.Answer = append(response.Answer, rr)
+		}
+		guard.limit(client, query, response)
+		return response
+	}
+	withCookie := func(cookie string) *dns.Msg {
+		query := new(dns.Msg)
+		query.SetQuestion("seed.example.org.", dns.TypeA)
+		query.SetEdns0(1232, false)
+		opt := query.IsEdns0()
+		opt.Option = append(opt.Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: cookie})
+		return query
+	}
+
+	query := new(dns.Msg)
+	query.SetQuestion("seed.example.org.", dns.TypeA)
+	response := answer(query)
+	if len(response.Answer) == 16 || response.Len() > 3*query.Len() {
+		t.Errorf("expected the answer to be trimmed to %d bytes, got %d records in %d bytes",
+			3*query.Len(), len(response.Answer), response.Len())
+	}
+	if response.Truncated {
+		t.Errorf("expected the trimmed answer not to be marked truncated without DNS over TCP")
+	}
+
+	clientCookie := "0102030405060708"
+	response = answer(withCookie(clientCookie))
+	if len(response.Answer) == 16 {
+		t.Errorf("expected the answer to a client cookie alone to be trimmed")
+	}
+	cookie := response.IsEdns0().Option[0].(*dns.EDNS0_COOKIE).Cookie
+	if len(cookie) != 2*(clientCookieSize+serverCookieSize) || cookie[:16] != clientCookie {
+		t.Fatalf("unexpected cookie %s", cookie)
+	}
+
+	response = answer(withCookie(cookie))
+	if len(response.Answer) != 16 {
+		t.Errorf("expected a full answer with a valid server cookie, got %d records", len(response.Answer))
+	}
+	response = answer(withCookie(clientCookie + "0000000000000000"))
+	if len(response.Answer) == 16 {
+		t.Errorf("expected the answer to an invalid server cookie to be trimmed")
+	}
+
+	d := &DNSServer{guard: guard}
+	query = new(dns.Msg)
+	query.SetQuestion("seed.example.org.", dns.TypeANY)
+	packed, err := d.buildANYResponse(client, &dnsZone{hostname: "seed.example.org."}, query)
+	if err != nil {
+		t.Fatalf("buildANYResponse: %s", err)
+	}
+	response = new(dns.Msg)
+	err = response.Unpack(packed)
+	if err != nil {
+		t.Fatalf("Unpack: %s", err)
+	}
+	if len(response.Answer) != 1 || response.Answer[0].(*dns.HINFO).Cpu != "RFC8482" {
+		t.Errorf("expected a single HINFO record, got %v", response.Answer)
+	}
+}
+
+func TestAmplificationGuardDefault(t *testing.T) {
+	cfg := setTestConfig(t, &ConfigFlags{DNSMaxAmplification: defaultDNSAmplification})
+
+	m := newTestManager(t, &dagconfig.MainnetParams, 42111)
+	for i := 1; i <= 20; i++ {
+		ip := net.ParseIP(fmt.Sprintf("2001:db8:%x::1", i))
+		m.nodes[ip.String()] = &Node{
+			Addr:        appmessage.NewNetAddressIPPort(ip, 42111),
+			LastSuccess: time.Now(),
+		}
+	}
+	authority, err := dns.NewRR("seed.example.org. 86400 IN NS ns.example.org.")
+	if err != nil {
+		t.Fatalf("NewRR: %s", err)
+	}
+	zone := &dnsZone{hostname: "seed.example.org.", authority: authority, amgr: m}
+	guard, err := newAmplificationGuard(cfg.DNSMaxAmplification)
+	if err != nil {
+		t.Fatalf("newAmplificationGuard: %s", err)
+	}
+	d := &DNSServer{guard: guard}
+	client := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53}
+
+	// A plain AAAA query without EDNS gets the full default answer.
+	query := new(dns.Msg)
+	query.SetQuestion("seed.example.org.", dns.TypeAAAA)
+	packed, err := d.buildDNSResponse(client, zone, query, true, nil, false, "AAAA")
+	if err != nil {
+		t.Fatalf("buildDNSResponse: %s", err)
+	}
+	response := new(dns.Msg)
+	err = response.Unpack(packed)
+	if err != nil {
+		t.Fatalf("Unpack: %s", err)
+	}
+	if len(response.Answer) != defaultMaxAddresses || response.Truncated {
+		t.Errorf("expected %d addresses in %d bytes, got %d, truncated %t", defaultMaxAddresses,
+			len(packed), len(response.Answer), response.Truncated)
+	}
+}
+
+func TestNoDataResponse(t *testing.T) {
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA, dns.TypeNS} {
+		if !servesQtype(qtype) {
+			t.Errorf("expected %s to be served", dns.TypeToString[qtype])
+		}
+	}
+	zone := &dnsZone{hostname: "seed.example.org."}
+	zone.soa = newZoneSOA(zone.hostname, "ns.example.org.", time.Now())
+
+	d := &DNSServer{}
+	client := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53}
+	// HTTPS records, type 65, are newer than this DNS library.
+	for _, qtype := range []uint16{dns.TypeMX, dns.TypeSRV, 65, dns.TypeTXT} {
+		if servesQtype(qtype) {
+			t.Errorf("expected type %d not to be served", qtype)
+		}
+		query := new(dns.Msg)
+		query.SetQuestion("seed.example.org.", qtype)
+		packed, err := d.buildNoDataResponse(client, zone, query, translateDNSQuestion(query))
+		if err != nil {
+			t.Fatalf("buildNoDataResponse: %s", err)
+		}
+		response := new(dns.Msg)
+		err = response.Unpack(packed)
+		if err != nil {
+			t.Fatalf("Unpack: %s", err)
+		}
+		if response.Rcode != dns.RcodeSuccess || len(response.Answer) != 0 || len(response.Ns) != 1 {
+			t.Fatalf("expected NOERROR with no answer and an SOA, got %s", response)
+		}
+		if soa, ok := response.Ns[0].(*dns.SOA); !ok || soa.Ns != "ns.example.org." {
+			t.Errorf("unexpected authority %v", response.Ns[0])
+		}
+	}
+
+	query := new(dns.Msg)
+	query.SetQuestion("seed.example.org.", 65280)
+	if atype := translateDNSQuestion(query); atype != "TYPE65280" {
+		t.Errorf("unexpected name %s of an unknown type", atype)
+	}
+}
+
+func TestAnswerCount(t *testing.T) {
+	cfg := setTestConfig(t, &ConfigFlags{MaxAnswerCount: 32})
+
+	m := newTestManager(t, &dagconfig.MainnetParams, 42111)
+	for i := 1; i <= 60; i++ {
+		ip := net.IPv4(203, byte(i), 113, 1)
+		m.nodes[ip.String()] = &Node{
+			Addr:        appmessage.NewNetAddressIPPort(ip, 42111),
+			LastSuccess: time.Now(),
+		}
+		ip = net.ParseIP(fmt.Sprintf("2001:db8:%x::1", i))
+		m.nodes[ip.String()] = &Node{
+			Addr:        appmessage.NewNetAddressIPPort(ip, 42111),
+			LastSuccess: time.Now(),
+		}
+	}
+	authority, err := dns.NewRR("seed.example.org. 86400 IN NS ns.example.org.")
+	if err != nil {
+		t.Fatalf("NewRR: %s", err)
+	}
+	zone := &dnsZone{hostname: "seed.example.org.", authority: authority, amgr: m}
+	d := &DNSServer{}
+	client := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53}
+
+	tests := []struct {
+		qtype     uint16
+		requested int
+		udpSize   uint16
+		max       int
+		expected  int
+	}{
+		{dns.TypeA, 0, 0, 32, defaultMaxAddresses},
+		{dns.TypeAAAA, 0, 0, 32, defaultMaxAddresses},
+		{dns.TypeA, 4, 0, 32, 4},
+		{dns.TypeA, 24, 0, 32, 24},
+		{dns.TypeA, 100, 0, 32, 32},
+		{dns.TypeA, 24, 0, 0, defaultMaxAddresses},
+		// Only 15 AAAA records fit in 512 bytes, with the NS and OPT records.
+		{dns.TypeAAAA, 32, 512, 32, 15},
+	}
+	for _, test := range tests {
+		cfg.MaxAnswerCount = test.max
+		query := new(dns.Msg)
+		query.SetQuestion("seed.example.org.", test.qtype)
+		if test.udpSize != 0 {
+			query.SetEdns0(test.udpSize, false)
+		}
+		if test.requested != 0 {
+			seeddns.SetAnswerCount(query, test.requested)
+		}
+		packed, err := d.buildDNSResponse(client, zone, query, true, nil, false, dns.TypeToString[test.qtype])
+		if err != nil {
+			t.Fatalf("buildDNSResponse: %s", err)
+		}
+		if test.udpSize != 0 && len(packed) > int(test.udpSize) {
+			t.Errorf("%+v: the response of %d bytes doesn't fit", test, len(packed))
+		}
+		response := new(dns.Msg)
+		err = response.Unpack(packed)
+		if err != nil {
+			t.Fatalf("Unpack: %s", err)
+		}
+		if len(response.Answer) != test.expected {
+			t.Errorf("%+v: expected %d addresses, got %d", test, test.expected, len(response.Answer))
+		}
+		if response.Truncated {
+			t.Errorf("%+v: expected an untruncated answer", test)
+		}
+		count, ok := seeddns.AnswerCount(response)
+		expected, honored := answerCount(query)
+		if honored != (test.requested != 0 && test.max != 0) {
+			t.Errorf("%+v: expected the option to be honored: %t", test, !honored)
+		}
+		if ok != honored || (ok && count != expected) {
+			t.Errorf("%+v: unexpected answer count option %d %t", test, count, ok)
+		}
+	}
+}
+
+func TestDNS64(t *testing.T) {
+	m := newTestManager(t, &dagconfig.MainnetParams, 42111)
+	for _, ip := range []string{"192.0.2.33", "2001:db8::1"} {
+		m.nodes[ip] = &Node{
+			Addr:        appmessage.NewNetAddressIPPort(net.ParseIP(ip), 42111),
+			LastSuccess: time.Now(),
+		}
+	}
+	authority, err := dns.NewRR("seed.example.org. 86400 IN NS ns.example.org.")
+	if err != nil {
+		t.Fatalf("NewRR: %s", err)
+	}
+	zone := &dnsZone{hostname: "seed.example.org.", authority: authority, amgr: m}
+	_, prefix, err := net.ParseCIDR("64:ff9b::/96")
+	if err != nil {
+		t.Fatalf("ParseCIDR: %s", err)
+	}
+	d := &DNSServer{nat64Prefix: prefix}
+	client := &net.UDPAddr{IP: net.ParseIP("2001:db8:ffff::1"), Port: 53}
+
+	tests := []struct {
+		name     string
+		qtype    uint16
+		expected []string
+	}{
+		{seeddns.DNS64Name("seed.example.org"), dns.TypeAAAA, []string{"64:ff9b::192.0.2.33"}},
+		{seeddns.DNS64Name("seed.example.org"), dns.TypeA, nil},
+		{"seed.example.org.", dns.TypeAAAA, []string{"2001:db8::1"}},
+		{"seed.example.org.", dns.TypeA, []string{"192.0.2.33"}},
+	}
+	for _, test := range tests {
+		query := new(dns.Msg)
+		query.SetQuestion(test.name, test.qtype)
+		packed, err := d.buildDNSResponse(client, zone, query, true, nil, false, dns.TypeToString[test.qtype])
+		if err != nil {
+			t.Fatalf("buildDNSResponse: %s", err)
+		}
+		response := new(dns.Msg)
+		err = response.Unpack(packed)
+		if err != nil {
+			t.Fatalf("Unpack: %s", err)
+		}
+		addresses := seeddns.ParseAnswer(response).Addresses
+		if len(addresses) != len(test.expected) {
+			t.Errorf("%s %s: expected %v, got %v", test.name, dns.TypeToString[test.qtype], test.expected, addresses)
+			continue
+		}
+		for i, address := range addresses {
+			if !address.Equal(net.ParseIP(test.expected[i])) {
+				t.Errorf("%s %s: expected %v, got %v", test.name, dns.TypeToString[test.qtype], test.expected, addresses)
+			}
+		}
+	}
+}
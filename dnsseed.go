@@ -5,9 +5,12 @@
 package main
 
 import (
+	"context"
+	"crypto/ed25519"
 	"fmt"
 	"net"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -15,11 +18,10 @@ import (
 	"time"
 
 	"github.com/karlsen-network/karlsend/app/protocol/common"
-	"github.com/karlsen-network/karlsend/infrastructure/config"
-	"github.com/karlsen-network/karlsend/infrastructure/network/netadapter/standalone"
 
 	"github.com/pkg/errors"
 
+	"github.com/karlsen-network/dnsseeder/netgroup"
 	"github.com/karlsen-network/dnsseeder/version"
 	"github.com/karlsen-network/karlsend/infrastructure/network/dnsseed"
 	"github.com/karlsen-network/karlsend/util/panics"
@@ -27,55 +29,60 @@ import (
 
 	"github.com/karlsen-network/karlsend/app/appmessage"
 	"github.com/karlsen-network/karlsend/infrastructure/os/signal"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	_ "net/http/pprof"
 )
 
+// probeTipsWait is how long a probe waits for the peer to announce blocks.
+const probeTipsWait = time.Second * 2
+
 var (
 	amgr             *Manager
 	wg               sync.WaitGroup
 	peersDefaultPort int
 	systemShutdown   int32
-	defaultSeeder    *appmessage.NetAddress
+
+	// dnsServer is the running DNS server, nil in crawl-only mode. The
+	// zones of the networks registered at runtime are added to it.
+	dnsServer *DNSServer
 )
 
-// hostLookup returns the correct DNS lookup function to use depending on the
-// passed host and configuration options. For example, .onion addresses will be
-// resolved using the onion specific proxy if one was specified, but will
-// otherwise treat the normal proxy as tor unless --noonion was specified in
-// which case the lookup will fail. Meanwhile, normal IP addresses will be
-// resolved using tor if a proxy was specified unless --noonion was also
-// specified in which case the normal system DNS resolver will be used.
+// hostLookup resolves the DNS seeds of the network, through the proxy or the
+// DNS-over-HTTPS resolver if one is configured, as lookupBootstrapHost
+// describes.
 func hostLookup(host string) ([]net.IP, error) {
-	return net.LookupIP(host)
+	return lookupBootstrapHost(host)
 }
 
-func creep() {
+func creep(network *seederNetwork) {
 	defer wg.Done()
 
-	netAdapter, err := standalone.NewMinimalNetAdapter(&config.Config{Flags: &config.Flags{NetworkFlags: ActiveConfig().NetworkFlags}})
+	amgr := network.amgr
+	netAdapter, err := network.crawlAdapter()
 	if err != nil {
 		panic(errors.Wrap(err, "Could not start net adapter"))
 	}
 
 	var knownPeers []*appmessage.NetAddress
 
-	if len(ActiveConfig().KnownPeers) != 0 {
-		for _, p := range strings.Split(ActiveConfig().KnownPeers, ",") {
+	if len(network.knownPeers) != 0 {
+		for _, p := range network.knownPeers {
 			addressStr := strings.Split(p, ":")
 			if len(addressStr) != 2 {
-				log.Errorf("Invalid peer address: %s; addresses should be in format \"IP\":\"port\"", p)
+				crawlLog.Errorf("Invalid peer address: %s; addresses should be in format \"IP\":\"port\"", p)
 				return
 			}
 
 			ip := net.ParseIP(addressStr[0])
 			if ip == nil {
-				log.Errorf("Invalid peer IP address: %s", addressStr[0])
+				crawlLog.Errorf("Invalid peer IP address: %s", addressStr[0])
 				return
 			}
 			port, err := strconv.Atoi(addressStr[1])
 			if err != nil {
-				log.Errorf("Invalid peer port: %s", addressStr[1])
+				crawlLog.Errorf("Invalid peer port: %s", addressStr[1])
 				return
 			}
 
@@ -89,94 +96,287 @@ func creep() {
 		}
 	}
 
+	// workers holds a token for each crawl under way when the network has
+	// a crawl worker quota.
+	var workers chan struct{}
+	if network.crawlWorkers > 0 {
+		workers = make(chan struct{}, network.crawlWorkers)
+	}
 	var wgCreep sync.WaitGroup
 	for {
+		if !waitWhileCrawlPaused() {
+			crawlLog.Infof("Creep thread shutdown")
+			return
+		}
+
 		peers := amgr.Addresses()
-		if len(peers) == 0 && amgr.AddressCount() == 0 {
+		if len(peers) == 0 && amgr.AddressCount() == 0 && !allowOnlyEnabled() {
 			// Add peers discovered through DNS to the address manager.
-			dnsseed.SeedFromDNS(ActiveConfig().NetParams(), "", true,
+			// Private deployments don't look up the public DNS seeds.
+			dnsseed.SeedFromDNS(network.flags.NetParams(), "", true,
 				nil, hostLookup, func(addrs []*appmessage.NetAddress) {
 					amgr.AddAddresses(addrs)
 				})
 			peers = amgr.Addresses()
 		}
 		if len(peers) == 0 {
-			log.Infof("No stale addresses -- sleeping for 10 minutes")
+			amgr.setWarm()
+			crawlLog.Infof("No stale %s addresses -- sleeping for 10 minutes", network.name())
+		sleep:
 			for i := 0; i < 600; i++ {
-				time.Sleep(time.Second)
+				select {
+				case <-amgr.crawlSignal:
+					break sleep
+				case <-time.After(time.Second):
+				}
 				if atomic.LoadInt32(&systemShutdown) != 0 {
-					log.Infof("Creep thread shutdown")
+					crawlLog.Infof("Creep thread shutdown")
 					return
 				}
 			}
 			continue
 		}
 
+		deferred := 0
 		for _, addr := range peers {
 			if atomic.LoadInt32(&systemShutdown) != 0 {
-				log.Infof("Waiting creep threads to terminate")
+				crawlLog.Infof("Waiting creep threads to terminate")
 				wgCreep.Wait()
-				log.Infof("Creep thread shutdown")
+				crawlLog.Infof("Creep thread shutdown")
 				return
 			}
+			if isCrawlPaused() {
+				break
+			}
+			if !politeness.admit(addr.IP, time.Now()) {
+				// The peer is still stale, so it comes up again in a
+				// later round.
+				deferred++
+				continue
+			}
+			warmup.wait()
+			politeness.wait()
+			if workers != nil {
+				select {
+				case workers <- struct{}{}:
+				case <-amgr.quit:
+					crawlLog.Infof("Waiting creep threads to terminate")
+					wgCreep.Wait()
+					crawlLog.Infof("Creep thread shutdown")
+					return
+				}
+			}
 			wgCreep.Add(1)
 			go func(addr *appmessage.NetAddress) {
 				defer wgCreep.Done()
+				if workers != nil {
+					defer func() { <-workers }()
+				}
 
-				err := pollPeer(netAdapter, addr)
+				err := pollPeer(amgr, netAdapter, addr)
 				if err != nil {
-					log.Debugf(err.Error())
-					if defaultSeeder != nil && addr == defaultSeeder {
-						panics.Exit(log, "failed to poll default seeder")
+					crawlLog.Debugf(err.Error())
+					if network.defaultSeeder != nil && addr == network.defaultSeeder {
+						panics.Exit(crawlLog, "failed to poll default seeder")
 					}
 				}
 			}(addr)
 		}
 		wgCreep.Wait()
+		if deferred == len(peers) {
+			crawlLog.Debugf("All %d stale %s addresses deferred by the politeness limits",
+				deferred, network.name())
+			time.Sleep(time.Second)
+		}
+		if !isCrawlPaused() {
+			amgr.setWarm()
+		}
 	}
 }
 
-func pollPeer(netAdapter *standalone.MinimalNetAdapter, addr *appmessage.NetAddress) error {
+func pollPeer(amgr *Manager, netAdapter *crawlAdapter, addr *appmessage.NetAddress) (err error) {
 	defer amgr.Attempt(addr.IP)
 
 	peerAddress := net.JoinHostPort(addr.IP.String(), strconv.Itoa(int(addr.Port)))
-	routes, err := netAdapter.Connect(peerAddress)
+	ctx, span := tracer.Start(context.Background(), "crawl",
+		trace.WithAttributes(attribute.String("peer.address", peerAddress)))
+
+	atomic.AddUint64(&stats.crawlAttempts, 1)
+	var handshakeTime time.Duration
+	var version *appmessage.MsgVersion
+	var received []*appmessage.NetAddress
+	var tip string
+	defer func() {
+		if errors.Is(err, errHostBusy) {
+			// Another crawl of the same host, or of too many hosts of
+			// its subnet, is under way; this isn't the peer's fault.
+			endSpan(span, err)
+			return
+		}
+		amgr.RecordCrawl(addr.IP, err == nil, handshakeTime)
+		amgr.RecordCrawlAttempt(addr.IP, newCrawlAttempt(time.Now(), err, handshakeTime, tip))
+		crawlRecord.record(newCrawlEvent(time.Now(), amgr.netParams.Name, addr, err == nil,
+			handshakeTime, version, received))
+		if err != nil {
+			atomic.AddUint64(&stats.crawlFailures, 1)
+			amgr.failures.add(classifyCrawlFailure(err))
+			recentFailures.add(addr, err)
+			rejections.add(addr, err)
+		} else {
+			atomic.AddUint64(&stats.crawlSuccesses, 1)
+		}
+		endSpan(span, err)
+	}()
+
+	if amgr.bans.IsBanned(addr.IP) || !isAllowed(addr.IP) {
+		return errors.Wrapf(errBanned, "not connecting to %s", peerAddress)
+	}
+	routes, started, err := netAdapter.connectTimed(ctx, peerAddress)
 	if err != nil {
 		return errors.Wrapf(err, "could not connect to %s", peerAddress)
 	}
 	defer routes.Disconnect()
-
-	msgRequestAddresses := appmessage.NewMsgRequestAddresses(true, nil)
-	err = routes.OutgoingRoute.Enqueue(msgRequestAddresses)
-	if err != nil {
-		return errors.Wrapf(err, "failed to request addresses from %s", peerAddress)
+	handshakeTime = time.Since(started)
+	version = routes.version
+	span.SetAttributes(attribute.String("peer.user_agent", routes.version.UserAgent))
+
+	_, getAddrSpan := tracer.Start(ctx, "crawl.getaddr")
+	msgAddresses, err := routes.RequestAddresses(common.DefaultTimeout)
+	endSpan(getAddrSpan, err)
+	// Keep whatever tip the peer announced meanwhile, without waiting for
+	// one.
+	if tips := routes.AnnouncedBlocks(0); len(tips) > 0 {
+		tip = tips[len(tips)-1]
 	}
-
-	message, err := routes.WaitForMessageOfType(appmessage.CmdAddresses, common.DefaultTimeout)
 	if err != nil {
-		return errors.Wrapf(err, "failed to receive addresses from %s", peerAddress)
+		return reject(rejectedNoAddresses, routes.version,
+			errors.Wrapf(err, "failed to receive addresses from %s", peerAddress))
 	}
-	msgAddresses := message.(*appmessage.MsgAddresses)
+	atomic.AddUint64(&stats.crawlAddrReceived, uint64(len(msgAddresses.AddressList)))
+	received = msgAddresses.AddressList
+	amgr.RecordAdvertised(addr.IP, received)
 
+	_, storeSpan := tracer.Start(ctx, "crawl.store",
+		trace.WithAttributes(attribute.Int("addresses.received", len(msgAddresses.AddressList))))
 	added := amgr.AddAddresses(msgAddresses.AddressList)
-	log.Infof("Peer %s sent %d addresses, %d new",
-		peerAddress, len(msgAddresses.AddressList), added)
+	if tip != "" && amgr.tips.lagging(tip, time.Now()) {
+		storeSpan.End()
+		return reject(rejectedLaggingTip, routes.version,
+			errors.Errorf("%s announced block %s, first announced over %s ago", peerAddress, tip, laggingTipAge))
+	}
+	amgr.Good(addr.IP, routes.version)
+	storeSpan.SetAttributes(attribute.Int("addresses.added", added))
+	storeSpan.End()
 
-	amgr.Good(addr.IP, nil)
+	crawlLog.Infof("Peer %s (%s) sent %d addresses, %d new",
+		peerAddress, routes.version.UserAgent, len(msgAddresses.AddressList), added)
 
 	return nil
 }
 
+// probePeer connects to addr and reports what it finds, without recording
+// anything in the peers database.
+func probePeer(ctx context.Context, netAdapter *crawlAdapter, addr *appmessage.NetAddress) (*CrawlNowResponse, error) {
+	peerAddress := net.JoinHostPort(addr.IP.String(), strconv.Itoa(int(addr.Port)))
+	ctx, span := tracer.Start(ctx, "crawl.probe",
+		trace.WithAttributes(attribute.String("peer.address", peerAddress)))
+
+	routes, started, err := netAdapter.connectTimed(ctx, peerAddress)
+	if err != nil {
+		endSpan(span, err)
+		return nil, errors.Wrapf(err, "could not connect to %s", peerAddress)
+	}
+	defer routes.Disconnect()
+	handshakeTime := time.Since(started)
+
+	response := &CrawlNowResponse{
+		Address:               peerAddress,
+		UserAgent:             routes.version.UserAgent,
+		ProtocolVersion:       routes.version.ProtocolVersion,
+		Services:              uint64(routes.version.Services),
+		HandshakeMilliseconds: handshakeTime.Milliseconds(),
+	}
+
+	start := time.Now()
+	msgAddresses, err := routes.RequestAddresses(common.DefaultTimeout)
+	if err != nil {
+		endSpan(span, err)
+		return nil, errors.Wrapf(err, "failed to receive addresses from %s", peerAddress)
+	}
+	response.AddressesMilliseconds = time.Since(start).Milliseconds()
+	response.AddressesReceived = len(msgAddresses.AddressList)
+	response.Tips = routes.AnnouncedBlocks(probeTipsWait)
+
+	span.End()
+	return response, nil
+}
+
 func main() {
 	defer panics.HandlePanic(log, "main", nil)
-	interrupt := signal.InterruptListener()
 
-	cfg, err := loadConfig()
+	_, command, err := loadConfig()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "loadConfig: %v\n", err)
 		os.Exit(1)
 	}
 
+	err = command.Execute(nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+// startDNSServer starts serving the zones of the given networks, along with
+// the answer audit log if enabled.
+func startDNSServer(cfg *ConfigFlags, networks []*seederNetwork) error {
+	if cfg.AuditRingSize > 0 || cfg.AuditLog != "" {
+		var err error
+		answerAudit, err = newAuditLog(cfg.AuditRingSize, cfg.AuditLog, cfg.LogMaxSize, cfg.LogMaxRolls,
+			cfg.AuditHashClients, cfg.AuditSalt)
+		if err != nil {
+			return err
+		}
+	}
+
+	exemptions, err := parseRateExemptions(cfg.DNSRateLimitExempt)
+	if err != nil {
+		return err
+	}
+	resolvers, err := parsePublicResolvers(cfg.PublicResolvers)
+	if err != nil {
+		return err
+	}
+	setPublicResolvers(resolvers)
+	limiter := newRateLimiter(cfg.DNSRateLimit, cfg.DNSRateBurst, exemptions)
+	guard, err := newAmplificationGuard(cfg.DNSMaxAmplification)
+	if err != nil {
+		return err
+	}
+	dnsServer = NewDNSServer(networks, cfg.Listen, limiter, guard)
+	if cfg.NAT64Prefix != "" {
+		_, dnsServer.nat64Prefix, err = net.ParseCIDR(cfg.NAT64Prefix)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	wg.Add(1)
+	spawn("main-DNSServer.Start", dnsServer.Start)
+	return nil
+}
+
+// run runs the seeder until an interrupt signal is received.
+func run(cfg *ConfigFlags) error {
+	interrupt := signal.InterruptListener()
+
+	if cfg.CheckDB {
+		err := checkPeersFile(filepath.Join(cfg.AppDir, peersFilename))
+		if err != nil {
+			return errors.Wrap(err, "check-db")
+		}
+		return nil
+	}
+
 	// Show version at startup.
 	log.Infof("Version %s", version.Version())
 
@@ -185,73 +385,277 @@ func main() {
 		profiling.Start(cfg.Profile, log)
 	}
 
-	amgr, err = NewManager(cfg.AppDir)
+	if cfg.OTLPEndpoint != "" {
+		stopTracing, err := startTracing(cfg.OTLPEndpoint, cfg.OTLPInsecure,
+			cfg.TraceSampleRatio, cfg.NetParams().Name)
+		if err != nil {
+			return err
+		}
+		defer stopTracing()
+	}
+
+	err := registerCustomNetworks(cfg.CustomNetworks)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "NewManager: %v\n", err)
-		os.Exit(1)
+		return err
 	}
 
-	peersDefaultPort, err = strconv.Atoi(ActiveConfig().NetParams().DefaultPort)
+	err = initGeoIP(cfg.GeoIPCity, cfg.GeoIPASN)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Invalid peers default port %s: %v\n", ActiveConfig().NetParams().DefaultPort, err)
-		os.Exit(1)
+		return err
+	}
+	defer closeGeoIP()
+	if cfg.ASNNetgroups {
+		setGrouper(netgroup.New(netgroup.ASNSourceFunc(lookupASN)))
+	}
+
+	politeness = newCrawlPoliteness(cfg.CrawlRate, cfg.CrawlNetgroupRate, cfg.CrawlRecontact)
+	warmupRate := cfg.CrawlWarmupRate
+	if cfg.CrawlRate > 0 {
+		warmupRate = cfg.CrawlRate
+	}
+	warmup = newCrawlWarmup(time.Now(), cfg.CrawlWarmup, warmupRate)
+	connections = newConnectionSlots(cfg.CrawlMaxPerSubnet)
+
+	if cfg.AllowOnly != "" {
+		allowed, err := parseAllowOnly(strings.Split(cfg.AllowOnly, ","))
+		if err != nil {
+			return err
+		}
+		setAllowOnly(allowed)
+		log.Infof("Only crawling and serving addresses in %s", cfg.AllowOnly)
+	}
+
+	if cfg.StaticAnswers != "" {
+		static, err := parseStaticAnswers(strings.Split(cfg.StaticAnswers, ","))
+		if err != nil {
+			return err
+		}
+		setStaticAnswers(static)
+		log.Infof("Serving the static answers %s instead of the crawled peers", cfg.StaticAnswers)
+	}
+
+	if cfg.HostingRanges != "" {
+		ranges, err := readHostingRanges(cfg.HostingRanges)
+		if err != nil {
+			return err
+		}
+		setHostingRanges(ranges)
+	}
+
+	if cfg.ServeFrom != "" {
+		return runReplica(cfg, interrupt)
+	}
+
+	networks, err = setupNetworks(cfg)
+	if err != nil {
+		return err
+	}
+	amgr = networks[0].amgr
+	peersDefaultPort = networks[0].defaultPort
+
+	if cfg.BanList != "" {
+		bans, err := readBanList(cfg.BanList)
+		if err != nil {
+			return err
+		}
+		for _, network := range networks {
+			network.amgr.SetBanList(banListSource, bans)
+		}
+	}
+
+	if cfg.Blocklists != "" {
+		urls := strings.Split(cfg.Blocklists, ",")
+		spawn("main-fetchBlocklists", func() { fetchBlocklists(amgr, urls, cfg.BlocklistInterval, amgr.quit) })
+	}
+
+	spawn("main-recordHistory", func() { recordHistory(amgr, amgr.quit) })
+	spawn("main-reloadOnHangup", func() { reloadOnHangup(amgr, amgr.quit) })
+	if !cfg.NoLogFiles && cfg.LogMaxAge > 0 {
+		logFiles := []string{
+			filepath.Join(cfg.AppDir, defaultLogFilename),
+			filepath.Join(cfg.AppDir, defaultErrLogFilename),
+		}
+		spawn("main-pruneLogs", func() { pruneLogs(cfg.LogMaxAge, amgr.quit, logFiles...) })
+	}
+	if len(geoIPDatabases) > 0 {
+		spawn("main-reloadGeoIP", func() { reloadGeoIP(amgr.quit) })
 	}
 
-	if len(cfg.Seeder) != 0 {
-		// Prepare the seeder address, supporting either a simple IP with default network port
-		// or a full IP:port format
-		seederIp := cfg.Seeder
-		seederPort := peersDefaultPort
+	if cfg.CrawlRecord != "" {
+		crawlRecord, err = newCrawlRecorder(cfg.CrawlRecord, cfg.LogMaxSize, cfg.LogMaxRolls)
+		if err != nil {
+			return err
+		}
+		defer crawlRecord.Close()
+	}
+
+	for _, network := range networks {
+		network := network
+		log.Infof("Serving %s", network.name())
+		wg.Add(1)
+		spawn("main-creep", func() { creep(network) })
+
+		if len(network.amgr.Canaries()) > 0 {
+			wg.Add(1)
+			spawn("main-monitorCanaries", func() {
+				monitorCanaries(network, cfg.CanaryInterval, network.amgr.quit)
+			})
+		}
+
+		if cfg.KeepalivePeers > 0 {
+			pool := newKeepalivePool(network.amgr, cfg.KeepalivePeers)
+			wg.Add(1)
+			spawn("main-keepPeersAlive", func() {
+				keepPeersAlive(network, pool, cfg.KeepaliveInterval, network.amgr.quit)
+			})
+		}
 
-		// Try to split seeder host and port
-		foundIp, foundPort, err := net.SplitHostPort(cfg.Seeder)
-		if (err == nil) {
-			seederIp = foundIp
-			seederPort, err = strconv.Atoi(foundPort)
+		if cfg.Harvest {
+			listen := net.JoinHostPort("", strconv.Itoa(network.defaultPort))
+			err = startHarvester(network, listen, network.amgr.quit)
 			if err != nil {
-				log.Errorf("Invalid seeder port: %s", foundPort)
-				return
+				return err
 			}
+			log.Infof("Accepting inbound %s connections on %s", network.name(), listen)
 		}
+	}
 
-		ip := net.ParseIP(seederIp)
-		if ip == nil {
-			hostAddrs, err := net.LookupHost(seederIp)
-			if err != nil {
-				log.Warnf("Failed to resolve seed host: %v, %v, ignoring", seederIp, err)
-			} else {
-				ip = net.ParseIP(hostAddrs[0])
-				if ip == nil {
-					log.Warnf("Failed to resolve seed host: %v, ignoring", seederIp)
-				}
+	if cfg.CrawlOnly {
+		log.Infof("Crawl-only mode: not serving DNS")
+	} else {
+		err = startDNSServer(cfg, networks)
+		if err != nil {
+			return err
+		}
+		defer answerAudit.Close()
+	}
+
+	if cfg.GossipPeers != "" {
+		for _, address := range strings.Split(cfg.GossipPeers, ",") {
+			for _, network := range networks {
+				address, network := address, network
+				spawn("main-gossipWith", func() {
+					gossipWith(network, address, cfg.GossipToken, cfg.GossipInterval, network.amgr.quit)
+				})
 			}
 		}
-		if ip != nil {
-			defaultSeeder = appmessage.NewNetAddressIPPort(ip, uint16(seederPort))
-			amgr.AddAddresses([]*appmessage.NetAddress{defaultSeeder})
+	}
+
+	if cfg.SnapshotKey != "" {
+		key, err := loadSigningKey(cfg.SnapshotKey)
+		if err != nil {
+			return err
+		}
+		log.Infof("Publishing snapshots signed with the public key %x",
+			key.Public().(ed25519.PublicKey))
+		for _, network := range networks {
+			network := network
+			spawn("main-publishSnapshots", func() {
+				publishSnapshots(network, key, cfg.SnapshotInterval, network.amgr.quit)
+			})
 		}
 	}
 
-	wg.Add(1)
-	spawn("main-creep", creep)
+	if cfg.SnapshotSources != "" {
+		trusted, err := parseSnapshotKeys(strings.Split(cfg.SnapshotTrustedKeys, ","))
+		if err != nil {
+			return err
+		}
+		for _, source := range strings.Split(cfg.SnapshotSources, ",") {
+			for _, network := range networks {
+				source, network := source, network
+				spawn("main-fetchSnapshots", func() {
+					fetchSnapshots(network, source, trusted, cfg.SnapshotInterval, network.amgr.quit)
+				})
+			}
+		}
+	}
 
-	dnsServer := NewDNSServer(cfg.Host, cfg.Nameserver, cfg.Listen)
-	wg.Add(1)
-	spawn("main-DNSServer.Start", dnsServer.Start)
+	if cfg.ServingIndex != "" {
+		spawn("main-writeServingIndexes", func() {
+			writeServingIndexes(cfg.ServingIndex, networks, cfg.ServingIndexInterval, amgr.quit)
+		})
+	}
 
-	grpcServer := NewGRPCServer(amgr)
+	for _, network := range networks {
+		network := network
+		spawn("main-watchPeers", func() { peerFeed.watch(network.amgr, network.amgr.quit) })
+	}
+
+	grpcServer := NewGRPCServer(amgr, cfg.AdminToken, cfg.GossipToken, cfg.PeerFeedToken)
 	err = grpcServer.Start(cfg.GRPCListen)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to start gRPC server")
-		return
+		return errors.Wrap(err, "Failed to start gRPC server")
+	}
+
+	var httpServer *HTTPServer
+	if cfg.HTTPListen != "" {
+		if cfg.BootstrapKey != "" {
+			key, err := loadSigningKey(cfg.BootstrapKey)
+			if err != nil {
+				return err
+			}
+			setBootstrapKey(key)
+			log.Infof("Serving bootstrap lists signed with the public key %x",
+				key.Public().(ed25519.PublicKey))
+		}
+		httpServer = NewHTTPServer(amgr)
+		err = httpServer.StartTLS(cfg.HTTPListen, cfg.HTTPTLSCert, cfg.HTTPTLSKey)
+		if err != nil {
+			return errors.Wrap(err, "Failed to start HTTP server")
+		}
+	}
+
+	var exporter *statsExporter
+	if cfg.StatsExport != "" {
+		exporter, err = newStatsExporter(cfg.StatsExport, cfg.StatsAddress, cfg.StatsPrefix,
+			cfg.NetParams().Name, cfg.StatsInterval, amgr)
+		if err != nil {
+			return errors.Wrap(err, "Failed to start stats exporter")
+		}
+		exporter.Start()
+	}
+
+	var alerts *alerter
+	if cfg.AlertWebhook != "" {
+		alerts, err = newAlerter(cfg.AlertWebhook, cfg.AlertInterval, cfg.AlertMinGoodPeers,
+			cfg.AlertCrawlFailureRate, cfg.AlertDNSErrorRate, cfg.AlertCanaryFailures, cfg.AlertCanaryLatency,
+			networkManagers(amgr))
+		if err != nil {
+			return errors.Wrap(err, "Failed to start alerter")
+		}
+		alerts.Start()
+	}
+
+	var churn *churnDetector
+	if cfg.ChurnInterval > 0 {
+		churn = newChurnDetector(cfg.AlertWebhook, cfg.ChurnInterval, cfg.ChurnThreshold, cfg.ChurnMinPeers,
+			networkManagers(amgr))
+		churn.Start()
 	}
 
 	defer func() {
 		log.Infof("Gracefully shutting down the seeder...")
 		atomic.StoreInt32(&systemShutdown, 1)
-		close(amgr.quit)
+		if exporter != nil {
+			exporter.Stop()
+		}
+		if alerts != nil {
+			alerts.Stop()
+		}
+		if churn != nil {
+			churn.Stop()
+		}
+		if httpServer != nil {
+			httpServer.Stop()
+		}
+		for _, network := range servedNetworks() {
+			close(network.amgr.quit)
+		}
 		wg.Wait()
-		amgr.wg.Wait()
+		for _, network := range servedNetworks() {
+			network.amgr.wg.Wait()
+		}
 		log.Infof("Seeder shutdown complete")
 	}()
 
@@ -259,4 +663,5 @@ func main() {
 	// shutdown is requested through one of the subsystems such as the RPC
 	// server.
 	<-interrupt
+	return nil
 }
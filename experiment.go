@@ -0,0 +1,144 @@
+package main
+
+import (
+	"hash/fnv"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// experimentBuckets is the number of buckets clients are hashed into to be
+// split between the arms of an answer experiment.
+const experimentBuckets = 10000
+
+// experimentArm is one of the two selection policies of an answer
+// experiment, with the metrics of the answers it selected. The counters
+// must be accessed atomically.
+type experimentArm struct {
+	name   string
+	scorer Scorer
+
+	answers           uint64
+	addresses         uint64
+	servedReached     uint64
+	servedUnreachable uint64
+}
+
+// answerExperiment splits the answers of a network between the scoring
+// policy of the network, the control, and another one, the treatment, by
+// a hash of the client address, so each client consistently gets the same
+// policy. Each arm counts the answers and addresses it served, and whether
+// the peers it served were reached when they were crawled next, so a new
+// policy can be evaluated on live traffic before it replaces the current
+// one.
+type answerExperiment struct {
+	control   experimentArm
+	treatment experimentArm
+
+	// share is the number of buckets, out of experimentBuckets, assigned
+	// to the treatment.
+	share uint64
+
+	// served holds, for each peer served since it was last crawled, the
+	// arms that served it: 1 for the control, 2 for the treatment. Served
+	// peers are good, so they are crawled, and dropped, before the garbage
+	// collector could remove them.
+	mtx    sync.Mutex
+	served map[string]uint8
+}
+
+// newAnswerExperiment returns an experiment serving share, between 0 and
+// 1, of the clients with treatment instead of control.
+func newAnswerExperiment(controlName string, control Scorer, treatmentName string, treatment Scorer,
+	share float64) *answerExperiment {
+
+	return &answerExperiment{
+		control:   experimentArm{name: controlName, scorer: control},
+		treatment: experimentArm{name: treatmentName, scorer: treatment},
+		share:     uint64(share * experimentBuckets),
+		served:    make(map[string]uint8),
+	}
+}
+
+// arm returns the arm source is assigned to. A nil source, which isn't a
+// DNS client, always gets the control.
+func (e *answerExperiment) arm(source net.IP) *experimentArm {
+	if source == nil {
+		return &e.control
+	}
+	hash := fnv.New64a()
+	hash.Write(source.To16())
+	if hash.Sum64()%experimentBuckets < e.share {
+		return &e.treatment
+	}
+	return &e.control
+}
+
+// recordAnswer counts an answer of arm serving addrs.
+func (e *answerExperiment) recordAnswer(arm *experimentArm, addrs []net.IP) {
+	atomic.AddUint64(&arm.answers, 1)
+	atomic.AddUint64(&arm.addresses, uint64(len(addrs)))
+
+	mask := uint8(1)
+	if arm == &e.treatment {
+		mask = 2
+	}
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	for _, ip := range addrs {
+		e.served[ip.String()] |= mask
+	}
+}
+
+// recordCrawl counts the crawl of the peer with the given IP for the arms
+// that served it since it was last crawled.
+func (e *answerExperiment) recordCrawl(ip net.IP, reached bool) {
+	e.mtx.Lock()
+	mask, ok := e.served[ip.String()]
+	delete(e.served, ip.String())
+	e.mtx.Unlock()
+	if !ok {
+		return
+	}
+
+	for i, arm := range []*experimentArm{&e.control, &e.treatment} {
+		if mask&(1<<i) == 0 {
+			continue
+		}
+		if reached {
+			atomic.AddUint64(&arm.servedReached, 1)
+		} else {
+			atomic.AddUint64(&arm.servedUnreachable, 1)
+		}
+	}
+}
+
+// metrics returns the metrics of both arms, named after their scorer.
+func (e *answerExperiment) metrics() []metric {
+	var metrics []metric
+	for _, arm := range []*experimentArm{&e.control, &e.treatment} {
+		prefix := "experiment_" + arm.name + "_"
+		metrics = append(metrics,
+			metric{prefix + "answers", atomic.LoadUint64(&arm.answers)},
+			metric{prefix + "addresses", atomic.LoadUint64(&arm.addresses)},
+			metric{prefix + "served_reached", atomic.LoadUint64(&arm.servedReached)},
+			metric{prefix + "served_unreachable", atomic.LoadUint64(&arm.servedUnreachable)},
+		)
+	}
+	return metrics
+}
+
+// SetExperiment starts an answer experiment on the network, or stops the
+// running one if experiment is nil.
+func (m *Manager) SetExperiment(experiment *answerExperiment) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.experiment = experiment
+}
+
+// Experiment returns the answer experiment running on the network, or nil.
+func (m *Manager) Experiment() *answerExperiment {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	return m.experiment
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/karlsen-network/karlsend/domain/dagconfig"
+	"github.com/miekg/dns"
+)
+
+// onlyScorer serves a single peer.
+type onlyScorer struct {
+	ip net.IP
+}
+
+func (s onlyScorer) Score(peer *PeerScoreInput) float64 {
+	if !peer.Node.Addr.IP.Equal(s.ip) {
+		return 0
+	}
+	return 1
+}
+
+func TestAnswerExperiment(t *testing.T) {
+	now := time.Now()
+	m := newTestManager(t, &dagconfig.MainnetParams, 1313)
+	for _, ip := range []string{"1.0.0.1", "2.0.0.1", "3.0.0.1"} {
+		m.nodes[ip] = &Node{
+			Addr:        appmessage.NewNetAddressIPPort(net.ParseIP(ip), 1313),
+			LastSuccess: now,
+		}
+	}
+	experiment := newAnswerExperiment(defaultScorerName, defaultScorer{}, "only",
+		onlyScorer{net.ParseIP("2.0.0.1")}, 0.5)
+	m.SetExperiment(experiment)
+
+	// Clients always get the same arm, and both arms get some of them.
+	var control, treatment net.IP
+	for i := 1; i < 255 && (control == nil || treatment == nil); i++ {
+		client := net.ParseIP(fmt.Sprintf("198.51.100.%d", i))
+		arm := experiment.arm(client)
+		if arm != experiment.arm(client) {
+			t.Fatalf("expected %s to always get the same arm", client)
+		}
+		if arm == &experiment.treatment {
+			treatment = client
+		} else {
+			control = client
+		}
+	}
+	if control == nil || treatment == nil {
+		t.Fatalf("expected the clients to be split between both arms")
+	}
+	if experiment.arm(nil) != &experiment.control {
+		t.Errorf("expected answers to no client to get the control")
+	}
+
+	if addrs := m.GoodAddresses(dns.TypeA, true, nil, false, 0, control, defaultMaxAddresses); len(addrs) != 3 {
+		t.Errorf("expected the control to serve every good peer, got %v", addrs)
+	}
+	addrs := m.GoodAddresses(dns.TypeA, true, nil, false, 0, treatment, defaultMaxAddresses)
+	if len(addrs) != 1 || !addrs[0].IP.Equal(net.ParseIP("2.0.0.1")) {
+		t.Errorf("expected the treatment to serve its only peer, got %v", addrs)
+	}
+	m.GoodAddresses(dns.TypeA, true, nil, false, 0, nil, defaultMaxAddresses)
+
+	m.RecordCrawl(net.ParseIP("1.0.0.1"), true, time.Millisecond)
+	m.RecordCrawl(net.ParseIP("2.0.0.1"), false, 0)
+	m.RecordCrawl(net.ParseIP("2.0.0.1"), true, time.Millisecond)
+
+	expected := map[string]uint64{
+		"experiment_default_answers":            1,
+		"experiment_default_addresses":          3,
+		"experiment_default_served_reached":     1,
+		"experiment_default_served_unreachable": 1,
+		"experiment_only_answers":               1,
+		"experiment_only_addresses":             1,
+		"experiment_only_served_reached":        0,
+		"experiment_only_served_unreachable":    1,
+	}
+	metrics := experiment.metrics()
+	if len(metrics) != len(expected) {
+		t.Fatalf("expected %d metrics, got %v", len(expected), metrics)
+	}
+	for _, metric := range metrics {
+		if value, ok := expected[metric.name]; !ok || metric.value != value {
+			t.Errorf("expected %s to be %d, got %d", metric.name, value, metric.value)
+		}
+	}
+}
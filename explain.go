@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/karlsen-network/dnsseeder/seeddns"
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// explainedPeer is a candidate of an explained answer, and why it was or
+// wasn't selected. Score is the weight the peer was drawn with, if it got
+// as far as being scored.
+type explainedPeer struct {
+	Address  string   `json:"address"`
+	Services uint64   `json:"services"`
+	Score    *float64 `json:"score,omitempty"`
+	Included bool     `json:"included"`
+	Reason   string   `json:"reason"`
+
+	ip net.IP
+}
+
+// answerExplanation records why each candidate of an answer was or wasn't
+// selected, as the answer is selected. Its methods do nothing on a nil
+// explanation, which is what regular answers pass along.
+type answerExplanation struct {
+	peers map[string]*explainedPeer
+
+	// arm is the experiment arm the answer was selected with, if any.
+	arm string
+}
+
+// newAnswerExplanation returns an empty explanation.
+func newAnswerExplanation() *answerExplanation {
+	return &answerExplanation{peers: make(map[string]*explainedPeer)}
+}
+
+// peer returns the record of addr, adding it if it is new.
+func (e *answerExplanation) peer(addr *appmessage.NetAddress) *explainedPeer {
+	key := addr.IP.String()
+	peer, exists := e.peers[key]
+	if !exists {
+		peer = &explainedPeer{
+			Address: net.JoinHostPort(key, strconv.Itoa(int(addr.Port))),
+			ip:      addr.IP,
+		}
+		e.peers[key] = peer
+	}
+	return peer
+}
+
+// exclude records that addr, advertising services, was left out for
+// reason.
+func (e *answerExplanation) exclude(addr *appmessage.NetAddress, services appmessage.ServiceFlag, reason string) {
+	if e == nil {
+		return
+	}
+	peer := e.peer(addr)
+	peer.Services = uint64(services)
+	peer.Included = false
+	peer.Reason = reason
+}
+
+// score records that addr, advertising services, is a candidate drawn with
+// the given weight.
+func (e *answerExplanation) score(addr *appmessage.NetAddress, services appmessage.ServiceFlag, weight float64) {
+	if e == nil {
+		return
+	}
+	peer := e.peer(addr)
+	peer.Services = uint64(services)
+	peer.Score = &weight
+}
+
+// include records that addr was selected for reason.
+func (e *answerExplanation) include(addr *appmessage.NetAddress, reason string) {
+	if e == nil {
+		return
+	}
+	peer := e.peer(addr)
+	peer.Included = true
+	peer.Reason = reason
+}
+
+// trim records that addr, selected, was trimmed from the response for
+// reason.
+func (e *answerExplanation) trim(addr *appmessage.NetAddress, reason string) {
+	if e == nil {
+		return
+	}
+	peer := e.peer(addr)
+	peer.Included = false
+	peer.Reason = reason
+}
+
+// setArm records the experiment arm the answer was selected with.
+func (e *answerExplanation) setArm(name string) {
+	if e == nil {
+		return
+	}
+	e.arm = name
+}
+
+// candidates returns the recorded candidates, sorted by address.
+func (e *answerExplanation) candidates() []*explainedPeer {
+	candidates := make([]*explainedPeer, 0, len(e.peers))
+	for _, peer := range e.peers {
+		candidates = append(candidates, peer)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return bytes.Compare(candidates[i].ip.To16(), candidates[j].ip.To16()) < 0
+	})
+	return candidates
+}
+
+// missingServicesReason returns why a peer lacking some of services is left
+// out of an answer.
+func missingServicesReason(services appmessage.ServiceFlag) string {
+	return fmt.Sprintf("lacks some of the services %#x", uint64(services))
+}
+
+// explainResponse is the explanation of the answer to a query: the
+// parameters the query name was parsed into, the addresses answered, and
+// why each candidate was or wasn't. Subnetwork is all, none or a
+// subnetwork ID. Reason tells why a query got no answer at all. TTL, Size
+// and Trimmed describe the response as it would be sent: its TTL, the size
+// of the packed message, and how many selected addresses were trimmed to
+// fit the buffer of the client or the limits of the amplification guard.
+type explainResponse struct {
+	Network     string           `json:"network"`
+	Zone        string           `json:"zone"`
+	QName       string           `json:"qname"`
+	QType       string           `json:"qtype"`
+	Client      string           `json:"client,omitempty"`
+	Subnetwork  string           `json:"subnetwork"`
+	Partial     bool             `json:"partial"`
+	DNS64       bool             `json:"dns64"`
+	Services    uint64           `json:"services"`
+	Count       int              `json:"count"`
+	Arm         string           `json:"arm,omitempty"`
+	Maintenance bool             `json:"maintenance"`
+	Static      bool             `json:"static"`
+	Reason      string           `json:"reason,omitempty"`
+	TTL         uint32           `json:"ttl"`
+	TTLClass    string           `json:"ttlClass"`
+	Size        int              `json:"size"`
+	Trimmed     int              `json:"trimmed"`
+	Answer      []string         `json:"answer"`
+	Candidates  []*explainedPeer `json:"candidates"`
+}
+
+// explainServer returns the running DNS server, or without one a DNS server
+// for the zones of the primary network of amgr, used to parse the names of
+// explained queries and build their answers.
+func explainServer(amgr *Manager) (*DNSServer, error) {
+	if dnsServer != nil {
+		return dnsServer, nil
+	}
+	zoneNetworks := servedNetworks()
+	cfg := ActiveConfig()
+	if len(zoneNetworks) == 0 && cfg != nil {
+		zoneNetworks = []*seederNetwork{{amgr: amgr, zones: cfg.AllZones()}}
+	}
+	d := NewDNSServer(zoneNetworks, "", nil, nil)
+	if cfg != nil && cfg.NAT64Prefix != "" {
+		_, d.nat64Prefix, _ = net.ParseCIDR(cfg.NAT64Prefix)
+	}
+	for _, zone := range d.zones {
+		err := zone.prepare(time.Now())
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid zone %s", zone.hostname)
+		}
+	}
+	return d, nil
+}
+
+// handleExplain selects the answer to an A or AAAA query as the DNS server
+// would, without serving or counting it, and returns the selected addresses
+// along with why each candidate was or wasn't selected. Supported query
+// parameters are qname, the name queried, client, the address of the
+// client, which picks its place in the rotation and its experiment arm,
+// qtype (A, the default, or AAAA), count, the number of addresses
+// requested with the answer count option, and udpsize, the EDNS0 buffer
+// size of the client. The answer is packed as it would be sent to a client
+// without a DNS cookie. Since peers are sampled by weight, repeated
+// explanations of the same query may differ.
+func (s *HTTPServer) handleExplain(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	qname := query.Get("qname")
+	if qname == "" {
+		writeError(w, http.StatusBadRequest, "missing qname")
+		return
+	}
+	domainName := dns.Fqdn(strings.ToLower(qname))
+	var qtype uint16
+	switch strings.ToUpper(query.Get("qtype")) {
+	case "", "A":
+		qtype = dns.TypeA
+	case "AAAA":
+		qtype = dns.TypeAAAA
+	default:
+		writeError(w, http.StatusBadRequest, "invalid qtype")
+		return
+	}
+	var client net.IP
+	if value := query.Get("client"); value != "" {
+		client = net.ParseIP(value)
+		if client == nil {
+			writeError(w, http.StatusBadRequest, "invalid client")
+			return
+		}
+	}
+	requested, err := intQueryParam(query.Get("count"), 0)
+	if err != nil || requested < 0 {
+		writeError(w, http.StatusBadRequest, "invalid count")
+		return
+	}
+	udpSize, err := intQueryParam(query.Get("udpsize"), 0)
+	if err != nil || udpSize < 0 || udpSize > dns.MaxMsgSize {
+		writeError(w, http.StatusBadRequest, "invalid udpsize")
+		return
+	}
+
+	// The answer is built for the query the client would send.
+	dnsMsg := &dns.Msg{}
+	dnsMsg.SetQuestion(domainName, qtype)
+	if udpSize > 0 {
+		dnsMsg.SetEdns0(uint16(udpSize), false)
+	}
+	if requested > 0 {
+		seeddns.SetAnswerCount(dnsMsg, requested)
+	}
+	count, _ := answerCount(dnsMsg)
+	addr := &net.UDPAddr{IP: client}
+
+	d, err := explainServer(s.amgr)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	zone := d.findZone(domainName)
+	if zone == nil {
+		writeError(w, http.StatusNotFound, "no zone serves "+qname)
+		return
+	}
+	if _, ok := zone.statusQueryIP(domainName); ok && peerStatusEnabled() {
+		writeError(w, http.StatusBadRequest, qname+" is a peer status query")
+		return
+	}
+	if _, ok := zone.membershipQueryIP(domainName); ok && membershipEnabled() {
+		writeError(w, http.StatusBadRequest, qname+" is a membership query")
+		return
+	}
+	subnetworkID, includeAllSubnetworks, err := seeddns.ParseSubnetworkName(zone.hostname, domainName)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid subnetwork: "+err.Error())
+		return
+	}
+
+	partial := zone.isPartialQuery(domainName)
+	peersType := qtype
+	dns64 := d.nat64Prefix != nil && zone.isDNS64Query(domainName)
+	if dns64 {
+		peersType = dns.TypeA
+	}
+	response := explainResponse{
+		Network:     zone.amgr.netParams.Name,
+		Zone:        zone.hostname,
+		QName:       domainName,
+		QType:       dns.TypeToString[qtype],
+		Subnetwork:  "all",
+		Partial:     partial,
+		DNS64:       dns64,
+		Services:    uint64(zone.answerServices(partial)),
+		Count:       count,
+		Maintenance: inMaintenance(),
+		Answer:      []string{},
+		Candidates:  []*explainedPeer{},
+	}
+	if client != nil {
+		response.Client = client.String()
+	}
+	if !includeAllSubnetworks {
+		response.Subnetwork = "none"
+		if subnetworkID != nil {
+			response.Subnetwork = subnetworkID.String()
+		}
+	}
+
+	var addrs []*appmessage.NetAddress
+	var explanation *answerExplanation
+	switch {
+	case !zone.servesType(peersType):
+		response.Reason = "the zone doesn't serve the peers of this address family"
+	case dns64 && qtype != dns.TypeAAAA:
+		response.Reason = "the DNS64 subdomain only answers AAAA queries"
+	case len(getStaticAnswers()) > 0:
+		response.Static = true
+		addrs = zone.amgr.GoodAddresses(peersType, includeAllSubnetworks, subnetworkID, partial,
+			zone.answerServices(partial), client, response.Count)
+	default:
+		explanation = newAnswerExplanation()
+		addrs = zone.amgr.selectAddresses(peersType, includeAllSubnetworks, subnetworkID, partial,
+			zone.answerServices(partial), client, response.Count, time.Now(), explanation)
+		response.Arm = explanation.arm
+	}
+
+	respMsg, _ := d.addressResponse(addr, zone, dnsMsg, addrs, dns64)
+	answered := len(respMsg.Answer)
+	udpTrimmed, _ := d.fit(addr, dnsMsg, respMsg)
+	fitted := answered - udpTrimmed
+	packed, err := respMsg.Pack()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to pack the answer: "+err.Error())
+		return
+	}
+	response.TTLClass, response.TTL = answerTTL(client, dnsMsg)
+	response.Size = len(packed)
+
+	// The records answer the selected addresses in order, so those past
+	// the records left are the trimmed ones.
+	for i, a := range addrs {
+		switch {
+		case i >= fitted:
+			explanation.trim(a, "trimmed to fit the UDP buffer of the client")
+		case i >= len(respMsg.Answer):
+			explanation.trim(a, "trimmed by the amplification guard")
+		default:
+			ip := a.IP
+			if dns64 {
+				ip = seeddns.SynthesizeNAT64(d.nat64Prefix, ip)
+			}
+			response.Answer = append(response.Answer, ip.String())
+			continue
+		}
+		response.Trimmed++
+	}
+	if explanation != nil {
+		response.Candidates = explanation.candidates()
+	}
+	writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/karlsen-network/karlsend/infrastructure/config"
+)
+
+func TestExplain(t *testing.T) {
+	cfg := setTestConfig(t, &ConfigFlags{
+		NetworkFlags:   config.NetworkFlags{Devnet: true},
+		Host:           "seed.example.com",
+		HostServices:   uint64(appmessage.SFNodeNetwork | appmessage.SFNodeBloom),
+		MaxPerNetgroup: 1,
+		MaxAnswerCount: 16,
+	})
+	err := cfg.ResolveNetwork(nil)
+	if err != nil {
+		t.Fatalf("ResolveNetwork: %s", err)
+	}
+	params := cfg.NetParams()
+	port, _ := strconv.Atoi(params.DefaultPort)
+
+	now := time.Now()
+	m := newTestManager(t, params, uint16(port))
+	m.alwaysServe = []net.IP{net.ParseIP("6.0.0.1")}
+	for _, ip := range []string{"1.0.0.1", "1.0.1.1", "2.0.0.1", "3.0.0.1", "4.0.0.1", "5.0.0.1", "2001:db8::1"} {
+		m.nodes[ip] = &Node{
+			Addr:            appmessage.NewNetAddressIPPort(net.ParseIP(ip), uint16(port)),
+			LastSuccess:     now,
+			ProtocolVersion: 5,
+			Services:        appmessage.SFNodeNetwork | appmessage.SFNodeBloom,
+		}
+	}
+	m.nodes["2.0.0.1"].LastSuccess = time.Time{}
+	m.nodes["3.0.0.1"].Addr.Port = 1
+	m.nodes["4.0.0.1"].Services = appmessage.SFNodeNetwork
+	m.nodes["5.0.0.1"].Services = 0
+	pool := newKeepalivePool(m, 1)
+
+	server := NewHTTPServer(m)
+	explain := func(url string, expectedStatus int) *explainResponse {
+		recorder := httptest.NewRecorder()
+		server.mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, url, nil))
+		if recorder.Code != expectedStatus {
+			t.Fatalf("%s: expected status %d, got %d", url, expectedStatus, recorder.Code)
+		}
+		var response explainResponse
+		err := json.Unmarshal(recorder.Body.Bytes(), &response)
+		if err != nil {
+			t.Fatalf("Unmarshal: %s", err)
+		}
+		return &response
+	}
+
+	response := explain("/v1/explain?qname=seed.example.com&client=192.0.2.1", http.StatusOK)
+	if response.Zone != "seed.example.com." || response.QType != "A" || response.Subnetwork != "all" ||
+		response.Services != uint64(appmessage.SFNodeNetwork|appmessage.SFNodeBloom) ||
+		response.Count != defaultMaxAddresses || response.Client != "192.0.2.1" {
+		t.Errorf("unexpected query parameters %+v", response)
+	}
+	if len(response.Answer) != 2 || response.Answer[0] != "6.0.0.1" {
+		t.Fatalf("expected the always served peer and a single peer of 1.0.0.0/16, got %v", response.Answer)
+	}
+	if response.TTLClass != ttlClassDefault || response.Size == 0 || response.Trimmed != 0 {
+		t.Errorf("unexpected packed answer %+v", response)
+	}
+	expected := map[string]string{
+		"2.0.0.1": "not scored",
+		"3.0.0.1": "not on the default port",
+		"4.0.0.1": "lacks some of the services 0x5",
+		"5.0.0.1": "partial node",
+		"6.0.0.1": "always served",
+	}
+	selected := response.Answer[1]
+	expected[selected] = "selected"
+	if selected == "1.0.0.1" {
+		expected["1.0.1.1"] = "over the per answer netgroup limit"
+	} else {
+		expected["1.0.0.1"] = "over the per answer netgroup limit"
+	}
+	if len(response.Candidates) != len(expected) {
+		t.Errorf("expected %d candidates, got %d", len(expected), len(response.Candidates))
+	}
+	for _, candidate := range response.Candidates {
+		host, _, _ := net.SplitHostPort(candidate.Address)
+		if candidate.Reason != expected[host] || candidate.Included != (candidate.Reason == "selected" ||
+			candidate.Reason == "always served") {
+			t.Errorf("expected %s to be explained as %q, got %+v", host, expected[host], candidate)
+		}
+		if (host == "1.0.0.1" || host == "1.0.1.1") && (candidate.Score == nil || *candidate.Score <= 0) {
+			t.Errorf("expected %s to be scored, got %+v", host, candidate)
+		}
+	}
+	// Explained answers aren't counted as served.
+	if ranking := pool.ranking(1, now); len(ranking) != 0 {
+		t.Errorf("expected the explained answer not to be counted, got %v", ranking)
+	}
+
+	subnetworkID := "0100000000000000000000000000000000000000"
+	response = explain("/v1/explain?qname=n"+subnetworkID+".SEED.example.com.&qtype=aaaa&count=1", http.StatusOK)
+	if response.Subnetwork != subnetworkID || response.QType != "AAAA" || response.Count != 1 ||
+		len(response.Answer) != 0 || len(response.Candidates) != 1 ||
+		response.Candidates[0].Reason != "in another subnetwork" {
+		t.Errorf("unexpected explanation of a query for the peers of a subnetwork %+v", response)
+	}
+	response = explain("/v1/explain?qname=n.seed.example.com&qtype=AAAA", http.StatusOK)
+	if response.Subnetwork != "none" || len(response.Answer) != 1 || response.Answer[0] != "2001:db8::1" {
+		t.Errorf("unexpected explanation of a query for the peers without a subnetwork %+v", response)
+	}
+
+	setStaticAnswers([]net.IP{net.ParseIP("7.0.0.1")})
+	response = explain("/v1/explain?qname=seed.example.com", http.StatusOK)
+	setStaticAnswers(nil)
+	if !response.Static || len(response.Answer) != 1 || response.Answer[0] != "7.0.0.1" {
+		t.Errorf("expected the static answers, got %+v", response)
+	}
+
+	// Answers are packed by the running DNS server, within the limits of
+	// its amplification guard.
+	guard, err := newAmplificationGuard(1)
+	if err != nil {
+		t.Fatalf("newAmplificationGuard: %s", err)
+	}
+	previousDNSServer := dnsServer
+	dnsServer = NewDNSServer([]*seederNetwork{{amgr: m, zones: cfg.AllZones()}}, "", nil, guard)
+	t.Cleanup(func() { dnsServer = previousDNSServer })
+	for _, zone := range dnsServer.zones {
+		err := zone.prepare(now)
+		if err != nil {
+			t.Fatalf("prepare: %s", err)
+		}
+	}
+	response = explain("/v1/explain?qname=seed.example.com&udpsize=1232", http.StatusOK)
+	if len(response.Answer) != 1 || response.Trimmed != 1 {
+		t.Fatalf("expected the answer to be trimmed by the amplification guard, got %+v", response)
+	}
+	var trimmed bool
+	for _, candidate := range response.Candidates {
+		if candidate.Reason == "trimmed by the amplification guard" && !candidate.Included {
+			trimmed = true
+		}
+	}
+	if !trimmed {
+		t.Errorf("expected a trimmed candidate, got %+v", response.Candidates)
+	}
+	dnsServer = nil
+
+	explain("/v1/explain", http.StatusBadRequest)
+	explain("/v1/explain?qname=seed.example.com&udpsize=65536", http.StatusBadRequest)
+	explain("/v1/explain?qname=seed.example.com&qtype=MX", http.StatusBadRequest)
+	explain("/v1/explain?qname=seed.example.com&client=nope", http.StatusBadRequest)
+	explain("/v1/explain?qname=seed.example.com&count=-1", http.StatusBadRequest)
+	explain("/v1/explain?qname=nzz.seed.example.com", http.StatusBadRequest)
+	explain("/v1/explain?qname=seed.example.org", http.StatusNotFound)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/karlsen-network/karlsend/infrastructure/network/netadapter/router"
+	"github.com/pkg/errors"
+)
+
+// The reasons a crawl attempt fails for.
+const (
+	failureDialTimeout      = "dial_timeout"
+	failureRefused          = "refused"
+	failureDialError        = "dial_error"
+	failureHandshakeTimeout = "handshake_timeout"
+	failureBadMagic         = "bad_magic"
+	failureProtocolError    = "protocol_error"
+	failureAddressesTimeout = "addresses_timeout"
+	failureDisconnected     = "disconnected"
+	failureBanned           = "banned"
+	failureOther            = "other"
+)
+
+// failureReasons lists all crawl failure reasons, in the order they are
+// reported.
+var failureReasons = []string{
+	failureDialTimeout,
+	failureRefused,
+	failureDialError,
+	failureHandshakeTimeout,
+	failureBadMagic,
+	failureProtocolError,
+	failureAddressesTimeout,
+	failureDisconnected,
+	failureBanned,
+	failureOther,
+}
+
+// handshakeError is returned when the handshake with a peer fails.
+type handshakeError struct {
+	err error
+}
+
+func (e *handshakeError) Error() string {
+	return "Error in handshake: " + e.err.Error()
+}
+
+func (e *handshakeError) Unwrap() error {
+	return e.err
+}
+
+// errBanned is returned when crawling a peer banned since it was queued, or
+// outside of the allowed networks.
+var errBanned = errors.New("peer is banned")
+
+// classifyCrawlFailure returns the reason of the failed crawl attempt err.
+func classifyCrawlFailure(err error) string {
+	if errors.Is(err, errBanned) {
+		return failureBanned
+	}
+
+	var rejection *rejectionError
+	if errors.As(err, &rejection) {
+		switch rejection.reason {
+		case rejectedUnreachable:
+			return classifyDialFailure(rejection.err)
+		case rejectedWrongNetwork:
+			return failureBadMagic
+		case rejectedProtocolVersion:
+			return failureProtocolError
+		case rejectedNoAddresses:
+			if errors.Is(err, router.ErrTimeout) {
+				return failureAddressesTimeout
+			}
+			return failureProtocolError
+		}
+	}
+
+	var handshake *handshakeError
+	if errors.As(err, &handshake) {
+		switch {
+		case errors.Is(err, router.ErrTimeout):
+			return failureHandshakeTimeout
+		case errors.Is(err, router.ErrRouteClosed):
+			return failureDisconnected
+		}
+		return failureProtocolError
+	}
+	return failureOther
+}
+
+// classifyDialFailure returns the reason a connection couldn't be opened.
+// gRPC reports some dial errors as text only, so the messages are checked
+// as well.
+func classifyDialFailure(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout(),
+		strings.Contains(err.Error(), "deadline exceeded"):
+		return failureDialTimeout
+	case errors.Is(err, syscall.ECONNREFUSED),
+		strings.Contains(err.Error(), "connection refused"):
+		return failureRefused
+	}
+	return failureDialError
+}
+
+// crawlFailureCounts counts the failed crawl attempts of a network by
+// reason. The zero value is ready to use.
+type crawlFailureCounts struct {
+	mtx    sync.Mutex
+	counts map[string]uint64
+}
+
+func (c *crawlFailureCounts) add(reason string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.counts == nil {
+		c.counts = make(map[string]uint64, len(failureReasons))
+	}
+	c.counts[reason]++
+}
+
+// Counts returns a copy of the count of each reason.
+func (c *crawlFailureCounts) Counts() map[string]uint64 {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	counts := make(map[string]uint64, len(failureReasons))
+	for _, reason := range failureReasons {
+		counts[reason] = c.counts[reason]
+	}
+	return counts
+}
+
+// metrics returns the failure counts as stats metrics.
+func (c *crawlFailureCounts) metrics() []metric {
+	counts := c.Counts()
+	metrics := make([]metric, len(failureReasons))
+	for i, reason := range failureReasons {
+		metrics[i] = metric{"crawl_failures_" + reason, counts[reason]}
+	}
+	return metrics
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"syscall"
+	"testing"
+
+	"github.com/karlsen-network/karlsend/infrastructure/network/netadapter/router"
+	"github.com/pkg/errors"
+)
+
+func TestClassifyCrawlFailure(t *testing.T) {
+	tests := []struct {
+		err    error
+		reason string
+	}{
+		{reject(rejectedUnreachable, nil, errors.Wrap(context.DeadlineExceeded, "dial")), failureDialTimeout},
+		{reject(rejectedUnreachable, nil, syscall.ECONNREFUSED), failureRefused},
+		{reject(rejectedUnreachable, nil, errors.New("connection error: desc = \"transport: connection refused\"")), failureRefused},
+		{reject(rejectedUnreachable, nil, errors.New("no route to host")), failureDialError},
+		{&handshakeError{err: errors.Wrap(router.ErrTimeout, "route 'handshake' got timeout")}, failureHandshakeTimeout},
+		{&handshakeError{err: router.ErrRouteClosed}, failureDisconnected},
+		{&handshakeError{err: errors.New("expected first message to be of type version")}, failureProtocolError},
+		{&handshakeError{err: reject(rejectedWrongNetwork, nil, errors.New("peer is on network testnet"))}, failureBadMagic},
+		{&handshakeError{err: reject(rejectedProtocolVersion, nil, errors.New("protocol version 4"))}, failureProtocolError},
+		{reject(rejectedNoAddresses, nil, errors.Wrap(router.ErrTimeout, "addresses")), failureAddressesTimeout},
+		{errors.Wrap(errBanned, "not connecting"), failureBanned},
+		{errors.New("invalid peer address"), failureOther},
+	}
+	for _, test := range tests {
+		err := errors.Wrap(test.err, "could not connect to 203.0.113.5:42111")
+		if reason := classifyCrawlFailure(err); reason != test.reason {
+			t.Errorf("%v: expected %s, got %s", err, test.reason, reason)
+		}
+	}
+
+	var counts crawlFailureCounts
+	counts.add(failureRefused)
+	counts.add(failureRefused)
+	if c := counts.Counts(); len(c) != len(failureReasons) || c[failureRefused] != 2 || c[failureBanned] != 0 {
+		t.Errorf("unexpected counts %v", c)
+	}
+}
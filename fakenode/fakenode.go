@@ -0,0 +1,316 @@
+// Package fakenode runs lightweight in-process peers speaking just enough of
+// the P2P protocol for the crawler: the version/verack handshake, address
+// requests and pings. Their behavior is scriptable, so crawler tests can
+// cover slow, misconfigured and misbehaving peers end to end.
+package fakenode
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/karlsen-network/karlsend/app/protocol/common"
+	"github.com/karlsen-network/karlsend/domain/consensus/model/externalapi"
+	"github.com/karlsen-network/karlsend/domain/dagconfig"
+	"github.com/karlsen-network/karlsend/infrastructure/config"
+	"github.com/karlsen-network/karlsend/infrastructure/network/netadapter"
+	"github.com/karlsen-network/karlsend/infrastructure/network/netadapter/router"
+	"github.com/karlsen-network/karlsend/util/mstime"
+	"github.com/pkg/errors"
+)
+
+// DefaultProtocolVersion is the protocol version announced by nodes whose
+// behavior doesn't set one.
+const DefaultProtocolVersion = 5
+
+// DefaultUserAgent is the user agent announced by nodes whose behavior
+// doesn't set one.
+const DefaultUserAgent = "/fakenode/"
+
+// startAttempts is the number of ports Start tries to listen on.
+const startAttempts = 3
+
+// Behavior scripts how a node answers the peers connecting to it. The zero
+// value behaves as a well-configured node knowing no addresses.
+type Behavior struct {
+	// Network is the network announced in the version message, that of
+	// the node if empty. Another one behaves as a node of the wrong
+	// network, whose magic doesn't match.
+	Network string
+
+	// ProtocolVersion, UserAgent, Services and SubnetworkID are announced
+	// in the version message. ProtocolVersion and UserAgent default to
+	// DefaultProtocolVersion and DefaultUserAgent.
+	ProtocolVersion uint32
+	UserAgent       string
+	Services        appmessage.ServiceFlag
+	SubnetworkID    *externalapi.DomainSubnetworkID
+
+	// Addresses are those answered to address requests.
+	Addresses []*appmessage.NetAddress
+
+	// HandshakeDelay delays the version message, and AddressesDelay each
+	// answer to an address request, as slow nodes do.
+	HandshakeDelay time.Duration
+	AddressesDelay time.Duration
+
+	// DisconnectOnConnect closes connections before the handshake, and
+	// DisconnectAfterHandshake right after it.
+	DisconnectOnConnect      bool
+	DisconnectAfterHandshake bool
+
+	// IgnoreAddressRequests leaves address requests after the handshake
+	// unanswered.
+	IgnoreAddressRequests bool
+
+	// Spam is the number of unsolicited ping and address messages sent
+	// right after the handshake, as spammy nodes do.
+	Spam int
+}
+
+// Node is an in-process peer listening on a local port.
+type Node struct {
+	// Address is the host:port address the node listens on.
+	Address string
+
+	params     *dagconfig.Params
+	netAdapter *netadapter.NetAdapter
+
+	mtx      sync.Mutex
+	behavior Behavior
+
+	connections uint32
+}
+
+// Start starts a node of the network with the given parameters, behaving
+// as behavior scripts, on a free local port.
+func Start(params *dagconfig.Params, behavior Behavior) (*Node, error) {
+	for attempt := 1; ; attempt++ {
+		reservation, err := Reserve("127.0.0.1")
+		if err != nil {
+			return nil, err
+		}
+		node, err := reservation.Start(params, behavior)
+		// The port may still be taken between the reservation and the
+		// node listening on it, in which case another one is tried.
+		if errors.Is(err, syscall.EADDRINUSE) && attempt < startAttempts {
+			continue
+		}
+		return node, err
+	}
+}
+
+// StartAt starts a node as Start does, listening on the host:port address.
+func StartAt(address string, params *dagconfig.Params, behavior Behavior) (*Node, error) {
+	cfg := &config.Config{Flags: &config.Flags{
+		NetworkFlags: config.NetworkFlags{ActiveNetParams: params},
+		Listeners:    []string{address},
+	}}
+	netAdapter, err := netadapter.NewNetAdapter(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create the net adapter")
+	}
+
+	n := &Node{
+		Address:    address,
+		params:     params,
+		netAdapter: netAdapter,
+		behavior:   behavior,
+	}
+	netAdapter.SetP2PRouterInitializer(n.initializeRouter)
+	netAdapter.SetRPCRouterInitializer(func(_ *router.Router, _ *netadapter.NetConnection) {})
+	err = netAdapter.Start()
+	if err != nil {
+		// The adapter may have started some of its servers.
+		_ = netAdapter.Stop()
+		return nil, errors.Wrap(err, "failed to start the net adapter")
+	}
+	return n, nil
+}
+
+// Reservation holds a free port of a host until a node is started on it.
+// The net adapter of the node opens its own listener, so the port is only
+// released right before.
+type Reservation struct {
+	// Address is the host:port address reserved.
+	Address string
+
+	listener net.Listener
+}
+
+// Reserve reserves a free port of host.
+func Reserve(host string) (*Reservation, error) {
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find a free port")
+	}
+	return &Reservation{Address: listener.Addr().String(), listener: listener}, nil
+}
+
+// Start releases the reserved port and starts a node as StartAt does,
+// listening on it.
+func (r *Reservation) Start(params *dagconfig.Params, behavior Behavior) (*Node, error) {
+	r.Release()
+	return StartAt(r.Address, params, behavior)
+}
+
+// Release releases the reserved port without starting a node on it.
+func (r *Reservation) Release() {
+	_ = r.listener.Close()
+}
+
+// Port returns the port the node listens on.
+func (n *Node) Port() uint16 {
+	_, portStr, _ := net.SplitHostPort(n.Address)
+	port, _ := strconv.ParseUint(portStr, 10, 16)
+	return uint16(port)
+}
+
+// SetBehavior replaces the behavior of the node for new connections.
+func (n *Node) SetBehavior(behavior Behavior) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	n.behavior = behavior
+}
+
+// Connections returns the number of connections the node accepted.
+func (n *Node) Connections() int {
+	return int(atomic.LoadUint32(&n.connections))
+}
+
+// Stop closes the connections of the node and stops it.
+func (n *Node) Stop() error {
+	for _, netConnection := range n.netAdapter.P2PConnections() {
+		netConnection.Disconnect()
+	}
+	return n.netAdapter.Stop()
+}
+
+func (n *Node) initializeRouter(r *router.Router, netConnection *netadapter.NetConnection) {
+	commands := make([]appmessage.MessageCommand, 0, len(appmessage.ProtocolMessageCommandToString))
+	for command := range appmessage.ProtocolMessageCommandToString {
+		commands = append(commands, command)
+	}
+	incomingRoute, err := r.AddIncomingRoute("fakenode", commands)
+	if err != nil {
+		panic(errors.Wrap(err, "error registering the fakenode route"))
+	}
+
+	atomic.AddUint32(&n.connections, 1)
+	n.mtx.Lock()
+	behavior := n.behavior
+	n.mtx.Unlock()
+
+	go func() {
+		defer netConnection.Disconnect()
+		_ = n.serve(behavior, incomingRoute, r.OutgoingRoute())
+	}()
+}
+
+// serve runs a connection as behavior scripts, until it is closed or the
+// peer misbehaves.
+func (n *Node) serve(behavior Behavior, incomingRoute, outgoingRoute *router.Route) error {
+	if behavior.DisconnectOnConnect {
+		return nil
+	}
+	time.Sleep(behavior.HandshakeDelay)
+
+	network := behavior.Network
+	if network == "" {
+		network = n.params.Name
+	}
+	protocolVersion := behavior.ProtocolVersion
+	if protocolVersion == 0 {
+		protocolVersion = DefaultProtocolVersion
+	}
+	userAgent := behavior.UserAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	err := outgoingRoute.Enqueue(&appmessage.MsgVersion{
+		ProtocolVersion: protocolVersion,
+		Network:         network,
+		Services:        behavior.Services,
+		Timestamp:       mstime.Now(),
+		ID:              n.netAdapter.ID(),
+		UserAgent:       userAgent,
+		SubnetworkID:    behavior.SubnetworkID,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = expect(incomingRoute, appmessage.CmdVersion)
+	if err != nil {
+		return err
+	}
+	err = outgoingRoute.Enqueue(&appmessage.MsgVerAck{})
+	if err != nil {
+		return err
+	}
+	_, err = expect(incomingRoute, appmessage.CmdVerAck)
+	if err != nil {
+		return err
+	}
+	err = outgoingRoute.Enqueue(appmessage.NewMsgRequestAddresses(true, nil))
+	if err != nil {
+		return err
+	}
+	_, err = expect(incomingRoute, appmessage.CmdAddresses)
+	if err != nil {
+		return err
+	}
+	if behavior.DisconnectAfterHandshake {
+		return nil
+	}
+
+	for i := 0; i < behavior.Spam; i++ {
+		err = outgoingRoute.Enqueue(appmessage.NewMsgPing(uint64(i)))
+		if err != nil {
+			return err
+		}
+		err = outgoingRoute.Enqueue(appmessage.NewMsgAddresses(behavior.Addresses))
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		message, err := incomingRoute.Dequeue()
+		if err != nil {
+			return err
+		}
+		switch message := message.(type) {
+		case *appmessage.MsgRequestAddresses:
+			if behavior.IgnoreAddressRequests {
+				continue
+			}
+			time.Sleep(behavior.AddressesDelay)
+			err = outgoingRoute.Enqueue(appmessage.NewMsgAddresses(behavior.Addresses))
+		case *appmessage.MsgPing:
+			err = outgoingRoute.Enqueue(appmessage.NewMsgPong(message.Nonce))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// expect waits for the next message, which must be of type command. Ready
+// messages, which peers send when they start, are skipped.
+func expect(incomingRoute *router.Route, command appmessage.MessageCommand) (appmessage.Message, error) {
+	message, err := incomingRoute.DequeueWithTimeout(common.DefaultTimeout)
+	for err == nil && message.Command() == appmessage.CmdReady {
+		message, err = incomingRoute.DequeueWithTimeout(common.DefaultTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if message.Command() != command {
+		return nil, errors.Errorf("expected a message of type %s, but got %s", command, message.Command())
+	}
+	return message, nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+	"github.com/pkg/errors"
+)
+
+// geoIPReloadInterval is the interval between checks for updated GeoIP
+// database files.
+const geoIPReloadInterval = time.Minute
+
+// NodeLocation is the geographic location and origin AS of a node, as
+// found in the GeoIP databases. Fields missing from the databases are left
+// empty.
+type NodeLocation struct {
+	Country   string  `json:"country,omitempty"`
+	Continent string  `json:"continent,omitempty"`
+	City      string  `json:"city,omitempty"`
+	Latitude  float64 `json:"latitude,omitempty"`
+	Longitude float64 `json:"longitude,omitempty"`
+	ASN       uint    `json:"asn,omitempty"`
+	ASOrg     string  `json:"asOrg,omitempty"`
+}
+
+// geoIPDatabase is a MaxMind database file, reopened whenever the file is
+// replaced.
+type geoIPDatabase struct {
+	path string
+
+	mtx     sync.RWMutex
+	reader  *geoip2.Reader
+	modTime time.Time
+}
+
+// openGeoIPDatabase opens the MaxMind database at path.
+func openGeoIPDatabase(path string) (*geoIPDatabase, error) {
+	db := &geoIPDatabase{path: path}
+	_, err := db.reloadIfChanged()
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// reloadIfChanged reopens the database if its file changed since it was
+// last opened, and returns whether it did.
+func (db *geoIPDatabase) reloadIfChanged() (bool, error) {
+	info, err := os.Stat(db.path)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	db.mtx.RLock()
+	unchanged := db.reader != nil && info.ModTime().Equal(db.modTime)
+	db.mtx.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	reader, err := geoip2.Open(db.path)
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to open GeoIP database %s", db.path)
+	}
+
+	db.mtx.Lock()
+	previous := db.reader
+	db.reader = reader
+	db.modTime = info.ModTime()
+	db.mtx.Unlock()
+
+	if previous != nil {
+		previous.Close()
+	}
+	return true, nil
+}
+
+// locate fills the fields of location found in the database.
+func (db *geoIPDatabase) locate(ip net.IP, location *NodeLocation) {
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+
+	databaseType := db.reader.Metadata().DatabaseType
+	switch {
+	case strings.Contains(databaseType, "ASN"):
+		record, err := db.reader.ASN(ip)
+		if err != nil {
+			return
+		}
+		location.ASN = record.AutonomousSystemNumber
+		location.ASOrg = record.AutonomousSystemOrganization
+	case strings.Contains(databaseType, "City"):
+		record, err := db.reader.City(ip)
+		if err != nil {
+			return
+		}
+		location.Country = record.Country.IsoCode
+		location.Continent = record.Continent.Code
+		location.City = record.City.Names["en"]
+		location.Latitude = record.Location.Latitude
+		location.Longitude = record.Location.Longitude
+	default:
+		record, err := db.reader.Country(ip)
+		if err != nil {
+			return
+		}
+		location.Country = record.Country.IsoCode
+		location.Continent = record.Continent.Code
+	}
+}
+
+// lookupASN returns the origin AS of ip, and whether the database knows it.
+// Only ASN databases are looked up, so grouping peers by AS doesn't pay for
+// a city lookup.
+func (db *geoIPDatabase) lookupASN(ip net.IP) (uint, bool) {
+	db.mtx.RLock()
+	defer db.mtx.RUnlock()
+
+	if !strings.Contains(db.reader.Metadata().DatabaseType, "ASN") {
+		return 0, false
+	}
+	record, err := db.reader.ASN(ip)
+	if err != nil || record.AutonomousSystemNumber == 0 {
+		return 0, false
+	}
+	return record.AutonomousSystemNumber, true
+}
+
+func (db *geoIPDatabase) close() {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	db.reader.Close()
+}
+
+// geoIPDatabases holds the configured GeoIP databases. It is empty unless
+// --geoipcity or --geoipasn is given.
+var geoIPDatabases []*geoIPDatabase
+
+// initGeoIP opens the given GeoIP databases, any of which may be empty, and
+// populates the dashboard map from them.
+func initGeoIP(paths ...string) error {
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		db, err := openGeoIPDatabase(path)
+		if err != nil {
+			closeGeoIP()
+			return err
+		}
+		geoIPDatabases = append(geoIPDatabases, db)
+	}
+
+	if len(geoIPDatabases) > 0 {
+		nodeLocator = func(node *Node) (float64, float64, bool) {
+			location := lookupLocation(node.Addr.IP)
+			if location == nil || (location.Latitude == 0 && location.Longitude == 0) {
+				return 0, 0, false
+			}
+			return location.Latitude, location.Longitude, true
+		}
+	}
+	return nil
+}
+
+// closeGeoIP closes the GeoIP databases.
+func closeGeoIP() {
+	for _, db := range geoIPDatabases {
+		db.close()
+	}
+	geoIPDatabases = nil
+	nodeLocator = nil
+}
+
+// lookupLocation returns the location of ip, or nil if no GeoIP database
+// is configured or none of them knows it.
+func lookupLocation(ip net.IP) *NodeLocation {
+	if len(geoIPDatabases) == 0 {
+		return nil
+	}
+
+	var location NodeLocation
+	for _, db := range geoIPDatabases {
+		db.locate(ip, &location)
+	}
+	if location == (NodeLocation{}) {
+		return nil
+	}
+	return &location
+}
+
+// lookupASN returns the origin AS of ip, and whether a GeoIP database knows
+// it.
+func lookupASN(ip net.IP) (uint, bool) {
+	for _, db := range geoIPDatabases {
+		if asn, ok := db.lookupASN(ip); ok {
+			return asn, true
+		}
+	}
+	return 0, false
+}
+
+// reloadGeoIP reopens the GeoIP databases whose files were updated every
+// geoIPReloadInterval, until quit is closed.
+func reloadGeoIP(quit <-chan struct{}) {
+	ticker := time.NewTicker(geoIPReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, db := range geoIPDatabases {
+				reloaded, err := db.reloadIfChanged()
+				if err != nil {
+					log.Warnf("Failed to reload GeoIP database: %v", err)
+					continue
+				}
+				if reloaded {
+					log.Infof("Reloaded GeoIP database %s", db.path)
+				}
+			}
+		case <-quit:
+			return
+		}
+	}
+}
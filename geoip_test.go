@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/karlsen-network/karlsend/app/appmessage"
+)
+
+// mmdbNetwork is a network of a test MaxMind database and its record.
+type mmdbNetwork struct {
+	cidr   string
+	record map[string]interface{}
+}
+
+// writeMMDB writes an IPv4 MaxMind database of the given type with the
+// given non-overlapping networks to path, since there are no test databases
+// among the dependencies.
+func writeMMDB(t *testing.T, path, databaseType string, networks []mmdbNetwork) {
+	const empty = -1
+
+	// Each node holds its left and right record: a node index, empty, or
+	// -(i+2) for the data of the i-th network.
+	nodes := [][2]int{{empty, empty}}
+	for i, network := range networks {
+		_, ipNet, err := net.ParseCIDR(network.cidr)
+		if err != nil {
+			t.Fatalf("ParseCIDR: %s", err)
+		}
+		ip := ipNet.IP.To4()
+		ones, _ := ipNet.Mask.Size()
+		node := 0
+		for bit := 0; bit < ones; bit++ {
+			b := int(ip[bit/8]>>(7-bit%8)) & 1
+			if bit == ones-1 {
+				nodes[node][b] = -(i + 2)
+				break
+			}
+			if nodes[node][b] == empty {
+				nodes = append(nodes, [2]int{empty, empty})
+				nodes[node][b] = len(nodes) - 1
+			}
+			node = nodes[node][b]
+		}
+	}
+
+	var data bytes.Buffer
+	offsets := make([]int, len(networks))
+	for i, network := range networks {
+		offsets[i] = data.Len()
+		encodeMMDB(&data, network.record)
+	}
+
+	var buf bytes.Buffer
+	for _, node := range nodes {
+		for _, record := range node {
+			value := record
+			switch {
+			case record == empty:
+				value = len(nodes)
+			case record < 0:
+				value = len(nodes) + 16 + offsets[-record-2]
+			}
+			buf.Write([]byte{byte(value >> 16), byte(value >> 8), byte(value)})
+		}
+	}
+	buf.Write(make([]byte, 16))
+	buf.Write(data.Bytes())
+	buf.WriteString("\xAB\xCD\xEFMaxMind.com")
+	encodeMMDB(&buf, map[string]interface{}{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(time.Now().Unix()),
+		"database_type":               databaseType,
+		"description":                 map[string]interface{}{},
+		"ip_version":                  uint16(4),
+		"languages":                   []interface{}{"en"},
+		"node_count":                  uint32(len(nodes)),
+		"record_size":                 uint16(24),
+	})
+
+	replaceFile(t, path, buf.Bytes())
+}
+
+// replaceFile atomically replaces the file at path like geoipupdate does,
+// since the open database maps the file it read.
+func replaceFile(t *testing.T, path string, data []byte) {
+	err := os.WriteFile(path+".tmp", data, 0644)
+	if err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+	err = os.Rename(path+".tmp", path)
+	if err != nil {
+		t.Fatalf("Rename: %s", err)
+	}
+}
+
+// encodeMMDB appends value to buf in the MaxMind DB data format.
+func encodeMMDB(buf *bytes.Buffer, value interface{}) {
+	control := func(dataType, size int) {
+		first := byte(dataType << 5)
+		if dataType > 7 {
+			first = 0
+		}
+		switch {
+		case size < 29:
+			buf.WriteByte(first | byte(size))
+		default:
+			buf.WriteByte(first | 29)
+		}
+		if dataType > 7 {
+			buf.WriteByte(byte(dataType - 7))
+		}
+		if size >= 29 {
+			buf.WriteByte(byte(size - 29))
+		}
+	}
+	unsigned := func(dataType int, v uint64) {
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], v)
+		trimmed := bytes.TrimLeft(b[:], "\x00")
+		control(dataType, len(trimmed))
+		buf.Write(trimmed)
+	}
+
+	switch v := value.(type) {
+	case string:
+		control(2, len(v))
+		buf.WriteString(v)
+	case float64:
+		control(3, 8)
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], math.Float64bits(v))
+		buf.Write(b[:])
+	case uint16:
+		unsigned(5, uint64(v))
+	case uint32:
+		unsigned(6, uint64(v))
+	case uint64:
+		unsigned(9, v)
+	case []interface{}:
+		control(11, len(v))
+		for _, item := range v {
+			encodeMMDB(buf, item)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		control(7, len(keys))
+		for _, key := range keys {
+			encodeMMDB(buf, key)
+			encodeMMDB(buf, v[key])
+		}
+	default:
+		panic("unsupported MaxMind DB value")
+	}
+}
+
+func cityRecord(country, continent, city string, latitude, longitude float64) map[string]interface{} {
+	return map[string]interface{}{
+		"city":      map[string]interface{}{"names": map[string]interface{}{"en": city}},
+		"continent": map[string]interface{}{"code": continent},
+		"country":   map[string]interface{}{"iso_code": country},
+		"location":  map[string]interface{}{"latitude": latitude, "longitude": longitude},
+	}
+}
+
+func TestGeoIPLookup(t *testing.T) {
+	if lookupLocation(net.ParseIP("192.0.2.1")) != nil {
+		t.Errorf("expected no location without a database")
+	}
+	if _, ok := lookupASN(net.ParseIP("192.0.2.1")); ok {
+		t.Errorf("expected no AS without a database")
+	}
+
+	dir := t.TempDir()
+	cityPath := filepath.Join(dir, "city.mmdb")
+	asnPath := filepath.Join(dir, "asn.mmdb")
+	writeMMDB(t, cityPath, "GeoLite2-City", []mmdbNetwork{
+		{"192.0.2.0/24", cityRecord("NL", "EU", "Amsterdam", 52.37, 4.89)},
+		{"198.51.100.0/24", cityRecord("JP", "AS", "Tokyo", 35.68, 139.69)},
+	})
+	writeMMDB(t, asnPath, "GeoLite2-ASN", []mmdbNetwork{
+		{"192.0.2.0/23", map[string]interface{}{
+			"autonomous_system_number":       uint32(64500),
+			"autonomous_system_organization": "Example Hosting",
+		}},
+	})
+
+	err := initGeoIP(cityPath, "", asnPath)
+	if err != nil {
+		t.Fatalf("initGeoIP: %s", err)
+	}
+	defer closeGeoIP()
+
+	location := lookupLocation(net.ParseIP("192.0.2.7"))
+	expected := NodeLocation{Country: "NL", Continent: "EU", City: "Amsterdam", Latitude: 52.37,
+		Longitude: 4.89, ASN: 64500, ASOrg: "Example Hosting"}
+	if location == nil || *location != expected {
+		t.Errorf("unexpected location %+v, expected %+v", location, expected)
+	}
+	location = lookupLocation(net.ParseIP("198.51.100.7"))
+	if location == nil || location.City != "Tokyo" || location.ASN != 0 {
+		t.Errorf("expected a location without AS, got %+v", location)
+	}
+	if location := lookupLocation(net.ParseIP("203.0.113.1")); location != nil {
+		t.Errorf("expected no location for an unknown address, got %+v", location)
+	}
+	if location := lookupLocation(net.ParseIP("2001:db8::1")); location != nil {
+		t.Errorf("expected no location for an IPv6 address, got %+v", location)
+	}
+
+	asn, ok := lookupASN(net.ParseIP("192.0.3.1"))
+	if !ok || asn != 64500 {
+		t.Errorf("expected AS64500, got %d, %t", asn, ok)
+	}
+	if _, ok := lookupASN(net.ParseIP("198.51.100.7")); ok {
+		t.Errorf("expected no AS for an address outside the ASN database")
+	}
+
+	node := &Node{Addr: appmessage.NewNetAddressIPPort(net.ParseIP("198.51.100.7"), 42111)}
+	latitude, longitude, ok := nodeLocator(node)
+	if !ok || latitude != 35.68 || longitude != 139.69 {
+		t.Errorf("unexpected coordinates %f, %f, %t", latitude, longitude, ok)
+	}
+	record := newPeerRecord(node, time.Now())
+	if record.Location == nil || record.Location.Country != "JP" {
+		t.Errorf("expected the peer record to carry the location, got %+v", record.Location)
+	}
+
+	closeGeoIP()
+	if lookupLocation(net.ParseIP("192.0.2.7")) != nil || nodeLocator != nil {
+		t.Errorf("expected no lookups after closing the databases")
+	}
+}
+
+func TestGeoIPReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "city.mmdb")
+	writeMMDB(t, path, "GeoLite2-City", []mmdbNetwork{
+		{"192.0.2.0/24", cityRecord("NL", "EU", "Amsterdam", 52.37, 4.89)},
+	})
+	db, err := openGeoIPDatabase(path)
+	if err != nil {
+		t.Fatalf("openGeoIPDatabase: %s", err)
+	}
+	defer db.close()
+
+	reloaded, err := db.reloadIfChanged()
+	if err != nil || reloaded {
+		t.Errorf("expected an unchanged database not to be reloaded, got %t, %v", reloaded, err)
+	}
+
+	writeMMDB(t, path, "GeoLite2-City", []mmdbNetwork{
+		{"192.0.2.0/24", cityRecord("DE", "EU", "Berlin", 52.52, 13.4)},
+	})
+	later := time.Now().Add(time.Minute)
+	err = os.Chtimes(path, later, later)
+	if err != nil {
+		t.Fatalf("Chtimes: %s", err)
+	}
+	reloaded, err = db.reloadIfChanged()
+	if err != nil || !reloaded {
+		t.Fatalf("expected the updated database to be reloaded, got %t, %v", reloaded, err)
+	}
+	var location NodeLocation
+	db.locate(net.ParseIP("192.0.2.7"), &location)
+	if location.City != "Berlin" {
+		t.Errorf("expected the reloaded database to be used, got %+v", location)
+	}
+
+	// A broken update keeps the last good database.
+	replaceFile(t, path, []byte("not a database"))
+	later = later.Add(time.Minute)
+	err = os.Chtimes(path, later, later)
+	if err != nil {
+		t.Fatalf("Chtimes: %s", err)
+	}
+	_, err = db.reloadIfChanged()
+	if err == nil {
+		t.Errorf("expected a broken database to fail to reload")
+	}
+	location = NodeLocation{}
+	db.locate(net.ParseIP("192.0.2.7"), &location)
+	if location.City != "Berlin" {
+		t.Errorf("expected the last good database to be kept, got %+v", location)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// gossipServiceName is the full gRPC name of the gossip service.
+	gossipServiceName = "dnsseeder.Gossip"
+
+	// gossipMaxPeers is the maximum number of peers sent in a single
+	// gossip response.
+	gossipMaxPeers = 1000
+
+	// gossipTimeout is the timeout of a single gossip exchange.
+	gossipTimeout = 30 * time.Second
+)
+
+// GossipPeer is a peer a seeder verified as good
+type GossipPeer struct {
+	Address      string
+	SubnetworkID []byte
+	// LastSuccessMillis is the time, in unix milliseconds, the peer was
+	// last reached successfully.
+	LastSuccessMillis int64
+}
+
+// GetGoodPeersRequest asks for the good peers of a network verified after
+// SinceMillis, in unix milliseconds, or verified within that millisecond
+// with an address after SinceAddress
+type GetGoodPeersRequest struct {
+	Network      string
+	SinceMillis  int64
+	SinceAddress string
+
+	// Site and Instance are the labels of the requesting seeder, if set.
+	Site     string
+	Instance string
+}
+
+// GetGoodPeersResponse is the response to GetGoodPeersRequest. The next
+// request should ask for the peers after NextSinceMillis and
+// NextSinceAddress.
+type GetGoodPeersResponse struct {
+	Peers            []GossipPeer
+	NextSinceMillis  int64
+	NextSinceAddress string
+
+	// Site and Instance are the labels of the responding seeder, if set.
+	Site     string
+	Instance string
+}
+
+// GossipServer is the server API of the gossip service
+type GossipServer interface {
+	GetGoodPeers(context.Context, *GetGoodPeersRequest) (*GetGoodPeersResponse, error)
+}
+
+var gossipServiceDesc = grpc.ServiceDesc{
+	ServiceName: gossipServiceName,
+	HandlerType: (*GossipServer)(nil),
+	Methods: []grpc.MethodDesc{
+		jsonMethod(gossipServiceName, "GetGoodPeers", func() interface{} { return &GetGoodPeersRequest{} },
+			func(srv interface{}, ctx context.Context, r interface{}) (interface{}, error) {
+				return srv.(GossipServer).GetGoodPeers(ctx, r.(*GetGoodPeersRequest))
+			}),
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "gossip.go",
+}
+
+type gossipServer struct {
+	amgr *Manager
+}
+
+func (s *gossipServer) GetGoodPeers(_ context.Context, req *GetGoodPeersRequest) (*GetGoodPeersResponse, error) {
+	amgr := networkManager(req.Network, s.amgr)
+	if amgr == nil {
+		return nil, status.Errorf(codes.NotFound, "network %s is not served", req.Network)
+	}
+
+	if identity := (siteLabels{site: req.Site, instance: req.Instance}).identity(); identity != "" {
+		rpcLog.Debugf("Sending the good %s peers to %s", req.Network, identity)
+	}
+	nodes, next := amgr.VerifiedSince(verifiedCursor{millis: req.SinceMillis, ip: req.SinceAddress}, gossipMaxPeers)
+	labels := activeSiteLabels()
+	response := &GetGoodPeersResponse{
+		Peers:            make([]GossipPeer, len(nodes)),
+		NextSinceMillis:  next.millis,
+		NextSinceAddress: next.ip,
+		Site:             labels.site,
+		Instance:         labels.instance,
+	}
+	for i, node := range nodes {
+		peer := GossipPeer{
+			Address:           net.JoinHostPort(node.Addr.IP.String(), strconv.Itoa(int(node.Addr.Port))),
+			LastSuccessMillis: node.LastSuccess.UnixMilli(),
+		}
+		if node.SubnetworkID != nil {
+			peer.SubnetworkID = node.SubnetworkID[:]
+		}
+		response.Peers[i] = peer
+	}
+	return response, nil
+}
+
+// networkManager returns the manager of the named network, or nil if it is
+// not served. When no networks are set up, amgr serves the active network.
+func networkManager(name string, amgr *Manager) *Manager {
+	networks := servedNetworks()
+	if len(networks) == 0 {
+		if ActiveConfig() != nil && ActiveConfig().NetParams().Name == name {
+			return amgr
+		}
+		return nil
+	}
+	for _, network := range networks {
+		if network.name() == name {
+			return network.amgr
+		}
+	}
+	return nil
+}
+
+// GossipClient calls the gossip service of a cooperating seeder
+type GossipClient struct {
+	*jsonClient
+}
+
+// NewGossipClient connects to the gRPC server at the given address, using
+// the given gossip token
+func NewGossipClient(address, token string) (*GossipClient, error) {
+	client, err := newJSONClient(address, gossipServiceName, token)
+	if err != nil {
+		return nil, err
+	}
+	return &GossipClient{client}, nil
+}
+
+// GetGoodPeers asks for the good peers verified since the given time
+func (c *GossipClient) GetGoodPeers(ctx context.Context, req *GetGoodPeersRequest) (*GetGoodPeersResponse, error) {
+	response := &GetGoodPeersResponse{}
+	return response, c.invoke(ctx, "GetGoodPeers", req, response)
+}
+
+// gossipWith periodically fetches the peers the seeder at address verified
+// since the previous exchange, and merges them into the network's peer
+// pool, until quit is closed.
+func gossipWith(network *seederNetwork, address, token string, interval time.Duration, quit <-chan struct{}) {
+	client, err := NewGossipClient(address, token)
+	if err != nil {
+		rpcLog.Errorf("Failed to connect to gossip peer %s: %v", address, err)
+		return
+	}
+	defer client.Close()
+
+	var since verifiedCursor
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		since, err = gossipOnce(client, network, since)
+		if err != nil {
+			rpcLog.Warnf("Gossip with %s for %s failed: %v", address, network.name(), err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-quit:
+			return
+		}
+	}
+}
+
+// gossipOnce fetches and merges the peers verified after since, following
+// up while the responses are truncated, and returns the position to ask
+// from next.
+func gossipOnce(client *GossipClient, network *seederNetwork, since verifiedCursor) (verifiedCursor, error) {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), gossipTimeout)
+		labels := activeSiteLabels()
+		response, err := client.GetGoodPeers(ctx, &GetGoodPeersRequest{
+			Network:      network.name(),
+			SinceMillis:  since.millis,
+			SinceAddress: since.ip,
+			Site:         labels.site,
+			Instance:     labels.instance,
+		})
+		cancel()
+		if err != nil {
+			return since, err
+		}
+
+		var merged int
+		for _, peer := range response.Peers {
+			ok, err := mergeGossipPeer(network, peer)
+			if err != nil {
+				rpcLog.Debugf("Ignoring gossiped peer %s: %v", peer.Address, err)
+				continue
+			}
+			if ok {
+				merged++
+			}
+		}
+		source := (siteLabels{site: response.Site, instance: response.Instance}).identity()
+		if source == "" {
+			source = "an unlabeled seeder"
+		}
+		rpcLog.Debugf("Merged %d of %d gossiped %s peers from %s", merged, len(response.Peers), network.name(),
+			source)
+
+		next := verifiedCursor{millis: response.NextSinceMillis, ip: response.NextSinceAddress}
+		if len(response.Peers) < gossipMaxPeers {
+			return next, nil
+		}
+		if !since.before(next) {
+			// A seeder not moving the cursor forward would be asked for
+			// the same page forever.
+			return since, errors.Errorf("the gossip cursor did not advance past %d/%s", since.millis, since.ip)
+		}
+		since = next
+	}
+}
+
+// mergeGossipPeer merges a single gossiped peer into the network's peer
+// pool, and returns whether it changed anything.
+func mergeGossipPeer(network *seederNetwork, peer GossipPeer) (bool, error) {
+	addr, err := parsePeerAddress(peer.Address, network.defaultPort)
+	if err != nil {
+		return false, err
+	}
+	subnetworkID, err := FromProtobufSubnetworkID(peer.SubnetworkID)
+	if err != nil {
+		return false, errors.Wrap(err, "invalid subnetwork ID")
+	}
+	return network.amgr.MergeVerified(addr, subnetworkID, time.UnixMilli(peer.LastSuccessMillis)), nil
+}
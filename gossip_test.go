@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/karlsen-network/karlsend/infrastructure/config"
+)
+
+func TestGossip(t *testing.T) {
+	cfg := setTestConfig(t, &ConfigFlags{NetworkFlags: config.NetworkFlags{Devnet: true}})
+	err := cfg.ResolveNetwork(nil)
+	if err != nil {
+		t.Fatalf("ResolveNetwork: %s", err)
+	}
+	params := cfg.NetParams()
+
+	now := time.Now()
+	remote := newTestManager(t, params, 0)
+	for i, ip := range []string{"203.105.20.21", "203.105.20.22"} {
+		remote.nodes[ip] = &Node{
+			Addr:        appmessage.NewNetAddressIPPort(net.ParseIP(ip), 1313),
+			LastSuccess: now.Add(-time.Duration(i) * time.Minute),
+		}
+	}
+	remote.nodes["198.51.100.1"] = &Node{Addr: appmessage.NewNetAddressIPPort(net.ParseIP("198.51.100.1"), 1313)}
+
+	host := "localhost:3739"
+	grpcServer := NewGRPCServer(remote, "", "secret", "")
+	err = grpcServer.Start(host)
+	if err != nil {
+		t.Fatalf("Failed to start gRPC server: %s", err)
+	}
+	defer grpcServer.Stop()
+
+	local := &seederNetwork{
+		flags:       cfg.NetworkFlags,
+		amgr:        newTestManager(t, params, 0),
+		defaultPort: 1313,
+	}
+
+	unauthenticated, err := NewGossipClient(host, "wrong")
+	if err != nil {
+		t.Fatalf("NewGossipClient: %s", err)
+	}
+	defer unauthenticated.Close()
+	_, err = gossipOnce(unauthenticated, local, verifiedCursor{})
+	if err == nil {
+		t.Fatalf("expected gossip with a wrong token to fail")
+	}
+
+	client, err := NewGossipClient(host, "secret")
+	if err != nil {
+		t.Fatalf("NewGossipClient: %s", err)
+	}
+	defer client.Close()
+	since, err := gossipOnce(client, local, verifiedCursor{})
+	if err != nil {
+		t.Fatalf("gossipOnce: %s", err)
+	}
+	if len(local.amgr.nodes) != 2 {
+		t.Fatalf("expected the 2 good peers to be merged, got %d", len(local.amgr.nodes))
+	}
+	node, ok := local.amgr.Node(net.ParseIP("203.105.20.22"))
+	if !ok || !node.LastSuccess.Equal(now.Add(-time.Minute).Truncate(time.Millisecond)) {
+		t.Errorf("unexpected merged node: %+v", node)
+	}
+
+	// Only peers verified since the previous exchange are sent again.
+	remote.nodes["203.105.20.22"].LastSuccess = time.Now()
+	peers, _ := remote.VerifiedSince(since, gossipMaxPeers)
+	if len(peers) != 1 || peers[0].Addr.IP.String() != "203.105.20.22" {
+		t.Errorf("unexpected delta: %+v", peers)
+	}
+
+	// Peers reached within the same millisecond are paged through by
+	// address, however many there are.
+	tied := time.Now()
+	for i := 0; i < gossipMaxPeers*2+10; i++ {
+		ip := net.IPv4(203, 106, byte(i/256), byte(i%256))
+		remote.nodes[ip.String()] = &Node{
+			Addr:        appmessage.NewNetAddressIPPort(ip, 1313),
+			LastSuccess: tied,
+		}
+	}
+	_, err = gossipOnce(client, local, since)
+	if err != nil {
+		t.Fatalf("gossipOnce: %s", err)
+	}
+	if len(local.amgr.nodes) != 2+gossipMaxPeers*2+10 {
+		t.Errorf("expected every tied peer to be merged, got %d nodes", len(local.amgr.nodes))
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/karlsen-network/karlsend/app/appmessage"
+)
+
+const (
+	// peerGraphIDSize is the number of bytes of the hash of an address kept
+	// as its anonymized ID.
+	peerGraphIDSize = 16
+
+	// peerGraphMaxTargets is the maximum number of addresses kept from the
+	// advertisement of a peer, that of a single addr message of the
+	// protocol.
+	peerGraphMaxTargets = 1000
+
+	// peerGraphMaxEdges is the maximum number of edges of the graph.
+	// Advertisements of new peers are dropped while it is full.
+	peerGraphMaxEdges = 1000000
+
+	// peerGraphCacheTTL is how long an export of the graph is served again
+	// before it is rebuilt, so frequent requests don't sort the whole
+	// graph each time.
+	peerGraphCacheTTL = time.Minute
+)
+
+// peerAdvertisement is the set of addresses a peer sent the last time it
+// was asked for them.
+type peerAdvertisement struct {
+	time    time.Time
+	targets []net.IP
+}
+
+// peerGraph records which peers advertised which addresses, as the edges of
+// the gossip topology of a network. Only the last advertisement of each
+// peer is kept, and those of peers the garbage collector removed are
+// dropped.
+type peerGraph struct {
+	// salt is mixed with the addresses of anonymized exports, so their IDs
+	// stay the same until the seeder restarts but can't be matched with
+	// the addresses.
+	salt []byte
+
+	mtx        sync.RWMutex
+	advertised map[string]*peerAdvertisement
+	edgeCount  int
+
+	// exports caches the last export of the graph, by whether it is
+	// anonymized.
+	exportsMtx sync.Mutex
+	exports    map[bool]*peerGraphExport
+}
+
+// peerGraphExport is an export of the graph, built at time.
+type peerGraphExport struct {
+	time  time.Time
+	edges []peerGraphEdge
+}
+
+// peerGraphEdge is an edge of the exported peer graph: source advertised
+// target, last at Seen.
+type peerGraphEdge struct {
+	Source string    `json:"source"`
+	Target string    `json:"target"`
+	Seen   time.Time `json:"seen"`
+}
+
+// peerGraphResponse is the JSON export of the peer graph of a network.
+type peerGraphResponse struct {
+	Network    string          `json:"network"`
+	Generated  time.Time       `json:"generated"`
+	Anonymized bool            `json:"anonymized"`
+	Edges      []peerGraphEdge `json:"edges"`
+}
+
+// newPeerGraph returns an empty peer graph.
+func newPeerGraph() (*peerGraph, error) {
+	salt := make([]byte, sha256.Size)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+	return &peerGraph{
+		salt:       salt,
+		advertised: make(map[string]*peerAdvertisement),
+		exports:    make(map[bool]*peerGraphExport),
+	}, nil
+}
+
+// record replaces the addresses source advertised with addrs, received at
+// now. Only the first peerGraphMaxTargets distinct addresses are kept, and
+// the advertisement is dropped if it would take the graph over
+// peerGraphMaxEdges.
+func (g *peerGraph) record(source net.IP, addrs []*appmessage.NetAddress, now time.Time) {
+	seen := make(map[string]bool, len(addrs))
+	targets := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		if len(targets) == peerGraphMaxTargets {
+			break
+		}
+		if seen[addr.IP.String()] {
+			continue
+		}
+		seen[addr.IP.String()] = true
+		targets = append(targets, addr.IP)
+	}
+
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	edgeCount := g.edgeCount + len(targets)
+	if previous, exists := g.advertised[source.String()]; exists {
+		edgeCount -= len(previous.targets)
+	}
+	if edgeCount > peerGraphMaxEdges {
+		crawlLog.Debugf("Peer graph full, dropped the advertisement of %s", source)
+		return
+	}
+	g.advertised[source.String()] = &peerAdvertisement{time: now, targets: targets}
+	g.edgeCount = edgeCount
+}
+
+// prune drops the advertisements of the peers that are no longer in nodes.
+func (g *peerGraph) prune(nodes map[string]*Node) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	for source, advertisement := range g.advertised {
+		if _, exists := nodes[source]; !exists {
+			g.edgeCount -= len(advertisement.targets)
+			delete(g.advertised, source)
+		}
+	}
+}
+
+// id returns how the peer with the given IP is named in an export,
+// anonymized or not.
+func (g *peerGraph) id(ip net.IP, anonymize bool) string {
+	if !anonymize {
+		return ip.String()
+	}
+	hash := sha256.Sum256(append(append([]byte{}, g.salt...), ip.To16()...))
+	return hex.EncodeToString(hash[:peerGraphIDSize])
+}
+
+// export returns the edges of the graph as of now, sorted by source and
+// target, reusing the export built less than peerGraphCacheTTL earlier if
+// any. The returned edges must not be modified.
+func (g *peerGraph) export(anonymize bool, now time.Time) []peerGraphEdge {
+	g.exportsMtx.Lock()
+	defer g.exportsMtx.Unlock()
+	if cached, ok := g.exports[anonymize]; ok && now.Sub(cached.time) < peerGraphCacheTTL {
+		return cached.edges
+	}
+	edges := g.edges(anonymize)
+	g.exports[anonymize] = &peerGraphExport{time: now, edges: edges}
+	return edges
+}
+
+// edges returns the edges of the graph, sorted by source and target.
+func (g *peerGraph) edges(anonymize bool) []peerGraphEdge {
+	g.mtx.RLock()
+	edges := make([]peerGraphEdge, 0, g.edgeCount)
+	for source, advertisement := range g.advertised {
+		sourceID := g.id(net.ParseIP(source), anonymize)
+		for _, target := range advertisement.targets {
+			edges = append(edges, peerGraphEdge{
+				Source: sourceID,
+				Target: g.id(target, anonymize),
+				Seen:   advertisement.time,
+			})
+		}
+	}
+	g.mtx.RUnlock()
+
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].Source != edges[j].Source {
+			return edges[i].Source < edges[j].Source
+		}
+		return edges[i].Target < edges[j].Target
+	})
+	return edges
+}
+
+// graphML is the GraphML document of an exported peer graph.
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLNode struct {
+	ID string `xml:"id,attr"`
+}
+
+type graphMLEdge struct {
+	Source string      `xml:"source,attr"`
+	Target string      `xml:"target,attr"`
+	Data   graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// newGraphML returns the GraphML document of the edges of the peer graph of
+// network, with every peer they involve as a node.
+func newGraphML(network string, edges []peerGraphEdge) *graphML {
+	doc := &graphML{
+		XMLNS: "http://graphml.graphdrawing.org/xmlns",
+		Keys:  []graphMLKey{{ID: "seen", For: "edge", Name: "seen", Type: "string"}},
+		Graph: graphMLGraph{ID: network, EdgeDefault: "directed"},
+	}
+	nodes := make(map[string]bool)
+	for _, edge := range edges {
+		for _, id := range []string{edge.Source, edge.Target} {
+			if !nodes[id] {
+				nodes[id] = true
+				doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{ID: id})
+			}
+		}
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{
+			Source: edge.Source,
+			Target: edge.Target,
+			Data:   graphMLData{Key: "seen", Value: edge.Seen.UTC().Format(time.RFC3339)},
+		})
+	}
+	return doc
+}
+
+// handleGraph serves the peer graph of a network: which peer advertised
+// which addresses when it was last crawled. Supported query parameters are
+// network (the primary one by default), format (json or graphml) and
+// anonymize (true or false), which replaces the addresses with salted
+// hashes. Exports are always anonymized when the seeder is configured so,
+// and are rebuilt at most every peerGraphCacheTTL.
+func (s *HTTPServer) handleGraph(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	amgr := s.amgr
+	if network := query.Get("network"); network != "" {
+		amgr = networkManager(network, s.amgr)
+		if amgr == nil {
+			writeError(w, http.StatusNotFound, "network "+network+" is not served")
+			return
+		}
+	}
+	graph := amgr.PeerGraph()
+	if graph == nil {
+		writeError(w, http.StatusNotFound, "the peer graph is disabled")
+		return
+	}
+	format := query.Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "graphml" {
+		writeError(w, http.StatusBadRequest, "invalid format")
+		return
+	}
+	anonymize := false
+	if value := query.Get("anonymize"); value != "" {
+		var err error
+		anonymize, err = strconv.ParseBool(value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid anonymize")
+			return
+		}
+	}
+	if cfg := ActiveConfig(); cfg != nil && cfg.PeerGraphAnonymize {
+		anonymize = true
+	}
+
+	edges := graph.export(anonymize, time.Now())
+	if format == "json" {
+		writeJSON(w, http.StatusOK, peerGraphResponse{
+			Network:    amgr.netParams.Name,
+			Generated:  time.Now(),
+			Anonymized: anonymize,
+			Edges:      edges,
+		})
+		return
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	encoder := xml.NewEncoder(&buf)
+	encoder.Indent("", "  ")
+	err := encoder.Encode(newGraphML(amgr.netParams.Name, edges))
+	if err != nil {
+		rpcLog.Errorf("Failed to encode the peer graph: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to encode the peer graph")
+		return
+	}
+	w.Header().Set("Content-Type", "application/graphml+xml")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(buf.Bytes())
+	if err != nil {
+		rpcLog.Warnf("Failed to write HTTP response: %v", err)
+	}
+}
+
+// RecordAdvertised records the addresses the peer with the given IP sent
+// when asked for them, if the peer graph of the network is enabled.
+func (m *Manager) RecordAdvertised(ip net.IP, addrs []*appmessage.NetAddress) {
+	if graph := m.PeerGraph(); graph != nil {
+		graph.record(ip, addrs, m.now())
+	}
+}
+
+// SetPeerGraph sets the peer graph of the network.
+func (m *Manager) SetPeerGraph(graph *peerGraph) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.graph = graph
+}
+
+// PeerGraph returns the peer graph of the network, or nil if it is disabled.
+func (m *Manager) PeerGraph() *peerGraph {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	return m.graph
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/karlsen-network/karlsend/domain/dagconfig"
+)
+
+func TestPeerGraph(t *testing.T) {
+	now := time.Now()
+	m := newTestManager(t, &dagconfig.MainnetParams, 0)
+	for _, ip := range []string{"1.0.0.1", "1.0.0.2"} {
+		m.nodes[ip] = &Node{Addr: appmessage.NewNetAddressIPPort(net.ParseIP(ip), 1313), LastSeen: now}
+	}
+	graph, err := newPeerGraph()
+	if err != nil {
+		t.Fatalf("newPeerGraph: %s", err)
+	}
+	m.SetPeerGraph(graph)
+
+	addrs := func(ips ...string) []*appmessage.NetAddress {
+		var addrs []*appmessage.NetAddress
+		for _, ip := range ips {
+			addrs = append(addrs, appmessage.NewNetAddressIPPort(net.ParseIP(ip), 1313))
+		}
+		return addrs
+	}
+	m.RecordAdvertised(net.ParseIP("1.0.0.1"), addrs("1.0.0.2", "1.0.0.3"))
+	// Only the last advertisement of each peer is kept, without duplicates.
+	m.RecordAdvertised(net.ParseIP("1.0.0.2"), addrs("1.0.0.4"))
+	m.RecordAdvertised(net.ParseIP("1.0.0.2"), addrs("1.0.0.1", "1.0.0.1"))
+	m.RecordAdvertised(net.ParseIP("1.0.0.5"), addrs("1.0.0.1"))
+
+	// The advertisements of peers no longer known are dropped.
+	m.collectGarbage(now, time.Hour, time.Hour, time.Hour)
+
+	server := NewHTTPServer(m)
+	get := func(url string, expectedStatus int) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		server.mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, url, nil))
+		if recorder.Code != expectedStatus {
+			t.Fatalf("%s: expected status %d, got %d", url, expectedStatus, recorder.Code)
+		}
+		return recorder
+	}
+
+	var response peerGraphResponse
+	err = json.Unmarshal(get("/v1/graph", http.StatusOK).Body.Bytes(), &response)
+	if err != nil {
+		t.Fatalf("Unmarshal: %s", err)
+	}
+	expected := []peerGraphEdge{
+		{Source: "1.0.0.1", Target: "1.0.0.2"},
+		{Source: "1.0.0.1", Target: "1.0.0.3"},
+		{Source: "1.0.0.2", Target: "1.0.0.1"},
+	}
+	if response.Network != "karlsen-mainnet" || response.Anonymized || len(response.Edges) != len(expected) {
+		t.Fatalf("unexpected peer graph %+v", response)
+	}
+	for i, edge := range response.Edges {
+		if edge.Source != expected[i].Source || edge.Target != expected[i].Target || edge.Seen.IsZero() {
+			t.Errorf("expected edge %d to be %+v, got %+v", i, expected[i], edge)
+		}
+	}
+
+	// Anonymized exports keep the topology, but not the addresses.
+	response = peerGraphResponse{}
+	err = json.Unmarshal(get("/v1/graph?anonymize=true", http.StatusOK).Body.Bytes(), &response)
+	if err != nil {
+		t.Fatalf("Unmarshal: %s", err)
+	}
+	if !response.Anonymized || len(response.Edges) != len(expected) {
+		t.Fatalf("unexpected anonymized peer graph %+v", response)
+	}
+	for i, edge := range response.Edges {
+		if net.ParseIP(edge.Source) != nil || net.ParseIP(edge.Target) != nil || len(edge.Source) != 32 {
+			t.Errorf("expected the addresses to be anonymized, got %+v", edge)
+		}
+		// The IDs are sorted, so they can't be matched with the edges by
+		// position.
+		found := false
+		for _, e := range expected {
+			found = found || (edge.Source == graph.id(net.ParseIP(e.Source), true) &&
+				edge.Target == graph.id(net.ParseIP(e.Target), true))
+		}
+		if !found {
+			t.Errorf("unexpected anonymized edge %d %+v", i, edge)
+		}
+	}
+
+	var doc graphML
+	recorder := get("/v1/graph?format=graphml", http.StatusOK)
+	err = xml.Unmarshal(recorder.Body.Bytes(), &doc)
+	if err != nil {
+		t.Fatalf("xml.Unmarshal: %s", err)
+	}
+	if len(doc.Graph.Nodes) != 3 || len(doc.Graph.Edges) != 3 || doc.Graph.EdgeDefault != "directed" {
+		t.Errorf("unexpected GraphML document %+v", doc)
+	}
+
+	get("/v1/graph?format=csv", http.StatusBadRequest)
+	get("/v1/graph?network=karlsen-testnet", http.StatusNotFound)
+	m.SetPeerGraph(nil)
+	get("/v1/graph", http.StatusNotFound)
+}
+
+func TestPeerGraphLimits(t *testing.T) {
+	graph, err := newPeerGraph()
+	if err != nil {
+		t.Fatalf("newPeerGraph: %s", err)
+	}
+	addrs := make([]*appmessage.NetAddress, peerGraphMaxTargets+10)
+	for i := range addrs {
+		addrs[i] = appmessage.NewNetAddressIPPort(net.IPv4(10, 0, byte(i>>8), byte(i)), 1313)
+	}
+	now := time.Now()
+
+	// Only the first addresses of a large advertisement are kept.
+	graph.record(net.ParseIP("1.0.0.1"), addrs, now)
+	if graph.edgeCount != peerGraphMaxTargets || len(graph.edges(false)) != peerGraphMaxTargets {
+		t.Fatalf("expected %d edges, got %d", peerGraphMaxTargets, graph.edgeCount)
+	}
+
+	// Exports are cached until peerGraphCacheTTL has passed.
+	if len(graph.export(false, now)) != peerGraphMaxTargets {
+		t.Fatalf("expected the export to hold every edge")
+	}
+	graph.record(net.ParseIP("1.0.0.1"), addrs[:1], now)
+	if len(graph.export(false, now.Add(time.Second))) != peerGraphMaxTargets {
+		t.Errorf("expected the cached export to be served")
+	}
+	if len(graph.export(false, now.Add(peerGraphCacheTTL))) != 1 {
+		t.Errorf("expected the export to be rebuilt after %s", peerGraphCacheTTL)
+	}
+
+	// A full graph drops the advertisements of new peers, but still
+	// replaces those of known ones.
+	graph.edgeCount = peerGraphMaxEdges
+	graph.record(net.ParseIP("1.0.0.2"), addrs[:1], now)
+	if _, exists := graph.advertised["1.0.0.2"]; exists {
+		t.Errorf("expected the advertisement of a new peer to be dropped from a full graph")
+	}
+	graph.record(net.ParseIP("1.0.0.1"), addrs[:1], now)
+	if graph.advertised["1.0.0.1"].time != now || graph.edgeCount != peerGraphMaxEdges {
+		t.Errorf("expected the advertisement of a known peer to be replaced, got %d edges", graph.edgeCount)
+	}
+}
@@ -24,17 +24,40 @@ type GRPCServer interface {
 type grpcServer struct {
 	pb.UnimplementedPeerServiceServer
 
-	server *grpc.Server
-	amgr   *Manager
+	server        *grpc.Server
+	amgr          *Manager
+	adminToken    string
+	gossipToken   string
+	peerFeedToken string
 }
 
-// NewGRPCServer returns new GRPC server
-func NewGRPCServer(amgr *Manager) GRPCServer {
-	return &grpcServer{amgr: amgr}
+// NewGRPCServer returns new GRPC server. The admin and gossip services are
+// only served when their respective tokens are not empty. The peer feed
+// service is always served, and requires its token when it is not empty.
+func NewGRPCServer(amgr *Manager, adminToken, gossipToken, peerFeedToken string) GRPCServer {
+	return &grpcServer{amgr: amgr, adminToken: adminToken, gossipToken: gossipToken, peerFeedToken: peerFeedToken}
 }
 
 func (s *grpcServer) Start(listenInterface string) error {
-	s.server = grpc.NewServer()
+	tokens := make(map[string]string)
+	if s.adminToken != "" {
+		tokens[adminServiceName] = s.adminToken
+	}
+	if s.gossipToken != "" {
+		tokens[gossipServiceName] = s.gossipToken
+	}
+	if s.peerFeedToken != "" {
+		tokens[peerFeedServiceName] = s.peerFeedToken
+	}
+	s.server = grpc.NewServer(grpc.UnaryInterceptor(tokenAuthInterceptor(tokens)),
+		grpc.StreamInterceptor(tokenAuthStreamInterceptor(tokens)))
+	if s.adminToken != "" {
+		s.server.RegisterService(&adminServiceDesc, &adminServer{amgr: s.amgr})
+	}
+	if s.gossipToken != "" {
+		s.server.RegisterService(&gossipServiceDesc, &gossipServer{amgr: s.amgr})
+	}
+	s.server.RegisterService(&peerFeedServiceDesc, &peerFeedServer{amgr: s.amgr, hub: peerFeed})
 	pb.RegisterPeerServiceServer(s.server, s)
 
 	lis, err := net.Listen("tcp", fmt.Sprintf(listenInterface))
@@ -42,7 +65,9 @@ func (s *grpcServer) Start(listenInterface string) error {
 		return errors.WithStack(err)
 	}
 
+	listeners.setGRPCListening(true)
 	spawn("gRPC server", func() {
+		defer listeners.setGRPCListening(false)
 		err = s.server.Serve(lis)
 		if err != nil {
 			fmt.Printf("%+v", err)
@@ -65,11 +90,11 @@ func (s *grpcServer) GetPeersList(ctx context.Context, req *pb.GetPeersListReque
 	}
 
 	// mb, we should move DNS-related logic out of manager?
-	ipv4Addresses := s.amgr.GoodAddresses(dns.TypeA, req.IncludeAllSubnetworks, subnetworkID)
-	ipv6Addresses := s.amgr.GoodAddresses(dns.TypeAAAA, req.IncludeAllSubnetworks, subnetworkID)
+	ipv4Addresses := s.amgr.GoodAddresses(dns.TypeA, req.IncludeAllSubnetworks, subnetworkID, false, 0, nil, defaultMaxAddresses)
+	ipv6Addresses := s.amgr.GoodAddresses(dns.TypeAAAA, req.IncludeAllSubnetworks, subnetworkID, false, 0, nil, defaultMaxAddresses)
 
 	addresses := ToProtobufAddresses(append(ipv4Addresses, ipv6Addresses...))
-	log.Errorf("ADDRESSES: %+v", addresses)
+	rpcLog.Errorf("ADDRESSES: %+v", addresses)
 
 	return &pb.GetPeersListResponse{Addresses: addresses}, nil
 }
@@ -16,11 +16,11 @@ import (
 )
 
 func TestGetPeers(t *testing.T) {
-	activeConfig = &ConfigFlags{
+	cfg := setTestConfig(t, &ConfigFlags{
 		NetworkFlags: config.NetworkFlags{Devnet: true},
-	}
+	})
 
-	err := activeConfig.NetworkFlags.ResolveNetwork(nil)
+	err := cfg.NetworkFlags.ResolveNetwork(nil)
 	if err != nil {
 		t.Fatalf("ResolveNetwork: %s", err)
 	}
@@ -39,7 +39,7 @@ func TestGetPeers(t *testing.T) {
 	amgr.Good(ip, nil)
 
 	host := "localhost:3737"
-	grpcServer := NewGRPCServer(amgr)
+	grpcServer := NewGRPCServer(amgr, "", "", "")
 	err = grpcServer.Start(host)
 
 	if err != nil {
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/karlsen-network/karlsend/app/protocol/common"
+	"github.com/karlsen-network/karlsend/infrastructure/config"
+	"github.com/karlsen-network/karlsend/infrastructure/network/addressmanager"
+)
+
+const (
+	// maxConcurrentHarvests is the maximum number of inbound connections
+	// handled at once. Connections beyond it are dropped.
+	maxConcurrentHarvests = 64
+
+	// harvestRecrawlInterval is the minimum interval between crawls of the
+	// same inbound peer forced by its connections, so a peer reconnecting
+	// in a loop doesn't keep the crawler busy.
+	harvestRecrawlInterval = 10 * time.Minute
+)
+
+// startHarvester accepts inbound P2P connections for network on listen.
+// Nodes that dial the seeder are prime candidates for the good pool, so
+// each one is queued to be crawled, along with the addresses it knows,
+// until quit is closed.
+func startHarvester(network *seederNetwork, listen string, quit <-chan struct{}) error {
+	flags := &config.Flags{NetworkFlags: network.flags, Listeners: []string{listen}}
+	harvester, err := newCrawlAdapter(&config.Config{Flags: flags}, network.protocolVersion)
+	if err != nil {
+		return err
+	}
+
+	slots := make(chan struct{}, maxConcurrentHarvests)
+	spawn("harvester-acceptConnections", func() {
+		defer harvester.netAdapter.Stop()
+		for {
+			select {
+			case routes := <-harvester.routesChan:
+				select {
+				case slots <- struct{}{}:
+				default:
+					crawlLog.Debugf("Dropped inbound connection from %s, too many are being handled",
+						routes.netConnection.NetAddress().IP)
+					routes.Disconnect()
+					continue
+				}
+				spawn("harvester-harvest", func() {
+					defer func() { <-slots }()
+					harvester.harvest(network, routes)
+				})
+			case <-quit:
+				return
+			}
+		}
+	})
+	return nil
+}
+
+// harvest performs the handshake with an inbound peer, and queues the
+// address it is reachable at to be crawled, unless it was crawled recently,
+// along with the addresses it knows.
+func (ca *crawlAdapter) harvest(network *seederNetwork, routes *peerRoutes) {
+	defer routes.Disconnect()
+	atomic.AddUint64(&stats.inboundConnections, 1)
+
+	amgr := network.amgr
+	remoteAddress := routes.netConnection.NetAddress()
+	if amgr.bans.IsBanned(remoteAddress.IP) {
+		crawlLog.Debugf("Rejected inbound connection from banned %s", remoteAddress.IP)
+		return
+	}
+	if !isAllowed(remoteAddress.IP) {
+		crawlLog.Debugf("Rejected inbound connection from %s, outside of the allowed networks", remoteAddress.IP)
+		return
+	}
+
+	err := ca.handleHandshake(routes)
+	if err != nil {
+		crawlLog.Debugf("Error in handshake with inbound %s: %v", remoteAddress.IP, err)
+		return
+	}
+
+	addr := harvestAddress(routes.version.Address, remoteAddress.IP, uint16(network.defaultPort),
+		amgr.netParams.AcceptUnroutable)
+	added := amgr.AddAddresses([]*appmessage.NetAddress{addr})
+	if !harvestDue(amgr, addr, time.Now()) {
+		crawlLog.Debugf("Not crawling inbound %s again, it was crawled recently", addr.IP)
+		return
+	}
+	err = amgr.ForceCrawl(addr)
+	if err != nil {
+		crawlLog.Debugf("Not crawling inbound %s: %v", addr.IP, err)
+		return
+	}
+	atomic.AddUint64(&stats.inboundHarvested, 1)
+
+	msgAddresses, err := routes.RequestAddresses(common.DefaultTimeout)
+	if err == nil {
+		added += amgr.AddAddresses(msgAddresses.AddressList)
+	}
+	crawlLog.Infof("Inbound peer %s (%s) queued to be crawled, %d new addresses",
+		net.JoinHostPort(addr.IP.String(), strconv.Itoa(int(addr.Port))), routes.version.UserAgent, added)
+}
+
+// harvestAddress returns the address an inbound peer connected from
+// remoteIP is crawled at: the one it advertises if it is routable and has
+// the same IP, since a peer could otherwise aim the crawler at any host, or
+// else remoteIP on the default port, since the peer connected from an
+// ephemeral one.
+func harvestAddress(advertised *appmessage.NetAddress, remoteIP net.IP, defaultPort uint16,
+	acceptUnroutable bool) *appmessage.NetAddress {
+
+	if advertised != nil && advertised.IP.Equal(remoteIP) && addressmanager.IsRoutable(advertised, acceptUnroutable) {
+		return advertised
+	}
+	return appmessage.NewNetAddressIPPort(remoteIP, defaultPort)
+}
+
+// harvestDue returns whether an inbound peer at addr should be crawled,
+// which it isn't if it was attempted within harvestRecrawlInterval.
+func harvestDue(amgr *Manager, addr *appmessage.NetAddress, now time.Time) bool {
+	node, ok := amgr.Node(addr.IP)
+	return !ok || now.Sub(node.LastAttempt) >= harvestRecrawlInterval
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/karlsen-network/karlsend/domain/dagconfig"
+)
+
+func TestHarvestAddress(t *testing.T) {
+	remote := net.ParseIP("93.184.216.5")
+	tests := []struct {
+		name       string
+		advertised *appmessage.NetAddress
+		expected   string
+	}{
+		{"none advertised", nil, "93.184.216.5:1313"},
+		{"own address", appmessage.NewNetAddressIPPort(net.ParseIP("93.184.216.5"), 4242), "93.184.216.5:4242"},
+		{"another host", appmessage.NewNetAddressIPPort(net.ParseIP("93.184.216.7"), 4242), "93.184.216.5:1313"},
+		{"unroutable", appmessage.NewNetAddressIPPort(net.ParseIP("10.0.0.1"), 4242), "93.184.216.5:1313"},
+	}
+	for _, test := range tests {
+		addr := harvestAddress(test.advertised, remote, 1313, false)
+		if actual := net.JoinHostPort(addr.IP.String(), strconv.Itoa(int(addr.Port))); actual != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestHarvestDue(t *testing.T) {
+	m := newTestManager(t, &dagconfig.MainnetParams, 0)
+	addr := appmessage.NewNetAddressIPPort(net.ParseIP("203.0.113.5"), 1313)
+	now := time.Now()
+	if !harvestDue(m, addr, now) {
+		t.Errorf("expected an unknown peer to be crawled")
+	}
+
+	// Connecting again doesn't queue the peer twice.
+	for i := 0; i < 3; i++ {
+		err := m.ForceCrawl(addr)
+		if err != nil {
+			t.Fatalf("ForceCrawl: %s", err)
+		}
+	}
+	if len(m.crawlQueue) != 1 {
+		t.Errorf("expected the peer to be queued once, got %d", len(m.crawlQueue))
+	}
+
+	m.nodes["203.0.113.5"].LastAttempt = now.Add(-time.Minute)
+	if harvestDue(m, addr, now) {
+		t.Errorf("expected a peer crawled a minute ago not to be crawled again")
+	}
+	if !harvestDue(m, addr, now.Add(harvestRecrawlInterval)) {
+		t.Errorf("expected the peer to be crawled again after the recrawl interval")
+	}
+}
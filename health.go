@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// livenessTimeout is how long the liveness probe waits for the peer
+// database lock before reporting the process as unresponsive.
+const livenessTimeout = 5 * time.Second
+
+// listenerState records which listeners are bound, for the readiness
+// probe.
+type listenerState struct {
+	dns  int32
+	grpc int32
+}
+
+var listeners listenerState
+
+func (l *listenerState) setDNSListening(listening bool) {
+	atomic.StoreInt32(&l.dns, boolToInt32(listening))
+}
+
+func (l *listenerState) setGRPCListening(listening bool) {
+	atomic.StoreInt32(&l.grpc, boolToInt32(listening))
+}
+
+func boolToInt32(b bool) int32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+type healthCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type healthResponse struct {
+	Status string        `json:"status"`
+	Checks []healthCheck `json:"checks"`
+}
+
+// writeHealth writes the outcome of the given checks, with status 503 if
+// any of them failed.
+func writeHealth(w http.ResponseWriter, checks []healthCheck) {
+	response := healthResponse{Status: "ok", Checks: checks}
+	status := http.StatusOK
+	for _, check := range checks {
+		if !check.OK {
+			response.Status = "fail"
+			status = http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(w, status, response)
+}
+
+// handleLiveness reports whether the process is responsive: it fails only
+// if the peer database lock can't be taken in time, which would leave the
+// crawler and DNS server stuck.
+func (s *HTTPServer) handleLiveness(w http.ResponseWriter, r *http.Request) {
+	var checks []healthCheck
+	for _, amgr := range networkManagers(s.amgr) {
+		check := healthCheck{Name: "peers database", OK: amgr.responsive(livenessTimeout)}
+		if !check.OK {
+			check.Detail = fmt.Sprintf("lock not acquired within %s", livenessTimeout)
+		}
+		checks = append(checks, check)
+	}
+	writeHealth(w, checks)
+}
+
+// handleReadiness reports whether the seeder should receive queries: its
+// listeners are bound, and every network finished its first crawl pass and
+// has at least the configured number of good peers. In crawl-only mode there
+// is no DNS listener to check.
+func (s *HTTPServer) handleReadiness(w http.ResponseWriter, r *http.Request) {
+	minReadyPeers := defaultMinReadyPeers
+	crawlOnly := false
+	if cfg := ActiveConfig(); cfg != nil {
+		minReadyPeers = cfg.MinReadyPeers
+		crawlOnly = cfg.CrawlOnly
+	}
+
+	var checks []healthCheck
+	if !crawlOnly {
+		checks = append(checks, healthCheck{Name: "dns listener", OK: atomic.LoadInt32(&listeners.dns) != 0})
+	}
+	checks = append(checks, healthCheck{Name: "grpc listener", OK: atomic.LoadInt32(&listeners.grpc) != 0})
+	for _, amgr := range networkManagers(s.amgr) {
+		network := amgr.netParams.Name
+		checks = append(checks, healthCheck{Name: network + " crawler warm", OK: amgr.isWarm()})
+
+		_, good := amgr.Counts()
+		check := healthCheck{Name: network + " good peers", OK: good >= minReadyPeers}
+		check.Detail = fmt.Sprintf("%d good peers, %d required", good, minReadyPeers)
+		checks = append(checks, check)
+	}
+	writeHealth(w, checks)
+}
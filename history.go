@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net"
+	"time"
+)
+
+// crawlAttempt is an entry of the crawl history of a node. Failure is the
+// reason the attempt failed for, as classified by classifyCrawlFailure, and
+// Tip the last block the node announced while it was connected, if any.
+type crawlAttempt struct {
+	Time    time.Time
+	Reached bool
+	Failure string        `json:",omitempty"`
+	Latency time.Duration `json:",omitempty"`
+	Tip     string        `json:",omitempty"`
+}
+
+// crawlHistorySize returns the configured number of crawl attempts kept for
+// each node, 0 if the history is disabled.
+func crawlHistorySize() int {
+	cfg := ActiveConfig()
+	if cfg == nil {
+		return 0
+	}
+	return cfg.CrawlHistory
+}
+
+// newCrawlAttempt returns the history entry of a crawl attempt which ended
+// at now with err.
+func newCrawlAttempt(now time.Time, err error, latency time.Duration, tip string) crawlAttempt {
+	attempt := crawlAttempt{
+		Time:    now,
+		Reached: err == nil,
+		Latency: latency,
+		Tip:     tip,
+	}
+	if err != nil {
+		attempt.Failure = classifyCrawlFailure(err)
+	}
+	return attempt
+}
+
+// RecordCrawlAttempt appends attempt to the history of the node with the
+// given IP, dropping the oldest attempts beyond the configured size.
+func (m *Manager) RecordCrawlAttempt(ip net.IP, attempt crawlAttempt) {
+	size := crawlHistorySize()
+	if size <= 0 {
+		return
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	node, exists := m.nodes[ip.String()]
+	if !exists {
+		return
+	}
+	node.History = appendCrawlAttempt(node.History, attempt, size)
+}
+
+// appendCrawlAttempt returns history with attempt appended, keeping only the
+// last size attempts. The result never shares its backing array with
+// history, since copies of the node handed out by the manager may still be
+// reading it.
+func appendCrawlAttempt(history []crawlAttempt, attempt crawlAttempt, size int) []crawlAttempt {
+	if len(history) >= size {
+		history = history[len(history)-size+1:]
+	}
+	appended := make([]crawlAttempt, len(history), len(history)+1)
+	copy(appended, history)
+	return append(appended, attempt)
+}
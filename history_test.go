@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/karlsen-network/karlsend/domain/dagconfig"
+	"github.com/pkg/errors"
+)
+
+func TestCrawlHistory(t *testing.T) {
+	cfg := setTestConfig(t, &ConfigFlags{CrawlHistory: 3})
+
+	ip := net.ParseIP("203.0.113.1")
+	m := newTestManager(t, &dagconfig.MainnetParams, 42111)
+	m.nodes[ip.String()] = &Node{Addr: appmessage.NewNetAddressIPPort(ip, 42111)}
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		var err error
+		if i%2 == 1 {
+			err = errors.Wrap(errBanned, "not connecting")
+		}
+		m.RecordCrawlAttempt(ip, newCrawlAttempt(start.Add(time.Duration(i)*time.Minute), err,
+			time.Duration(i)*time.Millisecond, "tip"))
+	}
+	m.RecordCrawlAttempt(net.ParseIP("203.0.113.2"), newCrawlAttempt(start, nil, 0, ""))
+
+	node, _ := m.Node(ip)
+	if len(node.History) != 3 {
+		t.Fatalf("expected the history to keep 3 attempts, got %d", len(node.History))
+	}
+	records := newCrawlAttemptRecords(node.History)
+	for i, expectedFailure := range []string{"", failureBanned, ""} {
+		record := records[i]
+		minute := 4 - i
+		if !record.Time.Equal(start.Add(time.Duration(minute)*time.Minute)) ||
+			record.Reached != (expectedFailure == "") || record.Failure != expectedFailure ||
+			record.LatencyMilliseconds != int64(minute) || record.Tip != "tip" {
+			t.Errorf("unexpected history record %d: %+v", i, record)
+		}
+	}
+
+	// Copies of the node handed out earlier keep their history as it was.
+	m.RecordCrawlAttempt(ip, newCrawlAttempt(start.Add(time.Hour), nil, 0, ""))
+	if !node.History[2].Time.Equal(start.Add(4 * time.Minute)) {
+		t.Errorf("expected the copied history to be left alone, got %+v", node.History)
+	}
+
+	cfg.CrawlHistory = 0
+	m.RecordCrawlAttempt(ip, newCrawlAttempt(start.Add(2*time.Hour), nil, 0, ""))
+	if node, _ := m.Node(ip); !node.History[2].Time.Equal(start.Add(time.Hour)) {
+		t.Errorf("expected nothing to be recorded with the history disabled")
+	}
+}
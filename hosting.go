@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// defaultHostingProvider is the provider of hosting ranges listed without
+// a name.
+const defaultHostingProvider = "hosting"
+
+// hostingRanges maps the networks of cloud and hosting providers to the
+// name of the provider. Networks are indexed by prefix length, in their
+// 16-byte form, so lookups take one map access per distinct length.
+type hostingRanges struct {
+	lengths  []int
+	networks map[int]map[string]string
+}
+
+// readHostingRanges reads the hosting ranges file at path. Each line holds
+// an IP address or CIDR network, optionally followed by the name of the
+// provider. Empty lines and lines starting with # are ignored.
+func readHostingRanges(path string) (*hostingRanges, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open hosting ranges")
+	}
+	defer file.Close()
+
+	return parseHostingRanges(file, path)
+}
+
+// parseHostingRanges parses hosting ranges in the format of
+// readHostingRanges, read from r. name identifies the list in errors.
+func parseHostingRanges(r io.Reader, name string) (*hostingRanges, error) {
+	ranges := &hostingRanges{networks: make(map[int]map[string]string)}
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		ipNet, err := parseIPNet(fields[0])
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s:%d", name, lineNumber)
+		}
+		provider := strings.Join(fields[1:], " ")
+		if provider == "" {
+			provider = defaultHostingProvider
+		}
+		ranges.add(ipNet, provider)
+	}
+	err := scanner.Err()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", name)
+	}
+	return ranges, nil
+}
+
+// add adds the network of provider.
+func (h *hostingRanges) add(ipNet *net.IPNet, provider string) {
+	ones, bits := ipNet.Mask.Size()
+	length := ones + 128 - bits
+	networks, ok := h.networks[length]
+	if !ok {
+		networks = make(map[string]string)
+		h.networks[length] = networks
+		h.lengths = append(h.lengths, length)
+		sort.Sort(sort.Reverse(sort.IntSlice(h.lengths)))
+	}
+	networks[ipNet.IP.To16().Mask(net.CIDRMask(length, 128)).String()] = provider
+}
+
+// provider returns the name of the provider whose network, the most
+// specific one if several match, contains ip. It returns an empty string
+// if ip is in no hosting range.
+func (h *hostingRanges) provider(ip net.IP) string {
+	ip = ip.To16()
+	if h == nil || ip == nil {
+		return ""
+	}
+	for _, length := range h.lengths {
+		provider, ok := h.networks[length][ip.Mask(net.CIDRMask(length, 128)).String()]
+		if ok {
+			return provider
+		}
+	}
+	return ""
+}
+
+var (
+	hostingMtx sync.RWMutex
+	hosting    *hostingRanges
+)
+
+// setHostingRanges replaces the hosting ranges peers are looked up in. nil
+// disables the lookups.
+func setHostingRanges(ranges *hostingRanges) {
+	hostingMtx.Lock()
+	defer hostingMtx.Unlock()
+	hosting = ranges
+}
+
+// lookupHosting returns the hosting provider of ip, or an empty string if
+// it is in no hosting range or none are configured.
+func lookupHosting(ip net.IP) string {
+	hostingMtx.RLock()
+	defer hostingMtx.RUnlock()
+	return hosting.provider(ip)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestHostingRanges(t *testing.T) {
+	ranges, err := parseHostingRanges(strings.NewReader(`
+# Cloud providers
+203.0.113.0/24 Example Cloud
+203.0.113.128/25 Example Cloud Frankfurt
+198.51.100.7
+2001:db8::/32 Example Hosting
+`), "test")
+	if err != nil {
+		t.Fatalf("parseHostingRanges: %s", err)
+	}
+
+	tests := []struct {
+		ip       string
+		provider string
+	}{
+		{"203.0.113.1", "Example Cloud"},
+		{"203.0.113.200", "Example Cloud Frankfurt"}, // the most specific range
+		{"198.51.100.7", defaultHostingProvider},
+		{"198.51.100.8", ""},
+		{"2001:db8:1::1", "Example Hosting"},
+		{"2001:db9::1", ""},
+	}
+	for _, test := range tests {
+		provider := ranges.provider(net.ParseIP(test.ip))
+		if provider != test.provider {
+			t.Errorf("%s: expected provider %q, got %q", test.ip, test.provider, provider)
+		}
+	}
+
+	_, err = parseHostingRanges(strings.NewReader("not-a-network\n"), "test")
+	if err == nil {
+		t.Errorf("expected an invalid network to be rejected")
+	}
+}
+
+func TestHostedPeersFilter(t *testing.T) {
+	ranges, err := parseHostingRanges(strings.NewReader("203.0.113.0/24\n198.51.0.0/16\n"), "test")
+	if err != nil {
+		t.Fatalf("parseHostingRanges: %s", err)
+	}
+	setHostingRanges(ranges)
+	defer setHostingRanges(nil)
+
+	f := &diversityFilter{maxHosted: 1, netgroups: make(map[string]int)}
+	for _, test := range []struct {
+		ip      string
+		allowed bool
+	}{
+		{"203.0.113.1", true},
+		{"198.51.100.1", false}, // second hosted peer
+		{"192.0.2.1", true},
+	} {
+		allowed := f.allow(net.ParseIP(test.ip))
+		if allowed != test.allowed {
+			t.Errorf("%s: expected allowed %t, got %t", test.ip, test.allowed, allowed)
+		}
+	}
+
+	f = &diversityFilter{excludeHosted: true, netgroups: make(map[string]int)}
+	if f.allow(net.ParseIP("203.0.113.1")) || !f.allow(net.ParseIP("192.0.2.1")) {
+		t.Errorf("expected only the hosted peer to be excluded")
+	}
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/karlsen-network/dnsseeder/version"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultPeersPageSize is the number of peers returned by the peer
+	// listing endpoints when no limit is requested.
+	defaultPeersPageSize = 100
+
+	// maxPeersPageSize is the maximum number of peers returned by the
+	// peer listing endpoints in a single response.
+	maxPeersPageSize = 1000
+)
+
+// HTTPServer serves the JSON status and peer listing API, and the web
+// dashboard
+type HTTPServer struct {
+	server *http.Server
+	amgr   *Manager
+	mux    *http.ServeMux
+}
+
+// NewHTTPServer returns a new HTTP API server backed by the given manager
+func NewHTTPServer(amgr *Manager) *HTTPServer {
+	s := &HTTPServer{
+		amgr: amgr,
+		mux:  http.NewServeMux(),
+	}
+	s.mux.HandleFunc("/v1/status", s.handleStatus)
+	s.mux.HandleFunc("/v1/peers", s.handlePeers)
+	s.mux.HandleFunc("/v1/peers/good", s.handleGoodPeers)
+	s.mux.HandleFunc("/v1/nodes/", s.handleNode)
+	s.mux.HandleFunc("/v1/dashboard", s.handleDashboardData)
+	s.mux.HandleFunc("/v1/audit", s.handleAudit)
+	s.mux.HandleFunc("/v1/snapshot", s.handleSnapshot)
+	s.mux.HandleFunc("/v1/bootstrap", s.handleBootstrap)
+	s.mux.HandleFunc("/v1/graph", s.handleGraph)
+	s.mux.HandleFunc("/v1/explain", s.handleExplain)
+	s.mux.HandleFunc("/livez", s.handleLiveness)
+	s.mux.HandleFunc("/readyz", s.handleReadiness)
+	s.mux.HandleFunc("/", s.handleDashboard)
+	return s
+}
+
+// Start starts listening for HTTP requests on the given interface
+func (s *HTTPServer) Start(listenInterface string) error {
+	return s.StartTLS(listenInterface, "", "")
+}
+
+// StartTLS starts listening for HTTPS requests on the given interface, with
+// the certificate and key in the given PEM files. Without them, it listens
+// for plain HTTP requests.
+func (s *HTTPServer) StartTLS(listenInterface, certFile, keyFile string) error {
+	lis, err := net.Listen("tcp", listenInterface)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	s.server = &http.Server{Handler: s.mux}
+	spawn("HTTP server", func() {
+		var err error
+		if certFile != "" {
+			err = s.server.ServeTLS(lis, certFile, keyFile)
+		} else {
+			err = s.server.Serve(lis)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			rpcLog.Errorf("HTTP server: %v", err)
+		}
+	})
+
+	return nil
+}
+
+// Stop stops the HTTP server
+func (s *HTTPServer) Stop() {
+	err := s.server.Close()
+	if err != nil {
+		rpcLog.Warnf("Failed to stop HTTP server: %v", err)
+	}
+}
+
+type peerCounts struct {
+	Known int `json:"known"`
+	Good  int `json:"good"`
+}
+
+type statusResponse struct {
+	Version       string                `json:"version"`
+	Site          string                `json:"site,omitempty"`
+	Instance      string                `json:"instance,omitempty"`
+	Network       string                `json:"network"`
+	UptimeSeconds int64                 `json:"uptimeSeconds"`
+	CrawlPaused   bool                  `json:"crawlPaused"`
+	Maintenance   bool                  `json:"maintenance"`
+	StaticAnswers []string              `json:"staticAnswers,omitempty"`
+	Peers         peerCounts            `json:"peers"`
+	PeersByFamily map[string]peerCounts `json:"peersByFamily"`
+	Metrics       map[string]uint64     `json:"metrics"`
+
+	// Rejections tells why crawled peers were rejected, as a hint of
+	// how many nodes are misconfigured.
+	Rejections map[string]rejectionSummary `json:"rejections"`
+
+	Networks []networkStatus `json:"networks"`
+}
+
+// networkStatus holds the peer counts, the failed crawl attempts by reason
+// and the state of the canaries of a single network.
+type networkStatus struct {
+	Name          string            `json:"name"`
+	Peers         peerCounts        `json:"peers"`
+	CrawlFailures map[string]uint64 `json:"crawlFailures"`
+	Canaries      []canaryStatus    `json:"canaries,omitempty"`
+
+	// SuspectedPartitions lists the churn events under way.
+	SuspectedPartitions []churnEvent `json:"suspectedPartitions,omitempty"`
+}
+
+type peerRecord struct {
+	IP           string    `json:"ip"`
+	Port         uint16    `json:"port"`
+	Good         bool      `json:"good"`
+	Demoted      bool      `json:"demoted"`
+	Partial      bool      `json:"partial"`
+	LastAttempt  time.Time `json:"lastAttempt"`
+	LastSuccess  time.Time `json:"lastSuccess"`
+	LastSeen     time.Time `json:"lastSeen"`
+	SubnetworkID string    `json:"subnetworkId,omitempty"`
+
+	InjectedUntil *time.Time    `json:"injectedUntil,omitempty"`
+	SnapshotUntil *time.Time    `json:"snapshotUntil,omitempty"`
+	Reliability   *float64      `json:"reliability,omitempty"`
+	Location      *NodeLocation `json:"location,omitempty"`
+	Hosting       string        `json:"hosting,omitempty"`
+
+	History []crawlAttemptRecord `json:"history,omitempty"`
+}
+
+// crawlAttemptRecord is an entry of the crawl history of a peer, as served
+// on /v1/nodes/<ip> and printed by dump --history.
+type crawlAttemptRecord struct {
+	Time                time.Time `json:"time"`
+	Reached             bool      `json:"reached"`
+	Failure             string    `json:"failure,omitempty"`
+	LatencyMilliseconds int64     `json:"latencyMilliseconds,omitempty"`
+	Tip                 string    `json:"tip,omitempty"`
+}
+
+type peersResponse struct {
+	Total  int          `json:"total"`
+	Offset int          `json:"offset"`
+	Limit  int          `json:"limit"`
+	Peers  []peerRecord `json:"peers"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func newPeerRecord(node *Node, now time.Time) peerRecord {
+	record := peerRecord{
+		IP:          node.Addr.IP.String(),
+		Port:        node.Addr.Port,
+		Good:        node.isGood(now),
+		Demoted:     node.Demoted,
+		Partial:     node.isPartial(),
+		LastAttempt: node.LastAttempt,
+		LastSuccess: node.LastSuccess,
+		LastSeen:    node.LastSeen,
+		Location:    lookupLocation(node.Addr.IP),
+		Hosting:     lookupHosting(node.Addr.IP),
+	}
+	if node.SubnetworkID != nil {
+		record.SubnetworkID = node.SubnetworkID.String()
+	}
+	if now.Before(node.InjectedUntil) {
+		injectedUntil := node.InjectedUntil
+		record.InjectedUntil = &injectedUntil
+	}
+	if now.Before(node.SnapshotUntil) {
+		snapshotUntil := node.SnapshotUntil
+		record.SnapshotUntil = &snapshotUntil
+	}
+	if halfLife, _, _ := reliabilityParams(); halfLife > 0 && !node.ReliabilityUpdated.IsZero() {
+		reliability := node.reliabilityAt(now, halfLife)
+		record.Reliability = &reliability
+	}
+	return record
+}
+
+// newCrawlAttemptRecords returns the crawl history of a peer, newest first.
+func newCrawlAttemptRecords(history []crawlAttempt) []crawlAttemptRecord {
+	records := make([]crawlAttemptRecord, len(history))
+	for i, attempt := range history {
+		records[len(history)-1-i] = crawlAttemptRecord{
+			Time:                attempt.Time,
+			Reached:             attempt.Reached,
+			Failure:             attempt.Failure,
+			LatencyMilliseconds: attempt.Latency.Milliseconds(),
+			Tip:                 attempt.Tip,
+		}
+	}
+	return records
+}
+
+// addressFamily returns "ipv4" or "ipv6" for the given IP.
+func addressFamily(ip net.IP) string {
+	if ip.To4() != nil {
+		return "ipv4"
+	}
+	return "ipv6"
+}
+
+func (s *HTTPServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	labels := activeSiteLabels()
+	response := statusResponse{
+		Version:       version.Version(),
+		Site:          labels.site,
+		Instance:      labels.instance,
+		Network:       ActiveConfig().NetParams().Name,
+		UptimeSeconds: int64(now.Sub(startTime).Seconds()),
+		CrawlPaused:   isCrawlPaused(),
+		Maintenance:   inMaintenance(),
+		PeersByFamily: map[string]peerCounts{"ipv4": {}, "ipv6": {}},
+		Metrics:       make(map[string]uint64),
+		Rejections:    rejections.Summaries(),
+	}
+
+	for _, ip := range getStaticAnswers() {
+		response.StaticAnswers = append(response.StaticAnswers, ip.String())
+	}
+
+	for _, node := range s.amgr.Nodes() {
+		family := addressFamily(node.Addr.IP)
+		counts := response.PeersByFamily[family]
+		counts.Known++
+		response.Peers.Known++
+		if node.isGood(now) {
+			counts.Good++
+			response.Peers.Good++
+		}
+		response.PeersByFamily[family] = counts
+	}
+
+	for _, m := range stats.snapshot(nil).metrics {
+		response.Metrics[m.name] = m.value
+	}
+
+	for _, amgr := range networkManagers(s.amgr) {
+		known, good := amgr.Counts()
+		status := networkStatus{
+			Name:          amgr.netParams.Name,
+			Peers:         peerCounts{Known: known, Good: good},
+			CrawlFailures: amgr.failures.Counts(),
+		}
+		for _, c := range amgr.Canaries() {
+			status.Canaries = append(status.Canaries, c.status())
+		}
+		if churn := amgr.Churn(); churn != nil {
+			status.SuspectedPartitions = churn.Events()
+		}
+		response.Networks = append(response.Networks, status)
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *HTTPServer) handlePeers(w http.ResponseWriter, r *http.Request) {
+	s.servePeers(w, r, false)
+}
+
+func (s *HTTPServer) handleGoodPeers(w http.ResponseWriter, r *http.Request) {
+	s.servePeers(w, r, true)
+}
+
+// servePeers serves a page of peers. Supported query parameters are
+// offset, limit, family (ipv4 or ipv6) and good (true or false).
+func (s *HTTPServer) servePeers(w http.ResponseWriter, r *http.Request, onlyGood bool) {
+	query := r.URL.Query()
+	offset, err := intQueryParam(query.Get("offset"), 0)
+	if err != nil || offset < 0 {
+		writeError(w, http.StatusBadRequest, "invalid offset")
+		return
+	}
+	limit, err := intQueryParam(query.Get("limit"), defaultPeersPageSize)
+	if err != nil || limit <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid limit")
+		return
+	}
+	if limit > maxPeersPageSize {
+		limit = maxPeersPageSize
+	}
+	family := query.Get("family")
+	if family != "" && family != "ipv4" && family != "ipv6" {
+		writeError(w, http.StatusBadRequest, "invalid family")
+		return
+	}
+	if good := query.Get("good"); good != "" {
+		onlyGood, err = strconv.ParseBool(good)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid good")
+			return
+		}
+	}
+
+	now := time.Now()
+	var records []peerRecord
+	for _, node := range s.amgr.Nodes() {
+		if onlyGood && !node.isGood(now) {
+			continue
+		}
+		if family != "" && addressFamily(node.Addr.IP) != family {
+			continue
+		}
+		records = append(records, newPeerRecord(&node, now))
+	}
+
+	response := peersResponse{
+		Total:  len(records),
+		Offset: offset,
+		Limit:  limit,
+		Peers:  []peerRecord{},
+	}
+	if offset < len(records) {
+		end := offset + limit
+		if end > len(records) {
+			end = len(records)
+		}
+		response.Peers = records[offset:end]
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *HTTPServer) handleNode(w http.ResponseWriter, r *http.Request) {
+	ip := net.ParseIP(strings.TrimPrefix(r.URL.Path, "/v1/nodes/"))
+	if ip == nil {
+		writeError(w, http.StatusBadRequest, "invalid ip")
+		return
+	}
+
+	node, exists := s.amgr.Node(ip)
+	if !exists {
+		writeError(w, http.StatusNotFound, "unknown node")
+		return
+	}
+
+	record := newPeerRecord(&node, time.Now())
+	record.History = newCrawlAttemptRecords(node.History)
+	writeJSON(w, http.StatusOK, record)
+}
+
+// handleAudit serves the audited DNS answers, newest first. Supported query
+// parameters are client (the resolver IP) and limit.
+func (s *HTTPServer) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if answerAudit == nil {
+		writeError(w, http.StatusNotFound, "auditing is disabled")
+		return
+	}
+
+	query := r.URL.Query()
+	var client net.IP
+	if clientStr := query.Get("client"); clientStr != "" {
+		client = net.ParseIP(clientStr)
+		if client == nil {
+			writeError(w, http.StatusBadRequest, "invalid client")
+			return
+		}
+	}
+	limit, err := intQueryParam(query.Get("limit"), defaultPeersPageSize)
+	if err != nil || limit <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid limit")
+		return
+	}
+
+	entries := answerAudit.Entries(client)
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// handleSnapshot serves the latest signed snapshot of the good peers of the
+// network given by the network query parameter, the primary one by default.
+func (s *HTTPServer) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	network := r.URL.Query().Get("network")
+	if network == "" {
+		network = s.amgr.netParams.Name
+	}
+	snapshot := getSnapshot(network)
+	if snapshot == nil {
+		writeError(w, http.StatusNotFound, "no snapshot of network "+network)
+		return
+	}
+	writeJSON(w, http.StatusOK, snapshot)
+}
+
+func intQueryParam(value string, defaultValue int) (int, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	return strconv.Atoi(value)
+}
+
+func writeJSON(w http.ResponseWriter, status int, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		rpcLog.Warnf("Failed to write HTTP response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, errorResponse{Error: message})
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/karlsen-network/karlsend/domain/dagconfig"
+)
+
+func TestHTTPPeers(t *testing.T) {
+	now := time.Now()
+	m := newTestManager(t, &dagconfig.MainnetParams, 0)
+	for _, ip := range []string{"1.0.0.1", "1.0.0.2", "1.0.0.3", "2001:db8::1"} {
+		m.nodes[ip] = &Node{
+			Addr:        appmessage.NewNetAddressIPPort(net.ParseIP(ip), 1313),
+			LastSeen:    now,
+			LastSuccess: now,
+		}
+	}
+	m.nodes["1.0.0.3"].LastSuccess = time.Time{}
+
+	server := NewHTTPServer(m)
+	get := func(url string, expectedStatus int, response interface{}) {
+		recorder := httptest.NewRecorder()
+		server.mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, url, nil))
+		if recorder.Code != expectedStatus {
+			t.Fatalf("%s: expected status %d, got %d", url, expectedStatus, recorder.Code)
+		}
+		if response != nil {
+			err := json.Unmarshal(recorder.Body.Bytes(), response)
+			if err != nil {
+				t.Fatalf("%s: Unmarshal: %s", url, err)
+			}
+		}
+	}
+
+	var peers peersResponse
+	get("/v1/peers?family=ipv4&offset=1&limit=1", http.StatusOK, &peers)
+	if peers.Total != 3 || len(peers.Peers) != 1 || peers.Peers[0].IP != "1.0.0.2" {
+		t.Errorf("unexpected ipv4 page: %+v", peers)
+	}
+
+	peers = peersResponse{}
+	get("/v1/peers/good", http.StatusOK, &peers)
+	if peers.Total != 3 {
+		t.Errorf("expected 3 good peers, got %d", peers.Total)
+	}
+
+	var node peerRecord
+	get("/v1/nodes/2001:db8::1", http.StatusOK, &node)
+	if node.IP != "2001:db8::1" || !node.Good {
+		t.Errorf("unexpected node record: %+v", node)
+	}
+
+	get("/v1/nodes/1.2.3.4", http.StatusNotFound, nil)
+	get("/v1/peers?limit=-1", http.StatusBadRequest, nil)
+}
+
+func TestHTTPHealth(t *testing.T) {
+	m := newTestManager(t, &dagconfig.MainnetParams, 0)
+	server := NewHTTPServer(m)
+	get := func(url string) (int, healthResponse) {
+		recorder := httptest.NewRecorder()
+		server.mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, url, nil))
+		var response healthResponse
+		err := json.Unmarshal(recorder.Body.Bytes(), &response)
+		if err != nil {
+			t.Fatalf("%s: Unmarshal: %s", url, err)
+		}
+		return recorder.Code, response
+	}
+
+	if status, _ := get("/livez"); status != http.StatusOK {
+		t.Errorf("expected live seeder, got status %d", status)
+	}
+	if status, _ := get("/readyz"); status != http.StatusServiceUnavailable {
+		t.Errorf("expected unready seeder, got status %d", status)
+	}
+
+	listeners.setDNSListening(true)
+	listeners.setGRPCListening(true)
+	defer listeners.setDNSListening(false)
+	defer listeners.setGRPCListening(false)
+	m.setWarm()
+	m.nodes["1.0.0.1"] = &Node{
+		Addr:        appmessage.NewNetAddressIPPort(net.ParseIP("1.0.0.1"), 1313),
+		LastSeen:    time.Now(),
+		LastSuccess: time.Now(),
+	}
+	status, response := get("/readyz")
+	if status != http.StatusOK || response.Status != "ok" {
+		t.Errorf("expected ready seeder, got status %d: %+v", status, response)
+	}
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/karlsen-network/karlsend/app/protocol/common"
+	"github.com/pkg/errors"
+)
+
+const (
+	// keepalivePingTimeout is how long a kept peer has to answer a ping.
+	keepalivePingTimeout = 30 * time.Second
+
+	// keepaliveSlack is how many times the pool size a kept peer may fall
+	// to in the ranking of the most served peers before its connection is
+	// closed, so peers around the cut aren't reconnected over and over.
+	keepaliveSlack = 2
+)
+
+// keptConn is a persistent connection to a peer of the keepalive pool.
+type keptConn struct {
+	addr   *appmessage.NetAddress
+	routes *peerRoutes
+
+	// pongs receives the nonces of the pongs the peer sent.
+	pongs chan uint64
+
+	// lastAddresses is when the peer was last asked for its addresses.
+	lastAddresses time.Time
+}
+
+// keepalivePool keeps persistent connections to the peers of a network
+// served most often, pinging them every interval instead of crawling them
+// again with a full connection and handshake. Each answered ping counts as
+// a successful crawl, so the liveness of the hottest answers is known
+// within an interval, and the peers are asked for their addresses over the
+// same connection once per crawl interval. Peers failing a ping are queued
+// for a regular crawl.
+type keepalivePool struct {
+	amgr *Manager
+	size int
+
+	mtx    sync.Mutex
+	served map[string]uint64
+	conns  map[string]*keptConn
+
+	// pings and pingFailures must be accessed atomically.
+	pings        uint64
+	pingFailures uint64
+}
+
+// newKeepalivePool returns a keepalive pool of the network of amgr keeping
+// connections to its size most served peers, and sets it on the network.
+func newKeepalivePool(amgr *Manager, size int) *keepalivePool {
+	p := &keepalivePool{
+		amgr:   amgr,
+		size:   size,
+		served: make(map[string]uint64),
+		conns:  make(map[string]*keptConn),
+	}
+	amgr.SetKeepalive(p)
+	return p
+}
+
+// recordServed counts the peers served in an answer.
+func (p *keepalivePool) recordServed(addrs []*appmessage.NetAddress) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	for _, addr := range addrs {
+		p.served[addr.IP.String()]++
+	}
+}
+
+// ranking returns the IPs of the good peers served most often, up to count
+// of them, most served first. The counts are then halved, so the ranking
+// follows the recent answers.
+func (p *keepalivePool) ranking(count int, now time.Time) []string {
+	p.mtx.Lock()
+	served := make(map[string]uint64, len(p.served))
+	for ip, n := range p.served {
+		served[ip] = n
+		if n/2 == 0 {
+			delete(p.served, ip)
+		} else {
+			p.served[ip] = n / 2
+		}
+	}
+	p.mtx.Unlock()
+
+	ips := make([]string, 0, len(served))
+	p.amgr.mtx.RLock()
+	for ip := range served {
+		node, exists := p.amgr.nodes[ip]
+		if exists && node.isGood(now) {
+			ips = append(ips, ip)
+		}
+	}
+	p.amgr.mtx.RUnlock()
+
+	sort.Slice(ips, func(i, j int) bool {
+		if served[ips[i]] != served[ips[j]] {
+			return served[ips[i]] > served[ips[j]]
+		}
+		return bytes.Compare(net.ParseIP(ips[i]).To16(), net.ParseIP(ips[j]).To16()) < 0
+	})
+	if len(ips) > count {
+		ips = ips[:count]
+	}
+	return ips
+}
+
+// tick pings the kept peers, closes the connections to those no longer
+// among the most served, and opens connections to those newly among them,
+// until quit is closed.
+func (p *keepalivePool) tick(ctx context.Context, netAdapter *crawlAdapter, now time.Time) {
+	ranking := p.ranking(p.size*keepaliveSlack, now)
+	kept := make(map[string]bool, len(ranking))
+	for _, ip := range ranking {
+		kept[ip] = true
+	}
+
+	p.mtx.Lock()
+	conns := make([]*keptConn, 0, len(p.conns))
+	for ip, conn := range p.conns {
+		if !kept[ip] {
+			conn.routes.Disconnect()
+			delete(p.conns, ip)
+			continue
+		}
+		conns = append(conns, conn)
+	}
+	p.mtx.Unlock()
+
+	var pings sync.WaitGroup
+	for _, conn := range conns {
+		conn := conn
+		pings.Add(1)
+		spawn("keepalivePool-check", func() {
+			defer pings.Done()
+			p.check(conn, now)
+		})
+	}
+	pings.Wait()
+
+	for _, ip := range ranking {
+		p.mtx.Lock()
+		full := len(p.conns) >= p.size
+		_, exists := p.conns[ip]
+		p.mtx.Unlock()
+		if full || ctx.Err() != nil {
+			break
+		}
+		if !exists {
+			p.connect(ctx, netAdapter, ip)
+		}
+	}
+}
+
+// check pings a kept peer, and asks it for its addresses once per crawl
+// interval. A peer answering counts as successfully crawled; one that
+// doesn't is dropped from the pool and queued for a regular crawl.
+func (p *keepalivePool) check(conn *keptConn, now time.Time) {
+	atomic.AddUint64(&p.pings, 1)
+	rtt, err := conn.ping()
+	if err == nil && now.Sub(conn.lastAddresses) >= crawlInterval() {
+		var msgAddresses *appmessage.MsgAddresses
+		msgAddresses, err = conn.routes.RequestAddresses(common.DefaultTimeout)
+		if err == nil {
+			conn.lastAddresses = now
+			p.amgr.AddAddresses(msgAddresses.AddressList)
+			p.amgr.RecordAdvertised(conn.addr.IP, msgAddresses.AddressList)
+		}
+	}
+	if err != nil {
+		atomic.AddUint64(&p.pingFailures, 1)
+		crawlLog.Debugf("Kept peer %s failed its check: %v", conn.addr.IP, err)
+		conn.routes.Disconnect()
+		p.mtx.Lock()
+		delete(p.conns, conn.addr.IP.String())
+		p.mtx.Unlock()
+		err = p.amgr.ForceCrawl(conn.addr)
+		if err != nil {
+			crawlLog.Debugf("Not crawling %s again: %v", conn.addr.IP, err)
+		}
+		return
+	}
+
+	p.amgr.Good(conn.addr.IP, nil)
+	p.amgr.Attempt(conn.addr.IP)
+	p.amgr.RecordCrawl(conn.addr.IP, true, rtt)
+}
+
+// connect opens a kept connection to the peer with the given IP, within the
+// crawl politeness limits like any crawl. Peers that can't be reached are
+// left to the regular crawls.
+func (p *keepalivePool) connect(ctx context.Context, netAdapter *crawlAdapter, ip string) {
+	node, exists := p.amgr.Node(net.ParseIP(ip))
+	if !exists || p.amgr.bans.IsBanned(node.Addr.IP) || !isAllowed(node.Addr.IP) {
+		return
+	}
+	if !politeness.admit(node.Addr.IP, time.Now()) {
+		return
+	}
+	politeness.wait()
+	address := net.JoinHostPort(node.Addr.IP.String(), strconv.Itoa(int(node.Addr.Port)))
+	routes, err := netAdapter.Connect(ctx, address)
+	if err != nil {
+		crawlLog.Debugf("Could not keep a connection to %s: %v", address, err)
+		return
+	}
+	// The connection is idle between pings, so it doesn't hold the crawl
+	// slot of its host and subnet, which regular crawls need.
+	routes.release()
+	routes.release = nil
+	conn := &keptConn{
+		addr:   node.Addr,
+		routes: routes,
+		pongs:  make(chan uint64, 1),
+	}
+	spawn("keepalivePool-receive", conn.receive)
+
+	// The latency of kept peers is that of their pings, whose round trip
+	// doesn't include the wait for the crawl adapter.
+	rtt, err := conn.ping()
+	if err != nil {
+		crawlLog.Debugf("Kept peer %s failed its first ping: %v", address, err)
+		routes.Disconnect()
+		return
+	}
+	p.amgr.Good(node.Addr.IP, routes.version)
+	p.amgr.Attempt(node.Addr.IP)
+	p.amgr.RecordCrawl(node.Addr.IP, true, rtt)
+
+	p.mtx.Lock()
+	p.conns[ip] = conn
+	p.mtx.Unlock()
+	crawlLog.Debugf("Keeping a connection to %s (%s)", address, routes.version.UserAgent)
+}
+
+// close closes every kept connection.
+func (p *keepalivePool) close() {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	for ip, conn := range p.conns {
+		conn.routes.Disconnect()
+		delete(p.conns, ip)
+	}
+}
+
+// metrics returns the number of kept connections, and of pings sent and
+// failed so far.
+func (p *keepalivePool) metrics() []metric {
+	p.mtx.Lock()
+	connections := len(p.conns)
+	p.mtx.Unlock()
+	return []metric{
+		{"keepalive_connections", uint64(connections)},
+		{"keepalive_pings", atomic.LoadUint64(&p.pings)},
+		{"keepalive_ping_failures", atomic.LoadUint64(&p.pingFailures)},
+	}
+}
+
+// receive passes on the pongs the peer sends, and drops the other messages
+// it isn't asked for, until the connection is closed.
+func (c *keptConn) receive() {
+	for {
+		message, err := c.routes.otherRoute.Dequeue()
+		if err != nil {
+			return
+		}
+		if pong, ok := message.(*appmessage.MsgPong); ok {
+			select {
+			case c.pongs <- pong.Nonce:
+			default:
+			}
+		}
+	}
+}
+
+// ping pings the peer and waits for its pong, returning the round trip
+// time.
+func (c *keptConn) ping() (time.Duration, error) {
+	nonce := rand.Uint64()
+	start := time.Now()
+	err := c.routes.outgoingRoute.Enqueue(appmessage.NewMsgPing(nonce))
+	if err != nil {
+		return 0, err
+	}
+	timeout := time.NewTimer(keepalivePingTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case received := <-c.pongs:
+			if received == nonce {
+				return time.Since(start), nil
+			}
+		case <-timeout.C:
+			return 0, errors.New("timed out waiting for a pong")
+		}
+	}
+}
+
+// keepPeersAlive runs the keepalive pool of network every interval, until
+// quit is closed.
+func keepPeersAlive(network *seederNetwork, pool *keepalivePool, interval time.Duration, quit <-chan struct{}) {
+	defer wg.Done()
+	defer pool.close()
+
+	netAdapter, err := network.crawlAdapter()
+	if err != nil {
+		crawlLog.Errorf("Could not keep %s peers alive: %v", network.name(), err)
+		return
+	}
+
+	// Connecting stops as soon as quit is closed.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	spawn("keepPeersAlive-cancel", func() {
+		select {
+		case <-quit:
+			cancel()
+		case <-ctx.Done():
+		}
+	})
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-quit:
+			return
+		}
+		if !isCrawlPaused() {
+			pool.tick(ctx, netAdapter, time.Now())
+		}
+	}
+}
+
+// SetKeepalive sets the keepalive pool of the network.
+func (m *Manager) SetKeepalive(pool *keepalivePool) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.keepalive = pool
+}
+
+// Keepalive returns the keepalive pool of the network, or nil if there is
+// none.
+func (m *Manager) Keepalive() *keepalivePool {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	return m.keepalive
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/karlsen-network/dnsseeder/fakenode"
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/karlsen-network/karlsend/domain/dagconfig"
+)
+
+func TestKeepalivePool(t *testing.T) {
+	params := &dagconfig.DevnetParams
+	netAdapter := newTestCrawlAdapter(t, params, 0)
+	node, err := fakenode.Start(params, fakenode.Behavior{UserAgent: "/karlsend:1.0.0/"})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer node.Stop()
+
+	now := time.Now()
+	kept := appmessage.NewNetAddressIPPort(net.ParseIP("127.0.0.1"), node.Port())
+	other := appmessage.NewNetAddressIPPort(net.ParseIP("203.0.113.1"), node.Port())
+	m := newTestManager(t, params, node.Port())
+	m.nodes["127.0.0.1"] = &Node{Addr: kept, LastSeen: now, LastSuccess: now.Add(-time.Minute)}
+	m.nodes["203.0.113.1"] = &Node{Addr: other, LastSeen: now, LastSuccess: now}
+	pool := newKeepalivePool(m, 1)
+	if m.Keepalive() != pool {
+		t.Fatalf("expected the keepalive pool to be set on the network")
+	}
+	serve := func() {
+		pool.recordServed([]*appmessage.NetAddress{kept, kept, kept, other})
+	}
+
+	// Only the most served peer is kept, and connecting to it counts as a
+	// successful crawl.
+	serve()
+	pool.tick(context.Background(), netAdapter, now)
+	if metrics := pool.metrics(); metrics[0].value != 1 || metrics[1].value != 0 {
+		t.Fatalf("expected a single kept connection, got %v", metrics)
+	}
+	crawled, _ := m.Node(kept.IP)
+	if !crawled.LastSuccess.After(now) || crawled.UserAgent != "/karlsend:1.0.0/" {
+		t.Errorf("expected the kept peer to be recorded as reached, got %+v", crawled)
+	}
+	// Kept connections don't hold a crawl slot.
+	release, err := connections.acquire(kept.IP)
+	if err != nil {
+		t.Errorf("expected the crawl slot of the kept peer to be released: %v", err)
+	} else {
+		release()
+	}
+
+	// Answered pings count as successful crawls too.
+	serve()
+	lastSuccess := crawled.LastSuccess
+	pool.tick(context.Background(), netAdapter, now)
+	if metrics := pool.metrics(); metrics[0].value != 1 || metrics[1].value != 1 || metrics[2].value != 0 {
+		t.Fatalf("expected the kept peer to answer its ping, got %v", metrics)
+	}
+	if crawled, _ := m.Node(kept.IP); !crawled.LastSuccess.After(lastSuccess) {
+		t.Errorf("expected the ping to be recorded as a success")
+	}
+
+	// Peers that stop answering are dropped, and queued for a regular
+	// crawl.
+	node.Stop()
+	serve()
+	pool.tick(context.Background(), netAdapter, now)
+	if metrics := pool.metrics(); metrics[0].value != 0 || metrics[2].value != 1 {
+		t.Errorf("expected the unreachable peer to be dropped, got %v", metrics)
+	}
+	if addrs := m.Addresses(); len(addrs) == 0 || !addrs[0].IP.Equal(kept.IP) {
+		t.Errorf("expected the dropped peer to be queued for a crawl, got %v", addrs)
+	}
+}
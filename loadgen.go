@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/karlsen-network/dnsseeder/fakenode"
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/karlsen-network/karlsend/domain/dagconfig"
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+const (
+	// loadgenTick is the interval at which DNS queries are dispatched to
+	// the load generator workers.
+	loadgenTick = time.Millisecond * 10
+
+	// loadgenQueryTimeout is the time after which an unanswered query is
+	// counted as timed out.
+	loadgenQueryTimeout = time.Second * 2
+
+	// loadgenNodeAddresses is the number of other fake nodes each fake
+	// node answers to address requests.
+	loadgenNodeAddresses = 16
+)
+
+// latencyPercentiles summarizes the latency of answered queries.
+type latencyPercentiles struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+// newLatencyPercentiles returns the percentiles of latencies, in
+// milliseconds. latencies is sorted in place.
+func newLatencyPercentiles(latencies []time.Duration) latencyPercentiles {
+	if len(latencies) == 0 {
+		return latencyPercentiles{}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	percentile := func(p float64) float64 {
+		latency := latencies[int(p*float64(len(latencies)-1))]
+		return float64(latency.Microseconds()) / 1000
+	}
+	return latencyPercentiles{
+		P50: percentile(0.5),
+		P90: percentile(0.9),
+		P99: percentile(0.99),
+		Max: percentile(1),
+	}
+}
+
+// dnsLoadReport is the outcome of the DNS load.
+type dnsLoadReport struct {
+	Target string `json:"target"`
+	Zone   string `json:"zone"`
+
+	// Sent queries were answered, timed out or failed otherwise. Dropped
+	// queries couldn't be sent at the requested rate because all workers
+	// were busy.
+	Sent     int `json:"sent"`
+	Answered int `json:"answered"`
+	Timeouts int `json:"timeouts"`
+	Errors   int `json:"errors"`
+	Dropped  int `json:"dropped"`
+
+	// Throughput is the number of queries answered per second.
+	Throughput float64 `json:"throughput"`
+
+	// AverageAddresses is the average number of addresses per answer.
+	AverageAddresses float64 `json:"averageAddresses"`
+
+	LatencyMilliseconds latencyPercentiles `json:"latencyMilliseconds"`
+}
+
+// dnsLoadResult is what a single worker measured.
+type dnsLoadResult struct {
+	sent, answered, timeouts, errors, addresses int
+	latencies                                   []time.Duration
+}
+
+// runDNSLoad sends qps A queries per second for zone to target, from
+// concurrency workers, until ctx is done.
+func runDNSLoad(ctx context.Context, target string, zone string, qps float64, concurrency int) *dnsLoadReport {
+	report := &dnsLoadReport{Target: target, Zone: dns.Fqdn(zone)}
+	queries := make(chan struct{}, concurrency)
+	results := make(chan *dnsLoadResult, concurrency)
+	for i := 0; i < concurrency; i++ {
+		spawn("runDNSLoad-worker", func() {
+			results <- dnsLoadWorker(target, report.Zone, queries)
+		})
+	}
+
+	start := time.Now()
+	ticker := time.NewTicker(loadgenTick)
+	var due float64
+out:
+	for {
+		select {
+		case <-ticker.C:
+			due += qps * loadgenTick.Seconds()
+			for ; due >= 1; due-- {
+				select {
+				case queries <- struct{}{}:
+				default:
+					report.Dropped++
+				}
+			}
+		case <-ctx.Done():
+			break out
+		}
+	}
+	ticker.Stop()
+	close(queries)
+
+	var latencies []time.Duration
+	var addresses int
+	for i := 0; i < concurrency; i++ {
+		result := <-results
+		report.Sent += result.sent
+		report.Answered += result.answered
+		report.Timeouts += result.timeouts
+		report.Errors += result.errors
+		addresses += result.addresses
+		latencies = append(latencies, result.latencies...)
+	}
+	elapsed := time.Since(start)
+	report.Throughput = float64(report.Answered) / elapsed.Seconds()
+	if report.Answered > 0 {
+		report.AverageAddresses = float64(addresses) / float64(report.Answered)
+	}
+	report.LatencyMilliseconds = newLatencyPercentiles(latencies)
+	return report
+}
+
+// dnsLoadWorker sends a query to target for each element of queries.
+func dnsLoadWorker(target string, zone string, queries <-chan struct{}) *dnsLoadResult {
+	result := &dnsLoadResult{}
+	client := &dns.Client{Net: "udp", Timeout: loadgenQueryTimeout}
+	query := new(dns.Msg)
+	query.SetQuestion(zone, dns.TypeA)
+	for range queries {
+		result.sent++
+		query.Id = dns.Id()
+		response, latency, err := client.Exchange(query, target)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				result.timeouts++
+			} else {
+				result.errors++
+			}
+			continue
+		}
+		if response.Rcode != dns.RcodeSuccess {
+			result.errors++
+			continue
+		}
+		result.answered++
+		result.addresses += len(response.Answer)
+		result.latencies = append(result.latencies, latency)
+	}
+	return result
+}
+
+// nodeLoadReport is the outcome of the fake nodes load.
+type nodeLoadReport struct {
+	// Nodes is the number of fake nodes run, the first of which is at
+	// Entry.
+	Nodes int    `json:"nodes"`
+	Entry string `json:"entry"`
+
+	// Connections is the number of crawl connections the nodes accepted,
+	// and Crawled the number of nodes that accepted at least one.
+	Connections          int     `json:"connections"`
+	ConnectionsPerSecond float64 `json:"connectionsPerSecond"`
+	Crawled              int     `json:"crawled"`
+}
+
+// fakeNodeNetwork is a set of fake nodes for a seeder to crawl. Since the
+// seeder tells peers apart by IP, each node listens on its own address,
+// counting up from a base one. Each node answers address requests with the
+// next loadgenNodeAddresses nodes, so crawling any of them leads to all the
+// others.
+type fakeNodeNetwork struct {
+	nodes   []*fakenode.Node
+	started time.Time
+}
+
+// startFakeNodeNetwork starts a fake node of the network with the given
+// parameters on a free port of each of hosts.
+func startFakeNodeNetwork(params *dagconfig.Params, hosts []net.IP) (*fakeNodeNetwork, error) {
+	// The ports are reserved until their node starts, since every node
+	// knows the addresses of others.
+	count := len(hosts)
+	addresses := make([]*appmessage.NetAddress, count)
+	reservations := make([]*fakenode.Reservation, 0, count)
+	defer func() {
+		for _, reservation := range reservations {
+			reservation.Release()
+		}
+	}()
+	for i, ip := range hosts {
+		reservation, err := fakenode.Reserve(ip.String())
+		if err != nil {
+			return nil, err
+		}
+		reservations = append(reservations, reservation)
+		_, portStr, _ := net.SplitHostPort(reservation.Address)
+		port, _ := strconv.Atoi(portStr)
+		addresses[i] = appmessage.NewNetAddressIPPort(ip, uint16(port))
+	}
+
+	network := &fakeNodeNetwork{started: time.Now()}
+	for i, reservation := range reservations {
+		var known []*appmessage.NetAddress
+		for j := 1; j <= loadgenNodeAddresses && j < count; j++ {
+			known = append(known, addresses[(i+j)%count])
+		}
+		node, err := reservation.Start(params, fakenode.Behavior{
+			UserAgent: "/dnsseeder-loadgen/",
+			Services:  appmessage.SFNodeNetwork,
+			Addresses: known,
+		})
+		if err != nil {
+			network.stop()
+			return nil, errors.Wrapf(err, "failed to start fake node %d", i)
+		}
+		network.nodes = append(network.nodes, node)
+	}
+	return network, nil
+}
+
+// nextIP returns the address following ip.
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+// report returns the crawl load the nodes got since they started.
+func (n *fakeNodeNetwork) report() *nodeLoadReport {
+	report := &nodeLoadReport{Nodes: len(n.nodes)}
+	if len(n.nodes) > 0 {
+		report.Entry = n.nodes[0].Address
+	}
+	for _, node := range n.nodes {
+		connections := node.Connections()
+		report.Connections += connections
+		if connections > 0 {
+			report.Crawled++
+		}
+	}
+	report.ConnectionsPerSecond = float64(report.Connections) / time.Since(n.started).Seconds()
+	return report
+}
+
+// stop stops all the nodes.
+func (n *fakeNodeNetwork) stop() {
+	var wg sync.WaitGroup
+	for _, node := range n.nodes {
+		node := node
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			node.Stop()
+		}()
+	}
+	wg.Wait()
+}
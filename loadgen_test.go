@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/karlsen-network/dnsseeder/seeddns/seeddnstest"
+	"github.com/karlsen-network/karlsend/domain/dagconfig"
+)
+
+func TestLoadgen(t *testing.T) {
+	server := seeddnstest.NewServer(t, "seed.example.org",
+		seeddnstest.Peer{IP: net.ParseIP("203.0.113.1")},
+		seeddnstest.Peer{IP: net.ParseIP("203.0.113.2")},
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	report := runDNSLoad(ctx, server.Client().Server, "seed.example.org", 200, 4)
+	if report.Sent == 0 || report.Answered != report.Sent || report.Timeouts != 0 || report.Errors != 0 {
+		t.Errorf("unexpected DNS load report %+v", report)
+	}
+	if report.AverageAddresses != 2 {
+		t.Errorf("expected 2 addresses per answer, got %f", report.AverageAddresses)
+	}
+	latency := report.LatencyMilliseconds
+	if latency.P50 > latency.P90 || latency.P90 > latency.P99 || latency.P99 > latency.Max || latency.Max == 0 {
+		t.Errorf("unexpected latency percentiles %+v", latency)
+	}
+
+	// Seeders don't learn loopback addresses, but the nodes can still be
+	// crawled there directly, each on its own port.
+	params := &dagconfig.DevnetParams
+	loopback := net.ParseIP("127.0.0.1").To4()
+	nodes, err := startFakeNodeNetwork(params, []net.IP{loopback, loopback, loopback})
+	if err != nil {
+		t.Fatalf("startFakeNodeNetwork: %v", err)
+	}
+	defer nodes.stop()
+	netAdapter := newTestCrawlAdapter(t, params, 0)
+	for i, node := range nodes.nodes {
+		routes, err := netAdapter.Connect(context.Background(), node.Address)
+		if err != nil {
+			t.Fatalf("Connect to node %d: %v", i, err)
+		}
+		addresses, err := routes.RequestAddresses(time.Second)
+		routes.Disconnect()
+		if err != nil {
+			t.Fatalf("RequestAddresses from node %d: %v", i, err)
+		}
+		if len(addresses.AddressList) != 2 {
+			t.Fatalf("expected node %d to know the 2 others, got %v", i, addresses.AddressList)
+		}
+		next := nodes.nodes[(i+1)%3]
+		if got := addresses.AddressList[0].TCPAddress().String(); got != next.Address {
+			t.Errorf("expected node %d to know node %d at %s first, got %s", i, (i+1)%3, next.Address, got)
+		}
+	}
+	nodesReport := nodes.report()
+	if nodesReport.Nodes != 3 || nodesReport.Connections != 3 || nodesReport.Crawled != 3 ||
+		nodesReport.Entry != nodes.nodes[0].Address {
+		t.Errorf("unexpected nodes report %+v", nodesReport)
+	}
+}
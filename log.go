@@ -1,40 +1,88 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/jrick/logrotate/rotator"
 	"github.com/karlsen-network/karlsend/infrastructure/logger"
 	"github.com/karlsen-network/karlsend/util/panics"
+	"github.com/pkg/errors"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+
+	// logPruneInterval is how often rolled log files are checked against
+	// the maximum log age.
+	logPruneInterval = time.Hour
 )
 
 var (
 	backendLog = logger.NewBackend()
 	log        = backendLog.Logger("SEED")
+	dnsLog     = backendLog.Logger("DNS")
+	crawlLog   = backendLog.Logger("CRWL")
+	amgrLog    = backendLog.Logger("AMGR")
+	rpcLog     = backendLog.Logger("RPC")
 	spawn      = panics.GoroutineWrapperFunc(log)
 )
 
-func initLog(noLogFiles bool, logLevel, logFile, errLogFile string) {
-	level, ok := logger.LevelFromString(logLevel)
-	if !ok {
-		fmt.Fprintf(os.Stderr, "Invalid loglevel: %s", logLevel)
+// subsystemLoggers maps each subsystem tag to its logger.
+var subsystemLoggers = map[string]*logger.Logger{
+	"SEED": log,
+	"DNS":  dnsLog,
+	"CRWL": crawlLog,
+	"AMGR": amgrLog,
+	"RPC":  rpcLog,
+}
+
+// logOptions holds the settings of the log outputs.
+type logOptions struct {
+	noLogFiles bool
+	format     string
+	levels     string
+	maxSizeMB  int64
+	maxRolls   int
+	logFile    string
+	errLogFile string
+
+	// labels are added to every JSON log entry.
+	labels siteLabels
+}
+
+func initLog(options logOptions) {
+	err := setLogLevels(options.levels)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
 		os.Exit(1)
 	}
-	err := backendLog.AddLogWriter(os.Stdout, level)
+
+	// Verbosity is controlled by the subsystem loggers, so every output
+	// accepts all levels, except for the error log file.
+	err = backendLog.AddLogWriter(newLogWriter(os.Stdout, options.format, options.labels), logger.LevelTrace)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error adding stdout to the logger for level %s: %s", logger.LevelWarn, err)
+		fmt.Fprintf(os.Stderr, "Error adding stdout to the logger for level %s: %s", logger.LevelTrace, err)
 		os.Exit(1)
 	}
 
-	if !noLogFiles {
-		err = backendLog.AddLogFile(logFile, logger.LevelTrace)
+	if !options.noLogFiles {
+		err = addLogFile(options, options.logFile, logger.LevelTrace)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error adding log file %s as log rotator for level %s: %s", logFile, logger.LevelTrace, err)
+			fmt.Fprintf(os.Stderr, "Error adding log file %s as log rotator for level %s: %s", options.logFile, logger.LevelTrace, err)
 			os.Exit(1)
 		}
-		err = backendLog.AddLogFile(errLogFile, logger.LevelWarn)
+		err = addLogFile(options, options.errLogFile, logger.LevelWarn)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error adding log file %s as log rotator for level %s: %s", errLogFile, logger.LevelWarn, err)
+			fmt.Fprintf(os.Stderr, "Error adding log file %s as log rotator for level %s: %s", options.errLogFile, logger.LevelWarn, err)
 			os.Exit(1)
 		}
 	}
@@ -44,6 +92,197 @@ func initLog(noLogFiles bool, logLevel, logFile, errLogFile string) {
 		fmt.Fprintf(os.Stderr, "Error starting the logger: %s ", err)
 		os.Exit(1)
 	}
+}
+
+// addLogFile adds a log file that is rolled once it reaches the maximum log
+// size, keeping at most the configured number of rolled files.
+func addLogFile(options logOptions, logFile string, level logger.Level) error {
+	err := os.MkdirAll(filepath.Dir(logFile), 0700)
+	if err != nil {
+		return errors.Wrap(err, "failed to create log directory")
+	}
+	r, err := rotator.New(logFile, options.maxSizeMB*1000, false, options.maxRolls)
+	if err != nil {
+		return errors.Wrap(err, "failed to create file rotator")
+	}
+	return backendLog.AddLogWriter(newLogWriter(r, options.format, options.labels), level)
+}
+
+// pruneLogs removes the rolled files of the given log files last written
+// more than maxAge ago every logPruneInterval, until quit is closed.
+func pruneLogs(maxAge time.Duration, quit <-chan struct{}, logFiles ...string) {
+	ticker := time.NewTicker(logPruneInterval)
+	defer ticker.Stop()
+	for {
+		for _, logFile := range logFiles {
+			pruneRolledLogs(logFile, maxAge)
+		}
+		select {
+		case <-ticker.C:
+		case <-quit:
+			return
+		}
+	}
+}
+
+// pruneRolledLogs removes the rolled files of logFile last written more than
+// maxAge ago.
+func pruneRolledLogs(logFile string, maxAge time.Duration) {
+	rolled, err := filepath.Glob(logFile + ".*.gz")
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	for _, name := range rolled {
+		info, err := os.Stat(name)
+		if err != nil || now.Sub(info.ModTime()) <= maxAge {
+			continue
+		}
+		err = os.Remove(name)
+		if err != nil {
+			log.Warnf("Failed to remove old log file %s: %v", name, err)
+		}
+	}
+}
+
+// parseLogLevels parses a log level specification: either a single level
+// applied to every subsystem, or a comma separated list of SUBSYSTEM=level
+// pairs, optionally starting with a level for the remaining subsystems,
+// e.g. "info,DNS=debug,CRWL=trace".
+func parseLogLevels(spec string) (map[string]logger.Level, error) {
+	levels := make(map[string]logger.Level, len(subsystemLoggers))
+	for _, entry := range strings.Split(spec, ",") {
+		subsystem, levelStr := "", entry
+		if i := strings.Index(entry, "="); i >= 0 {
+			subsystem, levelStr = strings.ToUpper(entry[:i]), entry[i+1:]
+			if _, ok := subsystemLoggers[subsystem]; !ok {
+				return nil, errors.Errorf("unknown log subsystem %s; supported subsystems: %s",
+					subsystem, strings.Join(supportedSubsystems(), ", "))
+			}
+		}
+
+		level, ok := logger.LevelFromString(levelStr)
+		if !ok {
+			return nil, errors.Errorf("invalid log level %s", levelStr)
+		}
+
+		if subsystem != "" {
+			levels[subsystem] = level
+			continue
+		}
+		for tag := range subsystemLoggers {
+			if _, set := levels[tag]; !set {
+				levels[tag] = level
+			}
+		}
+	}
+	return levels, nil
+}
+
+// setLogLevels applies a log level specification as described by
+// parseLogLevels. Subsystems it doesn't mention keep their level.
+func setLogLevels(spec string) error {
+	levels, err := parseLogLevels(spec)
+	if err != nil {
+		return err
+	}
+	for tag, level := range levels {
+		subsystemLoggers[tag].SetLevel(level)
+	}
+	return nil
+}
+
+// supportedSubsystems returns the sorted subsystem tags.
+func supportedSubsystems() []string {
+	tags := make([]string, 0, len(subsystemLoggers))
+	for tag := range subsystemLoggers {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// newLogWriter returns a writer that outputs log entries in the given
+// format, labeled with the site and instance of the seeder in JSON.
+func newLogWriter(w io.WriteCloser, format string, labels siteLabels) io.WriteCloser {
+	if format == logFormatJSON {
+		return &jsonLogWriter{out: w, labels: labels}
+	}
+	return w
+}
+
+// jsonLogEntry is a single log entry as written by jsonLogWriter.
+type jsonLogEntry struct {
+	Time      string `json:"time"`
+	Level     string `json:"level"`
+	Subsystem string `json:"subsystem"`
+	Message   string `json:"message"`
+	Site      string `json:"site,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+}
+
+// jsonLogWriter converts the entries written by the logger backend, which
+// are formatted as "YYYY-MM-DD hh:mm:ss.sss [LVL] TAG: message", into JSON
+// lines.
+type jsonLogWriter struct {
+	out    io.WriteCloser
+	labels siteLabels
+}
+
+const logTimeLayout = "2006-01-02 15:04:05.000"
+
+func (w *jsonLogWriter) Write(p []byte) (int, error) {
+	entry := parseLogEntry(p)
+	entry.Site = w.labels.site
+	entry.Instance = w.labels.instance
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return 0, err
+	}
+	_, err = w.out.Write(append(line, '\n'))
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *jsonLogWriter) Close() error {
+	return w.out.Close()
+}
+
+// parseLogEntry splits a text log entry into its parts. Entries that don't
+// match the expected format are kept whole as the message.
+func parseLogEntry(p []byte) *jsonLogEntry {
+	text := string(bytes.TrimRight(p, "\n"))
+	entry := &jsonLogEntry{Message: text}
+
+	if len(text) < len(logTimeLayout)+1 {
+		return entry
+	}
+	t, err := time.ParseInLocation(logTimeLayout, text[:len(logTimeLayout)], time.Local)
+	if err != nil {
+		return entry
+	}
+	rest := text[len(logTimeLayout)+1:]
+
+	if !strings.HasPrefix(rest, "[") {
+		return entry
+	}
+	end := strings.Index(rest, "] ")
+	if end < 0 {
+		return entry
+	}
+	level := rest[1:end]
+	rest = rest[end+2:]
+
+	colon := strings.Index(rest, ": ")
+	if colon < 0 {
+		return entry
+	}
 
-	log.SetLevel(logger.LevelDebug)
+	entry.Time = t.Format(time.RFC3339Nano)
+	entry.Level = level
+	entry.Subsystem = rest[:colon]
+	entry.Message = rest[colon+2:]
+	return entry
 }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/karlsen-network/karlsend/infrastructure/logger"
+)
+
+func TestParseLogLevels(t *testing.T) {
+	levels, err := parseLogLevels("warn,dns=debug,CRWL=trace")
+	if err != nil {
+		t.Fatalf("parseLogLevels: %s", err)
+	}
+	if levels["DNS"] != logger.LevelDebug || levels["CRWL"] != logger.LevelTrace ||
+		levels["AMGR"] != logger.LevelWarn || levels["SEED"] != logger.LevelWarn {
+		t.Errorf("unexpected levels: %v", levels)
+	}
+
+	_, err = parseLogLevels("FOO=info")
+	if err == nil {
+		t.Errorf("expected an error for an unknown subsystem")
+	}
+	_, err = parseLogLevels("loud")
+	if err == nil {
+		t.Errorf("expected an error for an unknown level")
+	}
+}
+
+func TestParseLogEntry(t *testing.T) {
+	entry := parseLogEntry([]byte("2023-04-05 06:07:08.009 [INF] DNS: 127.0.0.1:53: query 1\n"))
+	if entry.Level != "INF" || entry.Subsystem != "DNS" || entry.Message != "127.0.0.1:53: query 1" {
+		t.Errorf("unexpected entry: %+v", entry)
+	}
+	if entry.Time == "" {
+		t.Errorf("expected the entry time to be parsed")
+	}
+
+	entry = parseLogEntry([]byte("not a log line\n"))
+	if entry.Message != "not a log line" || entry.Subsystem != "" {
+		t.Errorf("unexpected entry: %+v", entry)
+	}
+}
+
+func TestPruneLogs(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "dnsseeder.log")
+	old := logFile + ".1.gz"
+	recent := logFile + ".2.gz"
+	for _, name := range []string{old, recent} {
+		err := os.WriteFile(name, nil, 0600)
+		if err != nil {
+			t.Fatalf("WriteFile: %s", err)
+		}
+	}
+	lastWeek := time.Now().Add(-7 * 24 * time.Hour)
+	err := os.Chtimes(old, lastWeek, lastWeek)
+	if err != nil {
+		t.Fatalf("Chtimes: %s", err)
+	}
+
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		pruneLogs(24*time.Hour, quit, logFile)
+		close(done)
+	}()
+	close(quit)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected pruning to stop once quit is closed")
+	}
+
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("expected the old rolled log to be removed, got %v", err)
+	}
+	if _, err := os.Stat(recent); err != nil {
+		t.Errorf("expected the recent rolled log to be kept, got %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// crawlPaused and maintenance are set through the admin service. While
+// crawling is paused no node is polled, and peers are served from the
+// existing pool. In maintenance mode, only the always-served addresses are
+// served. They must be accessed atomically.
+var (
+	crawlPaused int32
+	maintenance int32
+)
+
+// crawlFrozenAt is the crawl clock reading crawling was paused at, in unix
+// nanoseconds, or zero while it isn't. crawlPausedFor is the total time
+// crawling was paused for before, in nanoseconds. They must be accessed
+// atomically.
+var (
+	crawlFrozenAt  int64
+	crawlPausedFor int64
+)
+
+func setCrawlPaused(paused bool) {
+	now := time.Now()
+	if paused {
+		atomic.CompareAndSwapInt64(&crawlFrozenAt, 0, crawlClock(now).UnixNano())
+	} else if frozenAt := atomic.LoadInt64(&crawlFrozenAt); frozenAt != 0 {
+		// The total is updated first, so the clock never jumps forward.
+		atomic.StoreInt64(&crawlPausedFor, now.UnixNano()-frozenAt)
+		atomic.StoreInt64(&crawlFrozenAt, 0)
+	}
+	atomic.StoreInt32(&crawlPaused, boolToInt32(paused))
+}
+
+// crawlClock returns the time the age of peers is measured at: now, less
+// the time crawling was paused for, since peers can't be reached again
+// meanwhile and the pool served would otherwise age out. The clock stands
+// still while crawling is paused, and goes on from there once it resumes.
+func crawlClock(now time.Time) time.Time {
+	frozenAt := atomic.LoadInt64(&crawlFrozenAt)
+	clock := now.Add(-time.Duration(atomic.LoadInt64(&crawlPausedFor)))
+	if frozenAt != 0 {
+		if frozen := time.Unix(0, frozenAt); frozen.Before(clock) {
+			return frozen
+		}
+	}
+	return clock
+}
+
+func isCrawlPaused() bool {
+	return atomic.LoadInt32(&crawlPaused) != 0
+}
+
+func setMaintenance(enabled bool) {
+	atomic.StoreInt32(&maintenance, boolToInt32(enabled))
+}
+
+func inMaintenance() bool {
+	return atomic.LoadInt32(&maintenance) != 0
+}
+
+// staticAnswers, when not empty, are served in every answer instead of the
+// crawled peers, so client bootstrap can be tested deterministically. They
+// are set with --staticanswers or through the admin service.
+var (
+	staticAnswersMtx sync.RWMutex
+	staticAnswers    []net.IP
+)
+
+func setStaticAnswers(ips []net.IP) {
+	staticAnswersMtx.Lock()
+	defer staticAnswersMtx.Unlock()
+	staticAnswers = ips
+}
+
+func getStaticAnswers() []net.IP {
+	staticAnswersMtx.RLock()
+	defer staticAnswersMtx.RUnlock()
+	return staticAnswers
+}
+
+// parseStaticAnswers parses the IP addresses of the static answers.
+func parseStaticAnswers(addresses []string) ([]net.IP, error) {
+	ips := make([]net.IP, 0, len(addresses))
+	for _, address := range addresses {
+		ip := net.ParseIP(strings.TrimSpace(address))
+		if ip == nil {
+			return nil, errors.Errorf("invalid static answer %s: it must be an IP address", address)
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+// waitWhileCrawlPaused blocks while crawling is paused. It returns false if
+// the seeder is shutting down.
+func waitWhileCrawlPaused() bool {
+	logged := false
+	for isCrawlPaused() {
+		if !logged {
+			crawlLog.Infof("Crawling paused")
+			logged = true
+		}
+		if atomic.LoadInt32(&systemShutdown) != 0 {
+			return false
+		}
+		time.Sleep(time.Second)
+	}
+	if logged {
+		crawlLog.Infof("Crawling resumed")
+	}
+	return true
+}
@@ -5,17 +5,23 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"math/rand"
 	"net"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/karlsen-network/karlsend/infrastructure/network/addressmanager"
 
+	"github.com/karlsen-network/dnsseeder/seeddns"
 	"github.com/karlsen-network/karlsend/app/appmessage"
 	"github.com/karlsen-network/karlsend/domain/consensus/model/externalapi"
+	"github.com/karlsen-network/karlsend/domain/dagconfig"
 	"github.com/miekg/dns"
 	"github.com/pkg/errors"
 )
@@ -27,6 +33,138 @@ type Node struct {
 	LastSuccess  time.Time
 	LastSeen     time.Time
 	SubnetworkID *externalapi.DomainSubnetworkID
+
+	// UserAgent, ProtocolVersion and Services are taken from the version
+	// message the node sent during its last successful handshake.
+	UserAgent       string
+	ProtocolVersion uint32
+	Services        appmessage.ServiceFlag
+
+	// Demoted is set by the garbage collector on once-good nodes that
+	// have not been reached for a while. Demoted nodes are retried less
+	// often until they are reached again.
+	Demoted bool
+
+	// InjectedUntil is set on nodes an operator injected into the good
+	// pool. They are served, and kept by the garbage collector, until then
+	// whatever their crawl state.
+	InjectedUntil time.Time
+
+	// SnapshotUntil is set on nodes listed in the signed snapshot of a
+	// trusted seeder. They are served until then, with a lower weight than
+	// the nodes reached by this seeder, unless a crawl fails first.
+	SnapshotUntil time.Time
+
+	// Uptime holds the reliability of the node over each of the
+	// uptimeWindows, as of UptimeUpdated, and Latency the duration of
+	// its last successful handshake.
+	Uptime        []uptimeStat
+	UptimeUpdated time.Time
+	Latency       time.Duration
+
+	// Reliability is the estimate, between 0 and 1, of the node being
+	// reachable as of ReliabilityUpdated, with the configured half-life.
+	// Reliable is set when it last crossed the promotion threshold, and
+	// cleared when it last crossed the demotion one.
+	Reliability        float64
+	ReliabilityUpdated time.Time
+	Reliable           bool
+
+	// History holds the last crawl attempts of the node, oldest first, up
+	// to the configured history size.
+	History []crawlAttempt `json:",omitempty"`
+}
+
+// isGood returns whether the node was successfully reached recently enough
+// to be served to clients.
+func (n *Node) isGood(now time.Time) bool {
+	if now.Before(n.InjectedUntil) {
+		return true
+	}
+	return n.isVerified(now) || now.Before(n.SnapshotUntil)
+}
+
+// isVerified returns whether the seeder itself reached the node recently
+// enough for it to be served, or, when the reliability estimate is enabled,
+// reliably enough. Nodes without an estimate yet, such as those learned
+// from other seeders, are judged by their last success. Ages are measured by
+// crawlClock, so they stop growing while crawling is paused.
+func (n *Node) isVerified(now time.Time) bool {
+	if n.LastSuccess.IsZero() || n.Demoted {
+		return false
+	}
+	now = crawlClock(now)
+	if halfLife, _, demote := reliabilityParams(); halfLife > 0 && !n.ReliabilityUpdated.IsZero() {
+		return n.Reliable && n.reliabilityAt(now, halfLife) >= demote
+	}
+	return now.Sub(n.LastSuccess) <= crawlInterval()
+}
+
+// isSnapshotOnly returns whether the node is only served on the word of a
+// trusted seeder's snapshot.
+func (n *Node) isSnapshotOnly(now time.Time) bool {
+	return now.Before(n.SnapshotUntil) && !now.Before(n.InjectedUntil) && !n.isVerified(now)
+}
+
+// isPartial returns whether the node advertised, in its last version
+// message, that it doesn't keep the full DAG history.
+func (n *Node) isPartial() bool {
+	return n.ProtocolVersion != 0 && n.Services&appmessage.SFNodeNetwork == 0
+}
+
+// possibleServices returns the services the node may provide: those it
+// advertised in its last version message, or all of them if it was never
+// reached and its services are unknown.
+func (n *Node) possibleServices() appmessage.ServiceFlag {
+	if n.ProtocolVersion == 0 {
+		return ^appmessage.ServiceFlag(0)
+	}
+	return n.Services
+}
+
+// hasServices returns whether the node may provide all of services.
+func (n *Node) hasServices(services appmessage.ServiceFlag) bool {
+	return n.possibleServices()&services == services
+}
+
+// crawlInterval returns the configured interval between crawls of the same
+// node, falling back to defaultStaleTimeout when none is configured.
+func crawlInterval() time.Duration {
+	cfg := ActiveConfig()
+	if cfg == nil || cfg.CrawlInterval <= 0 {
+		return defaultStaleTimeout
+	}
+	return cfg.CrawlInterval
+}
+
+// maxServedAge returns the configured maximum time since a peer was last
+// reached for it to be served, or 0 if there is none.
+func maxServedAge() time.Duration {
+	cfg := ActiveConfig()
+	if cfg == nil {
+		return 0
+	}
+	return cfg.MaxServedAge
+}
+
+// isFresh returns whether the node was reached within maxAge of now, which
+// every served peer must be when maxAge is positive.
+func (n *Node) isFresh(now time.Time, maxAge time.Duration) bool {
+	return maxAge <= 0 || reachedWithin(n.LastSuccess, now, maxAge)
+}
+
+// reachedWithin returns whether a peer last reached at lastSuccess, zero if
+// never, was reached within maxAge of now, as measured by crawlClock.
+func reachedWithin(lastSuccess time.Time, now time.Time, maxAge time.Duration) bool {
+	return !lastSuccess.IsZero() && crawlClock(now).Sub(lastSuccess) <= maxAge
+}
+
+// gcResult holds the outcome of a single garbage collection run.
+type gcResult struct {
+	demoted            int
+	removedGood        int
+	removedUnreachable int
+	remaining          int
 }
 
 // Manager is dnsseeder's main worker-type, storing all information required
@@ -35,19 +173,97 @@ type Manager struct {
 	mtx sync.RWMutex
 
 	nodes     map[string]*Node
+	bans      *BanManager
 	wg        sync.WaitGroup
 	quit      chan struct{}
 	peersFile string
+
+	// netParams and defaultPort are those of the network the nodes
+	// belong to.
+	netParams   *dagconfig.Params
+	defaultPort uint16
+
+	// crawlQueue holds addresses that were requested to be crawled
+	// immediately. crawlSignal is notified whenever it is appended to.
+	crawlQueue  []*appmessage.NetAddress
+	crawlSignal chan struct{}
+
+	// warm is set once the crawler completed its first pass over the
+	// known nodes.
+	warm int32
+
+	// alwaysServe holds operator-trusted addresses included in every
+	// answer, whatever their crawl state.
+	alwaysServe []net.IP
+
+	// canaries are the nodes checked on a tight schedule to monitor
+	// their availability and latency.
+	canaries []*canary
+
+	// scorer decides which good peers are served, and how often. The
+	// default scorer is used when it is nil.
+	scorer Scorer
+
+	// experiment, when set, serves part of the clients with another
+	// scorer, recording the metrics of both.
+	experiment *answerExperiment
+
+	// churn, when set, is the analysis of the network reporting suspected
+	// partitions.
+	churn *churnState
+
+	// keepalive, when set, keeps persistent connections to the peers
+	// served most often.
+	keepalive *keepalivePool
+
+	// graph, when set, records which peers advertised which addresses.
+	graph *peerGraph
+
+	// zones holds the usage of the zones of the network, by host.
+	zones map[string]*zoneUsage
+
+	// maxPeers, if not zero, is the maximum number of known peers, and
+	// peersOverQuota the number of addresses dropped because of it, which
+	// must be accessed atomically.
+	maxPeers       int
+	peersOverQuota uint64
+
+	// clock, when set, tells the time crawls are recorded at instead of
+	// the system clock, for simulations.
+	clock func() time.Time
+
+	// tips remembers when the blocks announced by the crawled peers were
+	// first announced, to reject the peers lagging behind.
+	tips *tipTracker
+
+	// rotation makes consecutive answers to the same source cycle through
+	// the whole pool of good peers.
+	rotation *answerRotation
+
+	// failures counts the failed crawl attempts of the network by reason.
+	failures crawlFailureCounts
+
+	// servingIndex, when set, holds the peers answers are drawn from
+	// instead of the nodes, on read-only replicas.
+	servingIndex *servingIndex
 }
 
 const (
 	// defaultMaxAddresses is the maximum number of addresses to return.
-	defaultMaxAddresses = 16
+	defaultMaxAddresses = seeddns.MaxAddresses
+
+	// maxAnswerCountLimit is the highest number of addresses clients can
+	// be allowed to request per answer.
+	maxAnswerCountLimit = 256
 
 	// defaultStaleTimeout is the time in which a host is considered
-	// stale.
+	// stale when no crawl interval is configured.
 	defaultStaleTimeout = time.Hour
 
+	// demotedStaleTimeout is the time in which a demoted host is
+	// considered stale.
+	demotedStaleTimeout = time.Hour * 6
+
 	// dumpAddressInterval is the interval used to dump the address
 	// cache to disk for future use.
 	dumpAddressInterval = time.Second * 30
@@ -58,27 +274,40 @@ const (
 	// pruneAddressInterval is the interval used to run the address
 	// pruner.
 	pruneAddressInterval = time.Minute * 1
-
-	// pruneExpireTimeout is the expire time in which a node is
-	// considered dead.
-	pruneExpireTimeout = time.Hour * 8
 )
 
-// NewManager constructs and returns a new dnsseeder manager, with the provided dataDir
+// NewManager constructs and returns a new dnsseeder manager for the active
+// network, with the provided dataDir
 func NewManager(dataDir string) (*Manager, error) {
+	return NewNetworkManager(dataDir, ActiveConfig().NetParams(), peersDefaultPort)
+}
+
+// NewNetworkManager constructs and returns a new dnsseeder manager for the
+// given network, with the provided dataDir. Only nodes listening on
+// defaultPort are served.
+func NewNetworkManager(dataDir string, netParams *dagconfig.Params, defaultPort int) (*Manager, error) {
 	amgr := Manager{
-		nodes:     make(map[string]*Node),
-		peersFile: filepath.Join(dataDir, peersFilename),
-		quit:      make(chan struct{}),
+		nodes:       make(map[string]*Node),
+		bans:        NewBanManager(),
+		peersFile:   filepath.Join(dataDir, peersFilename),
+		quit:        make(chan struct{}),
+		crawlSignal: make(chan struct{}, 1),
+		netParams:   netParams,
+		defaultPort: uint16(defaultPort),
+		rotation:    newAnswerRotation(),
+		tips:        newTipTracker(),
 	}
 
 	err := amgr.deserializePeers()
+	if errors.Is(err, errPeersFileTooNew) {
+		return nil, err
+	}
 	if err != nil {
-		log.Warnf("Failed to parse file %s: %v", amgr.peersFile, err)
+		amgrLog.Warnf("Failed to parse file %s: %v", amgr.peersFile, err)
 		// if it is invalid we nuke the old one unconditionally.
 		err = os.Remove(amgr.peersFile)
 		if err != nil {
-			log.Warnf("Failed to remove corrupt peers file %s: %v",
+			amgrLog.Warnf("Failed to remove corrupt peers file %s: %v",
 				amgr.peersFile, err)
 		}
 	}
@@ -96,19 +325,25 @@ func (m *Manager) AddAddresses(addrs []*appmessage.NetAddress) int {
 
 	m.mtx.Lock()
 	for _, addr := range addrs {
-		if !addressmanager.IsRoutable(addr, ActiveConfig().NetParams().AcceptUnroutable) {
+		if !addressmanager.IsRoutable(addr, m.netParams.AcceptUnroutable) {
+			continue
+		}
+		if m.bans.IsBanned(addr.IP) || !isAllowed(addr.IP) {
 			continue
 		}
 		addrStr := addr.IP.String()
 
 		_, exists := m.nodes[addrStr]
 		if exists {
-			m.nodes[addrStr].LastSeen = time.Now()
+			m.nodes[addrStr].LastSeen = m.now()
+			continue
+		}
+		if m.atPeerQuota() {
 			continue
 		}
 		node := Node{
 			Addr:     addr,
-			LastSeen: time.Now(),
+			LastSeen: m.now(),
 		}
 		m.nodes[addrStr] = &node
 		count++
@@ -118,19 +353,49 @@ func (m *Manager) AddAddresses(addrs []*appmessage.NetAddress) int {
 	return count
 }
 
-// Addresses returns IPs that need to be tested again.
+// Addresses returns IPs that need to be tested again. Addresses queued with
+// ForceCrawl are returned first.
 func (m *Manager) Addresses() []*appmessage.NetAddress {
 	addrs := make([]*appmessage.NetAddress, 0, defaultMaxAddresses*8)
 	now := time.Now()
 	i := defaultMaxAddresses
 
+	m.mtx.Lock()
+	addrs = append(addrs, m.crawlQueue...)
+	m.crawlQueue = nil
+	m.mtx.Unlock()
+
+	// During the warm-up, the nodes that were good are crawled before the
+	// others, which only fill the rounds they leave room in.
+	prioritizeGood := warmup.active(now)
+	var others []*appmessage.NetAddress
+
+	interval := crawlInterval()
+	// With a maximum served age, good nodes are crawled again within half
+	// of it, so they are verified again before they stop being served.
+	goodInterval := interval
+	if maxAge := maxServedAge(); maxAge > 0 && maxAge/2 < goodInterval {
+		goodInterval = maxAge / 2
+	}
 	m.mtx.RLock()
 	for _, node := range m.nodes {
 		if i == 0 {
 			break
 		}
-		if now.Sub(node.LastSuccess) < defaultStaleTimeout ||
-			now.Sub(node.LastAttempt) < defaultStaleTimeout {
+		staleTimeout := interval
+		if node.Demoted {
+			staleTimeout = demotedStaleTimeout
+		} else if goodInterval < interval && node.isGood(now) {
+			staleTimeout = goodInterval
+		}
+		if now.Sub(node.LastSuccess) < staleTimeout ||
+			now.Sub(node.LastAttempt) < staleTimeout {
+			continue
+		}
+		if prioritizeGood && (node.LastSuccess.IsZero() || node.Demoted) {
+			if len(others) < i {
+				others = append(others, node.Addr)
+			}
 			continue
 		}
 		addrs = append(addrs, node.Addr)
@@ -138,7 +403,10 @@ func (m *Manager) Addresses() []*appmessage.NetAddress {
 	}
 	m.mtx.RUnlock()
 
-	return addrs
+	if len(others) > i {
+		others = others[:i]
+	}
+	return append(addrs, others...)
 }
 
 // AddressCount returns number of known nodes.
@@ -146,29 +414,215 @@ func (m *Manager) AddressCount() int {
 	return len(m.nodes)
 }
 
+// Counts returns the number of known nodes and how many of them are
+// currently good.
+func (m *Manager) Counts() (known int, good int) {
+	return m.countsAt(time.Now())
+}
+
+// countsAt returns the number of known nodes and how many of them are good
+// at now.
+func (m *Manager) countsAt(now time.Time) (known int, good int) {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	for _, node := range m.nodes {
+		if node.isGood(now) {
+			good++
+		}
+	}
+	return len(m.nodes), good
+}
+
+// Nodes returns a copy of all known nodes, sorted by IP.
+func (m *Manager) Nodes() []Node {
+	m.mtx.RLock()
+	nodes := make([]Node, 0, len(m.nodes))
+	for _, node := range m.nodes {
+		nodes = append(nodes, *node)
+	}
+	m.mtx.RUnlock()
+
+	sort.Slice(nodes, func(i, j int) bool {
+		return bytes.Compare(nodes[i].Addr.IP.To16(), nodes[j].Addr.IP.To16()) < 0
+	})
+	return nodes
+}
+
+// Node returns a copy of the node with the given IP, if it is known.
+func (m *Manager) Node(ip net.IP) (Node, bool) {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	node, exists := m.nodes[ip.String()]
+	if !exists {
+		return Node{}, false
+	}
+	return *node, true
+}
+
 // GoodAddresses returns good working IPs that match both the
-// passed DNS query type and have the requested services.
+// passed DNS query type and have the requested services. The always-served
+// addresses come first; the others are kept within the configured per
+// country, per continent and per AS limits. In maintenance mode, only the
+// always-served addresses are returned.
+//
+// Partial nodes, which don't keep the full DAG history, are only returned
+// when partial is set, and then exclusively. Nodes that advertised services
+// without all of services are left out.
+//
+// Which peers are served, and how often, is decided by the scorer of the
+// network. When static answers are set, exactly those of the address family
+// of the query are returned instead.
+//
+// Consecutive answers to the same source cycle through the whole pool of
+// good peers. A nil source gets a random part of the pool. At most count
+// addresses are returned, static answers aside.
 func (m *Manager) GoodAddresses(qtype uint16, includeAllSubnetworks bool, subnetworkID *externalapi.DomainSubnetworkID,
-) []*appmessage.NetAddress {
-	addrs := make([]*appmessage.NetAddress, 0, defaultMaxAddresses)
-	i := defaultMaxAddresses
+	partial bool, services appmessage.ServiceFlag, source net.IP, count int) []*appmessage.NetAddress {
+
+	if static := getStaticAnswers(); len(static) > 0 && (qtype == dns.TypeA || qtype == dns.TypeAAAA) {
+		addrs := make([]*appmessage.NetAddress, 0, len(static))
+		for _, ip := range static {
+			if (qtype == dns.TypeA) == (ip.To4() != nil) {
+				addrs = append(addrs, appmessage.NewNetAddressIPPort(ip, m.defaultPort))
+			}
+		}
+		return addrs
+	}
+
+	return m.goodAddresses(qtype, includeAllSubnetworks, subnetworkID, partial, services, source, count, time.Now())
+}
+
+// goodAddresses selects the addresses of an answer to a query made at now,
+// as GoodAddresses does, ignoring static answers.
+func (m *Manager) goodAddresses(qtype uint16, includeAllSubnetworks bool, subnetworkID *externalapi.DomainSubnetworkID,
+	partial bool, services appmessage.ServiceFlag, source net.IP, count int, now time.Time) []*appmessage.NetAddress {
+
+	return m.selectAddresses(qtype, includeAllSubnetworks, subnetworkID, partial, services, source, count, now, nil)
+}
+
+// selectAddresses selects the addresses of an answer as goodAddresses does.
+// With a non nil explanation, the answer is a dry run: why each candidate
+// was or wasn't selected is recorded in explanation, and the answer isn't
+// counted by the rotation, the experiment or the keepalive pool.
+func (m *Manager) selectAddresses(qtype uint16, includeAllSubnetworks bool,
+	subnetworkID *externalapi.DomainSubnetworkID, partial bool, services appmessage.ServiceFlag, source net.IP,
+	count int, now time.Time, explanation *answerExplanation) []*appmessage.NetAddress {
+
+	addrs := make([]*appmessage.NetAddress, 0, count)
+	i := count
 
 	if qtype != dns.TypeA && qtype != dns.TypeAAAA {
 		return addrs
 	}
 
-	now := time.Now()
+	diversity := newDiversityFilter()
 	m.mtx.RLock()
-	for _, node := range m.nodes {
-		if i == 0 {
-			break
+
+	served := make(map[string]bool)
+	if !partial && (includeAllSubnetworks || subnetworkID == nil) {
+		for _, ip := range m.alwaysServe {
+			if i == 0 {
+				break
+			}
+			if (qtype == dns.TypeA) != (ip.To4() != nil) {
+				continue
+			}
+			addr := appmessage.NewNetAddressIPPort(ip, m.defaultPort)
+			if m.bans.IsBanned(ip) {
+				explanation.exclude(addr, 0, "always served, but banned")
+				continue
+			}
+			if !isAllowed(ip) {
+				explanation.exclude(addr, 0, "always served, but outside of the allowed networks")
+				continue
+			}
+			explanation.include(addr, "always served")
+			addrs = append(addrs, addr)
+			served[ip.String()] = true
+			i--
 		}
+	}
 
-		if node.Addr.Port != uint16(peersDefaultPort) {
+	var pool []scoredPeer
+	var maxWeight float64
+	var arm *experimentArm
+	experiment := m.experiment
+	keepalive := m.keepalive
+	if i > 0 && !inMaintenance() {
+		if m.servingIndex != nil {
+			pool, maxWeight = m.servingIndex.pool(m.netParams.Name, qtype, includeAllSubnetworks, subnetworkID,
+				partial, services, served, now, explanation)
+		} else {
+			candidates := m.answerCandidates(qtype, includeAllSubnetworks, subnetworkID, partial, services, served,
+				explanation)
+			scorer := m.scorer
+			if experiment != nil {
+				arm = experiment.arm(source)
+				scorer = arm.scorer
+				explanation.setArm(arm.name)
+			}
+			pool, maxWeight = m.scorePeersWith(scorer, candidates, now, explanation)
+		}
+	}
+	m.mtx.RUnlock()
+
+	if len(pool) == 0 {
+		return addrs
+	}
+
+	sortScoredPeers(pool)
+	start := m.rotation.position(source, qtype, now) % len(pool)
+	scanned := 0
+	for ; scanned < len(pool) && i > 0; scanned++ {
+		peer := pool[(start+scanned)%len(pool)]
+		if peer.weight < maxWeight && rand.Float64()*maxWeight >= peer.weight {
+			explanation.exclude(peer.addr, peer.services, "left out by weighted sampling")
+			continue
+		}
+		if limit := diversity.check(peer.addr.IP); limit != "" {
+			explanation.exclude(peer.addr, peer.services, "over the per answer "+limit+" limit")
 			continue
 		}
+		explanation.include(peer.addr, "selected")
+		addrs = append(addrs, peer.addr)
+		i--
+	}
+	if explanation != nil {
+		for ; scanned < len(pool); scanned++ {
+			peer := pool[(start+scanned)%len(pool)]
+			explanation.exclude(peer.addr, peer.services, "answer already full")
+		}
+		return addrs
+	}
+	m.rotation.advance(source, qtype, scanned)
 
-		if !includeAllSubnetworks && !node.SubnetworkID.Equal(subnetworkID) {
+	if arm != nil && source != nil {
+		selected := make([]net.IP, 0, len(addrs)-len(served))
+		for _, addr := range addrs[len(served):] {
+			selected = append(selected, addr.IP)
+		}
+		experiment.recordAnswer(arm, selected)
+	}
+	if keepalive != nil {
+		keepalive.recordServed(addrs[len(served):])
+	}
+
+	return addrs
+}
+
+// answerCandidates returns the nodes an answer to a qtype query may be
+// drawn from, leaving out those in served and those without services. Why
+// the nodes of the address family of the query are left out is recorded in
+// explanation, if not nil. The caller must hold the lock.
+func (m *Manager) answerCandidates(qtype uint16, includeAllSubnetworks bool,
+	subnetworkID *externalapi.DomainSubnetworkID, partial bool, services appmessage.ServiceFlag,
+	served map[string]bool, explanation *answerExplanation) []*Node {
+
+	var candidates []*Node
+	for _, node := range m.nodes {
+		if served[node.Addr.IP.String()] {
 			continue
 		}
 
@@ -178,17 +632,281 @@ func (m *Manager) GoodAddresses(qtype uint16, includeAllSubnetworks bool, subnet
 			continue
 		}
 
-		if node.LastSuccess.IsZero() ||
-			now.Sub(node.LastSuccess) > defaultStaleTimeout {
+		if node.Addr.Port != m.defaultPort {
+			explanation.exclude(node.Addr, node.possibleServices(), "not on the default port")
 			continue
 		}
 
-		addrs = append(addrs, node.Addr)
-		i--
+		if !includeAllSubnetworks && !node.SubnetworkID.Equal(subnetworkID) {
+			explanation.exclude(node.Addr, node.possibleServices(), "in another subnetwork")
+			continue
+		}
+
+		if node.isPartial() != partial {
+			reason := "partial node"
+			if partial {
+				reason = "full node"
+			}
+			explanation.exclude(node.Addr, node.possibleServices(), reason)
+			continue
+		}
+
+		if !node.hasServices(services) {
+			explanation.exclude(node.Addr, node.possibleServices(), missingServicesReason(services))
+			continue
+		}
+
+		candidates = append(candidates, node)
 	}
-	m.mtx.RUnlock()
+	return candidates
+}
 
-	return addrs
+// SetAlwaysServe replaces the addresses included in every answer, and adds
+// them to the known nodes so they are crawled as well.
+func (m *Manager) SetAlwaysServe(ips []net.IP) {
+	addrs := make([]*appmessage.NetAddress, len(ips))
+	for i, ip := range ips {
+		addrs[i] = appmessage.NewNetAddressIPPort(ip, m.defaultPort)
+	}
+	m.AddAddresses(addrs)
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.alwaysServe = ips
+}
+
+// SetCanaries sets the canaries of the network, and adds them to the known
+// nodes so they are crawled like any other peer.
+func (m *Manager) SetCanaries(canaries []*canary) {
+	addrs := make([]*appmessage.NetAddress, len(canaries))
+	for i, c := range canaries {
+		addrs[i] = c.addr
+	}
+	m.AddAddresses(addrs)
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.canaries = canaries
+}
+
+// Canaries returns the canaries of the network.
+func (m *Manager) Canaries() []*canary {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	return m.canaries
+}
+
+// Inject adds the given address to the good pool until goodUntil, and
+// queues it to be crawled as soon as possible. It returns an error if the
+// address is banned or outside of the allowed networks.
+func (m *Manager) Inject(addr *appmessage.NetAddress, goodUntil time.Time) error {
+	if m.bans.IsBanned(addr.IP) {
+		return errors.Errorf("address %s is banned", addr.IP)
+	}
+	if !isAllowed(addr.IP) {
+		return errors.Errorf("address %s is outside of the allowed networks", addr.IP)
+	}
+
+	m.mtx.Lock()
+	addrStr := addr.IP.String()
+	node, exists := m.nodes[addrStr]
+	if !exists {
+		node = &Node{LastSeen: time.Now()}
+		m.nodes[addrStr] = node
+	}
+	node.Addr = addr
+	node.InjectedUntil = goodUntil
+	m.mtx.Unlock()
+
+	return m.ForceCrawl(addr)
+}
+
+// ForceCrawl queues the given address to be crawled as soon as possible,
+// adding it to the known nodes if needed. An address already queued is not
+// queued again. It returns an error if the address is banned or outside of
+// the allowed networks.
+func (m *Manager) ForceCrawl(addr *appmessage.NetAddress) error {
+	if m.bans.IsBanned(addr.IP) {
+		return errors.Errorf("address %s is banned", addr.IP)
+	}
+	if !isAllowed(addr.IP) {
+		return errors.Errorf("address %s is outside of the allowed networks", addr.IP)
+	}
+
+	m.mtx.Lock()
+	addrStr := addr.IP.String()
+	if _, exists := m.nodes[addrStr]; !exists {
+		m.nodes[addrStr] = &Node{
+			Addr:     addr,
+			LastSeen: time.Now(),
+		}
+	}
+	queued := false
+	for _, queuedAddr := range m.crawlQueue {
+		if queuedAddr.IP.Equal(addr.IP) {
+			queued = true
+			break
+		}
+	}
+	if !queued {
+		m.crawlQueue = append(m.crawlQueue, addr)
+	}
+	m.mtx.Unlock()
+
+	select {
+	case m.crawlSignal <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Ban bans the given network and removes all matching nodes. It returns
+// the number of nodes removed.
+func (m *Manager) Ban(ipNet *net.IPNet, reason string, duration time.Duration) int {
+	m.bans.Ban(ipNet, reason, duration)
+
+	var count int
+	m.mtx.Lock()
+	for k, node := range m.nodes {
+		if ipNet.Contains(node.Addr.IP) {
+			delete(m.nodes, k)
+			count++
+		}
+	}
+	m.mtx.Unlock()
+
+	amgrLog.Infof("Banned %s (%s): %d nodes removed", ipNet, reason, count)
+	return count
+}
+
+// SetBanList replaces the bans loaded from the given ban list file or
+// blocklist feed with the given ones, and removes the nodes they cover. It
+// returns the number of nodes removed.
+func (m *Manager) SetBanList(source string, bans []*Ban) int {
+	m.bans.setBanList(source, bans)
+
+	var count int
+	m.mtx.Lock()
+	for k, node := range m.nodes {
+		if m.bans.IsBanned(node.Addr.IP) {
+			delete(m.nodes, k)
+			count++
+		}
+	}
+	m.mtx.Unlock()
+
+	amgrLog.Infof("Loaded %d entries from %s: %d nodes removed", len(bans), source, count)
+	return count
+}
+
+// Unban lifts the ban on the given network, and returns whether it was
+// banned.
+func (m *Manager) Unban(ipNet *net.IPNet) bool {
+	unbanned := m.bans.Unban(ipNet)
+	if unbanned {
+		amgrLog.Infof("Unbanned %s", ipNet)
+	}
+	return unbanned
+}
+
+// Bans returns all active bans.
+func (m *Manager) Bans() []Ban {
+	return m.bans.Bans()
+}
+
+// Flush removes all known nodes and persists the now empty database. It
+// returns the number of nodes removed.
+func (m *Manager) Flush() int {
+	m.mtx.Lock()
+	count := len(m.nodes)
+	m.nodes = make(map[string]*Node)
+	m.crawlQueue = nil
+	m.mtx.Unlock()
+
+	m.savePeers()
+	amgrLog.Infof("Flushed %d nodes", count)
+	return count
+}
+
+// Backup returns a consistent snapshot of all known nodes and of the bans set
+// through the admin service, in the format of the peers file.
+func (m *Manager) Backup() ([]byte, error) {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	data, err := json.Marshal(&peersFile{Version: peersFileVersion, Nodes: m.nodes, Bans: m.bans.savedBans()})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return data, nil
+}
+
+// Restore replaces all known nodes with those of a snapshot made by Backup,
+// possibly by an older version of the seeder, adds its bans as they are
+// added on startup, and saves them. Invalid, unroutable, banned and
+// disallowed nodes are left out, as they would be if they were advertised.
+// It returns the number of restored nodes and bans.
+func (m *Manager) Restore(data []byte) (int, int, error) {
+	file, _, err := decodePeersFile(data)
+	if err != nil {
+		return 0, 0, err
+	}
+	bans := m.bans.loadSavedBans(file.Bans)
+	nodes := make(map[string]*Node, len(file.Nodes))
+	for _, node := range file.Nodes {
+		if node == nil || node.Addr == nil || node.Addr.IP == nil ||
+			!addressmanager.IsRoutable(node.Addr, m.netParams.AcceptUnroutable) ||
+			m.bans.IsBanned(node.Addr.IP) || !isAllowed(node.Addr.IP) {
+			continue
+		}
+		nodes[node.Addr.IP.String()] = node
+	}
+
+	m.mtx.Lock()
+	m.nodes = nodes
+	m.crawlQueue = nil
+	m.mtx.Unlock()
+
+	m.savePeers()
+	amgrLog.Infof("Restored %d nodes and %d bans", len(nodes), bans)
+	return len(nodes), bans, nil
+}
+
+// setWarm records that the crawler completed its first pass.
+func (m *Manager) setWarm() {
+	atomic.StoreInt32(&m.warm, 1)
+}
+
+// isWarm returns whether the crawler completed its first pass.
+func (m *Manager) isWarm() bool {
+	return atomic.LoadInt32(&m.warm) != 0
+}
+
+// responsive returns whether the nodes lock can be taken within the given
+// timeout.
+func (m *Manager) responsive(timeout time.Duration) bool {
+	acquired := make(chan struct{})
+	go func() {
+		m.mtx.RLock()
+		m.mtx.RUnlock()
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// now returns the current time, as told by the clock of the manager if it
+// has one.
+func (m *Manager) now() time.Time {
+	if m.clock != nil {
+		return m.clock()
+	}
+	return time.Now()
 }
 
 // Attempt updates the last connection attempt for the specified ip address to now
@@ -196,22 +914,155 @@ func (m *Manager) Attempt(ip net.IP) {
 	m.mtx.Lock()
 	node, exists := m.nodes[ip.String()]
 	if exists {
-		node.LastAttempt = time.Now()
+		node.LastAttempt = m.now()
 	}
 	m.mtx.Unlock()
 }
 
-// Good updates the last successful connection attempt for the specified ip address to now
-func (m *Manager) Good(ip net.IP, subnetworkid *externalapi.DomainSubnetworkID) {
+// Good updates the last successful connection attempt for the specified ip
+// address to now. When msgVersion is not nil, the node's version details are
+// updated from it.
+func (m *Manager) Good(ip net.IP, msgVersion *appmessage.MsgVersion) {
 	m.mtx.Lock()
 	node, exists := m.nodes[ip.String()]
 	if exists {
-		node.LastSuccess = time.Now()
-		node.SubnetworkID = subnetworkid
+		node.LastSuccess = m.now()
+		node.Demoted = false
+		if msgVersion != nil {
+			node.SubnetworkID = msgVersion.SubnetworkID
+			node.UserAgent = msgVersion.UserAgent
+			node.ProtocolVersion = msgVersion.ProtocolVersion
+			node.Services = msgVersion.Services
+		}
 	}
 	m.mtx.Unlock()
 }
 
+// verifiedCursor is a position among the good nodes ordered by their last
+// success, in unix milliseconds as sent to other seeders, then by address,
+// so nodes reached within the same millisecond are still paged through.
+// An empty address is before every node of its millisecond.
+type verifiedCursor struct {
+	millis int64
+	ip     string
+}
+
+// cursorOf returns the position of node.
+func cursorOf(node *Node) verifiedCursor {
+	return verifiedCursor{millis: node.LastSuccess.UnixMilli(), ip: node.Addr.IP.String()}
+}
+
+// before returns whether c is before other.
+func (c verifiedCursor) before(other verifiedCursor) bool {
+	if c.millis != other.millis {
+		return c.millis < other.millis
+	}
+	return c.ip < other.ip
+}
+
+// VerifiedSince returns copies of the good nodes after since, oldest first,
+// up to limit of them. It also returns the position to ask from next: that
+// of the last returned node when the result was truncated, now otherwise.
+func (m *Manager) VerifiedSince(since verifiedCursor, limit int) ([]Node, verifiedCursor) {
+	now := time.Now()
+	var nodes []Node
+	m.mtx.RLock()
+	for _, node := range m.nodes {
+		if node.isGood(now) && since.before(cursorOf(node)) {
+			nodes = append(nodes, *node)
+		}
+	}
+	m.mtx.RUnlock()
+
+	sort.Slice(nodes, func(i, j int) bool {
+		return cursorOf(&nodes[i]).before(cursorOf(&nodes[j]))
+	})
+	if len(nodes) > limit {
+		nodes = nodes[:limit]
+		return nodes, cursorOf(&nodes[limit-1])
+	}
+	return nodes, verifiedCursor{millis: now.UnixMilli()}
+}
+
+// MergeVerified records that another seeder reached the given address
+// successfully at lastSuccess, adding it to the known nodes if needed. Times
+// in the future are capped to now. It returns whether anything changed.
+func (m *Manager) MergeVerified(addr *appmessage.NetAddress, subnetworkID *externalapi.DomainSubnetworkID,
+	lastSuccess time.Time) bool {
+
+	now := time.Now()
+	if lastSuccess.After(now) {
+		lastSuccess = now
+	}
+	if !addressmanager.IsRoutable(addr, m.netParams.AcceptUnroutable) || m.bans.IsBanned(addr.IP) ||
+		!isAllowed(addr.IP) {
+		return false
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	node, exists := m.nodes[addr.IP.String()]
+	if !exists {
+		if m.atPeerQuota() {
+			return false
+		}
+		node = &Node{Addr: addr, LastSeen: now}
+		m.nodes[addr.IP.String()] = node
+	}
+	if !node.LastSuccess.Before(lastSuccess) {
+		return !exists
+	}
+	node.LastSuccess = lastSuccess
+	node.SubnetworkID = subnetworkID
+	node.Demoted = false
+	return true
+}
+
+// MergeSnapshot records that a trusted seeder reached the given address
+// successfully at lastSuccess, as listed in its snapshot, adding it to the
+// known nodes if needed. The node is served until a crawl interval after
+// lastSuccess, unless this seeder failed to reach it since. Times in the
+// future are capped to now. It returns whether anything changed.
+func (m *Manager) MergeSnapshot(addr *appmessage.NetAddress, subnetworkID *externalapi.DomainSubnetworkID,
+	lastSuccess time.Time) bool {
+
+	now := time.Now()
+	if lastSuccess.After(now) {
+		lastSuccess = now
+	}
+	if !addressmanager.IsRoutable(addr, m.netParams.AcceptUnroutable) || m.bans.IsBanned(addr.IP) ||
+		!isAllowed(addr.IP) {
+		return false
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	node, exists := m.nodes[addr.IP.String()]
+	if !exists {
+		if m.atPeerQuota() {
+			return false
+		}
+		node = &Node{Addr: addr}
+		m.nodes[addr.IP.String()] = node
+	}
+	node.LastSeen = now
+	if lastSuccess.Before(node.LastAttempt) && node.LastSuccess.Before(node.LastAttempt) {
+		// This seeder failed to reach the node more recently.
+		return !exists
+	}
+	until := lastSuccess.Add(crawlInterval())
+	if !node.SnapshotUntil.Before(until) {
+		return !exists
+	}
+	node.SnapshotUntil = until
+	if node.LastSuccess.IsZero() {
+		node.SubnetworkID = subnetworkID
+	}
+	return true
+}
+
 // addressHandler is the main handler for the address manager. It must be run
 // as a goroutine.
 func (m *Manager) addressHandler() {
@@ -231,39 +1082,76 @@ out:
 			break out
 		}
 	}
-	log.Infof("Address manager: saving peers")
+	amgrLog.Infof("Address manager: saving peers")
 	m.savePeers()
-	log.Infof("Address manager shoutdown")
+	amgrLog.Infof("Address manager shoutdown")
 }
 
 func (m *Manager) prunePeers() {
-	var count int
-	now := time.Now()
-	m.mtx.Lock()
-
-	lastSeenAbovePruneExpire := func(node *Node) bool {
-		return now.Sub(node.LastSeen) > pruneExpireTimeout
+	expiredBans := m.bans.removeExpired()
+	if expiredBans > 0 {
+		amgrLog.Infof("Lifted %d expired bans", expiredBans)
 	}
-	hadAttemptsButNoSuccess := func(node *Node) bool {
-		return !node.LastAttempt.IsZero() && node.LastSuccess.IsZero()
-	}
-	hadSuccessButLongTimeAgo := func(node *Node) bool {
-		return !node.LastSuccess.IsZero() && now.Sub(node.LastSuccess) > pruneExpireTimeout
+
+	// Peers can't be reached again while crawling is paused, so none is
+	// demoted or removed for not being reached.
+	if isCrawlPaused() {
+		amgrLog.Infof("Crawling paused, not pruning addresses")
+		return
 	}
 
+	m.mtx.Lock()
+	result := m.collectGarbage(time.Now(), ActiveConfig().GCDemoteAfter,
+		ActiveConfig().GCGoodRetention, ActiveConfig().GCUnreachableRetention)
+	m.mtx.Unlock()
+
+	amgrLog.Infof("Pruned %d addresses (%d once-good, %d never-reachable), "+
+		"demoted %d: %d remaining", result.removedGood+result.removedUnreachable,
+		result.removedGood, result.removedUnreachable, result.demoted, result.remaining)
+}
+
+// collectGarbage demotes once-good nodes that have not been reached for
+// demoteAfter and deletes them once they have not been reached for
+// goodRetention. Nodes that were never reached are deleted once no peer has
+// advertised them for unreachableRetention. Injected nodes are kept until
+// their injection expires. Ages are measured by crawlClock, so the time
+// crawling was paused for doesn't count.
+//
+// This function MUST be called with the manager lock held (for writes).
+func (m *Manager) collectGarbage(now time.Time, demoteAfter, goodRetention,
+	unreachableRetention time.Duration) gcResult {
+
+	var result gcResult
+	clock := crawlClock(now)
 	for k, node := range m.nodes {
-		if lastSeenAbovePruneExpire(node) ||
-			hadAttemptsButNoSuccess(node) ||
-			hadSuccessButLongTimeAgo(node) {
+		if now.Before(node.InjectedUntil) {
+			continue
+		}
+		if node.LastSuccess.IsZero() {
+			if clock.Sub(node.LastSeen) > unreachableRetention {
+				delete(m.nodes, k)
+				result.removedUnreachable++
+			}
+			continue
+		}
 
+		sinceSuccess := clock.Sub(node.LastSuccess)
+		if sinceSuccess > goodRetention {
 			delete(m.nodes, k)
-			count++
+			result.removedGood++
+			continue
+		}
+		if !node.Demoted && sinceSuccess > demoteAfter {
+			node.Demoted = true
+			result.demoted++
 		}
 	}
-	l := len(m.nodes)
-	m.mtx.Unlock()
+	result.remaining = len(m.nodes)
+	if m.graph != nil {
+		m.graph.prune(m.nodes)
+	}
 
-	log.Infof("Pruned %d addresses: %d remaining", count, l)
+	return result
 }
 
 func (m *Manager) deserializePeers() error {
@@ -272,26 +1160,35 @@ func (m *Manager) deserializePeers() error {
 	if os.IsNotExist(err) {
 		return nil
 	}
-	r, err := os.Open(filePath)
-	if err != nil {
-		return errors.Errorf("%s error opening file: %v", filePath, err)
-	}
-	defer r.Close()
 
-	var nodes map[string]*Node
-	dec := json.NewDecoder(r)
-	err = dec.Decode(&nodes)
+	file, originalVersion, err := readPeersFile(filePath)
 	if err != nil {
-		return errors.Errorf("error reading %s: %v", filePath, err)
+		return err
+	}
+	if originalVersion != peersFileVersion {
+		err = backupPeersFile(filePath, originalVersion)
+		if err != nil {
+			return errors.Errorf("%s error backing up file: %v", filePath, err)
+		}
+		amgrLog.Infof("Migrated %s from version %d to version %d",
+			filePath, originalVersion, peersFileVersion)
 	}
 
-	l := len(nodes)
+	// Nodes learned before the seeder was restricted to the allowed
+	// networks are forgotten.
+	for key, node := range file.Nodes {
+		if node != nil && node.Addr != nil && !isAllowed(node.Addr.IP) {
+			delete(file.Nodes, key)
+		}
+	}
+	l := len(file.Nodes)
+	bans := m.bans.loadSavedBans(file.Bans)
 
 	m.mtx.Lock()
-	m.nodes = nodes
+	m.nodes = file.Nodes
 	m.mtx.Unlock()
 
-	log.Infof("%d nodes loaded", l)
+	amgrLog.Infof("%d nodes and %d bans loaded", l, bans)
 	return nil
 }
 
@@ -303,20 +1200,21 @@ func (m *Manager) savePeers() {
 	tmpfile := m.peersFile + ".new"
 	w, err := os.Create(tmpfile)
 	if err != nil {
-		log.Errorf("Error opening file %s: %v", tmpfile, err)
+		amgrLog.Errorf("Error opening file %s: %v", tmpfile, err)
 		return
 	}
 	enc := json.NewEncoder(w)
-	if err := enc.Encode(&m.nodes); err != nil {
-		log.Errorf("Failed to encode file %s: %v", tmpfile, err)
+	file := peersFile{Version: peersFileVersion, Nodes: m.nodes, Bans: m.bans.savedBans()}
+	if err := enc.Encode(&file); err != nil {
+		amgrLog.Errorf("Failed to encode file %s: %v", tmpfile, err)
 		return
 	}
 	if err := w.Close(); err != nil {
-		log.Errorf("Error closing file %s: %v", tmpfile, err)
+		amgrLog.Errorf("Error closing file %s: %v", tmpfile, err)
 		return
 	}
 	if err := os.Rename(tmpfile, m.peersFile); err != nil {
-		log.Errorf("Error writing file %s: %v", m.peersFile, err)
+		amgrLog.Errorf("Error writing file %s: %v", m.peersFile, err)
 		return
 	}
 }
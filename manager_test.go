@@ -0,0 +1,378 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/karlsen-network/karlsend/domain/dagconfig"
+	"github.com/miekg/dns"
+)
+
+// newTestManager returns an address manager of the given network without
+// any peers, saving them to a temporary directory.
+func newTestManager(t *testing.T, params *dagconfig.Params, defaultPort uint16) *Manager {
+	return &Manager{
+		nodes:       make(map[string]*Node),
+		bans:        NewBanManager(),
+		peersFile:   filepath.Join(t.TempDir(), peersFilename),
+		quit:        make(chan struct{}),
+		crawlSignal: make(chan struct{}, 1),
+		netParams:   params,
+		defaultPort: defaultPort,
+	}
+}
+
+// setTestConfig makes cfg the active configuration until the test ends,
+// when the previous one is restored, and returns it.
+func setTestConfig(t *testing.T, cfg *ConfigFlags) *ConfigFlags {
+	previous := ActiveConfig()
+	setActiveConfig(cfg)
+	t.Cleanup(func() { setActiveConfig(previous) })
+	return cfg
+}
+
+func TestCollectGarbage(t *testing.T) {
+	now := time.Now()
+	newNode := func(ip string, lastSeen, lastSuccess time.Time) *Node {
+		return &Node{
+			Addr:        appmessage.NewNetAddressIPPort(net.ParseIP(ip), 1313),
+			LastSeen:    lastSeen,
+			LastSuccess: lastSuccess,
+		}
+	}
+
+	m := newTestManager(t, &dagconfig.MainnetParams, 1313)
+	m.nodes = map[string]*Node{
+		"good":              newNode("1.0.0.1", now, now),
+		"demoted":           newNode("1.0.0.2", now, now.Add(-3*time.Hour)),
+		"good-expired":      newNode("1.0.0.3", now, now.Add(-30*time.Hour)),
+		"unreachable":       newNode("1.0.0.4", now.Add(-time.Hour), time.Time{}),
+		"unreachable-stale": newNode("1.0.0.5", now.Add(-5*time.Hour), time.Time{}),
+	}
+
+	result := m.collectGarbage(now, 2*time.Hour, 24*time.Hour, 4*time.Hour)
+
+	expected := gcResult{demoted: 1, removedGood: 1, removedUnreachable: 1, remaining: 3}
+	if result != expected {
+		t.Fatalf("unexpected gc result: got %+v, want %+v", result, expected)
+	}
+	for _, key := range []string{"good", "demoted", "unreachable"} {
+		if _, ok := m.nodes[key]; !ok {
+			t.Errorf("expected node %s to be retained", key)
+		}
+	}
+	if !m.nodes["demoted"].Demoted {
+		t.Errorf("expected node demoted to be demoted")
+	}
+	if m.nodes["good"].Demoted {
+		t.Errorf("expected node good not to be demoted")
+	}
+}
+
+func TestAlwaysServe(t *testing.T) {
+	now := time.Now()
+	m := newTestManager(t, &dagconfig.MainnetParams, 1313)
+	m.nodes["1.0.0.1"] = &Node{
+		Addr:        appmessage.NewNetAddressIPPort(net.ParseIP("1.0.0.1"), 1313),
+		LastSeen:    now,
+		LastSuccess: now,
+	}
+	m.SetAlwaysServe([]net.IP{net.ParseIP("8.8.8.8"), net.ParseIP("2001:4860::1")})
+
+	addrs := m.GoodAddresses(dns.TypeA, true, nil, false, 0, nil, defaultMaxAddresses)
+	if len(addrs) != 2 || !addrs[0].IP.Equal(net.ParseIP("8.8.8.8")) || !addrs[1].IP.Equal(net.ParseIP("1.0.0.1")) {
+		t.Errorf("expected the always-served address first, then the good one, got %v", addrs)
+	}
+	if _, ok := m.nodes["8.8.8.8"]; !ok {
+		t.Errorf("expected the always-served address to be crawled")
+	}
+
+	setMaintenance(true)
+	addrs = m.GoodAddresses(dns.TypeA, true, nil, false, 0, nil, defaultMaxAddresses)
+	setMaintenance(false)
+	if len(addrs) != 1 || !addrs[0].IP.Equal(net.ParseIP("8.8.8.8")) {
+		t.Errorf("expected only the always-served address in maintenance mode, got %v", addrs)
+	}
+
+	_, ipNet, _ := net.ParseCIDR("8.8.8.0/24")
+	m.Ban(ipNet, "test", 0)
+	addrs = m.GoodAddresses(dns.TypeA, true, nil, false, 0, nil, defaultMaxAddresses)
+	if len(addrs) != 1 {
+		t.Errorf("expected banned always-served addresses to be skipped, got %v", addrs)
+	}
+}
+
+func TestStaticAnswers(t *testing.T) {
+	now := time.Now()
+	m := newTestManager(t, &dagconfig.MainnetParams, 1313)
+	m.nodes["1.0.0.1"] = &Node{
+		Addr:        appmessage.NewNetAddressIPPort(net.ParseIP("1.0.0.1"), 1313),
+		LastSeen:    now,
+		LastSuccess: now,
+	}
+
+	static, err := parseStaticAnswers([]string{"192.0.2.1", " 192.0.2.2", "2001:db8::1"})
+	if err != nil {
+		t.Fatalf("parseStaticAnswers: %v", err)
+	}
+	setStaticAnswers(static)
+	defer setStaticAnswers(nil)
+
+	addrs := m.GoodAddresses(dns.TypeA, true, nil, false, 0, nil, defaultMaxAddresses)
+	if len(addrs) != 2 || !addrs[0].IP.Equal(net.ParseIP("192.0.2.1")) || !addrs[1].IP.Equal(net.ParseIP("192.0.2.2")) {
+		t.Errorf("expected only the IPv4 static answers, got %v", addrs)
+	}
+	addrs = m.GoodAddresses(dns.TypeAAAA, true, nil, false, 0, nil, defaultMaxAddresses)
+	if len(addrs) != 1 || !addrs[0].IP.Equal(net.ParseIP("2001:db8::1")) || addrs[0].Port != 1313 {
+		t.Errorf("expected the IPv6 static answer on the default port, got %v", addrs)
+	}
+
+	setStaticAnswers(nil)
+	addrs = m.GoodAddresses(dns.TypeA, true, nil, false, 0, nil, defaultMaxAddresses)
+	if len(addrs) != 1 || !addrs[0].IP.Equal(net.ParseIP("1.0.0.1")) {
+		t.Errorf("expected the crawled peers once static answers are cleared, got %v", addrs)
+	}
+
+	_, err = parseStaticAnswers([]string{"example.com"})
+	if err == nil {
+		t.Errorf("expected a host name to be rejected")
+	}
+}
+
+func TestAnswerRotation(t *testing.T) {
+	now := time.Now()
+	m := newTestManager(t, &dagconfig.MainnetParams, 1313)
+	m.rotation = newAnswerRotation()
+	for i := 1; i <= 40; i++ {
+		ip := net.IPv4(1, 0, 0, byte(i))
+		m.nodes[ip.String()] = &Node{
+			Addr:        appmessage.NewNetAddressIPPort(ip, 1313),
+			LastSeen:    now,
+			LastSuccess: now,
+		}
+	}
+
+	source := net.ParseIP("192.0.2.1")
+	seen := make(map[string]int)
+	for i := 0; i < 5; i++ {
+		for _, addr := range m.GoodAddresses(dns.TypeA, true, nil, false, 0, source, defaultMaxAddresses) {
+			seen[addr.IP.String()]++
+		}
+	}
+	if len(seen) != 40 {
+		t.Fatalf("expected 5 answers to cover the 40 peers, got %d", len(seen))
+	}
+	for ip, count := range seen {
+		if count != 2 {
+			t.Errorf("expected %s to be served twice in 5 answers, got %d", ip, count)
+		}
+	}
+}
+
+func TestAnswerRotationLimits(t *testing.T) {
+	now := time.Now()
+	r := newAnswerRotation()
+
+	first := r.position(net.ParseIP("192.0.2.1"), dns.TypeA, now)
+	r.advance(net.ParseIP("192.0.2.200"), dns.TypeA, 8)
+	if position := r.position(net.ParseIP("192.0.2.1"), dns.TypeA, now); position != first+8 {
+		t.Errorf("expected sources in the same /24 to share a position, got %d want %d",
+			position, first+8)
+	}
+
+	for i := 0; i < maxRotationCursors; i++ {
+		source := net.IPv4(10, byte(i>>8), byte(i), 1)
+		r.position(source, dns.TypeA, now)
+	}
+	if len(r.cursors) != maxRotationCursors || r.recent.Len() != maxRotationCursors {
+		t.Fatalf("expected %d cursors, got %d", maxRotationCursors, len(r.cursors))
+	}
+	if _, ok := r.cursors[rotationKey(net.ParseIP("192.0.2.1"), dns.TypeA)]; ok {
+		t.Errorf("expected the least recently used cursor to be forgotten")
+	}
+}
+
+func TestPartialNodes(t *testing.T) {
+	now := time.Now()
+	m := newTestManager(t, &dagconfig.MainnetParams, 1313)
+	newNode := func(ip string, protocolVersion uint32, services appmessage.ServiceFlag) {
+		m.nodes[ip] = &Node{
+			Addr:            appmessage.NewNetAddressIPPort(net.ParseIP(ip), 1313),
+			LastSeen:        now,
+			LastSuccess:     now,
+			ProtocolVersion: protocolVersion,
+			Services:        services,
+		}
+	}
+	newNode("1.0.0.1", 5, appmessage.SFNodeNetwork|appmessage.SFNodeBloom)
+	newNode("1.0.0.2", 5, appmessage.SFNodeBloom)
+	// Nodes never handshaked with, such as injected ones, count as full.
+	newNode("1.0.0.3", 0, 0)
+
+	full := m.GoodAddresses(dns.TypeA, true, nil, false, 0, nil, defaultMaxAddresses)
+	if len(full) != 2 {
+		t.Errorf("expected the 2 full nodes, got %v", full)
+	}
+	for _, addr := range full {
+		if addr.IP.Equal(net.ParseIP("1.0.0.2")) {
+			t.Errorf("expected the partial node to be excluded, got %v", full)
+		}
+	}
+
+	partial := m.GoodAddresses(dns.TypeA, true, nil, true, 0, nil, defaultMaxAddresses)
+	if len(partial) != 1 || !partial[0].IP.Equal(net.ParseIP("1.0.0.2")) {
+		t.Errorf("expected only the partial node, got %v", partial)
+	}
+}
+
+func TestRequiredServices(t *testing.T) {
+	now := time.Now()
+	m := newTestManager(t, &dagconfig.MainnetParams, 1313)
+	newNode := func(ip string, protocolVersion uint32, services appmessage.ServiceFlag) {
+		m.nodes[ip] = &Node{
+			Addr:            appmessage.NewNetAddressIPPort(net.ParseIP(ip), 1313),
+			LastSeen:        now,
+			LastSuccess:     now,
+			ProtocolVersion: protocolVersion,
+			Services:        services,
+		}
+	}
+	newNode("1.0.0.1", 5, appmessage.SFNodeNetwork|appmessage.SFNodeCF)
+	newNode("1.0.0.2", 5, appmessage.SFNodeNetwork)
+	// The services of nodes never handshaked with are unknown.
+	newNode("1.0.0.3", 0, 0)
+
+	services := appmessage.SFNodeNetwork | appmessage.SFNodeCF
+	addrs := m.GoodAddresses(dns.TypeA, true, nil, false, services, nil, defaultMaxAddresses)
+	if len(addrs) != 2 {
+		t.Errorf("expected the 2 nodes that may provide the services, got %v", addrs)
+	}
+	for _, addr := range addrs {
+		if addr.IP.Equal(net.ParseIP("1.0.0.2")) {
+			t.Errorf("expected the node without the services to be excluded, got %v", addrs)
+		}
+	}
+
+	zone := &dnsZone{services: services}
+	if zone.answerServices(false) != services || zone.answerServices(true) != 0 {
+		t.Errorf("expected the zone services to apply to standard answers only")
+	}
+}
+
+func TestMaxServedAge(t *testing.T) {
+	setTestConfig(t, &ConfigFlags{CrawlInterval: time.Hour, MaxServedAge: 20 * time.Minute})
+
+	now := time.Now()
+	m := newTestManager(t, &dagconfig.MainnetParams, 1313)
+	newNode := func(ip string, lastSuccess time.Time) *Node {
+		node := &Node{
+			Addr:        appmessage.NewNetAddressIPPort(net.ParseIP(ip), 1313),
+			LastSeen:    now,
+			LastAttempt: lastSuccess,
+			LastSuccess: lastSuccess,
+		}
+		m.nodes[ip] = node
+		return node
+	}
+	newNode("1.0.0.1", now.Add(-time.Minute))
+	newNode("1.0.0.2", now.Add(-15*time.Minute))
+	// Still good for the crawl interval, but reached too long ago.
+	newNode("1.0.0.3", now.Add(-30*time.Minute))
+	// Injected, and not reached yet.
+	newNode("1.0.0.4", time.Time{}).InjectedUntil = now.Add(time.Hour)
+
+	addrs := m.GoodAddresses(dns.TypeA, true, nil, false, 0, nil, defaultMaxAddresses)
+	if len(addrs) != 2 {
+		t.Errorf("expected only the 2 peers reached within the maximum age, got %v", addrs)
+	}
+	for _, addr := range addrs {
+		if !addr.IP.Equal(net.ParseIP("1.0.0.1")) && !addr.IP.Equal(net.ParseIP("1.0.0.2")) {
+			t.Errorf("unexpected peer %s served", addr.IP)
+		}
+	}
+
+	// Good peers are crawled again within half of the maximum age.
+	crawled := make(map[string]bool)
+	for _, addr := range m.Addresses() {
+		crawled[addr.IP.String()] = true
+	}
+	if len(crawled) != 3 || crawled["1.0.0.1"] {
+		t.Errorf("expected the good peers reached over 10 minutes ago to be crawled, got %v", crawled)
+	}
+}
+
+func TestCrawlPauseKeepsPool(t *testing.T) {
+	setTestConfig(t, &ConfigFlags{CrawlInterval: time.Hour, GCDemoteAfter: 2 * time.Hour,
+		GCGoodRetention: 24 * time.Hour, GCUnreachableRetention: 4 * time.Hour})
+
+	reached := time.Now().Add(-30 * time.Minute)
+	m := newTestManager(t, &dagconfig.MainnetParams, 1313)
+	m.nodes["1.0.0.1"] = &Node{
+		Addr:        appmessage.NewNetAddressIPPort(net.ParseIP("1.0.0.1"), 1313),
+		LastSuccess: reached,
+	}
+	node := m.nodes["1.0.0.1"]
+	later := time.Now().Add(3 * time.Hour)
+
+	setCrawlPaused(true)
+	if !node.isGood(later) || !node.isFresh(later, time.Hour) {
+		t.Errorf("expected the peer to stay good while crawling is paused")
+	}
+	node.LastSuccess = reached.Add(-3 * time.Hour)
+	m.prunePeers()
+	if node.Demoted {
+		t.Errorf("expected no peer to be demoted while crawling is paused")
+	}
+	node.LastSuccess = reached
+
+	setCrawlPaused(false)
+	if node.isGood(later) {
+		t.Errorf("expected the peer to age again once crawling resumes")
+	}
+}
+
+func TestCrawlResumeKeepsPool(t *testing.T) {
+	setTestConfig(t, &ConfigFlags{CrawlInterval: time.Hour, GCDemoteAfter: 2 * time.Hour,
+		GCGoodRetention: 24 * time.Hour, GCUnreachableRetention: 4 * time.Hour})
+	t.Cleanup(func() {
+		setCrawlPaused(false)
+		atomic.StoreInt64(&crawlPausedFor, 0)
+	})
+
+	// Crawling was paused 30 hours ago, 30 minutes after the peers were
+	// last reached: longer than the stale timeout and every retention.
+	pausedAt := time.Now().Add(-30 * time.Hour)
+	m := newTestManager(t, &dagconfig.MainnetParams, 1313)
+	for _, ip := range []string{"1.0.0.1", "1.0.0.2"} {
+		m.nodes[ip] = &Node{
+			Addr:        appmessage.NewNetAddressIPPort(net.ParseIP(ip), 1313),
+			LastSuccess: pausedAt.Add(-30 * time.Minute),
+			LastSeen:    pausedAt.Add(-30 * time.Minute),
+		}
+	}
+	setCrawlPaused(true)
+	atomic.StoreInt64(&crawlFrozenAt, pausedAt.UnixNano())
+
+	setCrawlPaused(false)
+	m.prunePeers()
+	if len(m.nodes) != 2 {
+		t.Fatalf("expected the pool to be kept once crawling resumes, got %d nodes", len(m.nodes))
+	}
+	for ip, node := range m.nodes {
+		if node.Demoted || !node.isGood(time.Now()) {
+			t.Errorf("expected %s to be served once crawling resumes", ip)
+		}
+	}
+	if addrs := m.GoodAddresses(dns.TypeA, true, nil, false, 0, nil, defaultMaxAddresses); len(addrs) != 2 {
+		t.Errorf("expected the pool to be served once crawling resumes, got %d addresses", len(addrs))
+	}
+
+	// The peers age again from where the clock stood.
+	if node := m.nodes["1.0.0.1"]; node.isGood(time.Now().Add(time.Hour)) {
+		t.Errorf("expected the peers to age again once crawling resumes")
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+
+package main
+
+import (
+	"io"
+	"os"
+)
+
+// mapFile reads the first size bytes of file, on platforms without mmap.
+func mapFile(file *os.File, size int) ([]byte, error) {
+	data := make([]byte, size)
+	_, err := io.ReadFull(file, data)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// unmapFile releases data read by mapFile, which the garbage collector
+// does on platforms without mmap.
+func unmapFile(data []byte) error {
+	return nil
+}
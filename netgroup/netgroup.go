@@ -0,0 +1,85 @@
+// Package netgroup groups peer addresses by the network they are in, so
+// that peers likely run by the same operator or behind the same provider
+// can be limited together: when crawling, so the seeder doesn't hammer one
+// network, and when answering, so clients don't get all their initial
+// peers from one network. The seeder uses it for both, and other tools
+// picking peers can use it to group them the same way.
+package netgroup
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+)
+
+// onionCatPrefix is the IPv6 prefix OnionCat maps Tor onion addresses to.
+var onionCatPrefix = net.IP{0xfd, 0x87, 0xd8, 0x7e, 0xeb, 0x43}
+
+// ASNSource maps addresses to the number of the AS originating them.
+type ASNSource interface {
+	// ASN returns the origin AS of ip, and whether it is known.
+	ASN(ip net.IP) (uint, bool)
+}
+
+// ASNSourceFunc adapts a function to an ASNSource.
+type ASNSourceFunc func(ip net.IP) (uint, bool)
+
+// ASN calls f.
+func (f ASNSourceFunc) ASN(ip net.IP) (uint, bool) {
+	return f(ip)
+}
+
+// Grouper groups addresses by origin AS when its ASN source knows them,
+// and by network otherwise. A nil Grouper groups every address by network.
+type Grouper struct {
+	asns ASNSource
+}
+
+// New returns a Grouper grouping addresses by origin AS according to asns,
+// or by network only if asns is nil.
+func New(asns ASNSource) *Grouper {
+	return &Grouper{asns: asns}
+}
+
+// Group returns the group of ip: its onion group for OnionCat addresses,
+// then its AS group if the origin AS of ip is known, and its legacy group
+// otherwise. Groups of different kinds never collide.
+func (g *Grouper) Group(ip net.IP) string {
+	if IsOnion(ip) {
+		return Onion(ip)
+	}
+	if g != nil && g.asns != nil {
+		if asn, ok := g.asns.ASN(ip); ok && asn != 0 {
+			return ASN(asn)
+		}
+	}
+	return Legacy(ip)
+}
+
+// Legacy returns the legacy group of ip, as node address managers compute
+// it: its /16 network for IPv4, including IPv4-mapped IPv6 addresses, and
+// its /32 network for IPv6.
+func Legacy(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(16, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(32, 128)).String()
+}
+
+// ASN returns the group of the addresses originated by the given AS.
+func ASN(asn uint) string {
+	return "AS" + strconv.FormatUint(uint64(asn), 10)
+}
+
+// IsOnion returns whether ip is a Tor onion address mapped to IPv6 by
+// OnionCat.
+func IsOnion(ip net.IP) bool {
+	return ip.To4() == nil && len(ip) == net.IPv6len && bytes.HasPrefix(ip, onionCatPrefix)
+}
+
+// Onion returns the group of ip, an OnionCat address. Onion addresses are
+// spread over 16 groups by the first 4 bits of the address, since they say
+// nothing of the network of the node.
+func Onion(ip net.IP) string {
+	return "onion" + strconv.Itoa(int(ip[len(onionCatPrefix)]>>4))
+}
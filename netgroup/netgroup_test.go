@@ -0,0 +1,40 @@
+package netgroup
+
+import (
+	"net"
+	"testing"
+)
+
+func TestGroup(t *testing.T) {
+	asns := ASNSourceFunc(func(ip net.IP) (uint, bool) {
+		if ip.Equal(net.ParseIP("203.0.113.1")) || ip.Equal(net.ParseIP("2001:db8::1")) {
+			return 64500, true
+		}
+		return 0, false
+	})
+
+	tests := []struct {
+		ip      string
+		legacy  string
+		grouped string
+	}{
+		{"203.0.113.1", "203.0.0.0", "AS64500"},
+		{"203.0.200.1", "203.0.0.0", "203.0.0.0"},
+		{"::ffff:198.51.100.7", "198.51.0.0", "198.51.0.0"},
+		{"2001:db8::1", "2001:db8::", "AS64500"},
+		{"2001:db8:1::1", "2001:db8::", "2001:db8::"},
+		{"fd87:d87e:eb43:1234::1", "onion1", "onion1"},
+		{"fd87:d87e:eb43:f000::1", "onion15", "onion15"},
+	}
+	grouper := New(asns)
+	for _, test := range tests {
+		ip := net.ParseIP(test.ip)
+		var legacy *Grouper
+		if group := legacy.Group(ip); group != test.legacy {
+			t.Errorf("expected the legacy group of %s to be %s, got %s", test.ip, test.legacy, group)
+		}
+		if group := grouper.Group(ip); group != test.grouped {
+			t.Errorf("expected the group of %s to be %s, got %s", test.ip, test.grouped, group)
+		}
+	}
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/karlsen-network/karlsend/infrastructure/config"
+	"github.com/pkg/errors"
+)
+
+// NetworkConfig describes a network served in addition to the primary one,
+// with its own zones and peer pool.
+type NetworkConfig struct {
+	Network     string
+	Zones       []ZoneConfig
+	Peers       []string
+	Seeder      string
+	AlwaysServe []string
+	Canaries    []string
+
+	// CrawlWorkers and MaxPeers, if not zero, are the quotas of crawls
+	// under way and of known peers of the network.
+	CrawlWorkers int
+	MaxPeers     int
+}
+
+// seederNetwork holds the state of a single network served by the seeder:
+// its parameters, peer pool and crawl settings.
+type seederNetwork struct {
+	flags         config.NetworkFlags
+	amgr          *Manager
+	defaultPort   int
+	zones         []ZoneConfig
+	knownPeers    []string
+	defaultSeeder *appmessage.NetAddress
+
+	// protocolVersion, if not zero, is the only protocol version accepted
+	// from the crawled peers.
+	protocolVersion uint32
+
+	// crawlWorkers, if not zero, is the maximum number of crawls of the
+	// network under way, so a busy network can't take all the crawl
+	// connections of the process.
+	crawlWorkers int
+
+	crawlerOnce sync.Once
+	crawler     *crawlAdapter
+	crawlerErr  error
+}
+
+// networks holds every network served by the running seeder, starting with
+// the primary one. Networks registered at runtime are appended under
+// networksMtx.
+var (
+	networksMtx sync.RWMutex
+	networks    []*seederNetwork
+)
+
+// servedNetworks returns the networks served by the running seeder.
+func servedNetworks() []*seederNetwork {
+	networksMtx.RLock()
+	defer networksMtx.RUnlock()
+	return networks
+}
+
+// networkFlagsFromName returns the resolved network flags selecting the
+// named network, either a built-in or a custom one.
+func networkFlagsFromName(name string, customNetworks []CustomNetworkConfig) (config.NetworkFlags, error) {
+	customNetwork := findCustomNetwork(customNetworks, name)
+	if customNetwork == nil {
+		return builtinNetworkFlags(name)
+	}
+
+	flags, err := builtinNetworkFlags("mainnet")
+	if err != nil {
+		return flags, err
+	}
+	flags.ActiveNetParams, err = customNetwork.params()
+	return flags, err
+}
+
+// builtinNetworkFlags returns the resolved network flags selecting the
+// named built-in network.
+func builtinNetworkFlags(name string) (config.NetworkFlags, error) {
+	var flags config.NetworkFlags
+	switch name {
+	case "mainnet":
+	case "testnet":
+		flags.Testnet = true
+	case "simnet":
+		flags.Simnet = true
+	case "devnet":
+		flags.Devnet = true
+	default:
+		return flags, errors.Errorf("unknown network %s", name)
+	}
+	err := flags.ResolveNetwork(nil)
+	return flags, err
+}
+
+// newSeederNetwork loads the peer pool of the network selected by flags from
+// its directory under appDir, and resolves its default seeder.
+func newSeederNetwork(flags config.NetworkFlags, appDir string, zones []ZoneConfig,
+	knownPeers []string, seeder string) (*seederNetwork, error) {
+
+	params := flags.NetParams()
+	defaultPort, err := strconv.Atoi(params.DefaultPort)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid peers default port %s", params.DefaultPort)
+	}
+
+	dataDir := filepath.Join(appDir, params.Name)
+	err = createPathIfNeeded(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	amgr, err := NewNetworkManager(dataDir, params, defaultPort)
+	if err != nil {
+		return nil, errors.Wrapf(err, "NewManager %s", params.Name)
+	}
+
+	network := &seederNetwork{
+		flags:       flags,
+		amgr:        amgr,
+		defaultPort: defaultPort,
+		zones:       zones,
+		knownPeers:  knownPeers,
+	}
+
+	if len(seeder) != 0 {
+		network.defaultSeeder, err = resolveSeeder(seeder, defaultPort)
+		if err != nil {
+			return nil, err
+		}
+		if network.defaultSeeder != nil {
+			amgr.AddAddresses([]*appmessage.NetAddress{network.defaultSeeder})
+		}
+	}
+
+	return network, nil
+}
+
+// name returns the name of the network.
+func (n *seederNetwork) name() string {
+	return n.flags.NetParams().Name
+}
+
+// crawlAdapter returns the adapter crawling the network, starting it on
+// first use.
+func (n *seederNetwork) crawlAdapter() (*crawlAdapter, error) {
+	n.crawlerOnce.Do(func() {
+		n.crawler, n.crawlerErr = newCrawlAdapter(
+			&config.Config{Flags: &config.Flags{NetworkFlags: n.flags}}, n.protocolVersion)
+	})
+	return n.crawler, n.crawlerErr
+}
+
+// findNetwork returns the served network with the given name, or the
+// primary network if name is empty. It returns nil if there is no such
+// network.
+func findNetwork(name string) *seederNetwork {
+	networks := servedNetworks()
+	if len(networks) == 0 {
+		return nil
+	}
+	if name == "" {
+		return networks[0]
+	}
+	for _, network := range networks {
+		if network.name() == name {
+			return network
+		}
+	}
+	return nil
+}
+
+// resolveSeeder prepares the seeder address, supporting either a simple IP
+// or host name with the network's default port, or a full IP:port format.
+// It returns nil if the host can't be resolved.
+func resolveSeeder(seeder string, defaultPort int) (*appmessage.NetAddress, error) {
+	seederIP := seeder
+	seederPort := defaultPort
+
+	// Try to split seeder host and port
+	foundIP, foundPort, err := net.SplitHostPort(seeder)
+	if err == nil {
+		seederIP = foundIP
+		seederPort, err = strconv.Atoi(foundPort)
+		if err != nil {
+			return nil, errors.Errorf("Invalid seeder port: %s", foundPort)
+		}
+	}
+
+	ip := net.ParseIP(seederIP)
+	if ip == nil {
+		hostAddrs, err := lookupBootstrapHost(seederIP)
+		if err != nil {
+			log.Warnf("Failed to resolve seed host: %v, %v, ignoring", seederIP, err)
+			return nil, nil
+		}
+		if len(hostAddrs) == 0 {
+			log.Warnf("Failed to resolve seed host: %v, ignoring", seederIP)
+			return nil, nil
+		}
+		ip = hostAddrs[0]
+	}
+	return appmessage.NewNetAddressIPPort(ip, uint16(seederPort)), nil
+}
+
+// parseAlwaysServe parses the IP addresses of the always-served nodes.
+func parseAlwaysServe(addresses []string) ([]net.IP, error) {
+	ips := make([]net.IP, 0, len(addresses))
+	for _, address := range addresses {
+		ip := net.ParseIP(strings.TrimSpace(address))
+		if ip == nil {
+			return nil, errors.Errorf("invalid always-served address %s: it must be an IP address", address)
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+// validateNetworks checks that the additional networks are distinct from
+// each other and from the primary network, and that every zone is served
+// for a single network. Networks need no zones in crawl-only mode.
+func validateNetworks(cfg *ConfigFlags) error {
+	names := map[string]bool{cfg.NetParams().Name: true}
+	hosts := make(map[string]bool)
+	for _, zone := range cfg.AllZones() {
+		err := validateZone(zone)
+		if err != nil {
+			return err
+		}
+		hosts[strings.ToLower(zone.Host)] = true
+	}
+
+	for _, networkCfg := range cfg.Networks {
+		flags, err := networkFlagsFromName(networkCfg.Network, cfg.CustomNetworks)
+		if err != nil {
+			return err
+		}
+		name := flags.NetParams().Name
+		if names[name] {
+			return errors.Errorf("network %s is configured more than once", networkCfg.Network)
+		}
+		names[name] = true
+
+		if len(networkCfg.Zones) == 0 && !cfg.CrawlOnly {
+			return errors.Errorf("network %s has no zones", networkCfg.Network)
+		}
+		if networkCfg.CrawlWorkers < 0 || networkCfg.MaxPeers < 0 {
+			return errors.Errorf("the quotas of network %s must not be negative", networkCfg.Network)
+		}
+		_, err = parseAlwaysServe(networkCfg.AlwaysServe)
+		if err != nil {
+			return err
+		}
+		_, err = parseCanaries(networkCfg.Canaries, 0)
+		if err != nil {
+			return err
+		}
+		for _, zone := range networkCfg.Zones {
+			if zone.Host == "" || zone.Nameserver == "" {
+				return errors.New("every zone must have a host and a nameserver")
+			}
+			err := validateZone(zone)
+			if err != nil {
+				return err
+			}
+			host := strings.ToLower(zone.Host)
+			if hosts[host] {
+				return errors.Errorf("zone %s is served for more than one network", zone.Host)
+			}
+			hosts[host] = true
+		}
+	}
+	return nil
+}
+
+// validateZone checks the address family and the query quota of zone.
+func validateZone(zone ZoneConfig) error {
+	if zone.QPS < 0 {
+		return errors.Errorf("the query quota of zone %s must not be negative", zone.Host)
+	}
+	switch zone.Family {
+	case "", zoneFamilyIPv4, zoneFamilyIPv6, zoneFamilyMixed:
+		return nil
+	}
+	return errors.Errorf("unknown address family %s of zone %s: it must be %s, %s or %s",
+		zone.Family, zone.Host, zoneFamilyIPv4, zoneFamilyIPv6, zoneFamilyMixed)
+}
+
+// setupNetworks creates the primary network from the top level settings of
+// cfg, followed by the additional networks it declares.
+func setupNetworks(cfg *ConfigFlags) ([]*seederNetwork, error) {
+	var knownPeers []string
+	if len(cfg.KnownPeers) != 0 {
+		knownPeers = strings.Split(cfg.KnownPeers, ",")
+	}
+
+	// cfg.AppDir is already namespaced by the primary network.
+	baseAppDir := filepath.Dir(cfg.AppDir)
+	primary, err := newSeederNetwork(cfg.NetworkFlags, baseAppDir, cfg.AllZones(), knownPeers, cfg.Seeder)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.AlwaysServe != "" {
+		alwaysServe, err := parseAlwaysServe(strings.Split(cfg.AlwaysServe, ","))
+		if err != nil {
+			return nil, err
+		}
+		primary.amgr.SetAlwaysServe(alwaysServe)
+	}
+	if cfg.Canaries != "" {
+		canaries, err := parseCanaries(strings.Split(cfg.Canaries, ","), primary.defaultPort)
+		if err != nil {
+			return nil, err
+		}
+		primary.amgr.SetCanaries(canaries)
+	}
+	primary.amgr.SetMaxPeers(cfg.MaxPeers)
+	primary.crawlWorkers = cfg.CrawlWorkers
+	all := []*seederNetwork{primary}
+
+	for _, networkCfg := range cfg.Networks {
+		flags, err := networkFlagsFromName(networkCfg.Network, cfg.CustomNetworks)
+		if err != nil {
+			return nil, err
+		}
+		network, err := newSeederNetwork(flags, baseAppDir, networkCfg.Zones,
+			networkCfg.Peers, networkCfg.Seeder)
+		if err != nil {
+			return nil, err
+		}
+		alwaysServe, err := parseAlwaysServe(networkCfg.AlwaysServe)
+		if err != nil {
+			return nil, err
+		}
+		network.amgr.SetAlwaysServe(alwaysServe)
+		canaries, err := parseCanaries(networkCfg.Canaries, network.defaultPort)
+		if err != nil {
+			return nil, err
+		}
+		network.amgr.SetCanaries(canaries)
+		network.amgr.SetMaxPeers(networkCfg.MaxPeers)
+		network.crawlWorkers = networkCfg.CrawlWorkers
+		all = append(all, network)
+	}
+
+	scorer, err := lookupScorer(cfg.Scorer)
+	if err != nil {
+		return nil, err
+	}
+	var treatment Scorer
+	if cfg.ExperimentScorer != "" {
+		treatment, err = lookupScorer(cfg.ExperimentScorer)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, network := range all {
+		network.amgr.SetScorer(scorer)
+		if treatment != nil {
+			network.amgr.SetExperiment(newAnswerExperiment(cfg.Scorer, scorer, cfg.ExperimentScorer, treatment,
+				cfg.ExperimentShare))
+		}
+		if cfg.PeerGraph {
+			graph, err := newPeerGraph()
+			if err != nil {
+				return nil, err
+			}
+			network.amgr.SetPeerGraph(graph)
+		}
+		customNetwork := findCustomNetwork(cfg.CustomNetworks, network.name())
+		if customNetwork != nil {
+			network.protocolVersion = customNetwork.ProtocolVersion
+		}
+	}
+	return all, nil
+}
+
+// networkManagers returns the managers of every served network. When no
+// networks are set up, as when running without the run command, it returns
+// just amgr.
+func networkManagers(amgr *Manager) []*Manager {
+	networks := servedNetworks()
+	if len(networks) == 0 {
+		return []*Manager{amgr}
+	}
+	managers := make([]*Manager, len(networks))
+	for i, network := range networks {
+		managers[i] = network.amgr
+	}
+	return managers
+}
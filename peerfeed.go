@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// peerFeedServiceName is the full gRPC name of the peer feed service.
+	peerFeedServiceName = "dnsseeder.PeerFeed"
+
+	// peerFeedInterval is the interval at which the good peers of each
+	// network are checked for changes.
+	peerFeedInterval = time.Second
+
+	// peerFeedBuffer is the number of events buffered for each subscriber.
+	// Subscribers falling further behind are disconnected.
+	peerFeedBuffer = 4096
+)
+
+// Peer event types
+const (
+	PeerEventGood = "good"
+	PeerEventBad  = "bad"
+)
+
+// PeerEvent tells that a peer started (good) or stopped (bad) being served,
+// whether because it was reached, failed to be reached, went stale or was
+// removed. Partial peers are only served to queries for partial nodes.
+type PeerEvent struct {
+	Type    string
+	Network string
+	Address string
+	// TimeMillis is the time, in unix milliseconds, the change was noticed.
+	TimeMillis int64
+
+	// The details of the peer as of its last successful handshake.
+	SubnetworkID    string `json:",omitempty"`
+	UserAgent       string `json:",omitempty"`
+	ProtocolVersion uint32 `json:",omitempty"`
+	Services        uint64 `json:",omitempty"`
+	Partial         bool   `json:",omitempty"`
+}
+
+// SubscribePeersRequest subscribes to the changes of the good peers of
+// Network, or of every served network if empty. The stream starts with a
+// good event for every peer good at the time.
+type SubscribePeersRequest struct {
+	Network string
+}
+
+// PeerFeedServer is the server API of the peer feed service
+type PeerFeedServer interface {
+	SubscribePeers(*SubscribePeersRequest, grpc.ServerStream) error
+}
+
+// subscribePeersStreamDesc describes SubscribePeers, which receives a
+// SubscribePeersRequest and sends PeerEvent messages until the call ends.
+var subscribePeersStreamDesc = grpc.StreamDesc{
+	StreamName: "SubscribePeers",
+	Handler: func(srv interface{}, stream grpc.ServerStream) error {
+		request := &SubscribePeersRequest{}
+		if err := stream.RecvMsg(request); err != nil {
+			return err
+		}
+		return srv.(PeerFeedServer).SubscribePeers(request, stream)
+	},
+	ServerStreams: true,
+}
+
+var peerFeedServiceDesc = grpc.ServiceDesc{
+	ServiceName: peerFeedServiceName,
+	HandlerType: (*PeerFeedServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams:     []grpc.StreamDesc{subscribePeersStreamDesc},
+	Metadata:    "peerfeed.go",
+}
+
+// peerSubscriber is a subscriber to the events of a network, or of every
+// network if network is empty. events is closed if it falls behind.
+type peerSubscriber struct {
+	network string
+	events  chan *PeerEvent
+}
+
+// send queues events, and returns false if the subscriber fell behind.
+func (s *peerSubscriber) send(events []*PeerEvent) bool {
+	for _, event := range events {
+		select {
+		case s.events <- event:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// peerFeedHub tracks the good peers of every network, and sends their
+// changes to the subscribers.
+type peerFeedHub struct {
+	mtx         sync.Mutex
+	good        map[string]map[string]*PeerEvent
+	subscribers map[*peerSubscriber]struct{}
+}
+
+// peerFeed is the hub of the running seeder.
+var peerFeed = newPeerFeedHub()
+
+func newPeerFeedHub() *peerFeedHub {
+	return &peerFeedHub{
+		good:        make(map[string]map[string]*PeerEvent),
+		subscribers: make(map[*peerSubscriber]struct{}),
+	}
+}
+
+// watch checks the good peers of the network managed by amgr for changes
+// every peerFeedInterval, until quit is closed.
+func (h *peerFeedHub) watch(amgr *Manager, quit <-chan struct{}) {
+	ticker := time.NewTicker(peerFeedInterval)
+	defer ticker.Stop()
+	for {
+		h.update(amgr, time.Now())
+
+		select {
+		case <-ticker.C:
+		case <-quit:
+			return
+		}
+	}
+}
+
+// update compares the good peers of the network managed by amgr at now
+// with those of the previous update, and sends the changes to the
+// subscribers. Peers are good if they are served to some query: good, on
+// the default port and reached within the maximum served age.
+func (h *peerFeedHub) update(amgr *Manager, now time.Time) {
+	network := amgr.netParams.Name
+	maxAge := maxServedAge()
+	good := make(map[string]*PeerEvent)
+	for _, node := range amgr.Nodes() {
+		if node.Addr.Port != amgr.defaultPort || !node.isGood(now) || !node.isFresh(now, maxAge) {
+			continue
+		}
+		event := &PeerEvent{
+			Type:            PeerEventGood,
+			Network:         network,
+			Address:         net.JoinHostPort(node.Addr.IP.String(), strconv.Itoa(int(node.Addr.Port))),
+			TimeMillis:      now.UnixMilli(),
+			UserAgent:       node.UserAgent,
+			ProtocolVersion: node.ProtocolVersion,
+			Services:        uint64(node.Services),
+			Partial:         node.isPartial(),
+		}
+		if node.SubnetworkID != nil {
+			event.SubnetworkID = node.SubnetworkID.String()
+		}
+		good[event.Address] = event
+	}
+
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	previous := h.good[network]
+	var events []*PeerEvent
+	for address, event := range good {
+		if _, ok := previous[address]; !ok {
+			events = append(events, event)
+		}
+	}
+	for address, event := range previous {
+		if _, ok := good[address]; !ok {
+			bad := *event
+			bad.Type = PeerEventBad
+			bad.TimeMillis = now.UnixMilli()
+			events = append(events, &bad)
+		}
+	}
+	h.good[network] = good
+	sortPeerEvents(events)
+
+	for subscriber := range h.subscribers {
+		if subscriber.network != "" && subscriber.network != network {
+			continue
+		}
+		if !subscriber.send(events) {
+			rpcLog.Warnf("Disconnecting a peer feed subscriber falling behind")
+			close(subscriber.events)
+			delete(h.subscribers, subscriber)
+		}
+	}
+}
+
+// subscribe registers a subscriber to the events of network, or of every
+// network if empty, and queues a good event for every peer currently good.
+func (h *peerFeedHub) subscribe(network string) *peerSubscriber {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	var current []*PeerEvent
+	for name, good := range h.good {
+		if network != "" && name != network {
+			continue
+		}
+		for _, event := range good {
+			current = append(current, event)
+		}
+	}
+	sortPeerEvents(current)
+
+	buffer := peerFeedBuffer
+	if len(current) > buffer {
+		buffer = len(current)
+	}
+	subscriber := &peerSubscriber{network: network, events: make(chan *PeerEvent, buffer)}
+	for _, event := range current {
+		subscriber.events <- event
+	}
+	h.subscribers[subscriber] = struct{}{}
+	return subscriber
+}
+
+// unsubscribe unregisters the subscriber, if it wasn't already.
+func (h *peerFeedHub) unsubscribe(subscriber *peerSubscriber) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	delete(h.subscribers, subscriber)
+}
+
+// sortPeerEvents sorts events by network, then by address, so they are sent
+// in a stable order.
+func sortPeerEvents(events []*PeerEvent) {
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Network != events[j].Network {
+			return events[i].Network < events[j].Network
+		}
+		return events[i].Address < events[j].Address
+	})
+}
+
+type peerFeedServer struct {
+	amgr *Manager
+	hub  *peerFeedHub
+}
+
+func (s *peerFeedServer) SubscribePeers(req *SubscribePeersRequest, stream grpc.ServerStream) error {
+	if req.Network != "" && networkManager(req.Network, s.amgr) == nil {
+		return status.Errorf(codes.NotFound, "network %s is not served", req.Network)
+	}
+
+	subscriber := s.hub.subscribe(req.Network)
+	defer s.hub.unsubscribe(subscriber)
+	for {
+		select {
+		case event, ok := <-subscriber.events:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "the subscriber fell behind")
+			}
+			err := stream.SendMsg(event)
+			if err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// PeerFeedClient calls the peer feed service of a running seeder
+type PeerFeedClient struct {
+	*jsonClient
+}
+
+// NewPeerFeedClient connects to the gRPC server at the given address, using
+// the given peer feed token, if any
+func NewPeerFeedClient(address, token string) (*PeerFeedClient, error) {
+	client, err := newJSONClient(address, peerFeedServiceName, token)
+	if err != nil {
+		return nil, err
+	}
+	return &PeerFeedClient{client}, nil
+}
+
+// SubscribePeers calls handle with every peer event sent by the seeder,
+// until ctx is done, the seeder ends the stream or handle returns an error
+func (c *PeerFeedClient) SubscribePeers(ctx context.Context, req *SubscribePeersRequest,
+	handle func(*PeerEvent) error) error {
+
+	stream, err := c.newStream(ctx, &subscribePeersStreamDesc)
+	if err != nil {
+		return err
+	}
+	err = stream.SendMsg(req)
+	if err != nil {
+		return err
+	}
+	err = stream.CloseSend()
+	if err != nil {
+		return err
+	}
+	for {
+		event := &PeerEvent{}
+		err = stream.RecvMsg(event)
+		if err != nil {
+			return err
+		}
+		err = handle(event)
+		if err != nil {
+			return err
+		}
+	}
+}
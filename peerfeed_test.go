@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/karlsen-network/karlsend/infrastructure/config"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPeerFeed(t *testing.T) {
+	cfg := setTestConfig(t, &ConfigFlags{NetworkFlags: config.NetworkFlags{Devnet: true}})
+	err := cfg.ResolveNetwork(nil)
+	if err != nil {
+		t.Fatalf("ResolveNetwork: %s", err)
+	}
+
+	now := time.Now()
+	m := newTestManager(t, cfg.NetParams(), 1313)
+	for _, ip := range []string{"203.0.113.1", "203.0.113.2", "203.0.113.3"} {
+		m.nodes[ip] = &Node{Addr: appmessage.NewNetAddressIPPort(net.ParseIP(ip), 1313)}
+	}
+	m.nodes["203.0.113.1"].LastSuccess = now
+	m.nodes["203.0.113.1"].UserAgent = "/karlsend:1.0.0/"
+	// Peers off the default port are never served.
+	m.nodes["203.0.113.3"].Addr.Port = 1314
+	m.nodes["203.0.113.3"].LastSuccess = now
+
+	previous := peerFeed
+	peerFeed = newPeerFeedHub()
+	t.Cleanup(func() { peerFeed = previous })
+	peerFeed.update(m, now)
+
+	host := "localhost:3741"
+	grpcServer := NewGRPCServer(m, "", "", "secret")
+	err = grpcServer.Start(host)
+	if err != nil {
+		t.Fatalf("Failed to start gRPC server: %s", err)
+	}
+	defer grpcServer.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	unauthenticated, err := NewPeerFeedClient(host, "wrong")
+	if err != nil {
+		t.Fatalf("NewPeerFeedClient: %s", err)
+	}
+	defer unauthenticated.Close()
+	err = unauthenticated.SubscribePeers(ctx, &SubscribePeersRequest{}, func(*PeerEvent) error { return nil })
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected subscribing with a wrong token to fail, got %v", err)
+	}
+
+	client, err := NewPeerFeedClient(host, "secret")
+	if err != nil {
+		t.Fatalf("NewPeerFeedClient: %s", err)
+	}
+	defer client.Close()
+	events := make(chan *PeerEvent)
+	subscribed := make(chan error, 1)
+	go func() {
+		subscribed <- client.SubscribePeers(ctx, &SubscribePeersRequest{Network: m.netParams.Name},
+			func(event *PeerEvent) error {
+				events <- event
+				return nil
+			})
+	}()
+	receive := func() *PeerEvent {
+		select {
+		case event := <-events:
+			return event
+		case err := <-subscribed:
+			t.Fatalf("SubscribePeers: %v", err)
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for a peer event")
+		}
+		return nil
+	}
+
+	event := receive()
+	if event.Type != PeerEventGood || event.Address != "203.0.113.1:1313" || event.UserAgent != "/karlsend:1.0.0/" {
+		t.Errorf("expected the current good peer first, got %+v", event)
+	}
+
+	m.Good(net.ParseIP("203.0.113.2"), &appmessage.MsgVersion{ProtocolVersion: 5})
+	m.nodes["203.0.113.1"].Demoted = true
+	peerFeed.update(m, time.Now())
+	expected := []struct{ typ, address string }{
+		{PeerEventGood, "203.0.113.2:1313"},
+		{PeerEventBad, "203.0.113.1:1313"},
+	}
+	received := map[string]string{}
+	for range expected {
+		event := receive()
+		received[event.Address] = event.Type
+		if event.Address == "203.0.113.2:1313" && !event.Partial {
+			t.Errorf("expected a peer without the network service to be partial, got %+v", event)
+		}
+	}
+	for _, e := range expected {
+		if received[e.address] != e.typ {
+			t.Errorf("expected a %s event for %s, got %v", e.typ, e.address, received)
+		}
+	}
+
+	peerFeed.update(m, time.Now())
+	select {
+	case event := <-events:
+		t.Errorf("expected no event without changes, got %+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
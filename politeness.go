@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// crawlPoliteness limits the pace of new outbound crawl connections,
+// whatever the number of crawl workers, so the bursts after a restart don't
+// look like a scan to the intrusion detection of large providers.
+type crawlPoliteness struct {
+	rate         float64
+	netgroupRate float64
+	recontact    time.Duration
+
+	mtx        sync.Mutex
+	global     tokenBucket
+	netgroups  map[string]*tokenBucket
+	contacts   map[string]time.Time
+	lastPruned time.Time
+}
+
+// politeness is the politeness policy shared by the crawlers of all the
+// networks, or nil if outbound connections are not limited.
+var politeness *crawlPoliteness
+
+// newCrawlPoliteness returns a politeness policy allowing rate new
+// connections per second in total and netgroupRate per /16 (IPv4) or /32
+// (IPv6) network, and at most one connection to the same host every
+// recontact. Zero values disable the respective limit, and a nil policy is
+// returned if all of them are zero.
+func newCrawlPoliteness(rate, netgroupRate float64, recontact time.Duration) *crawlPoliteness {
+	if rate <= 0 && netgroupRate <= 0 && recontact <= 0 {
+		return nil
+	}
+	now := time.Now()
+	return &crawlPoliteness{
+		rate:         rate,
+		netgroupRate: netgroupRate,
+		recontact:    recontact,
+		global:       tokenBucket{tokens: politenessBurst(rate), updated: now},
+		netgroups:    make(map[string]*tokenBucket),
+		contacts:     make(map[string]time.Time),
+		lastPruned:   now,
+	}
+}
+
+// politenessBurst returns the number of connections that can be opened at
+// once under rate: a second's worth, and at least one.
+func politenessBurst(rate float64) float64 {
+	if rate < 1 {
+		return 1
+	}
+	return rate
+}
+
+// admit returns whether a connection to ip may be opened now as far as the
+// per host and per netgroup limits go, and counts it if so. Peers that are
+// not admitted should be retried later. A nil policy admits every peer.
+func (p *crawlPoliteness) admit(ip net.IP, now time.Time) bool {
+	if p == nil {
+		return true
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if now.Sub(p.lastPruned) > rateLimiterPruneInterval {
+		p.prune(now)
+	}
+
+	host := ip.String()
+	if p.recontact > 0 {
+		if contacted, ok := p.contacts[host]; ok && now.Sub(contacted) < p.recontact {
+			return false
+		}
+	}
+	if p.netgroupRate > 0 {
+		netgroup := netgroupKey(ip)
+		bucket, ok := p.netgroups[netgroup]
+		if !ok {
+			bucket = &tokenBucket{tokens: politenessBurst(p.netgroupRate), updated: now}
+			p.netgroups[netgroup] = bucket
+		}
+		if !bucket.take(now, p.netgroupRate, politenessBurst(p.netgroupRate)) {
+			return false
+		}
+	}
+	if p.recontact > 0 {
+		p.contacts[host] = now
+	}
+	return true
+}
+
+// wait blocks until the global limit allows a new connection, and counts
+// it. A nil policy returns immediately.
+func (p *crawlPoliteness) wait() {
+	if p == nil || p.rate <= 0 {
+		return
+	}
+	for {
+		p.mtx.Lock()
+		ok := p.global.take(time.Now(), p.rate, politenessBurst(p.rate))
+		tokens := p.global.tokens
+		p.mtx.Unlock()
+		if ok {
+			return
+		}
+		time.Sleep(time.Duration((1 - tokens) / p.rate * float64(time.Second)))
+	}
+}
+
+// prune forgets the hosts that may be contacted again and the netgroups
+// whose budget has been refilled.
+//
+// This function MUST be called with the politeness lock held.
+func (p *crawlPoliteness) prune(now time.Time) {
+	for host, contacted := range p.contacts {
+		if now.Sub(contacted) >= p.recontact {
+			delete(p.contacts, host)
+		}
+	}
+	burst := politenessBurst(p.netgroupRate)
+	for netgroup, bucket := range p.netgroups {
+		if bucket.tokens+now.Sub(bucket.updated).Seconds()*p.netgroupRate >= burst {
+			delete(p.netgroups, netgroup)
+		}
+	}
+	p.lastPruned = now
+}
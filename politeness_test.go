@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCrawlPoliteness(t *testing.T) {
+	if newCrawlPoliteness(0, 0, 0) != nil {
+		t.Fatalf("expected no policy without limits")
+	}
+	var disabled *crawlPoliteness
+	if !disabled.admit(net.ParseIP("203.0.113.5"), time.Now()) {
+		t.Errorf("expected a nil policy to admit every peer")
+	}
+	disabled.wait()
+
+	now := time.Now()
+	p := newCrawlPoliteness(0, 2, time.Hour)
+	if !p.admit(net.ParseIP("203.0.113.5"), now) {
+		t.Errorf("expected the first connection to be admitted")
+	}
+	if p.admit(net.ParseIP("203.0.113.5"), now.Add(time.Minute)) {
+		t.Errorf("expected the host not to be contacted again within the interval")
+	}
+	if !p.admit(net.ParseIP("203.0.113.6"), now) {
+		t.Errorf("expected the second connection to the netgroup to be admitted")
+	}
+	if p.admit(net.ParseIP("203.0.113.7"), now) {
+		t.Errorf("expected the netgroup rate to be exceeded")
+	}
+	if !p.admit(net.ParseIP("198.51.100.1"), now) {
+		t.Errorf("expected another netgroup to have its own budget")
+	}
+	if !p.admit(net.ParseIP("203.0.113.7"), now.Add(time.Second)) {
+		t.Errorf("expected the netgroup budget to be refilled")
+	}
+	if !p.admit(net.ParseIP("203.0.113.5"), now.Add(2*time.Hour)) {
+		t.Errorf("expected the host to be contacted again after the interval")
+	}
+
+	p = newCrawlPoliteness(20, 0, 0)
+	start := time.Now()
+	for i := 0; i < 25; i++ {
+		p.wait()
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("expected 25 connections at 20 per second to take at least 250ms, took %s", elapsed)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// zoneUsage counts the queries and answers of a zone, and enforces its
+// quota of queries per second, so a busy zone can't starve the others
+// served by the same process. The counters must be accessed atomically.
+type zoneUsage struct {
+	host string
+	qps  float64
+
+	mtx    sync.Mutex
+	bucket tokenBucket
+
+	queries         uint64
+	throttled       uint64
+	addressesServed uint64
+}
+
+// newZoneUsage returns the usage of the zone host, answering up to qps
+// queries per second with bursts of a second's worth of queries, or any
+// number of them if qps is zero.
+func newZoneUsage(host string, qps float64) *zoneUsage {
+	return &zoneUsage{
+		host:   dns.Fqdn(strings.ToLower(host)),
+		qps:    qps,
+		bucket: tokenBucket{tokens: zoneBurst(qps), updated: time.Now()},
+	}
+}
+
+// zoneBurst returns the number of queries a zone answering qps queries per
+// second may answer at once.
+func zoneBurst(qps float64) float64 {
+	if qps < 1 {
+		return 1
+	}
+	return qps
+}
+
+// admit counts a query of the zone made at now, and returns whether it is
+// within the quota of the zone. A nil usage admits every query.
+func (u *zoneUsage) admit(now time.Time) bool {
+	if u == nil {
+		return true
+	}
+	atomic.AddUint64(&u.queries, 1)
+	if u.qps <= 0 {
+		return true
+	}
+
+	u.mtx.Lock()
+	allowed := u.bucket.take(now, u.qps, zoneBurst(u.qps))
+	u.mtx.Unlock()
+	if !allowed {
+		atomic.AddUint64(&u.throttled, 1)
+	}
+	return allowed
+}
+
+// recordAnswer counts the addresses served in an answer of the zone.
+func (u *zoneUsage) recordAnswer(addresses int) {
+	if u == nil {
+		return
+	}
+	atomic.AddUint64(&u.addressesServed, uint64(addresses))
+}
+
+// metrics returns the queries of the zone, those over its quota, and the
+// addresses it served, named after the zone.
+func (u *zoneUsage) metrics() []metric {
+	prefix := "zone_" + zoneMetricName(u.host) + "_"
+	return []metric{
+		{prefix + "queries", atomic.LoadUint64(&u.queries)},
+		{prefix + "throttled", atomic.LoadUint64(&u.throttled)},
+		{prefix + "addresses_served", atomic.LoadUint64(&u.addressesServed)},
+	}
+}
+
+// zoneMetricName returns host as it appears in metric names: without the
+// trailing dot, and with every character but letters and digits replaced
+// by an underscore.
+func zoneMetricName(host string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, strings.TrimSuffix(host, "."))
+}
+
+// zoneUsage returns the usage of the zone host of the network, adding it
+// with a quota of qps queries per second if it is new.
+func (m *Manager) zoneUsage(host string, qps float64) *zoneUsage {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	key := dns.Fqdn(strings.ToLower(host))
+	usage, exists := m.zones[key]
+	if !exists {
+		if m.zones == nil {
+			m.zones = make(map[string]*zoneUsage)
+		}
+		usage = newZoneUsage(host, qps)
+		m.zones[key] = usage
+	}
+	return usage
+}
+
+// zoneUsages returns the usage of every zone of the network, sorted by
+// host.
+func (m *Manager) zoneUsages() []*zoneUsage {
+	m.mtx.RLock()
+	usages := make([]*zoneUsage, 0, len(m.zones))
+	for _, usage := range m.zones {
+		usages = append(usages, usage)
+	}
+	m.mtx.RUnlock()
+	sort.Slice(usages, func(i, j int) bool { return usages[i].host < usages[j].host })
+	return usages
+}
+
+// SetMaxPeers sets the maximum number of peers of the network. Once it is
+// reached, the addresses learned from crawls, other seeders and snapshots
+// are dropped until the garbage collector makes room. Zero removes the
+// limit.
+func (m *Manager) SetMaxPeers(maxPeers int) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.maxPeers = maxPeers
+}
+
+// atPeerQuota returns whether the network can't learn another peer, and
+// counts the address dropped if so. The caller must hold the lock.
+func (m *Manager) atPeerQuota() bool {
+	if m.maxPeers <= 0 || len(m.nodes) < m.maxPeers {
+		return false
+	}
+	atomic.AddUint64(&m.peersOverQuota, 1)
+	return true
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/karlsen-network/karlsend/domain/dagconfig"
+)
+
+func TestZoneUsage(t *testing.T) {
+	m := newTestManager(t, &dagconfig.MainnetParams, 0)
+	network := &seederNetwork{amgr: m, zones: []ZoneConfig{
+		{Host: "Busy.Example.org", Nameserver: "ns.example.org", QPS: 2},
+		{Host: "quiet.example.org", Nameserver: "ns.example.org"},
+	}}
+	d := NewDNSServer([]*seederNetwork{network}, "", nil, nil)
+	busy, quiet := d.findZone("busy.example.org."), d.findZone("quiet.example.org.")
+
+	// The busy zone answers a burst of a second's worth of queries, and
+	// its quota doesn't hold back the other zone.
+	now := time.Now()
+	for i, expected := range []bool{true, true, false, false} {
+		if admitted := busy.usage.admit(now); admitted != expected {
+			t.Errorf("expected query %d of the busy zone to be admitted: %t, got %t", i, expected, admitted)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		if !quiet.usage.admit(now) {
+			t.Fatalf("expected the zone without a quota to admit every query")
+		}
+	}
+	if !busy.usage.admit(now.Add(time.Second / 2)) {
+		t.Errorf("expected the busy zone to admit queries again once its bucket refills")
+	}
+	busy.usage.recordAnswer(8)
+
+	// Zones are shared by the servers built for the same network.
+	other := NewDNSServer([]*seederNetwork{network}, "", nil, nil)
+	if other.findZone("busy.example.org.").usage != busy.usage {
+		t.Errorf("expected the zone usage to be shared")
+	}
+
+	values := make(map[string]uint64)
+	for _, metric := range networkMetrics(m) {
+		values[metric.name] = metric.value
+	}
+	expected := map[string]uint64{
+		"zone_busy_example_org_queries":           5,
+		"zone_busy_example_org_throttled":         2,
+		"zone_busy_example_org_addresses_served":  8,
+		"zone_quiet_example_org_queries":          10,
+		"zone_quiet_example_org_throttled":        0,
+		"zone_quiet_example_org_addresses_served": 0,
+	}
+	for name, value := range expected {
+		if actual, ok := values[name]; !ok || actual != value {
+			t.Errorf("expected metric %s to be %d, got %d (present: %t)", name, value, actual, ok)
+		}
+	}
+}
+
+func TestMaxPeers(t *testing.T) {
+	m := newTestManager(t, &dagconfig.MainnetParams, 0)
+	m.SetMaxPeers(2)
+	addr := func(ip string) *appmessage.NetAddress {
+		return appmessage.NewNetAddressIPPort(net.ParseIP(ip), 1313)
+	}
+
+	added := m.AddAddresses([]*appmessage.NetAddress{addr("1.0.0.1"), addr("2.0.0.1"), addr("3.0.0.1")})
+	if added != 2 || len(m.nodes) != 2 {
+		t.Fatalf("expected only 2 peers to be added, got %d", added)
+	}
+	if m.MergeVerified(addr("4.0.0.1"), nil, time.Now()) || m.MergeSnapshot(addr("5.0.0.1"), nil, time.Now()) {
+		t.Errorf("expected the peers of other seeders to be dropped over the quota")
+	}
+	// Known peers are still updated, and explicit requests aren't limited.
+	if !m.MergeVerified(addr("1.0.0.1"), nil, time.Now()) {
+		t.Errorf("expected a known peer to be updated over the quota")
+	}
+	err := m.ForceCrawl(addr("6.0.0.1"))
+	if err != nil || len(m.nodes) != 3 {
+		t.Errorf("expected a forced crawl to add its peer over the quota, got %v", err)
+	}
+	for _, metric := range networkMetrics(m) {
+		if metric.name == "peers_over_quota" && metric.value != 3 {
+			t.Errorf("expected 3 addresses to be dropped, got %d", metric.value)
+		}
+	}
+
+	m.SetMaxPeers(0)
+	if added := m.AddAddresses([]*appmessage.NetAddress{addr("3.0.0.1")}); added != 1 {
+		t.Errorf("expected the peer to be added without a quota")
+	}
+}
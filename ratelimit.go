@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// rateLimiterPruneInterval is the interval between removals of the token
+// buckets of sources that went quiet.
+const rateLimiterPruneInterval = time.Minute
+
+// rateExemption gives the sources in a network their own budget, or
+// exempts them from rate limiting altogether when rate is zero.
+type rateExemption struct {
+	network *net.IPNet
+	rate    float64
+}
+
+// tokenBucket is the budget of a single source of queries or connections.
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// take refills the bucket with rate tokens per second, up to burst, and
+// spends one token if there is one. It returns whether a token was spent.
+func (b *tokenBucket) take(now time.Time, rate, burst float64) bool {
+	b.tokens += now.Sub(b.updated).Seconds() * rate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.updated = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// rateLimiter limits the number of DNS queries answered per second for each
+// source IP, allowing bursts of up to burst queries.
+type rateLimiter struct {
+	rate       float64
+	burst      float64
+	exemptions []rateExemption
+
+	mtx        sync.Mutex
+	buckets    map[string]*tokenBucket
+	lastPruned time.Time
+}
+
+// newRateLimiter returns a rate limiter allowing rate queries per second
+// with bursts of burst queries for each source, or nil if rate is zero.
+// exemptions are parsed by parseRateExemptions.
+func newRateLimiter(rate float64, burst int, exemptions []rateExemption) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return &rateLimiter{
+		rate:       rate,
+		burst:      float64(burst),
+		exemptions: exemptions,
+		buckets:    make(map[string]*tokenBucket),
+		lastPruned: time.Now(),
+	}
+}
+
+// parseRateExemptions parses a comma separated list of addresses or CIDR
+// networks, each optionally followed by =rate to give it its own budget
+// rather than exempting it.
+func parseRateExemptions(list string) ([]rateExemption, error) {
+	if list == "" {
+		return nil, nil
+	}
+
+	var exemptions []rateExemption
+	for _, entry := range strings.Split(list, ",") {
+		address, rateStr, hasRate := strings.Cut(strings.TrimSpace(entry), "=")
+		ipNet, err := parseIPNet(address)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid rate limit exemption")
+		}
+		exemption := rateExemption{network: ipNet}
+		if hasRate {
+			exemption.rate, err = strconv.ParseFloat(rateStr, 64)
+			if err != nil || exemption.rate <= 0 {
+				return nil, errors.Errorf("invalid rate limit exemption rate: %s", rateStr)
+			}
+		}
+		exemptions = append(exemptions, exemption)
+	}
+	return exemptions, nil
+}
+
+// allow returns whether a query from ip may be answered, and spends one
+// token of its budget if so. A nil rate limiter allows every query.
+func (l *rateLimiter) allow(ip net.IP, now time.Time) bool {
+	if l == nil {
+		return true
+	}
+
+	rate, burst := l.rate, l.burst
+	for _, exemption := range l.exemptions {
+		if exemption.network.Contains(ip) {
+			if exemption.rate == 0 {
+				return true
+			}
+			// Exempted networks keep bursts of the same duration.
+			rate, burst = exemption.rate, l.burst*exemption.rate/l.rate
+			break
+		}
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if now.Sub(l.lastPruned) > rateLimiterPruneInterval {
+		l.prune(now)
+	}
+
+	key := ip.String()
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: burst, updated: now}
+		l.buckets[key] = bucket
+	}
+	return bucket.take(now, rate, burst)
+}
+
+// prune removes the buckets that have been refilled, since a new bucket
+// would be identical.
+//
+// This function MUST be called with the rate limiter lock held.
+func (l *rateLimiter) prune(now time.Time) {
+	for key, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.updated).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastPruned = now
+}
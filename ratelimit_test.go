@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	exemptions, err := parseRateExemptions("192.0.2.53, 198.51.100.0/24=10")
+	if err != nil {
+		t.Fatalf("parseRateExemptions: %s", err)
+	}
+	l := newRateLimiter(1, 2, exemptions)
+
+	now := time.Now()
+	allowed := func(ip string, count int) int {
+		var n int
+		for i := 0; i < count; i++ {
+			if l.allow(net.ParseIP(ip), now) {
+				n++
+			}
+		}
+		return n
+	}
+
+	if n := allowed("203.0.113.1", 5); n != 2 {
+		t.Errorf("expected a burst of 2 queries, got %d", n)
+	}
+	now = now.Add(time.Second)
+	if n := allowed("203.0.113.1", 5); n != 1 {
+		t.Errorf("expected 1 query after a second, got %d", n)
+	}
+	if n := allowed("192.0.2.53", 100); n != 100 {
+		t.Errorf("expected the exempted address to be unlimited, got %d", n)
+	}
+	if n := allowed("198.51.100.7", 100); n != 20 {
+		t.Errorf("expected a burst of 20 queries for the higher budget, got %d", n)
+	}
+
+	_, err = parseRateExemptions("198.51.100.0/24=0")
+	if err == nil {
+		t.Errorf("expected a zero exemption rate to be rejected")
+	}
+	if newRateLimiter(0, 2, nil) != nil {
+		t.Errorf("expected a zero rate to disable the rate limiter")
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/pkg/errors"
+)
+
+// rejectionSamplesSize is the number of recent samples kept for each
+// rejection reason.
+const rejectionSamplesSize = 10
+
+// The reasons a crawled peer is rejected for. Failures that don't tell
+// anything about the peer's configuration, such as a dropped connection,
+// are not counted as rejections.
+const (
+	// rejectedUnreachable is used for peers the seeder couldn't connect to
+	// on the advertised port.
+	rejectedUnreachable = "unreachable"
+
+	// rejectedWrongNetwork is used for peers of another network, which is
+	// how karlsend's gRPC transport exposes wrong magic bytes.
+	rejectedWrongNetwork = "wrong_network"
+
+	// rejectedProtocolVersion is used for peers running another protocol
+	// version than the one required for their network.
+	rejectedProtocolVersion = "protocol_version"
+
+	// rejectedNoAddresses is used for peers that completed the handshake
+	// but didn't answer the request for addresses.
+	rejectedNoAddresses = "no_addresses"
+
+	// rejectedLaggingTip is used for peers announcing a tip that other
+	// peers announced more than laggingTipAge before.
+	rejectedLaggingTip = "lagging_tip"
+)
+
+// rejectionReasons lists all rejection reasons, in the order they are
+// reported.
+var rejectionReasons = []string{
+	rejectedUnreachable,
+	rejectedWrongNetwork,
+	rejectedProtocolVersion,
+	rejectedNoAddresses,
+	rejectedLaggingTip,
+}
+
+// rejectionError is an error that rejects a peer for a given reason.
+type rejectionError struct {
+	reason  string
+	version *appmessage.MsgVersion
+	err     error
+}
+
+func (e *rejectionError) Error() string {
+	return e.err.Error()
+}
+
+func (e *rejectionError) Unwrap() error {
+	return e.err
+}
+
+// reject returns err annotated with the reason the peer is rejected for.
+// version is the version message the peer sent, or nil if the handshake
+// didn't get that far.
+func reject(reason string, version *appmessage.MsgVersion, err error) error {
+	return &rejectionError{reason: reason, version: version, err: err}
+}
+
+// rejectionSample describes a single rejected peer.
+type rejectionSample struct {
+	Time      time.Time `json:"time"`
+	Address   string    `json:"address"`
+	UserAgent string    `json:"userAgent,omitempty"`
+	Error     string    `json:"error"`
+}
+
+// rejectionSummary is the number of peers rejected for a reason since
+// startup, along with the most recent of them.
+type rejectionSummary struct {
+	Count   uint64            `json:"count"`
+	Samples []rejectionSample `json:"samples"`
+}
+
+// rejectionLog keeps the counters and recent samples of the rejected peers
+// for each reason.
+type rejectionLog struct {
+	counts  map[string]*uint64
+	mtx     sync.RWMutex
+	samples map[string][]rejectionSample
+}
+
+func newRejectionLog() *rejectionLog {
+	counts := make(map[string]*uint64, len(rejectionReasons))
+	for _, reason := range rejectionReasons {
+		counts[reason] = new(uint64)
+	}
+	return &rejectionLog{
+		counts:  counts,
+		samples: make(map[string][]rejectionSample),
+	}
+}
+
+var rejections = newRejectionLog()
+
+// add records the peer at addr if err rejects it.
+func (l *rejectionLog) add(addr *appmessage.NetAddress, err error) {
+	var rejection *rejectionError
+	if !errors.As(err, &rejection) {
+		return
+	}
+	reason := rejection.reason
+	atomic.AddUint64(l.counts[reason], 1)
+
+	sample := rejectionSample{
+		Time:    time.Now(),
+		Address: addr.TCPAddress().String(),
+		Error:   err.Error(),
+	}
+	if rejection.version != nil {
+		sample.UserAgent = rejection.version.UserAgent
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	samples := append(l.samples[reason], sample)
+	if len(samples) > rejectionSamplesSize {
+		samples = samples[len(samples)-rejectionSamplesSize:]
+	}
+	l.samples[reason] = samples
+}
+
+// Summaries returns the summary of each rejection reason, with the samples
+// newest first.
+func (l *rejectionLog) Summaries() map[string]rejectionSummary {
+	l.mtx.RLock()
+	defer l.mtx.RUnlock()
+
+	summaries := make(map[string]rejectionSummary, len(rejectionReasons))
+	for _, reason := range rejectionReasons {
+		samples := make([]rejectionSample, len(l.samples[reason]))
+		for i, sample := range l.samples[reason] {
+			samples[len(samples)-1-i] = sample
+		}
+		summaries[reason] = rejectionSummary{
+			Count:   atomic.LoadUint64(l.counts[reason]),
+			Samples: samples,
+		}
+	}
+	return summaries
+}
+
+// metrics returns the rejection counters as stats metrics.
+func (l *rejectionLog) metrics() []metric {
+	metrics := make([]metric, len(rejectionReasons))
+	for i, reason := range rejectionReasons {
+		metrics[i] = metric{"crawl_rejected_" + reason, atomic.LoadUint64(l.counts[reason])}
+	}
+	return metrics
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/pkg/errors"
+)
+
+func TestRejectionLog(t *testing.T) {
+	l := newRejectionLog()
+	addr := appmessage.NewNetAddressIPPort(net.ParseIP("203.0.113.5"), 42111)
+	version := &appmessage.MsgVersion{UserAgent: "/karlsend:0.11.0/"}
+
+	l.add(addr, errors.New("connection reset"))
+	for i := 0; i < rejectionSamplesSize+2; i++ {
+		err := reject(rejectedProtocolVersion, version, errors.Errorf("protocol version %d", i))
+		l.add(addr, errors.Wrap(err, "Error in handshake"))
+	}
+	l.add(addr, reject(rejectedUnreachable, nil, errors.New("connection refused")))
+
+	summaries := l.Summaries()
+	if len(summaries) != len(rejectionReasons) {
+		t.Fatalf("expected a summary for each reason, got %v", summaries)
+	}
+	protocol := summaries[rejectedProtocolVersion]
+	if protocol.Count != rejectionSamplesSize+2 || len(protocol.Samples) != rejectionSamplesSize {
+		t.Errorf("unexpected protocol version summary: %d rejections, %d samples",
+			protocol.Count, len(protocol.Samples))
+	}
+	newest := protocol.Samples[0]
+	if newest.UserAgent != "/karlsend:0.11.0/" || newest.Error != "Error in handshake: protocol version 11" {
+		t.Errorf("unexpected newest sample %+v", newest)
+	}
+	if summaries[rejectedUnreachable].Count != 1 || summaries[rejectedUnreachable].Samples[0].UserAgent != "" {
+		t.Errorf("unexpected unreachable summary %+v", summaries[rejectedUnreachable])
+	}
+	if summaries[rejectedWrongNetwork].Count != 0 {
+		t.Errorf("expected errors without a reason not to be counted")
+	}
+}
+
+func TestTipTracker(t *testing.T) {
+	now := time.Now()
+	tips := newTipTracker()
+	if tips.lagging("tip1", now) {
+		t.Errorf("expected a new tip not to be lagging")
+	}
+	if tips.lagging("tip1", now.Add(laggingTipAge)) {
+		t.Errorf("expected a recent tip not to be lagging")
+	}
+	if !tips.lagging("tip1", now.Add(laggingTipAge+time.Second)) {
+		t.Errorf("expected a tip first announced long ago to be lagging")
+	}
+
+	for i := 0; i < maxTrackedTips; i++ {
+		tips.observe(strconv.Itoa(i), now)
+	}
+	if len(tips.firstSeen) != maxTrackedTips || len(tips.order) != maxTrackedTips {
+		t.Fatalf("expected %d tracked tips, got %d", maxTrackedTips, len(tips.firstSeen))
+	}
+	if tips.lagging("tip1", now.Add(laggingTipAge+time.Second)) {
+		t.Errorf("expected the oldest tip to be forgotten")
+	}
+
+	var nilTips *tipTracker
+	if nilTips.lagging("tip1", now) {
+		t.Errorf("expected a nil tracker to find no tip lagging")
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// reloadConfig re-reads the configuration file and command line, and
+// applies the settings that can change while the seeder is running: the
+// crawl interval, the DNS TTLs and public resolvers, the peer status and
+// membership subdomains, the answer diversity limits, the hosting ranges
+// and their answer limits, the garbage collection thresholds, the ban list
+// and the log level. Changes to any other setting are ignored with a
+// warning, since they require a restart.
+func reloadConfig(amgr *Manager) error {
+	newCfg, _, err := parseConfig(false)
+	if err != nil {
+		return errors.Wrap(err, "failed to reload configuration")
+	}
+
+	var bans []*Ban
+	if newCfg.BanList != "" {
+		bans, err = readBanList(newCfg.BanList)
+		if err != nil {
+			return err
+		}
+	}
+
+	resolvers, err := parsePublicResolvers(newCfg.PublicResolvers)
+	if err != nil {
+		return err
+	}
+
+	var hostingRanges *hostingRanges
+	if newCfg.HostingRanges != "" {
+		hostingRanges, err = readHostingRanges(newCfg.HostingRanges)
+		if err != nil {
+			return err
+		}
+	}
+
+	oldCfg := ActiveConfig()
+	cfg := *oldCfg
+	cfg.CrawlInterval = newCfg.CrawlInterval
+	cfg.DNSTTL = newCfg.DNSTTL
+	cfg.PublicResolvers = newCfg.PublicResolvers
+	cfg.ResolverTTL = newCfg.ResolverTTL
+	cfg.ECSTTL = newCfg.ECSTTL
+	cfg.PeerStatus = newCfg.PeerStatus
+	cfg.Membership = newCfg.Membership
+	cfg.MaxPerNetgroup = newCfg.MaxPerNetgroup
+	cfg.MaxPerCountry = newCfg.MaxPerCountry
+	cfg.MaxPerContinent = newCfg.MaxPerContinent
+	cfg.MaxPerASN = newCfg.MaxPerASN
+	cfg.HostingRanges = newCfg.HostingRanges
+	cfg.MaxHosted = newCfg.MaxHosted
+	cfg.ExcludeHosted = newCfg.ExcludeHosted
+	cfg.GCDemoteAfter = newCfg.GCDemoteAfter
+	cfg.GCGoodRetention = newCfg.GCGoodRetention
+	cfg.GCUnreachableRetention = newCfg.GCUnreachableRetention
+	cfg.BanList = newCfg.BanList
+	cfg.LogLevel = newCfg.LogLevel
+
+	// Whatever still differs once the reloadable settings are copied over
+	// can only be changed by a restart.
+	ignored := *newCfg
+	ignored.ConfigFile = oldCfg.ConfigFile
+	if !reflect.DeepEqual(&ignored, &cfg) {
+		log.Warnf("Some configuration changes require a restart and were not applied")
+	}
+
+	setActiveConfig(&cfg)
+
+	if cfg.LogLevel != oldCfg.LogLevel {
+		err = setLogLevels(cfg.LogLevel)
+		if err != nil {
+			return err
+		}
+	}
+	for _, amgr := range networkManagers(amgr) {
+		amgr.SetBanList(banListSource, bans)
+	}
+	setHostingRanges(hostingRanges)
+	setPublicResolvers(resolvers)
+
+	log.Infof("Configuration reloaded")
+	return nil
+}
+
+// reloadOnHangup reloads the configuration whenever SIGHUP is received,
+// until quit is closed.
+func reloadOnHangup(amgr *Manager, quit <-chan struct{}) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	for {
+		select {
+		case <-hangup:
+			log.Infof("Received SIGHUP, reloading configuration")
+			err := reloadConfig(amgr)
+			if err != nil {
+				log.Errorf("%+v", err)
+			}
+		case <-quit:
+			return
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/karlsen-network/karlsend/infrastructure/config"
+	"github.com/pkg/errors"
+)
+
+// runReplica serves DNS as a read-only replica from the serving index at
+// cfg.ServeFrom, mapped again whenever the crawling seeder replaces it,
+// until an interrupt signal is received.
+func runReplica(cfg *ConfigFlags, interrupt <-chan struct{}) error {
+	index, err := openServingIndex(cfg.ServeFrom)
+	if err != nil {
+		return err
+	}
+	defer index.close()
+	log.Infof("Serving DNS as a read-only replica from %s, generated at %s",
+		cfg.ServeFrom, index.generatedAt())
+
+	networks, err = setupReplicaNetworks(cfg, index)
+	if err != nil {
+		return err
+	}
+	amgr = networks[0].amgr
+	peersDefaultPort = networks[0].defaultPort
+
+	err = startDNSServer(cfg, networks)
+	if err != nil {
+		return err
+	}
+	defer answerAudit.Close()
+
+	spawn("main-watchServingIndex", func() { index.watch(amgr.quit) })
+
+	defer func() {
+		log.Infof("Gracefully shutting down the replica...")
+		atomic.StoreInt32(&systemShutdown, 1)
+		for _, network := range networks {
+			close(network.amgr.quit)
+		}
+		wg.Wait()
+		log.Infof("Replica shutdown complete")
+	}()
+
+	<-interrupt
+	return nil
+}
+
+// setupReplicaNetworks sets up the networks of the configuration to answer
+// from index.
+func setupReplicaNetworks(cfg *ConfigFlags, index *servingIndex) ([]*seederNetwork, error) {
+	var alwaysServe []string
+	if cfg.AlwaysServe != "" {
+		alwaysServe = strings.Split(cfg.AlwaysServe, ",")
+	}
+	primary, err := newReplicaNetwork(cfg.NetworkFlags, cfg.AllZones(), alwaysServe, index)
+	if err != nil {
+		return nil, err
+	}
+	all := []*seederNetwork{primary}
+
+	for _, networkCfg := range cfg.Networks {
+		flags, err := networkFlagsFromName(networkCfg.Network, cfg.CustomNetworks)
+		if err != nil {
+			return nil, err
+		}
+		network, err := newReplicaNetwork(flags, networkCfg.Zones, networkCfg.AlwaysServe, index)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, network)
+	}
+	return all, nil
+}
+
+// newReplicaNetwork returns the network selected by flags, answering from
+// index with no nodes of its own.
+func newReplicaNetwork(flags config.NetworkFlags, zones []ZoneConfig, alwaysServe []string,
+	index *servingIndex) (*seederNetwork, error) {
+
+	params := flags.NetParams()
+	defaultPort, err := strconv.Atoi(params.DefaultPort)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid peers default port %s", params.DefaultPort)
+	}
+
+	amgr := &Manager{
+		nodes:        make(map[string]*Node),
+		bans:         NewBanManager(),
+		quit:         make(chan struct{}),
+		crawlSignal:  make(chan struct{}, 1),
+		netParams:    params,
+		defaultPort:  uint16(defaultPort),
+		rotation:     newAnswerRotation(),
+		warm:         1,
+		servingIndex: index,
+	}
+	ips, err := parseAlwaysServe(alwaysServe)
+	if err != nil {
+		return nil, err
+	}
+	amgr.SetAlwaysServe(ips)
+
+	return &seederNetwork{
+		flags:       flags,
+		amgr:        amgr,
+		defaultPort: defaultPort,
+		zones:       zones,
+	}, nil
+}
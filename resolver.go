@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/btcsuite/go-socks/socks"
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+const (
+	// bootstrapLookupTimeout is the timeout of each lookup of a bootstrap
+	// name through the proxy or the DNS-over-HTTPS resolver.
+	bootstrapLookupTimeout = time.Second * 30
+
+	// dohContentType is the media type of DNS-over-HTTPS queries and
+	// answers, as RFC 8484 defines.
+	dohContentType = "application/dns-message"
+
+	// socksCommandResolve is the SOCKS command Tor adds to resolve a name
+	// without connecting to it.
+	socksCommandResolve = 0xf0
+)
+
+// lookupBootstrapHost resolves host, the name of a DNS seed or of the
+// seeder, with the DNS-over-HTTPS resolver (--bootstrapdoh) if set, through
+// the proxy (--bootstrapproxy) if set, and with the host resolver otherwise, so a
+// seeder routing its connections through Tor doesn't leak its lookups.
+func lookupBootstrapHost(host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	var proxy, doh string
+	if cfg := ActiveConfig(); cfg != nil {
+		proxy, doh = cfg.BootstrapProxy, cfg.BootstrapDoH
+	}
+	switch {
+	case doh != "":
+		return lookupDoH(doh, proxy, host)
+	case proxy != "":
+		return lookupSOCKS(proxy, host)
+	}
+	return net.LookupIP(host)
+}
+
+// lookupDoH resolves the A and AAAA records of host with the
+// DNS-over-HTTPS resolver at resolverURL, connecting to it through the
+// SOCKS proxy if one is given.
+func lookupDoH(resolverURL, proxy, host string) ([]net.IP, error) {
+	transport := &http.Transport{}
+	if proxy != "" {
+		// The proxy resolves the name of the DoH resolver too.
+		socksProxy := &socks.Proxy{Addr: proxy}
+		transport.DialContext = func(_ context.Context, network, address string) (net.Conn, error) {
+			return socksProxy.DialTimeout(network, address, bootstrapLookupTimeout)
+		}
+	}
+	client := &http.Client{Transport: transport, Timeout: bootstrapLookupTimeout}
+	defer transport.CloseIdleConnections()
+
+	var ips []net.IP
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		query := new(dns.Msg)
+		query.SetQuestion(dns.Fqdn(host), qtype)
+		// RFC 8484 recommends an ID of zero, so answers can be cached.
+		query.Id = 0
+		packed, err := query.Pack()
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := client.Post(resolverURL, dohContentType, bytes.NewReader(packed))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to query %s", resolverURL)
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, errors.Errorf("unexpected status %s from %s", resp.Status, resolverURL)
+		}
+
+		answer := new(dns.Msg)
+		err = answer.Unpack(body)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid answer from %s", resolverURL)
+		}
+		if answer.Rcode != dns.RcodeSuccess {
+			return nil, errors.Errorf("failed to resolve %s: %s", host, dns.RcodeToString[answer.Rcode])
+		}
+		for _, rr := range answer.Answer {
+			switch rr := rr.(type) {
+			case *dns.A:
+				ips = append(ips, rr.A)
+			case *dns.AAAA:
+				ips = append(ips, rr.AAAA)
+			}
+		}
+	}
+	if len(ips) == 0 {
+		return nil, errors.Errorf("no addresses found for %s", host)
+	}
+	return ips, nil
+}
+
+// lookupSOCKS resolves host through the SOCKS5 proxy with the RESOLVE
+// command of Tor, which returns a single address.
+func lookupSOCKS(proxy, host string) ([]net.IP, error) {
+	if len(host) > 255 {
+		return nil, errors.Errorf("name too long: %s", host)
+	}
+	conn, err := net.DialTimeout("tcp", proxy, bootstrapLookupTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	err = conn.SetDeadline(time.Now().Add(bootstrapLookupTimeout))
+	if err != nil {
+		return nil, err
+	}
+
+	// Offer no authentication only, as Tor accepts.
+	_, err = conn.Write([]byte{5, 1, 0})
+	if err != nil {
+		return nil, err
+	}
+	reply := make([]byte, 2)
+	_, err = io.ReadFull(conn, reply)
+	if err != nil {
+		return nil, err
+	}
+	if reply[0] != 5 || reply[1] != 0 {
+		return nil, errors.Errorf("the proxy %s refused the connection without authentication", proxy)
+	}
+
+	request := []byte{5, socksCommandResolve, 0, 3, byte(len(host))}
+	request = append(request, host...)
+	request = append(request, 0, 0)
+	_, err = conn.Write(request)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 4)
+	_, err = io.ReadFull(conn, header)
+	if err != nil {
+		return nil, err
+	}
+	if header[0] != 5 || header[1] != 0 {
+		return nil, errors.Errorf("the proxy %s failed to resolve %s: status %d", proxy, host, header[1])
+	}
+	var size int
+	switch header[3] {
+	case 1:
+		size = net.IPv4len
+	case 4:
+		size = net.IPv6len
+	default:
+		return nil, errors.Errorf("unexpected address type %d from the proxy %s", header[3], proxy)
+	}
+	// The address is followed by a port, which is meaningless here.
+	address := make([]byte, size+2)
+	_, err = io.ReadFull(conn, address)
+	if err != nil {
+		return nil, err
+	}
+	return []net.IP{net.IP(address[:size])}, nil
+}
+
+// validateBootstrapResolver checks the proxy and DNS-over-HTTPS resolver
+// bootstrap names are resolved with.
+func validateBootstrapResolver(proxy, doh string) error {
+	if proxy != "" {
+		_, _, err := net.SplitHostPort(proxy)
+		if err != nil {
+			return errors.Wrapf(err, "invalid proxy address %s", proxy)
+		}
+	}
+	if doh != "" {
+		u, err := url.Parse(doh)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return errors.Errorf("invalid DNS-over-HTTPS resolver %s: it must be an https URL", doh)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestLookupSOCKS(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %s", err)
+	}
+	defer listener.Close()
+
+	// The proxy answers the RESOLVE command of Tor for seed.example.org
+	// only.
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			greeting := make([]byte, 3)
+			_, err = io.ReadFull(conn, greeting)
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			conn.Write([]byte{5, 0})
+			header := make([]byte, 5)
+			io.ReadFull(conn, header)
+			name := make([]byte, header[4]+2)
+			io.ReadFull(conn, name)
+			if header[1] == socksCommandResolve && string(name[:len(name)-2]) == "seed.example.org" {
+				conn.Write([]byte{5, 0, 0, 1, 203, 0, 113, 5, 0, 0})
+			} else {
+				conn.Write([]byte{5, 4, 0, 1, 0, 0, 0, 0, 0, 0})
+			}
+			conn.Close()
+		}
+	}()
+
+	ips, err := lookupSOCKS(listener.Addr().String(), "seed.example.org")
+	if err != nil || len(ips) != 1 || !ips[0].Equal(net.ParseIP("203.0.113.5")) {
+		t.Errorf("expected seed.example.org to resolve to 203.0.113.5, got %v, %v", ips, err)
+	}
+	_, err = lookupSOCKS(listener.Addr().String(), "unknown.example.org")
+	if err == nil {
+		t.Errorf("expected the lookup of an unknown name to fail")
+	}
+
+	setTestConfig(t, &ConfigFlags{BootstrapProxy: listener.Addr().String()})
+	ips, err = lookupBootstrapHost("seed.example.org")
+	if err != nil || len(ips) != 1 {
+		t.Errorf("expected the bootstrap lookup to go through the proxy, got %v, %v", ips, err)
+	}
+}
+
+func TestLookupDoH(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		query := new(dns.Msg)
+		if r.Header.Get("Content-Type") != dohContentType || query.Unpack(body) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		answer := new(dns.Msg)
+		answer.SetReply(query)
+		if query.Question[0].Name != "seed.example.org." {
+			answer.Rcode = dns.RcodeNameError
+		} else if query.Question[0].Qtype == dns.TypeA {
+			rr, _ := dns.NewRR("seed.example.org. 60 IN A 203.0.113.5")
+			answer.Answer = append(answer.Answer, rr)
+		} else {
+			rr, _ := dns.NewRR("seed.example.org. 60 IN AAAA 2001:db8::5")
+			answer.Answer = append(answer.Answer, rr)
+		}
+		packed, _ := answer.Pack()
+		w.Header().Set("Content-Type", dohContentType)
+		w.Write(packed)
+	}))
+	defer server.Close()
+
+	ips, err := lookupDoH(server.URL, "", "seed.example.org")
+	if err != nil || len(ips) != 2 || !ips[0].Equal(net.ParseIP("203.0.113.5")) ||
+		!ips[1].Equal(net.ParseIP("2001:db8::5")) {
+		t.Errorf("expected both addresses of seed.example.org, got %v, %v", ips, err)
+	}
+	_, err = lookupDoH(server.URL, "", "unknown.example.org")
+	if err == nil {
+		t.Errorf("expected the lookup of an unknown name to fail")
+	}
+
+	for proxy, doh := range map[string]string{"127.0.0.1": "", "": server.URL} {
+		if validateBootstrapResolver(proxy, doh) == nil {
+			t.Errorf("expected the proxy %q and resolver %q to be refused", proxy, doh)
+		}
+	}
+	if err := validateBootstrapResolver("127.0.0.1:9050", "https://dns.example.org/dns-query"); err != nil {
+		t.Errorf("validateBootstrapResolver: %s", err)
+	}
+}
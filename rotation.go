@@ -0,0 +1,120 @@
+package main
+
+import (
+	"container/list"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// rotationIdleTimeout is the time after which the position of a source
+	// that stopped querying is forgotten.
+	rotationIdleTimeout = time.Hour
+
+	// maxRotationCursors is the maximum number of positions kept. The
+	// position of the source that queried least recently is forgotten to
+	// make room for a new one, so sources spoofing many addresses can't
+	// grow the rotation without bound.
+	maxRotationCursors = 1 << 16
+)
+
+// rotationCursor is the position in the pool of good peers at which the
+// next answer to a source starts.
+type rotationCursor struct {
+	key      string
+	position int
+	used     time.Time
+}
+
+// answerRotation keeps track of the position of each source in the pool of
+// good peers, so consecutive answers to the same source cycle through the
+// whole pool instead of sampling it independently. Sources are grouped by
+// their /24 (IPv4) or /48 (IPv6) network, as a resolver farm or a client
+// with many IPv6 addresses is a single source.
+type answerRotation struct {
+	mtx     sync.Mutex
+	cursors map[string]*list.Element
+
+	// recent holds the cursors, most recently used first.
+	recent     *list.List
+	lastPruned time.Time
+}
+
+func newAnswerRotation() *answerRotation {
+	return &answerRotation{
+		cursors:    make(map[string]*list.Element),
+		recent:     list.New(),
+		lastPruned: time.Now(),
+	}
+}
+
+// rotationKey returns the key of the cursor of source for the given query
+// type, since each type is answered from its own pool.
+func rotationKey(source net.IP, qtype uint16) string {
+	return subnetKey(source) + "/" + strconv.Itoa(int(qtype))
+}
+
+// position returns the position at which the answer to source for qtype
+// starts. Answers without a source, or from a nil rotation, start at a
+// random position.
+func (r *answerRotation) position(source net.IP, qtype uint16, now time.Time) int {
+	if r == nil || source == nil {
+		return rand.Int()
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if now.Sub(r.lastPruned) > rotationIdleTimeout {
+		for element := r.recent.Back(); element != nil; element = r.recent.Back() {
+			cursor := element.Value.(*rotationCursor)
+			if now.Sub(cursor.used) <= rotationIdleTimeout {
+				break
+			}
+			r.remove(element)
+		}
+		r.lastPruned = now
+	}
+
+	key := rotationKey(source, qtype)
+	element, ok := r.cursors[key]
+	if !ok {
+		if r.recent.Len() >= maxRotationCursors {
+			r.remove(r.recent.Back())
+		}
+		// New sources start at a random position, so they don't all
+		// get the same first answer.
+		element = r.recent.PushFront(&rotationCursor{key: key, position: rand.Int()})
+		r.cursors[key] = element
+	}
+	r.recent.MoveToFront(element)
+	cursor := element.Value.(*rotationCursor)
+	cursor.used = now
+	return cursor.position
+}
+
+// advance moves the position of source for qtype past the scanned peers.
+func (r *answerRotation) advance(source net.IP, qtype uint16, scanned int) {
+	if r == nil || source == nil {
+		return
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	element, ok := r.cursors[rotationKey(source, qtype)]
+	if ok {
+		element.Value.(*rotationCursor).position += scanned
+	}
+}
+
+// remove forgets the cursor of element.
+//
+// This function MUST be called with the rotation lock held.
+func (r *answerRotation) remove(element *list.Element) {
+	delete(r.cursors, element.Value.(*rotationCursor).key)
+	r.recent.Remove(element)
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/karlsen-network/karlsend/domain/consensus/model/externalapi"
+	"github.com/pkg/errors"
+)
+
+// defaultScorerName is the name of the scorer reproducing the built-in
+// selection: every good peer is served, all with the same weight.
+const defaultScorerName = "default"
+
+// uptimeWindows are the periods over which the reliability of nodes is
+// tracked. Node.Uptime holds one entry per window, in this order.
+var uptimeWindows = []time.Duration{
+	time.Hour * 2,
+	time.Hour * 8,
+	time.Hour * 24,
+	time.Hour * 24 * 7,
+	time.Hour * 24 * 30,
+}
+
+// uptimeStat tracks the reliability of a node over one uptime window.
+// Each crawl attempt stands for the time since the previous one, and older
+// periods weigh exponentially less. Reachable is the decayed time the node
+// was reachable and Weight the decayed time covered, both as fractions of
+// the window; Weight tends to 1 as the attempts cover the window, telling
+// how much the reliability can be trusted.
+type uptimeStat struct {
+	Reachable float64
+	Weight    float64
+}
+
+// Reliability returns the fraction of the covered time the node was
+// reachable, or 0 if nothing is known yet.
+func (s uptimeStat) Reliability() float64 {
+	if s.Weight <= 0 {
+		return 0
+	}
+	return s.Reachable / s.Weight
+}
+
+// updateUptime records a crawl attempt of the node at now in its uptime
+// windows. The first attempt only starts the tracking.
+func (n *Node) updateUptime(reached bool, now time.Time) {
+	if len(n.Uptime) != len(uptimeWindows) {
+		n.Uptime = make([]uptimeStat, len(uptimeWindows))
+	}
+	if !n.UptimeUpdated.IsZero() && now.After(n.UptimeUpdated) {
+		age := now.Sub(n.UptimeUpdated).Seconds()
+		for i, window := range uptimeWindows {
+			decay := math.Exp(-age / window.Seconds())
+			stat := &n.Uptime[i]
+			stat.Reachable *= decay
+			stat.Weight = stat.Weight*decay + 1 - decay
+			if reached {
+				stat.Reachable += 1 - decay
+			}
+		}
+	}
+	n.UptimeUpdated = now
+}
+
+// reliabilityParams returns the configured half-life of the reliability
+// estimate, 0 if it is disabled, and its promotion and demotion thresholds.
+func reliabilityParams() (halfLife time.Duration, promote float64, demote float64) {
+	cfg := ActiveConfig()
+	if cfg == nil {
+		return 0, defaultReliabilityPromote, defaultReliabilityDemote
+	}
+	return cfg.ReliabilityHalfLife, cfg.ReliabilityPromote, cfg.ReliabilityDemote
+}
+
+// reliabilityAt returns the reliability estimate of the node at now. Without
+// crawl attempts since the last update, it decays toward 0, so nodes that
+// stop being crawled eventually stop being served.
+func (n *Node) reliabilityAt(now time.Time, halfLife time.Duration) float64 {
+	if !now.After(n.ReliabilityUpdated) {
+		return n.Reliability
+	}
+	return n.Reliability * math.Exp2(-now.Sub(n.ReliabilityUpdated).Seconds()/halfLife.Seconds())
+}
+
+// updateReliability records a crawl attempt of the node at now in its
+// reliability estimate, as uptime windows do: the attempt stands for the
+// time since the previous one, and weighs half as much after each
+// half-life. The first attempt sets the estimate to its outcome. The node
+// becomes reliable when the estimate reaches promote, and stops being so
+// when it falls below demote.
+func (n *Node) updateReliability(reached bool, now time.Time, halfLife time.Duration, promote, demote float64) {
+	if n.ReliabilityUpdated.IsZero() {
+		n.Reliability = 0
+		if reached {
+			n.Reliability = 1
+		}
+	} else {
+		decay := 1.0
+		if now.After(n.ReliabilityUpdated) {
+			decay = math.Exp2(-now.Sub(n.ReliabilityUpdated).Seconds() / halfLife.Seconds())
+		}
+		n.Reliability *= decay
+		if reached {
+			n.Reliability += 1 - decay
+		}
+	}
+	n.ReliabilityUpdated = now
+
+	if n.Reliability >= promote {
+		n.Reliable = true
+	} else if n.Reliability < demote {
+		n.Reliable = false
+	}
+}
+
+// PeerScoreInput is what a Scorer knows about a candidate peer.
+type PeerScoreInput struct {
+	// Node is the peer as known to the seeder, including its last version
+	// message, its uptime windows and its handshake latency. It must not
+	// be modified or retained.
+	Node *Node
+
+	// Now is the time of the query being answered.
+	Now time.Time
+
+	// NetgroupPeers is the number of candidate peers of the answer,
+	// including this one, sharing its netgroup: its /16 (IPv4) or /32
+	// (IPv6) network, or its origin AS with --asnnetgroups.
+	NetgroupPeers int
+}
+
+// Scorer decides which of the candidate peers of an answer are served, and
+// how often. Candidates already match the address family, subnetwork and
+// partial node requirements of the query; the per answer diversity limits
+// are applied afterwards. Implementations must be safe for concurrent use.
+type Scorer interface {
+	// Score returns the weight of the peer in answers: peers are kept,
+	// when their turn in the rotation comes, with a probability
+	// proportional to their weight relative to the heaviest candidate. A
+	// weight of 0 or less keeps the peer from being served.
+	Score(peer *PeerScoreInput) float64
+}
+
+// defaultScorer serves every good peer with the same weight.
+type defaultScorer struct{}
+
+func (defaultScorer) Score(peer *PeerScoreInput) float64 {
+	if !peer.Node.isGood(peer.Now) {
+		return 0
+	}
+	return 1
+}
+
+var (
+	scorersMtx sync.RWMutex
+	scorers    = map[string]Scorer{defaultScorerName: defaultScorer{}}
+)
+
+// RegisterScorer makes a scoring policy selectable with --scorer. It is
+// meant to be called from the init function of a file added to the seeder,
+// so custom policies don't need changes to the selection code.
+func RegisterScorer(name string, scorer Scorer) {
+	scorersMtx.Lock()
+	defer scorersMtx.Unlock()
+	scorers[name] = scorer
+}
+
+// lookupScorer returns the registered scorer with the given name.
+func lookupScorer(name string) (Scorer, error) {
+	scorersMtx.RLock()
+	defer scorersMtx.RUnlock()
+
+	scorer, ok := scorers[name]
+	if !ok {
+		names := make([]string, 0, len(scorers))
+		for name := range scorers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, errors.Errorf("unknown scorer %s: it must be one of %s", name, strings.Join(names, ", "))
+	}
+	return scorer, nil
+}
+
+// scoredPeer is a peer that may be served, with its weight in answers, the
+// services it may provide and when it was last reached.
+type scoredPeer struct {
+	addr         *appmessage.NetAddress
+	subnetworkID *externalapi.DomainSubnetworkID
+	services     appmessage.ServiceFlag
+	lastSuccess  time.Time
+	weight       float64
+}
+
+// sortScoredPeers sorts pool by address, so that answers draw from it in
+// the same order from one to the next.
+func sortScoredPeers(pool []scoredPeer) {
+	sort.Slice(pool, func(a, b int) bool {
+		return bytes.Compare(pool[a].addr.IP.To16(), pool[b].addr.IP.To16()) < 0
+	})
+}
+
+// scorePeers scores the candidate peers of an answer, and returns those
+// that may be served along with the heaviest weight. The weight of peers
+// only known from snapshots is scaled down by the snapshot weight. Peers
+// not reached within the maximum served age, if any, are left out.
+//
+// This function MUST be called with the manager lock held (for reads).
+func (m *Manager) scorePeers(candidates []*Node, now time.Time) ([]scoredPeer, float64) {
+	return m.scorePeersWith(m.scorer, candidates, now, nil)
+}
+
+// scorePeersWith scores the candidate peers of an answer with scorer, or
+// the default scorer if it is nil, as scorePeers does. The score of each
+// candidate is recorded in explanation, if not nil.
+//
+// This function MUST be called with the manager lock held (for reads).
+func (m *Manager) scorePeersWith(scorer Scorer, candidates []*Node, now time.Time,
+	explanation *answerExplanation) ([]scoredPeer, float64) {
+
+	if scorer == nil {
+		scorer = defaultScorer{}
+	}
+	keys := make([]string, len(candidates))
+	netgroups := make(map[string]int)
+	for i, node := range candidates {
+		keys[i] = netgroupKey(node.Addr.IP)
+		netgroups[keys[i]]++
+	}
+
+	maxAge := maxServedAge()
+	var pool []scoredPeer
+	var maxWeight float64
+	for i, node := range candidates {
+		if !node.isFresh(now, maxAge) {
+			explanation.exclude(node.Addr, node.possibleServices(), "not reached within the maximum served age")
+			continue
+		}
+		weight := scorer.Score(&PeerScoreInput{
+			Node:          node,
+			Now:           now,
+			NetgroupPeers: netgroups[keys[i]],
+		})
+		if weight <= 0 || math.IsNaN(weight) {
+			explanation.exclude(node.Addr, node.possibleServices(), "not scored")
+			continue
+		}
+		if node.isSnapshotOnly(now) {
+			weight *= snapshotWeight()
+		}
+		explanation.score(node.Addr, node.possibleServices(), weight)
+		pool = append(pool, scoredPeer{addr: node.Addr, subnetworkID: node.SubnetworkID,
+			services: node.possibleServices(), lastSuccess: node.LastSuccess, weight: weight})
+		if weight > maxWeight {
+			maxWeight = weight
+		}
+	}
+	return pool, maxWeight
+}
+
+// SetScorer replaces the scoring policy of the network. A nil scorer
+// restores the default one.
+func (m *Manager) SetScorer(scorer Scorer) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.scorer = scorer
+}
+
+// RecordCrawl records a crawl attempt of the node with the given IP in its
+// uptime windows and reliability estimate, along with the handshake latency
+// if it was reached.
+func (m *Manager) RecordCrawl(ip net.IP, reached bool, latency time.Duration) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	node, exists := m.nodes[ip.String()]
+	if !exists {
+		return
+	}
+	now := m.now()
+	node.updateUptime(reached, now)
+	if halfLife, promote, demote := reliabilityParams(); halfLife > 0 {
+		node.updateReliability(reached, now, halfLife, promote, demote)
+	}
+	if m.experiment != nil {
+		m.experiment.recordCrawl(ip, reached)
+	}
+	if reached {
+		node.Latency = latency
+	} else {
+		// The seeder's own crawl outweighs the snapshots of others.
+		node.SnapshotUntil = time.Time{}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/karlsen-network/karlsend/domain/dagconfig"
+	"github.com/miekg/dns"
+)
+
+func TestUpdateUptime(t *testing.T) {
+	now := time.Now()
+	node := &Node{}
+	node.updateUptime(true, now)
+	for i, stat := range node.Uptime {
+		if stat.Weight != 0 || stat.Reliability() != 0 {
+			t.Errorf("window %s: expected nothing to be known after the first attempt, got %+v",
+				uptimeWindows[i], stat)
+		}
+	}
+
+	// Reachable for an hour, then unreachable for the next one.
+	node.updateUptime(true, now.Add(time.Hour))
+	node.updateUptime(false, now.Add(2*time.Hour))
+	twoHours := node.Uptime[0]
+	expectedWeight := 1 - math.Exp(-1)
+	if math.Abs(twoHours.Weight-expectedWeight) > 1e-9 {
+		t.Errorf("expected a weight of %f over 2 hours, got %f", expectedWeight, twoHours.Weight)
+	}
+	// The reachable hour is the older one, so it weighs less.
+	if reliability := twoHours.Reliability(); reliability <= 0.3 || reliability >= 0.5 {
+		t.Errorf("expected a reliability a bit under 0.5 over 2 hours, got %f", reliability)
+	}
+	if month := node.Uptime[len(uptimeWindows)-1]; month.Weight >= twoHours.Weight {
+		t.Errorf("expected the month window to be covered less than the 2 hour one, got %+v", month)
+	}
+}
+
+func TestReliability(t *testing.T) {
+	setTestConfig(t, &ConfigFlags{
+		CrawlInterval:       time.Hour,
+		ReliabilityHalfLife: 2 * time.Hour,
+		ReliabilityPromote:  defaultReliabilityPromote,
+		ReliabilityDemote:   defaultReliabilityDemote,
+	})
+	halfLife, promote, demote := reliabilityParams()
+
+	start := time.Now()
+	node := &Node{LastSuccess: start}
+	attempt := func(hours int, reached bool) time.Time {
+		now := start.Add(time.Duration(hours) * time.Hour)
+		if reached {
+			node.LastSuccess = now
+		}
+		node.updateReliability(reached, now, halfLife, promote, demote)
+		return now
+	}
+
+	now := attempt(0, true)
+	if !node.isVerified(now) {
+		t.Fatalf("expected a node reached on its first attempt to be good")
+	}
+	// A stable node missing a crawl stays good, unlike with the last
+	// success alone.
+	attempt(1, true)
+	now = attempt(2, false)
+	if !node.isVerified(now.Add(time.Hour)) {
+		t.Errorf("expected a single failure not to demote the node, estimate %f", node.Reliability)
+	}
+	attempt(3, false)
+	attempt(4, false)
+	now = attempt(5, false)
+	if node.isVerified(now) {
+		t.Errorf("expected repeated failures to demote the node, estimate %f", node.Reliability)
+	}
+
+	// Once demoted, a single success isn't enough to be served again.
+	now = attempt(6, true)
+	if node.Reliability < demote || node.isVerified(now) {
+		t.Errorf("expected the node to stay demoted above the demotion threshold, estimate %f", node.Reliability)
+	}
+	now = attempt(7, true)
+	if !node.isVerified(now) {
+		t.Errorf("expected successes to promote the node again, estimate %f", node.Reliability)
+	}
+
+	// Without crawls, the estimate decays until the node isn't served.
+	if node.isVerified(now.Add(4 * time.Hour)) {
+		t.Errorf("expected a node no longer crawled to stop being served")
+	}
+	if math.Abs(node.reliabilityAt(now.Add(halfLife), halfLife)-node.Reliability/2) > 1e-9 {
+		t.Errorf("expected the estimate to halve in a half-life")
+	}
+
+	// Nodes without an estimate are judged by their last success.
+	if !(&Node{LastSuccess: now}).isVerified(now) {
+		t.Errorf("expected a node without an estimate to be good after a recent success")
+	}
+}
+
+// latencyScorer serves good peers whose handshake took less than a second,
+// favoring the fastest and those with few neighbors.
+type latencyScorer struct{}
+
+func (latencyScorer) Score(peer *PeerScoreInput) float64 {
+	if !peer.Node.isGood(peer.Now) || peer.Node.Latency >= time.Second {
+		return 0
+	}
+	return float64(time.Second-peer.Node.Latency) / float64(peer.NetgroupPeers)
+}
+
+func TestScorer(t *testing.T) {
+	now := time.Now()
+	m := newTestManager(t, &dagconfig.MainnetParams, 1313)
+	for ip, latency := range map[string]time.Duration{
+		"1.0.0.1": 100 * time.Millisecond,
+		"2.0.0.1": 2 * time.Second,
+		"3.0.0.1": 200 * time.Millisecond,
+	} {
+		m.nodes[ip] = &Node{
+			Addr:        appmessage.NewNetAddressIPPort(net.ParseIP(ip), 1313),
+			LastSuccess: now,
+			Latency:     latency,
+		}
+	}
+
+	if addrs := m.GoodAddresses(dns.TypeA, true, nil, false, 0, nil, defaultMaxAddresses); len(addrs) != 3 {
+		t.Errorf("expected the default scorer to serve every good peer, got %v", addrs)
+	}
+
+	RegisterScorer("latency", latencyScorer{})
+	t.Cleanup(func() {
+		scorersMtx.Lock()
+		delete(scorers, "latency")
+		scorersMtx.Unlock()
+	})
+	scorer, err := lookupScorer("latency")
+	if err != nil {
+		t.Fatalf("lookupScorer: %s", err)
+	}
+	m.SetScorer(scorer)
+	pool, maxWeight := m.scorePeers([]*Node{m.nodes["1.0.0.1"], m.nodes["2.0.0.1"], m.nodes["3.0.0.1"]}, now)
+	if len(pool) != 2 || maxWeight != float64(900*time.Millisecond) {
+		t.Errorf("expected 2 peers with a maximum weight of 900ms, got %v, %f", pool, maxWeight)
+	}
+	for i := 0; i < 10; i++ {
+		for _, addr := range m.GoodAddresses(dns.TypeA, true, nil, false, 0, nil, defaultMaxAddresses) {
+			if addr.IP.Equal(net.ParseIP("2.0.0.1")) {
+				t.Fatalf("expected the slow peer not to be served")
+			}
+		}
+	}
+
+	_, err = lookupScorer("unknown")
+	if err == nil {
+		t.Errorf("expected an unknown scorer to be rejected")
+	}
+}
@@ -0,0 +1,120 @@
+package seeddns
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// MaxAddresses is the number of addresses in an answer of the seeder,
+	// unless the client requests another one with the answer count
+	// option.
+	MaxAddresses = 16
+
+	// DefaultMaxAnswerCount is the maximum number of addresses the seeder
+	// allows clients to request with the answer count option by default.
+	DefaultMaxAnswerCount = 32
+
+	// DefaultTTL is the TTL of the address records of the seeder by
+	// default.
+	DefaultTTL = 30
+)
+
+// EmptyAnswerAddress is answered alone to AAAA queries for peers when there
+// are none, since some resolvers, such as musl's, treat empty answers as
+// failures. It is in the discard-only prefix of RFC 6666, and is no peer.
+var EmptyAnswerAddress = net.ParseIP("100::")
+
+// NewAddressResponse returns the authoritative response to query, an A or
+// AAAA query for peers, answering the given addresses with a TTL of ttl
+// seconds. authority is the NS record of the zone. The response is
+// compressed, since every record has the same name.
+func NewAddressResponse(query *dns.Msg, authority dns.RR, ttl uint32, ips []net.IP) *dns.Msg {
+	response := query.Copy()
+	response.Authoritative = true
+	response.Response = true
+	response.Compress = true
+	response.Ns = append(response.Ns, authority)
+
+	question := query.Question[0]
+	if len(ips) == 0 && question.Qtype == dns.TypeAAAA {
+		ips = []net.IP{EmptyAnswerAddress}
+	}
+	header := dns.RR_Header{
+		Name:   question.Name,
+		Rrtype: question.Qtype,
+		Class:  dns.ClassINET,
+		Ttl:    ttl,
+	}
+	for _, ip := range ips {
+		if question.Qtype == dns.TypeA {
+			response.Answer = append(response.Answer, &dns.A{Hdr: header, A: ip.To4()})
+		} else {
+			response.Answer = append(response.Answer, &dns.AAAA{Hdr: header, AAAA: ip.To16()})
+		}
+	}
+	return response
+}
+
+// FitUDPSize trims the answer records of response so it fits the buffer
+// advertised by the EDNS0 OPT record of query, or in the 512 bytes of plain
+// DNS over UDP without one. The response isn't marked truncated, since
+// seeders don't serve DNS over TCP to retry on: the addresses left are a
+// smaller sample of the peers.
+func FitUDPSize(query *dns.Msg, response *dns.Msg) {
+	size := dns.MinMsgSize
+	if opt := query.IsEdns0(); opt != nil && int(opt.UDPSize()) > size {
+		size = int(opt.UDPSize())
+	}
+	for response.Len() > size && len(response.Answer) > 1 {
+		response.Answer = response.Answer[:len(response.Answer)-1]
+	}
+}
+
+// Answer is a response of the seeder, as read by clients.
+type Answer struct {
+	// Msg is the response itself.
+	Msg *dns.Msg
+
+	// Rcode, Authoritative and Truncated are the response code and flags
+	// of the response.
+	Rcode         int
+	Authoritative bool
+	Truncated     bool
+
+	// Addresses are those of the A and AAAA records answered, in order,
+	// leaving out EmptyAnswerAddress.
+	Addresses []net.IP
+
+	// TXT holds the strings of the TXT records answered, such as the
+	// status of a peer.
+	TXT []string
+
+	// TTLs holds the TTL of each record answered, in order.
+	TTLs []uint32
+}
+
+// ParseAnswer reads the response msg of the seeder.
+func ParseAnswer(msg *dns.Msg) *Answer {
+	answer := &Answer{
+		Msg:           msg,
+		Rcode:         msg.Rcode,
+		Authoritative: msg.Authoritative,
+		Truncated:     msg.Truncated,
+	}
+	for _, rr := range msg.Answer {
+		answer.TTLs = append(answer.TTLs, rr.Header().Ttl)
+		switch record := rr.(type) {
+		case *dns.A:
+			answer.Addresses = append(answer.Addresses, record.A)
+		case *dns.AAAA:
+			if !record.AAAA.Equal(EmptyAnswerAddress) {
+				answer.Addresses = append(answer.Addresses, record.AAAA)
+			}
+		case *dns.TXT:
+			answer.TXT = append(answer.TXT, record.Txt...)
+		}
+	}
+	return answer
+}
@@ -0,0 +1,62 @@
+package seeddns
+
+import (
+	"context"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// DefaultTimeout is the timeout of queries of clients without one.
+const DefaultTimeout = time.Second * 5
+
+// Client queries a seeder directly over UDP, as resolvers do.
+type Client struct {
+	// Server is the host:port address of the seeder.
+	Server string
+
+	// Timeout is the timeout of each query, DefaultTimeout if zero.
+	Timeout time.Duration
+
+	// AnswerCount, if non-zero, is the number of addresses requested per
+	// answer with the answer count option. The seeder answers at most as
+	// many as it allows.
+	AnswerCount int
+}
+
+// Query sends a query of type qtype for name, and reads the response.
+func (c *Client) Query(ctx context.Context, name string, qtype uint16) (*Answer, error) {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+
+	query := new(dns.Msg)
+	query.SetQuestion(dns.Fqdn(name), qtype)
+	if c.AnswerCount > 0 {
+		SetAnswerCount(query, c.AnswerCount)
+	}
+	client := &dns.Client{Net: "udp", Timeout: timeout}
+	response, _, err := client.ExchangeContext(ctx, query, c.Server)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to query %s", name)
+	}
+	return ParseAnswer(response), nil
+}
+
+// Peers queries the peers served under name, a zone or one of its peers
+// names, of the address family of qtype, dns.TypeA or dns.TypeAAAA.
+func (c *Client) Peers(ctx context.Context, name string, qtype uint16) (*Answer, error) {
+	if qtype != dns.TypeA && qtype != dns.TypeAAAA {
+		return nil, errors.Errorf("peers are queried with A or AAAA queries, not %s", dns.TypeToString[qtype])
+	}
+	answer, err := c.Query(ctx, name, qtype)
+	if err != nil {
+		return nil, err
+	}
+	if answer.Rcode != dns.RcodeSuccess {
+		return nil, errors.Errorf("query for %s failed: %s", name, dns.RcodeToString[answer.Rcode])
+	}
+	return answer, nil
+}
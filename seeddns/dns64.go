@@ -0,0 +1,49 @@
+package seeddns
+
+import (
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// ValidNAT64Prefix returns an error unless prefix is an IPv6 prefix of one
+// of the lengths RFC 6052 allows IPv4 addresses to be embedded in: 32, 40,
+// 48, 56, 64 or 96 bits, with bits 64 to 71 cleared.
+func ValidNAT64Prefix(prefix *net.IPNet) error {
+	ones, bits := prefix.Mask.Size()
+	if bits != net.IPv6len*8 || prefix.IP.To4() != nil {
+		return errors.Errorf("the NAT64 prefix %s is not an IPv6 prefix", prefix)
+	}
+	switch ones {
+	case 32, 40, 48, 56, 64, 96:
+	default:
+		return errors.Errorf("the NAT64 prefix %s must be 32, 40, 48, 56, 64 or 96 bits long", prefix)
+	}
+	if ones > 64 && prefix.IP[8] != 0 {
+		return errors.Errorf("bits 64 to 71 of the NAT64 prefix %s must be zero", prefix)
+	}
+	return nil
+}
+
+// SynthesizeNAT64 returns the IPv6 address embedding the IPv4 address ip in
+// prefix, as RFC 6052 specifies, skipping bits 64 to 71. prefix must be
+// valid according to ValidNAT64Prefix. It returns nil if ip is not an IPv4
+// address.
+func SynthesizeNAT64(prefix *net.IPNet, ip net.IP) net.IP {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return nil
+	}
+	ones, _ := prefix.Mask.Size()
+	synthesized := make(net.IP, net.IPv6len)
+	copy(synthesized, prefix.IP.To16()[:ones/8])
+	position := ones / 8
+	for _, b := range ip4 {
+		if position == 8 {
+			position++
+		}
+		synthesized[position] = b
+		position++
+	}
+	return synthesized
+}
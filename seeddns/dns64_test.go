@@ -0,0 +1,46 @@
+package seeddns
+
+import (
+	"net"
+	"testing"
+)
+
+func TestSynthesizeNAT64(t *testing.T) {
+	// The examples of RFC 6052, section 2.4.
+	tests := []struct {
+		prefix      string
+		synthesized string
+	}{
+		{"2001:db8::/32", "2001:db8:c000:221::"},
+		{"2001:db8:100::/40", "2001:db8:1c0:2:21::"},
+		{"2001:db8:122::/48", "2001:db8:122:c000:2:2100::"},
+		{"2001:db8:122:300::/56", "2001:db8:122:3c0:0:221::"},
+		{"2001:db8:122:344::/64", "2001:db8:122:344:c0:2:2100:0"},
+		{"2001:db8:122:344::/96", "2001:db8:122:344::192.0.2.33"},
+		{"64:ff9b::/96", "64:ff9b::192.0.2.33"},
+	}
+	ip := net.ParseIP("192.0.2.33")
+	for _, test := range tests {
+		_, prefix, err := net.ParseCIDR(test.prefix)
+		if err != nil {
+			t.Fatalf("ParseCIDR: %v", err)
+		}
+		if err := ValidNAT64Prefix(prefix); err != nil {
+			t.Errorf("%s: unexpected error %v", test.prefix, err)
+		}
+		if synthesized := SynthesizeNAT64(prefix, ip); !synthesized.Equal(net.ParseIP(test.synthesized)) {
+			t.Errorf("%s: expected %s, got %s", test.prefix, test.synthesized, synthesized)
+		}
+	}
+
+	_, prefix, _ := net.ParseCIDR("64:ff9b::/96")
+	if synthesized := SynthesizeNAT64(prefix, net.ParseIP("2001:db8::1")); synthesized != nil {
+		t.Errorf("expected no synthesis of an IPv6 address, got %s", synthesized)
+	}
+	for _, invalid := range []string{"192.0.2.0/24", "64:ff9b::/80", "64:ff9b:0:0:ff00::/96"} {
+		_, prefix, _ := net.ParseCIDR(invalid)
+		if ValidNAT64Prefix(prefix) == nil {
+			t.Errorf("expected %s to be rejected", invalid)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+// Package seeddns holds the DNS conventions of the seeder: the names under
+// which a zone serves peers and their status, how answers are built, and how
+// clients read them. The seeder serves its zones with it, and wallets and
+// other clients can use it to query them.
+package seeddns
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/karlsen-network/karlsend/domain/consensus/model/externalapi"
+	"github.com/karlsen-network/karlsend/domain/consensus/utils/subnetworks"
+	"github.com/karlsen-network/karlsend/infrastructure/network/dnsseed"
+	"github.com/miekg/dns"
+)
+
+const (
+	// StatusLabel is the label of the subdomain of each zone serving the
+	// status of single peers as TXT records.
+	StatusLabel = "status"
+
+	// MembershipLabel is the label of the subdomain of each zone answering
+	// DNSBL-style queries for whether an address is a good peer.
+	MembershipLabel = "known"
+
+	// PartialLabel is the label of the subdomain of each zone serving the
+	// partial nodes, which are left out of all the other answers since
+	// they can't serve the full DAG history.
+	PartialLabel = "partial"
+
+	// DNS64Label is the label of the subdomain of each zone answering AAAA
+	// queries with the IPv4 peers, embedded in the NAT64 prefix of the
+	// seeder, for IPv6-only clients behind NAT64.
+	DNS64Label = "dns64"
+)
+
+// MembershipAnswer is the address answered for good peers by membership
+// queries, as customary for DNSBLs.
+var MembershipAnswer = net.IPv4(127, 0, 0, 2)
+
+// SubnetworkName returns the name under zone serving the peers of the
+// subnetwork with the given ID. A nil ID names the peers that reported no
+// subnetwork.
+func SubnetworkName(zone string, subnetworkID *externalapi.DomainSubnetworkID) string {
+	label := string(dnsseed.SubnetworkIDPrefixChar)
+	if subnetworkID != nil {
+		label += subnetworkID.String()
+	}
+	return label + "." + dns.Fqdn(zone)
+}
+
+// PartialName returns the name under zone serving the partial nodes.
+func PartialName(zone string) string {
+	return PartialLabel + "." + dns.Fqdn(zone)
+}
+
+// DNS64Name returns the name under zone serving the IPv4 peers as
+// synthesized IPv6 addresses.
+func DNS64Name(zone string) string {
+	return DNS64Label + "." + dns.Fqdn(zone)
+}
+
+// StatusName returns the name under zone serving the status of the peer at
+// ip, in which the dots of an IPv4 address or the colons of an IPv6 address
+// are replaced by dashes.
+func StatusName(zone string, ip net.IP) string {
+	label := strings.NewReplacer(".", "-", ":", "-").Replace(ip.String())
+	return label + "." + StatusLabel + "." + dns.Fqdn(zone)
+}
+
+// MembershipName returns the name under zone answering whether ip is a good
+// peer. As in DNSBLs, IPv4 addresses are given by their four octets and IPv6
+// addresses by their 32 nibbles, in reverse order.
+func MembershipName(zone string, ip net.IP) string {
+	var labels []string
+	if ip4 := ip.To4(); ip4 != nil {
+		for i := len(ip4) - 1; i >= 0; i-- {
+			labels = append(labels, strconv.Itoa(int(ip4[i])))
+		}
+	} else {
+		const hexDigits = "0123456789abcdef"
+		ip16 := ip.To16()
+		for i := len(ip16) - 1; i >= 0; i-- {
+			labels = append(labels, string(hexDigits[ip16[i]&0xf]), string(hexDigits[ip16[i]>>4]))
+		}
+	}
+	return strings.Join(labels, ".") + "." + MembershipLabel + "." + dns.Fqdn(zone)
+}
+
+// ParseSubnetworkName returns which subnetwork a query for name, under the
+// zone zone, asks the peers of. Names of the form n<subnetwork ID>.<zone>
+// ask for those of that subnetwork, and n.<zone> for those that reported no
+// subnetwork; all other names ask for the peers of all subnetworks. Both
+// names must be lower case and fully qualified.
+func ParseSubnetworkName(zone string, name string) (subnetworkID *externalapi.DomainSubnetworkID,
+	includeAllSubnetworks bool, err error) {
+
+	if name == zone {
+		return nil, true, nil
+	}
+	labels := dns.SplitDomainName(name)
+	if len(labels) == 0 || labels[0][0] != dnsseed.SubnetworkIDPrefixChar {
+		return nil, true, nil
+	}
+	if len(labels[0]) == 1 {
+		return nil, false, nil
+	}
+	subnetworkID, err = subnetworks.FromString(labels[0][1:])
+	if err != nil {
+		return nil, false, err
+	}
+	return subnetworkID, false, nil
+}
+
+// IsPartialName returns whether name is the subdomain of zone serving the
+// partial nodes.
+func IsPartialName(zone string, name string) bool {
+	return name == PartialLabel+"."+zone
+}
+
+// IsDNS64Name returns whether name is the subdomain of zone serving the
+// IPv4 peers as synthesized IPv6 addresses.
+func IsDNS64Name(zone string, name string) bool {
+	return name == DNS64Label+"."+zone
+}
+
+// ParseStatusName returns the peer address queried by a status name under
+// zone, as built by StatusName.
+func ParseStatusName(zone string, name string) (net.IP, bool) {
+	suffix := "." + StatusLabel + "." + zone
+	if !strings.HasSuffix(name, suffix) {
+		return nil, false
+	}
+	label := strings.TrimSuffix(name, suffix)
+	if strings.Contains(label, ".") {
+		return nil, false
+	}
+
+	ip := net.ParseIP(strings.ReplaceAll(label, "-", "."))
+	if ip == nil || ip.To4() == nil {
+		ip = net.ParseIP(strings.ReplaceAll(label, "-", ":"))
+	}
+	return ip, ip != nil
+}
+
+// ParseMembershipName returns the peer address queried by a membership name
+// under zone, as built by MembershipName.
+func ParseMembershipName(zone string, name string) (net.IP, bool) {
+	suffix := "." + MembershipLabel + "." + zone
+	if !strings.HasSuffix(name, suffix) {
+		return nil, false
+	}
+	labels := strings.Split(strings.TrimSuffix(name, suffix), ".")
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+
+	switch len(labels) {
+	case net.IPv4len:
+		ip := net.ParseIP(strings.Join(labels, "."))
+		return ip, ip != nil && ip.To4() != nil
+	case net.IPv6len * 2:
+		var address strings.Builder
+		for i, label := range labels {
+			if len(label) != 1 {
+				return nil, false
+			}
+			if i > 0 && i%4 == 0 {
+				address.WriteByte(':')
+			}
+			address.WriteString(label)
+		}
+		ip := net.ParseIP(address.String())
+		return ip, ip != nil
+	}
+	return nil, false
+}
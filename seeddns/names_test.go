@@ -0,0 +1,65 @@
+package seeddns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/karlsen-network/karlsend/domain/consensus/utils/subnetworks"
+)
+
+func TestNames(t *testing.T) {
+	const zone = "seed.example.org."
+	for _, address := range []string{"203.0.113.5", "2001:db8::1"} {
+		ip := net.ParseIP(address)
+		parsed, ok := ParseStatusName(zone, StatusName("seed.example.org", ip))
+		if !ok || !parsed.Equal(ip) {
+			t.Errorf("status name of %s: got %s %t", address, parsed, ok)
+		}
+		parsed, ok = ParseMembershipName(zone, MembershipName("seed.example.org", ip))
+		if !ok || !parsed.Equal(ip) {
+			t.Errorf("membership name of %s: got %s %t", address, parsed, ok)
+		}
+	}
+	if name := MembershipName(zone, net.ParseIP("203.0.113.5")); name != "5.113.0.203.known.seed.example.org." {
+		t.Errorf("unexpected membership name %s", name)
+	}
+
+	subnetworkID, err := subnetworks.FromString("0100000000000000000000000000000000000000")
+	if err != nil {
+		t.Fatalf("FromString: %v", err)
+	}
+	tests := []struct {
+		name         string
+		subnetworkID string
+		all          bool
+		err          bool
+	}{
+		{zone, "", true, false},
+		{SubnetworkName(zone, subnetworkID), subnetworkID.String(), false, false},
+		{SubnetworkName(zone, nil), "", false, false},
+		{PartialName(zone), "", true, false},
+		{DNS64Name(zone), "", true, false},
+		{"nope.seed.example.org.", "", false, true},
+	}
+	for _, test := range tests {
+		parsed, all, err := ParseSubnetworkName(zone, test.name)
+		if (err != nil) != test.err {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+			continue
+		}
+		got := ""
+		if parsed != nil {
+			got = parsed.String()
+		}
+		if got != test.subnetworkID || all != test.all {
+			t.Errorf("%s: got subnetwork %q (all: %t), expected %q (all: %t)",
+				test.name, got, all, test.subnetworkID, test.all)
+		}
+	}
+	if !IsPartialName(zone, PartialName(zone)) || IsPartialName(zone, zone) {
+		t.Errorf("unexpected partial name detection")
+	}
+	if !IsDNS64Name(zone, DNS64Name(zone)) || IsDNS64Name(zone, PartialName(zone)) {
+		t.Errorf("unexpected DNS64 name detection")
+	}
+}
@@ -0,0 +1,67 @@
+package seeddns
+
+import (
+	"encoding/binary"
+
+	"github.com/miekg/dns"
+)
+
+// AnswerCountOption is the code of the EDNS0 option with which cooperating
+// clients request a number of addresses per answer instead of the default
+// one. The seeder answers with the option set to the number of addresses it
+// allowed, within its own limit. The data of the option is the count as a
+// big-endian uint16. The code is in the range RFC 6891 reserves for local
+// and experimental use.
+const AnswerCountOption = 65430
+
+// SetAnswerCount sets the answer count option of msg to count, adding an
+// OPT record advertising a buffer of dns.DefaultMsgSize bytes if msg has
+// none, so the larger answer fits.
+func SetAnswerCount(msg *dns.Msg, count int) {
+	opt := msg.IsEdns0()
+	if opt == nil {
+		msg.SetEdns0(dns.DefaultMsgSize, false)
+		opt = msg.IsEdns0()
+	}
+	data := make([]byte, 2)
+	binary.BigEndian.PutUint16(data, uint16(count))
+	for _, option := range opt.Option {
+		if local, ok := option.(*dns.EDNS0_LOCAL); ok && local.Code == AnswerCountOption {
+			local.Data = data
+			return
+		}
+	}
+	opt.Option = append(opt.Option, &dns.EDNS0_LOCAL{Code: AnswerCountOption, Data: data})
+}
+
+// RemoveAnswerCount removes the answer count option of msg, if any.
+func RemoveAnswerCount(msg *dns.Msg) {
+	opt := msg.IsEdns0()
+	if opt == nil {
+		return
+	}
+	options := opt.Option[:0]
+	for _, option := range opt.Option {
+		if local, ok := option.(*dns.EDNS0_LOCAL); ok && local.Code == AnswerCountOption {
+			continue
+		}
+		options = append(options, option)
+	}
+	opt.Option = options
+}
+
+// AnswerCount returns the count of the answer count option of msg, and
+// whether msg has a valid one.
+func AnswerCount(msg *dns.Msg) (int, bool) {
+	opt := msg.IsEdns0()
+	if opt == nil {
+		return 0, false
+	}
+	for _, option := range opt.Option {
+		local, ok := option.(*dns.EDNS0_LOCAL)
+		if ok && local.Code == AnswerCountOption && len(local.Data) == 2 {
+			return int(binary.BigEndian.Uint16(local.Data)), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,77 @@
+package seeddnstest
+
+import (
+	"net"
+	"sort"
+	"testing"
+
+	"github.com/karlsen-network/dnsseeder/seeddns"
+	"github.com/miekg/dns"
+)
+
+// AssertAddresses fails the test unless answer holds exactly the addresses
+// want, in any order, since seeders rotate the peers they serve.
+func AssertAddresses(t testing.TB, answer *seeddns.Answer, want ...string) {
+	t.Helper()
+
+	got := make([]string, len(answer.Addresses))
+	for i, ip := range answer.Addresses {
+		got[i] = ip.String()
+	}
+	expected := make([]string, len(want))
+	for i, address := range want {
+		ip := net.ParseIP(address)
+		if ip == nil {
+			t.Fatalf("seeddnstest: invalid expected address %s", address)
+		}
+		expected[i] = ip.String()
+	}
+	sort.Strings(got)
+	sort.Strings(expected)
+
+	equal := len(got) == len(expected)
+	for i := 0; equal && i < len(got); i++ {
+		equal = got[i] == expected[i]
+	}
+	if !equal {
+		t.Errorf("expected addresses %v, got %v", expected, got)
+	}
+}
+
+// AssertTTL fails the test unless every record of answer has a TTL of ttl
+// seconds.
+func AssertTTL(t testing.TB, answer *seeddns.Answer, ttl uint32) {
+	t.Helper()
+
+	for i, got := range answer.TTLs {
+		if got != ttl {
+			t.Errorf("expected a TTL of %d, got %d for %s", ttl, got, answer.Msg.Answer[i])
+		}
+	}
+}
+
+// AssertAuthoritative fails the test unless answer is an authoritative,
+// successful and untruncated response, as those of seeders to the queries
+// for their zones.
+func AssertAuthoritative(t testing.TB, answer *seeddns.Answer) {
+	t.Helper()
+
+	if answer.Rcode != dns.RcodeSuccess {
+		t.Errorf("expected a successful response, got %s", dns.RcodeToString[answer.Rcode])
+	}
+	if !answer.Authoritative {
+		t.Errorf("expected an authoritative response")
+	}
+	if answer.Truncated {
+		t.Errorf("expected an untruncated response")
+	}
+}
+
+// AssertRcode fails the test unless answer has the response code rcode.
+func AssertRcode(t testing.TB, answer *seeddns.Answer, rcode int) {
+	t.Helper()
+
+	if answer.Rcode != rcode {
+		t.Errorf("expected response code %s, got %s", dns.RcodeToString[rcode], dns.RcodeToString[answer.Rcode])
+	}
+}
@@ -0,0 +1,226 @@
+// Package seeddnstest provides an in-process DNS server answering as the
+// seeder does, and assertions on answers, so clients can integration-test
+// their bootstrap code without a crawler or network access.
+package seeddnstest
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/karlsen-network/dnsseeder/seeddns"
+	"github.com/karlsen-network/karlsend/domain/consensus/model/externalapi"
+	"github.com/miekg/dns"
+)
+
+// The limits and TTL of the answers are those of the seeder, shared through
+// the seeddns package.
+const (
+	// MaxAnswers is the maximum number of peers in an answer, as served
+	// by the seeder.
+	MaxAnswers = seeddns.MaxAddresses
+
+	// MaxAnswerCount is the maximum number of peers clients can request
+	// per answer with the answer count option, as allowed by the seeder
+	// by default.
+	MaxAnswerCount = seeddns.DefaultMaxAnswerCount
+
+	// DefaultTTL is the TTL of the records of servers without one.
+	DefaultTTL = seeddns.DefaultTTL
+)
+
+// Peer is a peer served by a Server.
+type Peer struct {
+	IP net.IP
+
+	// Partial peers don't keep the full DAG history. They are only served
+	// under the partial name of the zone.
+	Partial bool
+
+	// SubnetworkID is the subnetwork the peer reported, nil if none.
+	SubnetworkID *externalapi.DomainSubnetworkID
+}
+
+// Server is a DNS server authoritative for a zone, which answers queries as
+// the seeder does, with the same names and records, from a fixed set of
+// peers instead of crawled ones. Peers are served in the order they were
+// given, up to MaxAnswers of them, or up to the number requested with the
+// answer count option within MaxAnswerCount, and trimmed to the buffer of
+// the client with seeddns.FitUDPSize, as the seeder does. Peers, NS and
+// membership queries are answered; queries of other types get no records.
+type Server struct {
+	// Addr is the host:port UDP address the server listens on.
+	Addr string
+
+	zone       string
+	nameserver string
+	ttl        uint32
+	server     *dns.Server
+
+	mtx   sync.Mutex
+	peers []Peer
+}
+
+// NewServer starts a server for zone on a free local port, serving peers,
+// and stops it when the test ends.
+func NewServer(t testing.TB, zone string, peers ...Peer) *Server {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("seeddnstest: failed to listen: %v", err)
+	}
+	zone = dns.Fqdn(strings.ToLower(zone))
+	s := &Server{
+		Addr:       conn.LocalAddr().String(),
+		zone:       zone,
+		nameserver: "ns." + zone,
+		ttl:        DefaultTTL,
+		peers:      peers,
+	}
+
+	started := make(chan struct{})
+	s.server = &dns.Server{PacketConn: conn, Handler: s, NotifyStartedFunc: func() { close(started) }}
+	go s.server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { s.server.Shutdown() })
+	return s
+}
+
+// SetPeers replaces the peers served.
+func (s *Server) SetPeers(peers ...Peer) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.peers = peers
+}
+
+// SetTTL sets the TTL of the records served.
+func (s *Server) SetTTL(ttl uint32) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.ttl = ttl
+}
+
+// Client returns a client querying the server.
+func (s *Server) Client() *seeddns.Client {
+	return &seeddns.Client{Server: s.Addr, Timeout: time.Second}
+}
+
+// ServeDNS answers query. Like the seeder, it doesn't answer queries with
+// more than one question or for names outside of the zone.
+func (s *Server) ServeDNS(w dns.ResponseWriter, query *dns.Msg) {
+	if len(query.Question) != 1 {
+		return
+	}
+	name := strings.ToLower(query.Question[0].Name)
+	if !dns.IsSubDomain(s.zone, name) {
+		return
+	}
+
+	s.mtx.Lock()
+	peers := s.peers
+	ttl := s.ttl
+	s.mtx.Unlock()
+
+	authority := &dns.NS{
+		Hdr: dns.RR_Header{Name: s.zone, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 86400},
+		Ns:  s.nameserver,
+	}
+	qtype := query.Question[0].Qtype
+	var response *dns.Msg
+	if ip, ok := seeddns.ParseMembershipName(s.zone, name); ok {
+		response = s.membershipResponse(query, authority, ttl, peers, ip)
+	} else if qtype == dns.TypeA || qtype == dns.TypeAAAA {
+		count := MaxAnswers
+		requested, honored := seeddns.AnswerCount(query)
+		honored = honored && requested > 0
+		if honored {
+			count = requested
+			if count > MaxAnswerCount {
+				count = MaxAnswerCount
+			}
+		}
+		response = seeddns.NewAddressResponse(query, authority, ttl, s.selectPeers(name, qtype, peers, count))
+		if honored {
+			seeddns.SetAnswerCount(response, count)
+		} else {
+			seeddns.RemoveAnswerCount(response)
+		}
+	} else {
+		response = query.Copy()
+		response.Authoritative = true
+		response.Response = true
+		if qtype == dns.TypeNS {
+			response.Answer = append(response.Answer, &dns.NS{
+				Hdr: dns.RR_Header{Name: query.Question[0].Name, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 86400},
+				Ns:  s.nameserver,
+			})
+		}
+	}
+	seeddns.FitUDPSize(query, response)
+	w.WriteMsg(response)
+}
+
+// selectPeers returns the addresses of up to count peers answered to a
+// query of type qtype for name.
+func (s *Server) selectPeers(name string, qtype uint16, peers []Peer, count int) []net.IP {
+	subnetworkID, includeAllSubnetworks, err := seeddns.ParseSubnetworkName(s.zone, name)
+	if err != nil {
+		return nil
+	}
+	partial := seeddns.IsPartialName(s.zone, name)
+
+	var ips []net.IP
+	for _, peer := range peers {
+		if len(ips) == count {
+			break
+		}
+		if (qtype == dns.TypeA) != (peer.IP.To4() != nil) || peer.Partial != partial {
+			continue
+		}
+		if !includeAllSubnetworks && !peer.SubnetworkID.Equal(subnetworkID) {
+			continue
+		}
+		ips = append(ips, peer.IP)
+	}
+	return ips
+}
+
+// membershipResponse answers a membership query for ip.
+func (s *Server) membershipResponse(query *dns.Msg, authority dns.RR, ttl uint32, peers []Peer,
+	ip net.IP) *dns.Msg {
+
+	response := query.Copy()
+	response.Authoritative = true
+	response.Response = true
+	response.Ns = append(response.Ns, authority)
+	response.Rcode = dns.RcodeNameError
+	for _, peer := range peers {
+		if !peer.IP.Equal(ip) {
+			continue
+		}
+		response.Rcode = dns.RcodeSuccess
+		if query.Question[0].Qtype == dns.TypeA {
+			response.Answer = append(response.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: query.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+				A:   seeddns.MembershipAnswer,
+			})
+		}
+		break
+	}
+	return response
+}
+
+// Query queries client for name, failing the test if the query fails.
+func Query(t testing.TB, client *seeddns.Client, name string, qtype uint16) *seeddns.Answer {
+	t.Helper()
+
+	answer, err := client.Query(context.Background(), name, qtype)
+	if err != nil {
+		t.Fatalf("seeddnstest: %v", err)
+	}
+	return answer
+}
@@ -0,0 +1,115 @@
+package seeddnstest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/karlsen-network/dnsseeder/seeddns"
+	"github.com/karlsen-network/karlsend/domain/consensus/utils/subnetworks"
+	"github.com/miekg/dns"
+)
+
+func TestServer(t *testing.T) {
+	subnetworkID, err := subnetworks.FromString("0100000000000000000000000000000000000000")
+	if err != nil {
+		t.Fatalf("FromString: %v", err)
+	}
+	server := NewServer(t, "Seed.Example.org",
+		Peer{IP: net.ParseIP("203.0.113.1")},
+		Peer{IP: net.ParseIP("203.0.113.2"), SubnetworkID: subnetworkID},
+		Peer{IP: net.ParseIP("203.0.113.3"), Partial: true},
+		Peer{IP: net.ParseIP("2001:db8::1")},
+	)
+	client := server.Client()
+
+	answer := Query(t, client, "seed.example.org", dns.TypeA)
+	AssertAuthoritative(t, answer)
+	AssertAddresses(t, answer, "203.0.113.1", "203.0.113.2")
+	AssertTTL(t, answer, DefaultTTL)
+
+	answer = Query(t, client, "seed.example.org", dns.TypeAAAA)
+	AssertAddresses(t, answer, "2001:db8::1")
+
+	answer = Query(t, client, seeddns.SubnetworkName("seed.example.org", subnetworkID), dns.TypeA)
+	AssertAddresses(t, answer, "203.0.113.2")
+
+	answer = Query(t, client, seeddns.PartialName("seed.example.org"), dns.TypeA)
+	AssertAddresses(t, answer, "203.0.113.3")
+
+	answer = Query(t, client, seeddns.MembershipName("seed.example.org", net.ParseIP("2001:db8::1")), dns.TypeA)
+	AssertRcode(t, answer, dns.RcodeSuccess)
+	AssertAddresses(t, answer, seeddns.MembershipAnswer.String())
+	answer = Query(t, client, seeddns.MembershipName("seed.example.org", net.ParseIP("203.0.113.9")), dns.TypeA)
+	AssertRcode(t, answer, dns.RcodeNameError)
+
+	server.SetPeers()
+	server.SetTTL(60)
+	answer, err = client.Peers(context.Background(), "seed.example.org", dns.TypeAAAA)
+	if err != nil {
+		t.Fatalf("Peers: %v", err)
+	}
+	AssertAddresses(t, answer)
+	AssertTTL(t, answer, 60)
+	if len(answer.Msg.Answer) != 1 {
+		t.Errorf("expected empty AAAA answers to hold the placeholder address, got %v", answer.Msg.Answer)
+	}
+
+	_, err = client.Peers(context.Background(), "seed.example.org", dns.TypeTXT)
+	if err == nil {
+		t.Errorf("expected peers queries of other types than A and AAAA to be rejected")
+	}
+}
+
+func TestServerAnswerCount(t *testing.T) {
+	var peers []Peer
+	for i := 1; i <= 40; i++ {
+		peers = append(peers, Peer{IP: net.IPv4(203, 0, 113, byte(i))})
+	}
+	server := NewServer(t, "seed.example.org", peers...)
+	client := server.Client()
+
+	tests := []struct {
+		requested int
+		expected  int
+	}{
+		{0, MaxAnswers},
+		{4, 4},
+		{24, 24},
+		{100, MaxAnswerCount},
+	}
+	for _, test := range tests {
+		client.AnswerCount = test.requested
+		answer := Query(t, client, "seed.example.org", dns.TypeA)
+		if len(answer.Addresses) != test.expected {
+			t.Errorf("requested %d: expected %d addresses, got %d", test.requested, test.expected,
+				len(answer.Addresses))
+		}
+		count, ok := seeddns.AnswerCount(answer.Msg)
+		if ok != (test.requested > 0) || (ok && count != test.expected) {
+			t.Errorf("requested %d: unexpected answer count option %d %t", test.requested, count, ok)
+		}
+	}
+
+	// Like the seeder, answers are trimmed to the buffer of the client
+	// without being marked truncated. Only 15 AAAA records fit in 512
+	// bytes, with the NS and OPT records.
+	var ipv6Peers []Peer
+	for i := 1; i <= 40; i++ {
+		ipv6Peers = append(ipv6Peers, Peer{IP: net.ParseIP(fmt.Sprintf("2001:db8:%x::1", i))})
+	}
+	server.SetPeers(ipv6Peers...)
+	query := new(dns.Msg)
+	query.SetQuestion("seed.example.org.", dns.TypeAAAA)
+	query.SetEdns0(512, false)
+	seeddns.SetAnswerCount(query, MaxAnswerCount)
+	response, err := dns.Exchange(query, server.Addr)
+	if err != nil {
+		t.Fatalf("Exchange: %s", err)
+	}
+	if len(response.Answer) != 15 || response.Truncated {
+		t.Errorf("expected 15 addresses in an untruncated answer, got %d, truncated %t", len(response.Answer),
+			response.Truncated)
+	}
+}
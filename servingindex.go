@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/karlsen-network/karlsend/domain/consensus/model/externalapi"
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// The serving index is the file through which a crawling seeder hands the
+// peers it serves to read-only replicas. It starts with a header, followed
+// by a table of sections, one for each network, query type and kind of
+// node, each pointing to its records. Records are sorted by address, as
+// answers draw from them in that order. Every integer is little-endian, and
+// every record has a fixed size, so the replicas can answer straight from
+// the memory-mapped file.
+//
+//	header:  magic [4], version u32, generated unix millis i64,
+//	         section count u32, reserved u32
+//	section: network name [32], query type u16, partial u8, reserved u8,
+//	         record count u32, offset of the first record u64
+//	record:  IP [16], port u16, has subnetwork u8, reserved u8,
+//	         subnetwork ID [20], weight f64, services u64,
+//	         last success unix millis i64
+//
+// The services of a record are those the peer may provide, all bits set if
+// they are unknown. Its last success is 0 if the peer was never reached, as
+// peers injected by an operator may not be.
+const (
+	servingIndexVersion = 3
+
+	servingIndexHeaderSize  = 24
+	servingIndexSectionSize = 48
+	servingIndexRecordSize  = 64
+
+	servingIndexNetworkSize = 32
+
+	// servingIndexReloadInterval is the interval at which replicas check
+	// whether the serving index was replaced.
+	servingIndexReloadInterval = time.Second
+)
+
+var servingIndexMagic = []byte("KSIX")
+
+// servingIndexSectionKey identifies a section of the serving index.
+type servingIndexSectionKey struct {
+	network string
+	qtype   uint16
+	partial bool
+}
+
+// servingIndexSection locates the records of a section in the index.
+type servingIndexSection struct {
+	offset int
+	count  int
+}
+
+// servingPool returns the peers answers to qtype queries for full or
+// partial nodes may draw from, in every subnetwork, with their weight, as
+// of now.
+func (m *Manager) servingPool(qtype uint16, partial bool, now time.Time) []scoredPeer {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	pool, _ := m.scorePeers(m.answerCandidates(qtype, true, nil, partial, 0, nil, nil), now)
+	return pool
+}
+
+// writeServingIndex writes the serving index of the given networks as of
+// now to path, replacing the previous one at once so replicas never see a
+// partial file.
+func writeServingIndex(path string, networks []*seederNetwork, now time.Time) error {
+	type section struct {
+		key  servingIndexSectionKey
+		pool []scoredPeer
+	}
+	var sections []section
+	for _, network := range networks {
+		if len(network.name()) > servingIndexNetworkSize {
+			return errors.Errorf("the network name %s is too long for the serving index", network.name())
+		}
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			for _, partial := range []bool{false, true} {
+				pool := network.amgr.servingPool(qtype, partial, now)
+				sortScoredPeers(pool)
+				sections = append(sections, section{
+					key:  servingIndexSectionKey{network: network.name(), qtype: qtype, partial: partial},
+					pool: pool,
+				})
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	header := make([]byte, servingIndexHeaderSize)
+	copy(header, servingIndexMagic)
+	binary.LittleEndian.PutUint32(header[4:], servingIndexVersion)
+	binary.LittleEndian.PutUint64(header[8:], uint64(now.UnixMilli()))
+	binary.LittleEndian.PutUint32(header[16:], uint32(len(sections)))
+	buf.Write(header)
+
+	offset := servingIndexHeaderSize + len(sections)*servingIndexSectionSize
+	for _, s := range sections {
+		entry := make([]byte, servingIndexSectionSize)
+		copy(entry, s.key.network)
+		binary.LittleEndian.PutUint16(entry[32:], s.key.qtype)
+		if s.key.partial {
+			entry[34] = 1
+		}
+		binary.LittleEndian.PutUint32(entry[36:], uint32(len(s.pool)))
+		binary.LittleEndian.PutUint64(entry[40:], uint64(offset))
+		buf.Write(entry)
+		offset += len(s.pool) * servingIndexRecordSize
+	}
+
+	for _, s := range sections {
+		for _, peer := range s.pool {
+			record := make([]byte, servingIndexRecordSize)
+			copy(record, peer.addr.IP.To16())
+			binary.LittleEndian.PutUint16(record[16:], peer.addr.Port)
+			if peer.subnetworkID != nil {
+				record[18] = 1
+				copy(record[20:40], peer.subnetworkID[:])
+			}
+			binary.LittleEndian.PutUint64(record[40:], math.Float64bits(peer.weight))
+			binary.LittleEndian.PutUint64(record[48:], uint64(peer.services))
+			if !peer.lastSuccess.IsZero() {
+				binary.LittleEndian.PutUint64(record[56:], uint64(peer.lastSuccess.UnixMilli()))
+			}
+			buf.Write(record)
+		}
+	}
+
+	tmpPath := path + ".tmp"
+	err := os.WriteFile(tmpPath, buf.Bytes(), 0644)
+	if err != nil {
+		return errors.Wrap(err, "failed to write the serving index")
+	}
+	return errors.Wrap(os.Rename(tmpPath, path), "failed to replace the serving index")
+}
+
+// writeServingIndexes writes the serving index of the given networks to
+// path every interval, until quit is closed.
+func writeServingIndexes(path string, networks []*seederNetwork, interval time.Duration, quit <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err := writeServingIndex(path, networks, time.Now())
+		if err != nil {
+			log.Warnf("Failed to write the serving index: %v", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-quit:
+			return
+		}
+	}
+}
+
+// servingIndex is a serving index file mapped in memory, mapped again
+// whenever the file is replaced.
+type servingIndex struct {
+	path string
+
+	mtx       sync.RWMutex
+	data      []byte
+	info      os.FileInfo
+	generated time.Time
+	sections  map[servingIndexSectionKey]servingIndexSection
+}
+
+// openServingIndex maps the serving index at path.
+func openServingIndex(path string) (*servingIndex, error) {
+	index := &servingIndex{path: path}
+	_, err := index.reloadIfChanged()
+	if err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// reloadIfChanged maps the file again if it was replaced since it was last
+// mapped, and returns whether it did.
+func (index *servingIndex) reloadIfChanged() (bool, error) {
+	info, err := os.Stat(index.path)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	index.mtx.RLock()
+	unchanged := index.info != nil && os.SameFile(index.info, info) &&
+		info.ModTime().Equal(index.info.ModTime())
+	index.mtx.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	file, err := os.Open(index.path)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to open the serving index")
+	}
+	defer file.Close()
+	info, err = file.Stat()
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	if info.Size() < servingIndexHeaderSize || info.Size() > math.MaxInt32 {
+		return false, errors.Errorf("%s is not a serving index", index.path)
+	}
+	data, err := mapFile(file, int(info.Size()))
+	if err != nil {
+		return false, errors.Wrap(err, "failed to map the serving index")
+	}
+	generated, sections, err := parseServingIndex(data)
+	if err != nil {
+		unmapFile(data)
+		return false, errors.Wrapf(err, "invalid serving index %s", index.path)
+	}
+
+	index.mtx.Lock()
+	previous := index.data
+	index.data = data
+	index.info = info
+	index.generated = generated
+	index.sections = sections
+	index.mtx.Unlock()
+
+	if previous != nil {
+		err = unmapFile(previous)
+		if err != nil {
+			log.Warnf("Failed to unmap the previous serving index: %v", err)
+		}
+	}
+	return true, nil
+}
+
+// parseServingIndex checks the header and section table of a serving index,
+// and returns when it was generated and where its sections are.
+func parseServingIndex(data []byte) (time.Time, map[servingIndexSectionKey]servingIndexSection, error) {
+	if !bytes.Equal(data[:len(servingIndexMagic)], servingIndexMagic) {
+		return time.Time{}, nil, errors.New("bad magic")
+	}
+	version := binary.LittleEndian.Uint32(data[4:])
+	if version != servingIndexVersion {
+		return time.Time{}, nil, errors.Errorf("unsupported version %d", version)
+	}
+	generated := time.UnixMilli(int64(binary.LittleEndian.Uint64(data[8:])))
+	count := int(binary.LittleEndian.Uint32(data[16:]))
+	if count > (len(data)-servingIndexHeaderSize)/servingIndexSectionSize {
+		return time.Time{}, nil, errors.New("truncated section table")
+	}
+
+	sections := make(map[servingIndexSectionKey]servingIndexSection, count)
+	for i := 0; i < count; i++ {
+		entry := data[servingIndexHeaderSize+i*servingIndexSectionSize:][:servingIndexSectionSize]
+		key := servingIndexSectionKey{
+			network: string(bytes.TrimRight(entry[:servingIndexNetworkSize], "\x00")),
+			qtype:   binary.LittleEndian.Uint16(entry[32:]),
+			partial: entry[34] != 0,
+		}
+		section := servingIndexSection{
+			offset: int(binary.LittleEndian.Uint64(entry[40:])),
+			count:  int(binary.LittleEndian.Uint32(entry[36:])),
+		}
+		if section.offset < 0 || section.offset > len(data) ||
+			section.count > (len(data)-section.offset)/servingIndexRecordSize {
+			return time.Time{}, nil, errors.Errorf("section %d is out of bounds", i)
+		}
+		sections[key] = section
+	}
+	return generated, sections, nil
+}
+
+// pool returns the peers of network an answer at now to a qtype query may
+// draw from, as goodAddresses selects them, along with the heaviest weight.
+// Addresses in served, peers without services, and peers not reached within
+// the maximum served age of the replica, if any, are left out, which is
+// recorded in explanation if not nil.
+func (index *servingIndex) pool(network string, qtype uint16, includeAllSubnetworks bool,
+	subnetworkID *externalapi.DomainSubnetworkID, partial bool, services appmessage.ServiceFlag,
+	served map[string]bool, now time.Time, explanation *answerExplanation) ([]scoredPeer, float64) {
+
+	index.mtx.RLock()
+	defer index.mtx.RUnlock()
+
+	section, ok := index.sections[servingIndexSectionKey{network: network, qtype: qtype, partial: partial}]
+	if !ok {
+		return nil, 0
+	}
+	maxAge := maxServedAge()
+	var pool []scoredPeer
+	var maxWeight float64
+	for i := 0; i < section.count; i++ {
+		peer := parseServingRecord(index.data[section.offset+i*servingIndexRecordSize:][:servingIndexRecordSize])
+		if served[peer.addr.IP.String()] {
+			continue
+		}
+		if !includeAllSubnetworks && !peer.subnetworkID.Equal(subnetworkID) {
+			explanation.exclude(peer.addr, peer.services, "in another subnetwork")
+			continue
+		}
+		if peer.services&services != services {
+			explanation.exclude(peer.addr, peer.services, missingServicesReason(services))
+			continue
+		}
+		if maxAge > 0 && !reachedWithin(peer.lastSuccess, now, maxAge) {
+			explanation.exclude(peer.addr, peer.services, "not reached within the maximum served age")
+			continue
+		}
+		explanation.score(peer.addr, peer.services, peer.weight)
+		pool = append(pool, peer)
+		if peer.weight > maxWeight {
+			maxWeight = peer.weight
+		}
+	}
+	return pool, maxWeight
+}
+
+// parseServingRecord parses a record of the serving index, copying it out
+// of the mapped file.
+func parseServingRecord(data []byte) scoredPeer {
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, data[:net.IPv6len])
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	peer := scoredPeer{
+		addr:     appmessage.NewNetAddressIPPort(ip, binary.LittleEndian.Uint16(data[16:])),
+		weight:   math.Float64frombits(binary.LittleEndian.Uint64(data[40:])),
+		services: appmessage.ServiceFlag(binary.LittleEndian.Uint64(data[48:])),
+	}
+	if lastSuccess := int64(binary.LittleEndian.Uint64(data[56:])); lastSuccess != 0 {
+		peer.lastSuccess = time.UnixMilli(lastSuccess)
+	}
+	if data[18] != 0 {
+		var subnetworkID externalapi.DomainSubnetworkID
+		copy(subnetworkID[:], data[20:40])
+		peer.subnetworkID = &subnetworkID
+	}
+	return peer
+}
+
+// generatedAt returns when the mapped index was generated.
+func (index *servingIndex) generatedAt() time.Time {
+	index.mtx.RLock()
+	defer index.mtx.RUnlock()
+	return index.generated
+}
+
+// close unmaps the index.
+func (index *servingIndex) close() {
+	index.mtx.Lock()
+	defer index.mtx.Unlock()
+	if index.data != nil {
+		unmapFile(index.data)
+		index.data = nil
+		index.sections = nil
+	}
+}
+
+// watch maps the index again whenever the file is replaced, until quit is
+// closed.
+func (index *servingIndex) watch(quit <-chan struct{}) {
+	ticker := time.NewTicker(servingIndexReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-quit:
+			return
+		}
+
+		reloaded, err := index.reloadIfChanged()
+		if err != nil {
+			log.Warnf("Failed to reload the serving index: %v", err)
+			continue
+		}
+		if reloaded {
+			log.Debugf("Reloaded the serving index generated at %s", index.generatedAt())
+		}
+	}
+}
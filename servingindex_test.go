@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/karlsen-network/karlsend/domain/consensus/model/externalapi"
+	"github.com/karlsen-network/karlsend/infrastructure/config"
+	"github.com/miekg/dns"
+)
+
+func TestServingIndex(t *testing.T) {
+	cfg := setTestConfig(t, &ConfigFlags{NetworkFlags: config.NetworkFlags{Devnet: true}})
+	err := cfg.ResolveNetwork(nil)
+	if err != nil {
+		t.Fatalf("ResolveNetwork: %s", err)
+	}
+	params := cfg.NetParams()
+	port, _ := strconv.Atoi(params.DefaultPort)
+
+	now := time.Now()
+	subnetworkID := &externalapi.DomainSubnetworkID{1}
+	crawler := newTestManager(t, params, uint16(port))
+	crawler.rotation = newAnswerRotation()
+	for _, ip := range []string{"203.0.113.1", "203.0.113.2", "203.0.113.3", "203.0.113.4", "2001:db8::1"} {
+		crawler.nodes[ip] = &Node{
+			Addr:        appmessage.NewNetAddressIPPort(net.ParseIP(ip), uint16(port)),
+			LastSuccess: now,
+		}
+	}
+	crawler.nodes["203.0.113.2"].SubnetworkID = subnetworkID
+	crawler.nodes["203.0.113.3"].LastSuccess = time.Time{}
+	crawler.nodes["203.0.113.4"].ProtocolVersion = 1
+	crawler.nodes["203.0.113.1"].ProtocolVersion = 1
+	crawler.nodes["203.0.113.1"].Services = appmessage.SFNodeNetwork | appmessage.SFNodeCF
+
+	path := filepath.Join(t.TempDir(), "serving.idx")
+	network := &seederNetwork{flags: cfg.NetworkFlags, amgr: crawler, defaultPort: port}
+	err = writeServingIndex(path, []*seederNetwork{network}, now)
+	if err != nil {
+		t.Fatalf("writeServingIndex: %s", err)
+	}
+	index, err := openServingIndex(path)
+	if err != nil {
+		t.Fatalf("openServingIndex: %s", err)
+	}
+	defer index.close()
+	replica, err := newReplicaNetwork(cfg.NetworkFlags, nil, nil, index)
+	if err != nil {
+		t.Fatalf("newReplicaNetwork: %s", err)
+	}
+
+	answer := func(m *Manager, qtype uint16, includeAll bool, subnetworkID *externalapi.DomainSubnetworkID,
+		partial bool, services appmessage.ServiceFlag) []string {
+
+		var ips []string
+		for _, addr := range m.goodAddresses(qtype, includeAll, subnetworkID, partial, services, nil,
+			defaultMaxAddresses, now) {
+			ips = append(ips, addr.IP.String())
+		}
+		sort.Strings(ips)
+		return ips
+	}
+	tests := []struct {
+		qtype        uint16
+		includeAll   bool
+		subnetworkID *externalapi.DomainSubnetworkID
+		partial      bool
+		services     appmessage.ServiceFlag
+		expected     []string
+	}{
+		{dns.TypeA, true, nil, false, 0, []string{"203.0.113.1", "203.0.113.2"}},
+		{dns.TypeA, false, nil, false, 0, []string{"203.0.113.1"}},
+		{dns.TypeA, false, subnetworkID, false, 0, []string{"203.0.113.2"}},
+		{dns.TypeA, true, nil, true, 0, []string{"203.0.113.4"}},
+		{dns.TypeAAAA, true, nil, false, 0, []string{"2001:db8::1"}},
+		{dns.TypeA, true, nil, false, appmessage.SFNodeCF, []string{"203.0.113.1", "203.0.113.2"}},
+		{dns.TypeA, true, nil, false, appmessage.SFNodeBloom, []string{"203.0.113.2"}},
+	}
+	for _, test := range tests {
+		for name, m := range map[string]*Manager{"crawler": crawler, "replica": replica.amgr} {
+			ips := answer(m, test.qtype, test.includeAll, test.subnetworkID, test.partial, test.services)
+			if len(ips) != len(test.expected) || (len(ips) > 0 && ips[0] != test.expected[0]) ||
+				(len(ips) > 1 && ips[1] != test.expected[1]) {
+				t.Errorf("expected the %s to answer %v to a %s query (all subnetworks: %t, partial: %t, "+
+					"services: %d), got %v", name, test.expected, dns.TypeToString[test.qtype], test.includeAll,
+					test.partial, test.services, ips)
+			}
+		}
+	}
+
+	// The replica picks up the index once the crawler replaces it.
+	crawler.nodes["203.0.113.3"].LastSuccess = now
+	err = writeServingIndex(path, []*seederNetwork{network}, now.Add(time.Second))
+	if err != nil {
+		t.Fatalf("writeServingIndex: %s", err)
+	}
+	reloaded, err := index.reloadIfChanged()
+	if err != nil || !reloaded {
+		t.Fatalf("expected the replaced index to be reloaded, got %t, %v", reloaded, err)
+	}
+	if ips := answer(replica.amgr, dns.TypeA, true, nil, false, 0); len(ips) != 3 {
+		t.Errorf("expected the replica to serve the newly good peer, got %v", ips)
+	}
+	// Replicas only serve the peers reached within their maximum age.
+	cfg.MaxServedAge = time.Minute
+	if addrs := replica.amgr.goodAddresses(dns.TypeA, true, nil, false, 0, nil, defaultMaxAddresses,
+		now.Add(2*time.Minute)); len(addrs) != 0 {
+		t.Errorf("expected the replica to serve no peer reached too long ago, got %v", addrs)
+	}
+	cfg.MaxServedAge = 0
+	if !index.generatedAt().Equal(now.Add(time.Second).Truncate(time.Millisecond)) {
+		t.Errorf("unexpected generation time %s", index.generatedAt())
+	}
+
+	corrupt := filepath.Join(t.TempDir(), "corrupt.idx")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %s", err)
+	}
+	err = os.WriteFile(corrupt, data[:len(data)-1], 0644)
+	if err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+	_, err = openServingIndex(corrupt)
+	if err == nil {
+		t.Errorf("expected a truncated index to be rejected")
+	}
+}
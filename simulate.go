@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/karlsen-network/karlsend/domain/consensus/utils/subnetworks"
+	"github.com/karlsen-network/karlsend/domain/dagconfig"
+	"github.com/karlsen-network/karlsend/infrastructure/network/addressmanager"
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// answerSummary sums up the answers of one address family during a
+// simulation.
+type answerSummary struct {
+	Queries     int     `json:"queries"`
+	Empty       int     `json:"empty"`
+	AverageSize float64 `json:"averageSize"`
+
+	addresses int
+}
+
+// servedPeer is a peer with the number of answers it was served in.
+type servedPeer struct {
+	Address string  `json:"address"`
+	Answers int     `json:"answers"`
+	Share   float64 `json:"share"`
+}
+
+// simulationReport is the outcome of a simulation.
+type simulationReport struct {
+	Start              time.Time     `json:"start"`
+	End                time.Time     `json:"end"`
+	Crawls             int           `json:"crawls"`
+	Reached            int           `json:"reached"`
+	Known              int           `json:"known"`
+	Good               int           `json:"good"`
+	Demoted            int           `json:"demoted"`
+	RemovedGood        int           `json:"removedGood"`
+	RemovedUnreachable int           `json:"removedUnreachable"`
+	IPv4               answerSummary `json:"ipv4"`
+	IPv6               answerSummary `json:"ipv6"`
+	DistinctServed     int           `json:"distinctServed"`
+	TopServed          []servedPeer  `json:"topServed"`
+}
+
+// simulation replays crawl events through an address manager, in simulated
+// time and without any network I/O. Queries are answered every query
+// interval and garbage is collected every pruneAddressInterval, as the
+// running seeder does.
+type simulation struct {
+	amgr          *Manager
+	queryInterval time.Duration
+
+	demoteAfter          time.Duration
+	goodRetention        time.Duration
+	unreachableRetention time.Duration
+
+	now       time.Time
+	nextQuery time.Time
+	nextPrune time.Time
+	served    map[string]int
+	report    simulationReport
+}
+
+// newSimulation returns a simulation of a network starting with no known
+// nodes, selecting the peers it serves with scorer.
+func newSimulation(netParams *dagconfig.Params, defaultPort uint16, scorer Scorer, queryInterval,
+	demoteAfter, goodRetention, unreachableRetention time.Duration) *simulation {
+
+	s := &simulation{
+		amgr: &Manager{
+			nodes:       make(map[string]*Node),
+			bans:        NewBanManager(),
+			netParams:   netParams,
+			defaultPort: defaultPort,
+			scorer:      scorer,
+			rotation:    newAnswerRotation(),
+		},
+		queryInterval:        queryInterval,
+		demoteAfter:          demoteAfter,
+		goodRetention:        goodRetention,
+		unreachableRetention: unreachableRetention,
+		served:               make(map[string]int),
+	}
+	// Crawls are recorded at the simulated time.
+	s.amgr.clock = func() time.Time { return s.now }
+	return s
+}
+
+// readCrawlEvents reads the crawl events of network from the given files,
+// sorted by time. Events without a network are included as well.
+func readCrawlEvents(paths []string, network string) ([]*crawlEvent, error) {
+	var events []*crawlEvent
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open crawl record")
+		}
+		decoder := json.NewDecoder(file)
+		for {
+			event := &crawlEvent{}
+			err = decoder.Decode(event)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				file.Close()
+				return nil, errors.Wrapf(err, "failed to read %s", path)
+			}
+			if event.Network == "" || event.Network == network {
+				events = append(events, event)
+			}
+		}
+		file.Close()
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.Before(events[j].Time)
+	})
+	return events, nil
+}
+
+// replay applies event, after answering the queries and collecting the
+// garbage due until its time.
+func (s *simulation) replay(event *crawlEvent) error {
+	if s.now.IsZero() {
+		s.report.Start = event.Time
+		s.now = event.Time
+		s.nextQuery = event.Time
+		s.nextPrune = event.Time.Add(pruneAddressInterval)
+	}
+	s.advance(event.Time)
+
+	addr, err := parsePeerAddress(event.Address, int(s.amgr.defaultPort))
+	if err != nil {
+		return errors.Wrapf(err, "invalid crawl event at %s", event.Time)
+	}
+	s.report.Crawls++
+	if event.Reached {
+		s.report.Reached++
+	}
+	return s.replayCrawl(event, addr)
+}
+
+// advance moves the simulated time forward to now.
+func (s *simulation) advance(now time.Time) {
+	if !now.After(s.now) {
+		return
+	}
+	for {
+		if !s.nextPrune.After(now) && !s.nextPrune.After(s.nextQuery) {
+			s.prune(s.nextPrune)
+			s.nextPrune = s.nextPrune.Add(pruneAddressInterval)
+			continue
+		}
+		if !s.nextQuery.After(now) {
+			s.query(s.nextQuery)
+			s.nextQuery = s.nextQuery.Add(s.queryInterval)
+			continue
+		}
+		break
+	}
+	s.now = now
+}
+
+// query answers an IPv4 and an IPv6 query at now.
+func (s *simulation) query(now time.Time) {
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		summary := &s.report.IPv4
+		if qtype == dns.TypeAAAA {
+			summary = &s.report.IPv6
+		}
+		addrs := s.amgr.goodAddresses(qtype, true, nil, false, 0, nil, defaultMaxAddresses, now)
+		summary.Queries++
+		summary.addresses += len(addrs)
+		if len(addrs) == 0 {
+			summary.Empty++
+		}
+		for _, addr := range addrs {
+			s.served[addr.IP.String()]++
+		}
+	}
+}
+
+// prune collects the garbage at now.
+func (s *simulation) prune(now time.Time) {
+	s.amgr.mtx.Lock()
+	result := s.amgr.collectGarbage(now, s.demoteAfter, s.goodRetention, s.unreachableRetention)
+	s.amgr.mtx.Unlock()
+
+	s.report.Demoted += result.demoted
+	s.report.RemovedGood += result.removedGood
+	s.report.RemovedUnreachable += result.removedUnreachable
+}
+
+// finish returns the report of the simulation, with the top most served
+// peers.
+func (s *simulation) finish(top int) *simulationReport {
+	report := s.report
+	report.End = s.now
+	report.Known, report.Good = s.amgr.countsAt(s.now)
+
+	var answers int
+	for _, summary := range []*answerSummary{&report.IPv4, &report.IPv6} {
+		if summary.Queries > 0 {
+			summary.AverageSize = float64(summary.addresses) / float64(summary.Queries)
+		}
+		answers += summary.Queries - summary.Empty
+	}
+
+	report.DistinctServed = len(s.served)
+	report.TopServed = make([]servedPeer, 0, len(s.served))
+	for address, count := range s.served {
+		report.TopServed = append(report.TopServed, servedPeer{
+			Address: address,
+			Answers: count,
+			Share:   float64(count) / float64(answers),
+		})
+	}
+	sort.Slice(report.TopServed, func(i, j int) bool {
+		if report.TopServed[i].Answers != report.TopServed[j].Answers {
+			return report.TopServed[i].Answers > report.TopServed[j].Answers
+		}
+		return report.TopServed[i].Address < report.TopServed[j].Address
+	})
+	if len(report.TopServed) > top {
+		report.TopServed = report.TopServed[:top]
+	}
+	return &report
+}
+
+// replayCrawl records the crawl attempt event of addr, made at the
+// simulated time, through the methods pollPeer records crawls with. Nodes
+// are added when first crawled.
+func (s *simulation) replayCrawl(event *crawlEvent, addr *appmessage.NetAddress) error {
+	m := s.amgr
+	if !addressmanager.IsRoutable(addr, m.netParams.AcceptUnroutable) || m.bans.IsBanned(addr.IP) ||
+		!isAllowed(addr.IP) {
+		return nil
+	}
+	m.AddAddresses([]*appmessage.NetAddress{addr})
+	defer m.Attempt(addr.IP)
+
+	latency := time.Duration(event.LatencyMilliseconds) * time.Millisecond
+	defer m.RecordCrawl(addr.IP, event.Reached, latency)
+	if !event.Reached {
+		return nil
+	}
+
+	version := &appmessage.MsgVersion{
+		UserAgent:       event.UserAgent,
+		ProtocolVersion: event.ProtocolVersion,
+		Services:        appmessage.ServiceFlag(event.Services),
+	}
+	if event.SubnetworkID != "" {
+		subnetworkID, err := subnetworks.FromString(event.SubnetworkID)
+		if err != nil {
+			return errors.Wrapf(err, "invalid subnetwork ID in crawl event at %s", event.Time)
+		}
+		version.SubnetworkID = subnetworkID
+	}
+
+	received := make([]*appmessage.NetAddress, 0, len(event.Addresses))
+	for _, address := range event.Addresses {
+		receivedAddr, err := parsePeerAddress(address, int(m.defaultPort))
+		if err != nil {
+			return errors.Wrapf(err, "invalid received address in crawl event at %s", event.Time)
+		}
+		received = append(received, receivedAddr)
+	}
+	m.RecordAdvertised(addr.IP, received)
+	m.AddAddresses(received)
+	m.Good(addr.IP, version)
+	return nil
+}
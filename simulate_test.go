@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/karlsen-network/karlsend/domain/dagconfig"
+)
+
+func TestSimulation(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var events []*crawlEvent
+	for i := 0; i < 24; i++ {
+		now := start.Add(time.Duration(i) * 30 * time.Minute)
+		events = append(events, &crawlEvent{
+			Time:            now,
+			Address:         "1.0.0.1:1313",
+			Reached:         true,
+			ProtocolVersion: 5,
+			Services:        1,
+			Addresses:       []string{"1.0.0.2:1313", "1.0.0.3"},
+		})
+		events = append(events, &crawlEvent{
+			Time:    now.Add(time.Minute),
+			Address: "1.0.0.2:1313",
+			Reached: i == 0,
+		})
+	}
+
+	// Replayed events are read back from rolled files, in time order.
+	dir := t.TempDir()
+	var paths []string
+	for i, half := range [][]*crawlEvent{events[len(events)/2:], events[:len(events)/2]} {
+		path := filepath.Join(dir, "crawl.log."+strconv.Itoa(i))
+		file, err := os.Create(path)
+		if err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		enc := json.NewEncoder(file)
+		for _, event := range half {
+			err = enc.Encode(event)
+			if err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+		}
+		file.Close()
+		paths = append(paths, path)
+	}
+	otherNetwork := &crawlEvent{Time: start, Network: "other", Address: "1.0.0.9:1313", Reached: true}
+	line, _ := json.Marshal(otherNetwork)
+	err := os.WriteFile(filepath.Join(dir, "other"), append(line, '\n'), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	paths = append(paths, filepath.Join(dir, "other"))
+
+	read, err := readCrawlEvents(paths, dagconfig.MainnetParams.Name)
+	if err != nil {
+		t.Fatalf("readCrawlEvents: %v", err)
+	}
+	if len(read) != len(events) {
+		t.Fatalf("expected %d events of the network, got %d", len(events), len(read))
+	}
+	for i := 1; i < len(read); i++ {
+		if read[i].Time.Before(read[i-1].Time) {
+			t.Fatalf("expected events sorted by time, got %s after %s", read[i].Time, read[i-1].Time)
+		}
+	}
+
+	sim := newSimulation(&dagconfig.MainnetParams, 1313, defaultScorer{}, time.Minute,
+		2*time.Hour, 24*time.Hour, 4*time.Hour)
+	for _, event := range read {
+		err := sim.replay(event)
+		if err != nil {
+			t.Fatalf("replay: %v", err)
+		}
+	}
+	report := sim.finish(1)
+
+	if report.Crawls != 48 || report.Reached != 25 {
+		t.Errorf("expected 48 crawls, 25 reached, got %d and %d", report.Crawls, report.Reached)
+	}
+	if report.Known != 3 || report.Good != 1 {
+		t.Errorf("expected 3 known nodes, 1 good, got %d and %d", report.Known, report.Good)
+	}
+	if report.Demoted != 1 {
+		t.Errorf("expected the node unreachable after its first crawl to be demoted, got %d demoted", report.Demoted)
+	}
+	if report.IPv4.Queries == 0 || report.IPv4.Empty != 0 || report.IPv6.Empty != report.IPv6.Queries {
+		t.Errorf("unexpected answers: %+v and %+v", report.IPv4, report.IPv6)
+	}
+	if len(report.TopServed) != 1 || report.TopServed[0].Address != "1.0.0.1" {
+		t.Errorf("expected the reliable node to be the most served, got %+v", report.TopServed)
+	}
+	if report.DistinctServed != 2 {
+		t.Errorf("expected the node reached once to be served until it went stale, got %d distinct peers served",
+			report.DistinctServed)
+	}
+}
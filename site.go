@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// identityNames are the CHAOS class names answered with the identity of the
+// seeder, as RFC 4892 describes.
+var identityNames = map[string]bool{"id.server.": true, "hostname.bind.": true}
+
+// siteLabels identify a seeder among those serving the same seed domain
+// from several sites, such as the points of presence of an anycast
+// deployment. Either may be empty.
+type siteLabels struct {
+	site     string
+	instance string
+}
+
+// activeSiteLabels returns the site labels of the active configuration.
+func activeSiteLabels() siteLabels {
+	cfg := ActiveConfig()
+	if cfg == nil {
+		return siteLabels{}
+	}
+	return siteLabels{site: cfg.Site, instance: cfg.Instance}
+}
+
+// identity returns how the seeder identifies itself in answers to CHAOS
+// identity queries: its instance, followed by @ and its site if both are
+// set, or an empty string if neither is.
+func (l siteLabels) identity() string {
+	switch {
+	case l.instance != "" && l.site != "":
+		return l.instance + "@" + l.site
+	case l.instance != "":
+		return l.instance
+	}
+	return l.site
+}
+
+// validSiteLabel returns whether label can be used as a site or instance
+// label: it may only hold letters, digits, dashes and underscores, so it
+// fits in metric paths and tags as is.
+func validSiteLabel(label string) bool {
+	for _, r := range label {
+		if !(r >= 'a' && r <= 'z') && !(r >= 'A' && r <= 'Z') && !(r >= '0' && r <= '9') && r != '-' && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// isIdentityQuery returns whether question asks for the identity of the
+// seeder, which is only answered when a site or instance label is set.
+func isIdentityQuery(question dns.Question) bool {
+	return question.Qclass == dns.ClassCHAOS && identityNames[strings.ToLower(question.Name)] &&
+		activeSiteLabels().identity() != ""
+}
+
+// buildIdentityResponse answers a CHAOS identity query with a TXT record
+// holding the identity of the seeder. Queries for other types get no
+// records.
+func (d *DNSServer) buildIdentityResponse(addr *net.UDPAddr, dnsMsg *dns.Msg) ([]byte, error) {
+	respMsg := dnsMsg.Copy()
+	respMsg.Authoritative = true
+	respMsg.Response = true
+
+	qtype := dnsMsg.Question[0].Qtype
+	if qtype == dns.TypeTXT || qtype == dns.TypeANY {
+		respMsg.Answer = append(respMsg.Answer, &dns.TXT{
+			Hdr: dns.RR_Header{
+				Name:   dnsMsg.Question[0].Name,
+				Rrtype: dns.TypeTXT,
+				Class:  dns.ClassCHAOS,
+			},
+			Txt: []string{activeSiteLabels().identity()},
+		})
+	}
+	dnsLog.Infof("%s: Sending the identity of the seeder", addr)
+	return d.pack(addr, dnsMsg, respMsg)
+}
+
+// siteStatus is the status of a single site in a merged status.
+type siteStatus struct {
+	Site          string     `json:"site,omitempty"`
+	Instance      string     `json:"instance,omitempty"`
+	Version       string     `json:"version"`
+	UptimeSeconds int64      `json:"uptimeSeconds"`
+	CrawlPaused   bool       `json:"crawlPaused"`
+	Maintenance   bool       `json:"maintenance"`
+	Peers         peerCounts `json:"peers"`
+}
+
+// mergedStatus is the status of a network served from several sites, as
+// merged from the status of each. Peers are the highest counts of any
+// site, since the sites crawl the same network, while the metrics and the
+// crawl failures are summed.
+type mergedStatus struct {
+	Network  string            `json:"network"`
+	Sites    []siteStatus      `json:"sites"`
+	Peers    peerCounts        `json:"peers"`
+	Metrics  map[string]uint64 `json:"metrics"`
+	Networks []networkStatus   `json:"networks"`
+}
+
+// readStatusDump reads a status saved from the HTTP API of a seeder, as
+// printed by the stats command.
+func readStatusDump(path string) (*statusResponse, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var status statusResponse
+	err = json.Unmarshal(data, &status)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid status dump %s", path)
+	}
+	return &status, nil
+}
+
+// mergeStatuses merges the status of the sites serving a network into one
+// view of it. Every status must be of the same primary network.
+func mergeStatuses(statuses []*statusResponse) (*mergedStatus, error) {
+	if len(statuses) == 0 {
+		return nil, errors.New("no status to merge")
+	}
+	merged := &mergedStatus{
+		Network: statuses[0].Network,
+		Metrics: make(map[string]uint64),
+	}
+	networks := make(map[string]*networkStatus)
+	var names []string
+	for _, status := range statuses {
+		if status.Network != merged.Network {
+			return nil, errors.Errorf("can't merge the status of %s with that of %s", status.Network,
+				merged.Network)
+		}
+		merged.Sites = append(merged.Sites, siteStatus{
+			Site:          status.Site,
+			Instance:      status.Instance,
+			Version:       status.Version,
+			UptimeSeconds: status.UptimeSeconds,
+			CrawlPaused:   status.CrawlPaused,
+			Maintenance:   status.Maintenance,
+			Peers:         status.Peers,
+		})
+		merged.Peers = maxPeerCounts(merged.Peers, status.Peers)
+		for name, value := range status.Metrics {
+			merged.Metrics[name] += value
+		}
+
+		for _, network := range status.Networks {
+			mergedNetwork, exists := networks[network.Name]
+			if !exists {
+				mergedNetwork = &networkStatus{Name: network.Name, CrawlFailures: make(map[string]uint64)}
+				networks[network.Name] = mergedNetwork
+				names = append(names, network.Name)
+			}
+			mergedNetwork.Peers = maxPeerCounts(mergedNetwork.Peers, network.Peers)
+			for reason, count := range network.CrawlFailures {
+				mergedNetwork.CrawlFailures[reason] += count
+			}
+		}
+	}
+	sort.Slice(merged.Sites, func(i, j int) bool {
+		if merged.Sites[i].Site != merged.Sites[j].Site {
+			return merged.Sites[i].Site < merged.Sites[j].Site
+		}
+		return merged.Sites[i].Instance < merged.Sites[j].Instance
+	})
+	for _, name := range names {
+		merged.Networks = append(merged.Networks, *networks[name])
+	}
+	return merged, nil
+}
+
+// maxPeerCounts returns the highest of the known and good counts of a and
+// b.
+func maxPeerCounts(a, b peerCounts) peerCounts {
+	if b.Known > a.Known {
+		a.Known = b.Known
+	}
+	if b.Good > a.Good {
+		a.Good = b.Good
+	}
+	return a
+}
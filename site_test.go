@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/karlsen-network/karlsend/domain/dagconfig"
+	"github.com/miekg/dns"
+)
+
+func TestIdentityQuery(t *testing.T) {
+	m := newTestManager(t, &dagconfig.MainnetParams, 0)
+	network := &seederNetwork{amgr: m, zones: []ZoneConfig{{Host: "seed.example.org", Nameserver: "ns.example.org"}}}
+	d := NewDNSServer([]*seederNetwork{network}, "", nil, nil)
+	addr := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53}
+	query := func(name string) []byte {
+		msg := new(dns.Msg)
+		msg.SetQuestion(name, dns.TypeTXT)
+		msg.Question[0].Qclass = dns.ClassCHAOS
+		b, err := msg.Pack()
+		if err != nil {
+			t.Fatalf("Pack: %s", err)
+		}
+		return b
+	}
+
+	// Without labels, identity queries are names like any other.
+	setTestConfig(t, &ConfigFlags{})
+	_, _, _, _, err := d.validateDNSRequest(addr, query("id.server."))
+	if err == nil {
+		t.Errorf("expected the identity query to be refused without labels")
+	}
+
+	setTestConfig(t, &ConfigFlags{Site: "ams1", Instance: "seed-1"})
+	for _, name := range []string{"id.server.", "HOSTNAME.bind."} {
+		dnsMsg, zone, _, _, err := d.validateDNSRequest(addr, query(name))
+		if err != nil || zone != nil {
+			t.Fatalf("%s: expected an identity query, got zone %v and error %v", name, zone, err)
+		}
+		b, err := d.buildIdentityResponse(addr, dnsMsg)
+		if err != nil {
+			t.Fatalf("buildIdentityResponse: %s", err)
+		}
+		response := new(dns.Msg)
+		err = response.Unpack(b)
+		if err != nil {
+			t.Fatalf("Unpack: %s", err)
+		}
+		if len(response.Answer) != 1 {
+			t.Fatalf("%s: expected a single answer, got %v", name, response.Answer)
+		}
+		txt, ok := response.Answer[0].(*dns.TXT)
+		if !ok || txt.Hdr.Class != dns.ClassCHAOS || len(txt.Txt) != 1 || txt.Txt[0] != "seed-1@ams1" {
+			t.Errorf("%s: unexpected answer %v", name, response.Answer[0])
+		}
+	}
+}
+
+func TestSiteLabels(t *testing.T) {
+	tests := []struct {
+		labels   siteLabels
+		identity string
+	}{
+		{siteLabels{}, ""},
+		{siteLabels{site: "ams1"}, "ams1"},
+		{siteLabels{instance: "seed-1"}, "seed-1"},
+		{siteLabels{site: "ams1", instance: "seed-1"}, "seed-1@ams1"},
+	}
+	for _, test := range tests {
+		if identity := test.labels.identity(); identity != test.identity {
+			t.Errorf("expected the identity of %+v to be %q, got %q", test.labels, test.identity, identity)
+		}
+	}
+
+	for label, valid := range map[string]bool{"": true, "ams-1_a": true, "ams.1": false, "ams 1": false, "ams=1": false} {
+		if validSiteLabel(label) != valid {
+			t.Errorf("expected %q to be valid: %t", label, valid)
+		}
+	}
+
+	snapshot := &statsSnapshot{timestamp: time.Unix(100, 0), metrics: []metric{{"dns_queries", 3}}}
+	line := string(formatInfluxLine("dnsseeder", "mainnet", siteLabels{site: "ams1", instance: "seed-1"}, snapshot))
+	if !strings.HasPrefix(line, "dnsseeder,network=mainnet,site=ams1,instance=seed-1 dns_queries=3i ") {
+		t.Errorf("unexpected InfluxDB line %q", line)
+	}
+	lines := string(formatGraphiteLines("dnsseeder", "mainnet", siteLabels{site: "ams1"}, snapshot))
+	if lines != "dnsseeder.ams1.mainnet.dns_queries 3 100\n" {
+		t.Errorf("unexpected Graphite lines %q", lines)
+	}
+}
+
+func TestMergeStatuses(t *testing.T) {
+	statuses := []*statusResponse{
+		{
+			Network: "karlsen-mainnet", Site: "fra1", Peers: peerCounts{Known: 100, Good: 20},
+			Metrics:  map[string]uint64{"dns_queries": 10},
+			Networks: []networkStatus{{Name: "karlsen-mainnet", CrawlFailures: map[string]uint64{"refused": 2}}},
+		},
+		{
+			Network: "karlsen-mainnet", Site: "ams1", Peers: peerCounts{Known: 90, Good: 25},
+			Metrics:  map[string]uint64{"dns_queries": 5, "dns_throttled": 1},
+			Networks: []networkStatus{{Name: "karlsen-mainnet", CrawlFailures: map[string]uint64{"refused": 3}}},
+		},
+	}
+	merged, err := mergeStatuses(statuses)
+	if err != nil {
+		t.Fatalf("mergeStatuses: %s", err)
+	}
+	if len(merged.Sites) != 2 || merged.Sites[0].Site != "ams1" || merged.Sites[1].Site != "fra1" {
+		t.Errorf("expected the sites sorted by name, got %+v", merged.Sites)
+	}
+	if merged.Peers != (peerCounts{Known: 100, Good: 25}) {
+		t.Errorf("expected the highest peer counts, got %+v", merged.Peers)
+	}
+	if merged.Metrics["dns_queries"] != 15 || merged.Metrics["dns_throttled"] != 1 {
+		t.Errorf("expected the metrics to be summed, got %v", merged.Metrics)
+	}
+	if len(merged.Networks) != 1 || merged.Networks[0].CrawlFailures["refused"] != 5 {
+		t.Errorf("expected the crawl failures to be summed, got %+v", merged.Networks)
+	}
+
+	statuses = append(statuses, &statusResponse{Network: "karlsen-testnet"})
+	if _, err := mergeStatuses(statuses); err == nil {
+		t.Errorf("expected the statuses of different networks not to be merged")
+	}
+	if _, err := mergeStatuses(nil); err == nil {
+		t.Errorf("expected nothing to merge to fail")
+	}
+}
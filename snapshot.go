@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/karlsen-network/karlsend/domain/consensus/model/externalapi"
+	"github.com/karlsen-network/karlsend/domain/consensus/utils/subnetworks"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultSnapshotWeight is the weight in answers of the peers only
+	// known from snapshots when none is configured.
+	defaultSnapshotWeight = 0.25
+
+	// snapshotTimeout is the timeout for fetching a single snapshot.
+	snapshotTimeout = time.Second * 30
+
+	// snapshotMaxSize is the maximum size of a fetched snapshot.
+	snapshotMaxSize = 16 << 20
+
+	// snapshotMaxSkew is how far in the future a snapshot may be dated, to
+	// allow for clock differences between seeders.
+	snapshotMaxSkew = time.Minute * 5
+)
+
+// snapshotPeer is a peer listed in a snapshot.
+type snapshotPeer struct {
+	Address           string `json:"address"`
+	SubnetworkID      string `json:"subnetworkId,omitempty"`
+	LastSuccessMillis int64  `json:"lastSuccessMillis"`
+}
+
+// peerSnapshot lists the peers of a network a seeder reached itself
+// recently enough to serve them, as of CreatedMillis.
+type peerSnapshot struct {
+	Network       string         `json:"network"`
+	CreatedMillis int64          `json:"createdMillis"`
+	Peers         []snapshotPeer `json:"peers"`
+}
+
+// signedSnapshot is a snapshot as published: Snapshot is the JSON encoding
+// of a peerSnapshot, and Signature its Ed25519 signature by PublicKey. The
+// key and signature are hex encoded.
+type signedSnapshot struct {
+	PublicKey string `json:"publicKey"`
+	Signature string `json:"signature"`
+	Snapshot  []byte `json:"snapshot"`
+}
+
+var (
+	snapshotsMtx sync.RWMutex
+	snapshots    = make(map[string]*signedSnapshot)
+)
+
+// setSnapshot replaces the published snapshot of the named network.
+func setSnapshot(network string, snapshot *signedSnapshot) {
+	snapshotsMtx.Lock()
+	defer snapshotsMtx.Unlock()
+	snapshots[network] = snapshot
+}
+
+// getSnapshot returns the published snapshot of the named network, or nil
+// if there is none yet.
+func getSnapshot(network string) *signedSnapshot {
+	snapshotsMtx.RLock()
+	defer snapshotsMtx.RUnlock()
+	return snapshots[network]
+}
+
+// snapshotWeight returns the configured weight of the peers only known from
+// snapshots, falling back to defaultSnapshotWeight when none is configured.
+func snapshotWeight() float64 {
+	cfg := ActiveConfig()
+	if cfg == nil || cfg.SnapshotWeight <= 0 {
+		return defaultSnapshotWeight
+	}
+	return cfg.SnapshotWeight
+}
+
+// loadSigningKey reads the hex encoded Ed25519 seed in the file at path,
+// generating and saving a new one if the file doesn't exist.
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		err = os.WriteFile(path, []byte(hex.EncodeToString(key.Seed())+"\n"), 0600)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to save the signing key")
+		}
+		log.Infof("Generated a new signing key in %s", path)
+		return key, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the signing key")
+	}
+	return parseSigningKey(path, data)
+}
+
+// parseSigningKey parses the content of the signing key file at path.
+func parseSigningKey(path string, data []byte) (ed25519.PrivateKey, error) {
+	seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, errors.Errorf("%s doesn't hold a hex encoded %d-byte Ed25519 seed", path, ed25519.SeedSize)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// parseSnapshotKeys parses hex encoded Ed25519 public keys.
+func parseSnapshotKeys(keys []string) ([]ed25519.PublicKey, error) {
+	parsed := make([]ed25519.PublicKey, len(keys))
+	for i, key := range keys {
+		data, err := hex.DecodeString(strings.TrimSpace(key))
+		if err != nil || len(data) != ed25519.PublicKeySize {
+			return nil, errors.Errorf("%s is not a hex encoded Ed25519 public key", key)
+		}
+		parsed[i] = data
+	}
+	return parsed, nil
+}
+
+// newPeerSnapshot returns the snapshot of the peers amgr reached itself
+// recently enough to serve them, as of now.
+func newPeerSnapshot(amgr *Manager, now time.Time) *peerSnapshot {
+	snapshot := &peerSnapshot{
+		Network:       amgr.netParams.Name,
+		CreatedMillis: now.UnixMilli(),
+		Peers:         []snapshotPeer{},
+	}
+	for _, node := range amgr.Nodes() {
+		if !node.isVerified(now) {
+			continue
+		}
+		peer := snapshotPeer{
+			Address:           net.JoinHostPort(node.Addr.IP.String(), strconv.Itoa(int(node.Addr.Port))),
+			LastSuccessMillis: node.LastSuccess.UnixMilli(),
+		}
+		if node.SubnetworkID != nil {
+			peer.SubnetworkID = node.SubnetworkID.String()
+		}
+		snapshot.Peers = append(snapshot.Peers, peer)
+	}
+	sort.Slice(snapshot.Peers, func(i, j int) bool {
+		return snapshot.Peers[i].Address < snapshot.Peers[j].Address
+	})
+	return snapshot
+}
+
+// signSnapshot encodes and signs the snapshot with key.
+func signSnapshot(snapshot *peerSnapshot, key ed25519.PrivateKey) (*signedSnapshot, error) {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &signedSnapshot{
+		PublicKey: hex.EncodeToString(key.Public().(ed25519.PublicKey)),
+		Signature: hex.EncodeToString(ed25519.Sign(key, data)),
+		Snapshot:  data,
+	}, nil
+}
+
+// verify checks that the snapshot is signed by one of the trusted keys, and
+// returns its content.
+func (s *signedSnapshot) verify(trusted []ed25519.PublicKey) (*peerSnapshot, error) {
+	publicKey, err := hex.DecodeString(s.PublicKey)
+	if err != nil {
+		return nil, errors.New("invalid public key")
+	}
+	isTrusted := false
+	for _, key := range trusted {
+		if bytes.Equal(key, publicKey) {
+			isTrusted = true
+			break
+		}
+	}
+	if !isTrusted {
+		return nil, errors.Errorf("the key %s is not trusted", s.PublicKey)
+	}
+	signature, err := hex.DecodeString(s.Signature)
+	if err != nil || !ed25519.Verify(publicKey, s.Snapshot, signature) {
+		return nil, errors.New("invalid signature")
+	}
+
+	snapshot := &peerSnapshot{}
+	err = json.Unmarshal(s.Snapshot, snapshot)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid snapshot")
+	}
+	return snapshot, nil
+}
+
+// publishSnapshots signs a snapshot of the good peers of network with key
+// every interval, and publishes it on the HTTP API, until quit is closed.
+func publishSnapshots(network *seederNetwork, key ed25519.PrivateKey, interval time.Duration, quit <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		snapshot := newPeerSnapshot(network.amgr, time.Now())
+		signed, err := signSnapshot(snapshot, key)
+		if err != nil {
+			rpcLog.Errorf("Failed to sign the %s snapshot: %v", network.name(), err)
+		} else {
+			setSnapshot(network.name(), signed)
+			rpcLog.Debugf("Published a snapshot of %d %s peers", len(snapshot.Peers), network.name())
+		}
+
+		select {
+		case <-ticker.C:
+		case <-quit:
+			return
+		}
+	}
+}
+
+// fetchSnapshots fetches the snapshot of network published at source every
+// interval, and merges the peers of those signed by one of the trusted keys
+// into the network's peer pool, until quit is closed.
+func fetchSnapshots(network *seederNetwork, source string, trusted []ed25519.PublicKey,
+	interval time.Duration, quit <-chan struct{}) {
+
+	client := &http.Client{Timeout: snapshotTimeout}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		merged, err := fetchSnapshot(client, network, source, trusted)
+		if err != nil {
+			rpcLog.Warnf("Failed to fetch the %s snapshot from %s: %v", network.name(), source, err)
+		} else {
+			rpcLog.Debugf("Merged %d %s peers from the snapshot of %s", merged, network.name(), source)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-quit:
+			return
+		}
+	}
+}
+
+// fetchSnapshot fetches, verifies and merges a single snapshot, and returns
+// the number of peers that changed.
+func fetchSnapshot(client *http.Client, network *seederNetwork, source string,
+	trusted []ed25519.PublicKey) (int, error) {
+
+	sourceURL, err := url.Parse(source)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	query := sourceURL.Query()
+	query.Set("network", network.name())
+	sourceURL.RawQuery = query.Encode()
+
+	resp, err := client.Get(sourceURL.String())
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, errors.Errorf("unexpected status %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, snapshotMaxSize))
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	signed := &signedSnapshot{}
+	err = json.Unmarshal(body, signed)
+	if err != nil {
+		return 0, errors.Wrap(err, "invalid snapshot")
+	}
+	snapshot, err := signed.verify(trusted)
+	if err != nil {
+		return 0, err
+	}
+	return mergeSnapshot(network, snapshot, time.Now())
+}
+
+// mergeSnapshot merges the peers of a verified snapshot into the network's
+// peer pool, and returns the number of peers that changed.
+func mergeSnapshot(network *seederNetwork, snapshot *peerSnapshot, now time.Time) (int, error) {
+	if snapshot.Network != network.name() {
+		return 0, errors.Errorf("the snapshot is of network %s", snapshot.Network)
+	}
+	if time.UnixMilli(snapshot.CreatedMillis).After(now.Add(snapshotMaxSkew)) {
+		return 0, errors.Errorf("the snapshot is dated in the future")
+	}
+
+	var merged int
+	for _, peer := range snapshot.Peers {
+		addr, err := parsePeerAddress(peer.Address, network.defaultPort)
+		if err != nil {
+			rpcLog.Debugf("Ignoring snapshot peer %s: %v", peer.Address, err)
+			continue
+		}
+		var subnetworkID *externalapi.DomainSubnetworkID
+		if peer.SubnetworkID != "" {
+			subnetworkID, err = subnetworks.FromString(peer.SubnetworkID)
+			if err != nil {
+				rpcLog.Debugf("Ignoring snapshot peer %s: invalid subnetwork ID: %v", peer.Address, err)
+				continue
+			}
+		}
+		if network.amgr.MergeSnapshot(addr, subnetworkID, time.UnixMilli(peer.LastSuccessMillis)) {
+			merged++
+		}
+	}
+	return merged, nil
+}
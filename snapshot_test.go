@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/karlsen-network/karlsend/infrastructure/config"
+)
+
+func TestSnapshots(t *testing.T) {
+	cfg := setTestConfig(t, &ConfigFlags{NetworkFlags: config.NetworkFlags{Devnet: true}, SnapshotWeight: 0.25})
+	err := cfg.ResolveNetwork(nil)
+	if err != nil {
+		t.Fatalf("ResolveNetwork: %s", err)
+	}
+	params := cfg.NetParams()
+
+	keyPath := filepath.Join(t.TempDir(), "snapshot.key")
+	key, err := loadSigningKey(keyPath)
+	if err != nil {
+		t.Fatalf("loadSigningKey: %s", err)
+	}
+	reloaded, err := loadSigningKey(keyPath)
+	if err != nil || !reloaded.Equal(key) {
+		t.Fatalf("expected the generated key to be reloaded, got %v", err)
+	}
+	trusted := []ed25519.PublicKey{key.Public().(ed25519.PublicKey)}
+	_, other, _ := ed25519.GenerateKey(nil)
+
+	now := time.Now()
+	remote := newTestManager(t, params, 0)
+	for _, ip := range []string{"203.105.20.21", "203.105.20.22"} {
+		remote.nodes[ip] = &Node{
+			Addr:        appmessage.NewNetAddressIPPort(net.ParseIP(ip), 1313),
+			LastSuccess: now.Add(-time.Minute),
+		}
+	}
+	remote.nodes["198.51.100.1"] = &Node{Addr: appmessage.NewNetAddressIPPort(net.ParseIP("198.51.100.1"), 1313)}
+	remote.nodes["198.51.100.2"] = &Node{
+		Addr:          appmessage.NewNetAddressIPPort(net.ParseIP("198.51.100.2"), 1313),
+		SnapshotUntil: now.Add(time.Hour),
+	}
+	snapshot := newPeerSnapshot(remote, now)
+	if len(snapshot.Peers) != 2 {
+		t.Fatalf("expected only the peers reached by the seeder itself, got %+v", snapshot.Peers)
+	}
+
+	signed, err := signSnapshot(snapshot, key)
+	if err != nil {
+		t.Fatalf("signSnapshot: %s", err)
+	}
+	setSnapshot(params.Name, signed)
+	defer setSnapshot(params.Name, nil)
+	server := httptest.NewServer(NewHTTPServer(remote).mux)
+	defer server.Close()
+
+	local := &seederNetwork{
+		flags:       cfg.NetworkFlags,
+		amgr:        newTestManager(t, params, 1313),
+		defaultPort: 1313,
+	}
+	client := &http.Client{Timeout: snapshotTimeout}
+	_, err = fetchSnapshot(client, local, server.URL+"/v1/snapshot", []ed25519.PublicKey{other.Public().(ed25519.PublicKey)})
+	if err == nil {
+		t.Fatalf("expected a snapshot signed by an untrusted key to be rejected")
+	}
+	merged, err := fetchSnapshot(client, local, server.URL+"/v1/snapshot", trusted)
+	if err != nil {
+		t.Fatalf("fetchSnapshot: %s", err)
+	}
+	if merged != 2 {
+		t.Fatalf("expected the 2 peers to be merged, got %d", merged)
+	}
+
+	tampered := *signed
+	tampered.Snapshot = append([]byte(nil), signed.Snapshot...)
+	tampered.Snapshot[len(tampered.Snapshot)-2] = ' '
+	_, err = tampered.verify(trusted)
+	if err == nil {
+		t.Errorf("expected a tampered snapshot to be rejected")
+	}
+
+	// Peers only known from snapshots weigh less than those reached by the
+	// seeder itself, until a crawl of theirs fails.
+	local.amgr.nodes["203.105.20.21"].LastSuccess = now
+	pool, _ := local.amgr.scorePeers([]*Node{local.amgr.nodes["203.105.20.21"], local.amgr.nodes["203.105.20.22"]}, now)
+	if len(pool) != 2 || pool[0].weight != 1 || pool[1].weight != 0.25 {
+		t.Errorf("unexpected weights %+v", pool)
+	}
+	local.amgr.Attempt(net.ParseIP("203.105.20.22"))
+	local.amgr.RecordCrawl(net.ParseIP("203.105.20.22"), false, 0)
+	_, err = mergeSnapshot(local, snapshot, now)
+	if err != nil {
+		t.Fatalf("mergeSnapshot: %s", err)
+	}
+	node, _ := local.amgr.Node(net.ParseIP("203.105.20.22"))
+	if node.isGood(time.Now()) {
+		t.Errorf("expected a peer the seeder failed to reach not to be served on the word of a snapshot")
+	}
+}
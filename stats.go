@@ -0,0 +1,248 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/miekg/dns"
+)
+
+const (
+	// statsHistoryInterval is the interval between peer count samples.
+	statsHistoryInterval = time.Minute
+
+	// statsHistorySize is the number of peer count samples kept.
+	statsHistorySize = 24 * 60
+
+	// recentFailuresSize is the number of recent crawl failures kept.
+	recentFailuresSize = 50
+)
+
+// seederStats holds the counters collected by the crawler and the DNS
+// server. All fields must be accessed atomically.
+type seederStats struct {
+	crawlAttempts     uint64
+	crawlSuccesses    uint64
+	crawlFailures     uint64
+	crawlAddrReceived uint64
+
+	dnsQueries     uint64
+	dnsErrors      uint64
+	dnsResponses   uint64
+	dnsAddrsServed uint64
+	dnsRateLimited uint64
+	dnsClamped     uint64
+
+	dnsResolverAnswers uint64
+	dnsECSAnswers      uint64
+
+	inboundConnections uint64
+	inboundHarvested   uint64
+}
+
+var stats seederStats
+
+// startTime is the time the seeder was started, used to report uptime.
+var startTime = time.Now()
+
+// metric is a single named value in a stats snapshot.
+type metric struct {
+	name  string
+	value uint64
+}
+
+// statsSnapshot is a point-in-time copy of all seeder metrics.
+type statsSnapshot struct {
+	timestamp time.Time
+	metrics   []metric
+}
+
+// snapshot returns the current value of all counters together with the
+// address manager gauges.
+func (s *seederStats) snapshot(amgr *Manager) *statsSnapshot {
+	metrics := []metric{
+		{"crawl_attempts", atomic.LoadUint64(&s.crawlAttempts)},
+		{"crawl_successes", atomic.LoadUint64(&s.crawlSuccesses)},
+		{"crawl_failures", atomic.LoadUint64(&s.crawlFailures)},
+		{"crawl_addresses_received", atomic.LoadUint64(&s.crawlAddrReceived)},
+		{"dns_queries", atomic.LoadUint64(&s.dnsQueries)},
+		{"dns_errors", atomic.LoadUint64(&s.dnsErrors)},
+		{"dns_responses", atomic.LoadUint64(&s.dnsResponses)},
+		{"dns_addresses_served", atomic.LoadUint64(&s.dnsAddrsServed)},
+		{"dns_rate_limited", atomic.LoadUint64(&s.dnsRateLimited)},
+		{"dns_clamped", atomic.LoadUint64(&s.dnsClamped)},
+		{"dns_answers_resolver_ttl", atomic.LoadUint64(&s.dnsResolverAnswers)},
+		{"dns_answers_ecs_ttl", atomic.LoadUint64(&s.dnsECSAnswers)},
+		{"inbound_connections", atomic.LoadUint64(&s.inboundConnections)},
+		{"inbound_harvested", atomic.LoadUint64(&s.inboundHarvested)},
+	}
+	metrics = append(metrics, rejections.metrics()...)
+	metrics = append(metrics, queryTypes.metrics()...)
+	if amgr != nil {
+		metrics = append(metrics, networkMetrics(amgr)...)
+	}
+
+	return &statsSnapshot{
+		timestamp: time.Now(),
+		metrics:   metrics,
+	}
+}
+
+// queryTypeCounts counts the DNS queries by type, to show what clients
+// actually ask for. Types without a name are counted together as "other",
+// so clients can't add metrics at will.
+type queryTypeCounts struct {
+	mtx    sync.Mutex
+	counts map[string]uint64
+}
+
+var queryTypes = queryTypeCounts{counts: make(map[string]uint64)}
+
+func (c *queryTypeCounts) add(qtype uint16) {
+	atype, ok := dns.TypeToString[qtype]
+	if !ok {
+		atype = "other"
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.counts[atype]++
+}
+
+// metrics returns the query counts as stats metrics named after the query
+// types, in alphabetical order.
+func (c *queryTypeCounts) metrics() []metric {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	metrics := make([]metric, 0, len(c.counts))
+	for atype, count := range c.counts {
+		metrics = append(metrics, metric{"dns_queries_" + strings.ToLower(atype), count})
+	}
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name < metrics[j].name })
+	return metrics
+}
+
+// networkMetrics returns the metrics of the network of amgr alone: its peer
+// counts, its failed crawl attempts by reason and the usage of its zones.
+func networkMetrics(amgr *Manager) []metric {
+	known, good := amgr.Counts()
+	metrics := []metric{
+		{"peers_known", uint64(known)},
+		{"peers_good", uint64(good)},
+		{"peers_over_quota", atomic.LoadUint64(&amgr.peersOverQuota)},
+	}
+	metrics = append(metrics, amgr.failures.metrics()...)
+	for _, usage := range amgr.zoneUsages() {
+		metrics = append(metrics, usage.metrics()...)
+	}
+	if experiment := amgr.Experiment(); experiment != nil {
+		metrics = append(metrics, experiment.metrics()...)
+	}
+	if churn := amgr.Churn(); churn != nil {
+		metrics = append(metrics, churn.metrics()...)
+	}
+	if keepalive := amgr.Keepalive(); keepalive != nil {
+		metrics = append(metrics, keepalive.metrics()...)
+	}
+	for _, c := range amgr.Canaries() {
+		metrics = append(metrics, c.metrics()...)
+	}
+	return metrics
+}
+
+// peerCountSample is the number of known and good peers at a point in time.
+type peerCountSample struct {
+	Time  time.Time `json:"time"`
+	Known int       `json:"known"`
+	Good  int       `json:"good"`
+}
+
+// peerCountHistory keeps the most recent peer count samples.
+type peerCountHistory struct {
+	mtx     sync.RWMutex
+	samples []peerCountSample
+}
+
+var history peerCountHistory
+
+func (h *peerCountHistory) add(sample peerCountSample) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	h.samples = append(h.samples, sample)
+	if len(h.samples) > statsHistorySize {
+		h.samples = h.samples[len(h.samples)-statsHistorySize:]
+	}
+}
+
+// Samples returns a copy of all kept samples, oldest first.
+func (h *peerCountHistory) Samples() []peerCountSample {
+	h.mtx.RLock()
+	defer h.mtx.RUnlock()
+
+	return append([]peerCountSample(nil), h.samples...)
+}
+
+// crawlFailure describes a single failed crawl attempt.
+type crawlFailure struct {
+	Time    time.Time `json:"time"`
+	Address string    `json:"address"`
+	Error   string    `json:"error"`
+}
+
+// crawlFailureLog keeps the most recent crawl failures.
+type crawlFailureLog struct {
+	mtx      sync.RWMutex
+	failures []crawlFailure
+}
+
+var recentFailures crawlFailureLog
+
+func (l *crawlFailureLog) add(addr *appmessage.NetAddress, err error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.failures = append(l.failures, crawlFailure{
+		Time:    time.Now(),
+		Address: addr.TCPAddress().String(),
+		Error:   err.Error(),
+	})
+	if len(l.failures) > recentFailuresSize {
+		l.failures = l.failures[len(l.failures)-recentFailuresSize:]
+	}
+}
+
+// Failures returns a copy of the kept failures, newest first.
+func (l *crawlFailureLog) Failures() []crawlFailure {
+	l.mtx.RLock()
+	defer l.mtx.RUnlock()
+
+	failures := make([]crawlFailure, len(l.failures))
+	for i, failure := range l.failures {
+		failures[len(failures)-1-i] = failure
+	}
+	return failures
+}
+
+// recordHistory samples the peer counts every statsHistoryInterval until
+// quit is closed. It must be run as a goroutine.
+func recordHistory(amgr *Manager, quit <-chan struct{}) {
+	ticker := time.NewTicker(statsHistoryInterval)
+	defer ticker.Stop()
+	for {
+		known, good := amgr.Counts()
+		history.add(peerCountSample{Time: time.Now(), Known: known, Good: good})
+
+		select {
+		case <-ticker.C:
+		case <-quit:
+			return
+		}
+	}
+}
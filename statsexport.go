@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	statsExportInflux   = "influx"
+	statsExportGraphite = "graphite"
+
+	// statsExportTimeout is the timeout for a single push to the
+	// metrics backend.
+	statsExportTimeout = time.Second * 10
+)
+
+// statsExporter periodically pushes stats snapshots to an InfluxDB or
+// Graphite endpoint.
+type statsExporter struct {
+	format   string
+	address  string
+	prefix   string
+	network  string
+	interval time.Duration
+	amgr     *Manager
+
+	wg   sync.WaitGroup
+	quit chan struct{}
+}
+
+// newStatsExporter returns a new stats exporter pushing to the given
+// address in the given format ("influx" or "graphite").
+func newStatsExporter(format, address, prefix, network string, interval time.Duration,
+	amgr *Manager) (*statsExporter, error) {
+
+	if format != statsExportInflux && format != statsExportGraphite {
+		return nil, errors.Errorf("unknown stats export format %s", format)
+	}
+	if interval <= 0 {
+		return nil, errors.New("stats export interval must be positive")
+	}
+
+	return &statsExporter{
+		format:   format,
+		address:  address,
+		prefix:   prefix,
+		network:  network,
+		interval: interval,
+		amgr:     amgr,
+		quit:     make(chan struct{}),
+	}, nil
+}
+
+// Start starts pushing stats in the background.
+func (e *statsExporter) Start() {
+	e.wg.Add(1)
+	spawn("statsExporter.exportHandler", e.exportHandler)
+}
+
+// Stop stops the exporter and waits for it to finish.
+func (e *statsExporter) Stop() {
+	close(e.quit)
+	e.wg.Wait()
+}
+
+func (e *statsExporter) exportHandler() {
+	defer e.wg.Done()
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := e.export(e.network, stats.snapshot(e.amgr))
+			if err != nil {
+				log.Warnf("Failed to export stats to %s: %v", e.address, err)
+			}
+			// The other networks only have their own metrics, since the
+			// others are global.
+			for _, amgr := range networkManagers(e.amgr) {
+				if amgr == e.amgr {
+					continue
+				}
+				snapshot := &statsSnapshot{timestamp: time.Now(), metrics: networkMetrics(amgr)}
+				err := e.export(amgr.netParams.Name, snapshot)
+				if err != nil {
+					log.Warnf("Failed to export %s stats to %s: %v", amgr.netParams.Name, e.address, err)
+				}
+			}
+		case <-e.quit:
+			return
+		}
+	}
+}
+
+// export pushes the snapshot of the given network.
+func (e *statsExporter) export(network string, snapshot *statsSnapshot) error {
+	switch e.format {
+	case statsExportInflux:
+		payload := formatInfluxLine(e.prefix, network, activeSiteLabels(), snapshot)
+		if strings.HasPrefix(e.address, "http://") || strings.HasPrefix(e.address, "https://") {
+			return postPayload(e.address, payload)
+		}
+		return sendPayload("udp", e.address, payload)
+	default:
+		return sendPayload("tcp", e.address, formatGraphiteLines(e.prefix, network, activeSiteLabels(), snapshot))
+	}
+}
+
+// influxMeasurementEscaper and influxKeyEscaper escape measurement names,
+// and tag keys, tag values and field keys, as the InfluxDB line protocol
+// requires.
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxKeyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// formatInfluxLine renders the snapshot as a single InfluxDB line protocol
+// point, with the prefix as measurement and the network, and the site and
+// instance if set, as tags.
+func formatInfluxLine(prefix, network string, labels siteLabels, snapshot *statsSnapshot) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s,network=%s", influxMeasurementEscaper.Replace(prefix), influxKeyEscaper.Replace(network))
+	if labels.site != "" {
+		fmt.Fprintf(&buf, ",site=%s", influxKeyEscaper.Replace(labels.site))
+	}
+	if labels.instance != "" {
+		fmt.Fprintf(&buf, ",instance=%s", influxKeyEscaper.Replace(labels.instance))
+	}
+	buf.WriteByte(' ')
+	for i, m := range snapshot.metrics {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, "%s=%di", influxKeyEscaper.Replace(m.name), m.value)
+	}
+	fmt.Fprintf(&buf, " %d\n", snapshot.timestamp.UnixNano())
+	return buf.Bytes()
+}
+
+// formatGraphiteLines renders the snapshot in the Graphite plaintext
+// protocol, one metric per line under prefix.network, or
+// prefix.site.instance.network with the site and instance that are set.
+func formatGraphiteLines(prefix, network string, labels siteLabels, snapshot *statsSnapshot) []byte {
+	path := prefix
+	for _, label := range []string{labels.site, labels.instance} {
+		if label != "" {
+			path += "." + label
+		}
+	}
+	var buf bytes.Buffer
+	for _, m := range snapshot.metrics {
+		fmt.Fprintf(&buf, "%s.%s.%s %d %d\n", path, network, m.name, m.value,
+			snapshot.timestamp.Unix())
+	}
+	return buf.Bytes()
+}
+
+func sendPayload(network, address string, payload []byte) error {
+	conn, err := net.DialTimeout(network, address, statsExportTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	err = conn.SetWriteDeadline(time.Now().Add(statsExportTimeout))
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(payload)
+	return err
+}
+
+func postPayload(url string, payload []byte) error {
+	client := http.Client{Timeout: statsExportTimeout}
+	resp, err := client.Post(url, "text/plain; charset=utf-8", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
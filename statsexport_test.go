@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStatsExportFormat(t *testing.T) {
+	snapshot := &statsSnapshot{
+		timestamp: time.Unix(1700000000, 5),
+		metrics:   []metric{{"dns_queries", 12}, {"nodes_good", 3}},
+	}
+
+	influx := string(formatInfluxLine("dnsseeder", "karlsen-mainnet", siteLabels{}, snapshot))
+	expected := "dnsseeder,network=karlsen-mainnet dns_queries=12i,nodes_good=3i 1700000000000000005\n"
+	if influx != expected {
+		t.Errorf("unexpected influx line %q, expected %q", influx, expected)
+	}
+	influx = string(formatInfluxLine("dnsseeder", "karlsen-mainnet",
+		siteLabels{site: "ams1", instance: "seed-1"}, snapshot))
+	expected = "dnsseeder,network=karlsen-mainnet,site=ams1,instance=seed-1 dns_queries=12i,nodes_good=3i 1700000000000000005\n"
+	if influx != expected {
+		t.Errorf("unexpected labeled influx line %q, expected %q", influx, expected)
+	}
+	influx = string(formatInfluxLine("dns seeder,eu", "karlsen mainnet",
+		siteLabels{site: "ams,1", instance: "seed=1"}, snapshot))
+	expected = `dns\ seeder\,eu,network=karlsen\ mainnet,site=ams\,1,instance=seed\=1 ` +
+		"dns_queries=12i,nodes_good=3i 1700000000000000005\n"
+	if influx != expected {
+		t.Errorf("unexpected escaped influx line %q, expected %q", influx, expected)
+	}
+
+	graphite := string(formatGraphiteLines("dnsseeder", "karlsen-mainnet", siteLabels{site: "ams1"}, snapshot))
+	expected = "dnsseeder.ams1.karlsen-mainnet.dns_queries 12 1700000000\n" +
+		"dnsseeder.ams1.karlsen-mainnet.nodes_good 3 1700000000\n"
+	if graphite != expected {
+		t.Errorf("unexpected graphite lines %q, expected %q", graphite, expected)
+	}
+}
+
+func TestStatsExportPush(t *testing.T) {
+	snapshot := &statsSnapshot{timestamp: time.Unix(1700000000, 0), metrics: []metric{{"dns_queries", 7}}}
+
+	_, err := newStatsExporter("statsd", "127.0.0.1:1", "dnsseeder", "karlsen-mainnet", time.Minute, nil)
+	if err == nil {
+		t.Errorf("expected an unknown format to be rejected")
+	}
+	_, err = newStatsExporter(statsExportInflux, "127.0.0.1:1", "dnsseeder", "karlsen-mainnet", 0, nil)
+	if err == nil {
+		t.Errorf("expected a zero interval to be rejected")
+	}
+
+	// Influx over UDP.
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket: %s", err)
+	}
+	defer udp.Close()
+	e, err := newStatsExporter(statsExportInflux, udp.LocalAddr().String(), "dnsseeder", "karlsen-mainnet",
+		time.Minute, nil)
+	if err != nil {
+		t.Fatalf("newStatsExporter: %s", err)
+	}
+	err = e.export("karlsen-mainnet", snapshot)
+	if err != nil {
+		t.Fatalf("export over UDP: %s", err)
+	}
+	buf := make([]byte, 1024)
+	udp.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := udp.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("ReadFrom: %s", err)
+	}
+	expected := "dnsseeder,network=karlsen-mainnet dns_queries=7i 1700000000000000000\n"
+	if string(buf[:n]) != expected {
+		t.Errorf("unexpected UDP payload %q, expected %q", buf[:n], expected)
+	}
+
+	// Influx over HTTP, including a failing endpoint.
+	received := make(chan string, 1)
+	status := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- string(body)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	e.address = server.URL + "/write?db=seeder"
+	err = e.export("karlsen-mainnet", snapshot)
+	if err != nil {
+		t.Fatalf("export over HTTP: %s", err)
+	}
+	if body := <-received; body != expected {
+		t.Errorf("unexpected HTTP payload %q, expected %q", body, expected)
+	}
+	status = http.StatusInternalServerError
+	err = e.export("karlsen-mainnet", snapshot)
+	<-received
+	if err == nil {
+		t.Errorf("expected a failing HTTP endpoint to return an error")
+	}
+
+	// Graphite over TCP.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %s", err)
+	}
+	defer listener.Close()
+	lines := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			lines <- ""
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		lines <- line
+	}()
+	e.format = statsExportGraphite
+	e.address = listener.Addr().String()
+	err = e.export("karlsen-mainnet", snapshot)
+	if err != nil {
+		t.Fatalf("export over TCP: %s", err)
+	}
+	expected = "dnsseeder.karlsen-mainnet.dns_queries 7 1700000000\n"
+	if line := <-lines; line != expected {
+		t.Errorf("unexpected graphite payload %q, expected %q", line, expected)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// peersFileVersion is the current version of the on-disk peers file format.
+// Bump it and register a migration in peersFileMigrations whenever the
+// layout of the serialized Node record changes.
+const peersFileVersion = 2
+
+// errPeersFileTooNew is returned when the peers file was written by a newer
+// version of dnsseeder than the running one.
+var errPeersFileTooNew = errors.New("peers file version is newer than supported")
+
+// peersFile is the versioned on-disk representation of the peers database.
+// Bans holds the bans set through the admin service, so restarts don't lift
+// them; those loaded from ban lists and blocklists are loaded again.
+type peersFile struct {
+	Version uint32
+	Nodes   map[string]*Node
+	Bans    []savedBan `json:",omitempty"`
+}
+
+// peersFileMigration upgrades raw peers file contents from version `from` to
+// version from+1.
+type peersFileMigration struct {
+	from    uint32
+	migrate func(data []byte) ([]byte, error)
+}
+
+// peersFileMigrations holds all known migrations, ordered by version.
+var peersFileMigrations = []peersFileMigration{
+	{from: 1, migrate: migratePeersFileV1ToV2},
+}
+
+// migratePeersFileV1ToV2 wraps the legacy bare node map into the versioned
+// envelope.
+func migratePeersFileV1ToV2(data []byte) ([]byte, error) {
+	var nodes map[string]json.RawMessage
+	err := json.Unmarshal(data, &nodes)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		Version uint32
+		Nodes   map[string]json.RawMessage
+	}{
+		Version: 2,
+		Nodes:   nodes,
+	})
+}
+
+// peersFileDataVersion returns the format version of the given raw peers
+// file contents. Files written before versioning was introduced are a bare
+// map of nodes keyed by IP and are reported as version 1.
+func peersFileDataVersion(data []byte) (uint32, error) {
+	var envelope map[string]json.RawMessage
+	err := json.Unmarshal(data, &envelope)
+	if err != nil {
+		return 0, err
+	}
+	rawVersion, ok := envelope["Version"]
+	if !ok {
+		return 1, nil
+	}
+	var version uint32
+	err = json.Unmarshal(rawVersion, &version)
+	if err != nil {
+		return 0, errors.Wrap(err, "invalid peers file version")
+	}
+	return version, nil
+}
+
+// migratePeersFileData applies all migrations required to bring the given
+// raw peers file contents to peersFileVersion. It returns the migrated
+// contents along with the version they were originally in.
+func migratePeersFileData(data []byte) ([]byte, uint32, error) {
+	originalVersion, err := peersFileDataVersion(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	if originalVersion > peersFileVersion {
+		return nil, originalVersion, errors.Wrapf(errPeersFileTooNew,
+			"version %d, supported %d", originalVersion, peersFileVersion)
+	}
+
+	version := originalVersion
+	for _, migration := range peersFileMigrations {
+		if migration.from != version {
+			continue
+		}
+		data, err = migration.migrate(data)
+		if err != nil {
+			return nil, originalVersion, errors.Wrapf(err,
+				"failed to migrate peers file from version %d", version)
+		}
+		version++
+	}
+	if version != peersFileVersion {
+		return nil, originalVersion, errors.Errorf("no migration path "+
+			"from peers file version %d", version)
+	}
+
+	return data, originalVersion, nil
+}
+
+// readPeersFile reads, migrates and decodes the peers file at the given
+// path. When the file was migrated, a backup of the original contents is
+// kept next to it.
+func readPeersFile(filePath string) (*peersFile, uint32, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	file, originalVersion, err := decodePeersFile(data)
+	if err != nil {
+		return nil, originalVersion, errors.Wrapf(err, "error reading %s", filePath)
+	}
+	return file, originalVersion, nil
+}
+
+// decodePeersFile migrates and decodes raw peers file contents, and returns
+// them along with the version they were originally in.
+func decodePeersFile(data []byte) (*peersFile, uint32, error) {
+	migrated, originalVersion, err := migratePeersFileData(data)
+	if err != nil {
+		return nil, originalVersion, err
+	}
+
+	var file peersFile
+	err = json.Unmarshal(migrated, &file)
+	if err != nil {
+		return nil, originalVersion, errors.WithStack(err)
+	}
+	if file.Nodes == nil {
+		file.Nodes = make(map[string]*Node)
+	}
+
+	return &file, originalVersion, nil
+}
+
+// backupPeersFile copies the peers file aside before it gets overwritten
+// in a newer format.
+func backupPeersFile(filePath string, version uint32) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fmt.Sprintf("%s.v%d.bak", filePath, version), data, 0600)
+}
+
+// checkPeersFile validates the peers file at the given path without
+// modifying it, and prints a short report to stdout.
+func checkPeersFile(filePath string) error {
+	_, err := os.Stat(filePath)
+	if os.IsNotExist(err) {
+		fmt.Printf("%s: does not exist\n", filePath)
+		return nil
+	}
+
+	file, originalVersion, err := readPeersFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	invalid := 0
+	for key, node := range file.Nodes {
+		if node == nil || node.Addr == nil || node.Addr.IP == nil {
+			fmt.Printf("%s: invalid node record %s\n", filePath, key)
+			invalid++
+		}
+	}
+
+	fmt.Printf("%s: version %d (current %d), %d nodes, %d invalid, %d bans\n",
+		filePath, originalVersion, peersFileVersion, len(file.Nodes), invalid, len(file.Bans))
+	if originalVersion < peersFileVersion {
+		fmt.Printf("%s: will be migrated on next start\n", filePath)
+	}
+	if invalid > 0 {
+		return errors.Errorf("%s contains %d invalid node records", filePath, invalid)
+	}
+	return nil
+}
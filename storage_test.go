@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/karlsen-network/karlsend/domain/dagconfig"
+	"github.com/pkg/errors"
+)
+
+func TestMigratePeersFileData(t *testing.T) {
+	legacy := []byte(`{"203.105.20.21":{"Addr":{"IP":"203.105.20.21","Port":1313}}}`)
+
+	migrated, originalVersion, err := migratePeersFileData(legacy)
+	if err != nil {
+		t.Fatalf("migratePeersFileData: %s", err)
+	}
+	if originalVersion != 1 {
+		t.Errorf("expected original version 1, got %d", originalVersion)
+	}
+
+	var file peersFile
+	err = json.Unmarshal(migrated, &file)
+	if err != nil {
+		t.Fatalf("Unmarshal: %s", err)
+	}
+	if file.Version != peersFileVersion {
+		t.Errorf("expected version %d, got %d", peersFileVersion, file.Version)
+	}
+	node, ok := file.Nodes["203.105.20.21"]
+	if !ok || node.Addr.Port != 1313 {
+		t.Errorf("node record was not preserved: %+v", file.Nodes)
+	}
+
+	_, _, err = migratePeersFileData([]byte(`{"Version":4294967295,"Nodes":{}}`))
+	if !errors.Is(err, errPeersFileTooNew) {
+		t.Errorf("expected errPeersFileTooNew, got %v", err)
+	}
+}
+
+func TestSavedBans(t *testing.T) {
+	m := newTestManager(t, &dagconfig.MainnetParams, 0)
+	peersFilePath := m.peersFile
+	_, permanent, _ := net.ParseCIDR("198.51.100.0/24")
+	_, temporary, _ := net.ParseCIDR("203.0.113.7/32")
+	_, listed, _ := net.ParseCIDR("192.0.2.0/24")
+	m.Ban(permanent, "spam", 0)
+	m.Ban(temporary, "flood", time.Hour)
+	m.SetBanList(banListSource, []*Ban{{Network: listed, Reason: "listed"}})
+	m.savePeers()
+
+	restarted := newTestManager(t, &dagconfig.MainnetParams, 0)
+	restarted.peersFile = peersFilePath
+	err := restarted.deserializePeers()
+	if err != nil {
+		t.Fatalf("deserializePeers: %s", err)
+	}
+	bans := restarted.Bans()
+	if len(bans) != 2 || bans[0].Network.String() != "198.51.100.0/24" || bans[0].Reason != "spam" ||
+		!bans[0].Expiry.IsZero() || bans[1].Network.String() != "203.0.113.7/32" || bans[1].Expiry.IsZero() {
+		t.Fatalf("expected the admin bans alone to be restored, got %+v", bans)
+	}
+	if !restarted.bans.IsBanned(net.ParseIP("203.0.113.7")) {
+		t.Errorf("expected the restored ban to be enforced")
+	}
+
+	// Bans that expired while the seeder was down, and invalid ones, are
+	// left out.
+	data, err := json.Marshal(&peersFile{
+		Version: peersFileVersion,
+		Bans: []savedBan{
+			{Network: "203.0.113.8/32", Expiry: time.Now().Add(-time.Minute)},
+			{Network: "nope"},
+			{Network: "203.0.113.9"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Marshal: %s", err)
+	}
+	err = os.WriteFile(peersFilePath, data, 0600)
+	if err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+	restarted = newTestManager(t, &dagconfig.MainnetParams, 0)
+	restarted.peersFile = peersFilePath
+	err = restarted.deserializePeers()
+	if err != nil {
+		t.Fatalf("deserializePeers: %s", err)
+	}
+	if bans := restarted.Bans(); len(bans) != 1 || bans[0].Network.String() != "203.0.113.9/32" {
+		t.Errorf("expected the single valid unexpired ban to be restored, got %+v", bans)
+	}
+}
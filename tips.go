@@ -0,0 +1,65 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// laggingTipAge is how long before a peer announced its tip the block
+	// must have been first announced by another peer for the peer to be
+	// rejected as lagging.
+	laggingTipAge = time.Minute * 10
+
+	// maxTrackedTips is the maximum number of announced blocks whose
+	// first announcement is remembered. The oldest are forgotten first.
+	maxTrackedTips = 1 << 16
+)
+
+// tipTracker remembers when each block announced by the crawled peers was
+// first announced. Peers announce their tip right after the handshake, so a
+// peer announcing a block that others announced long ago is lagging behind
+// the network.
+type tipTracker struct {
+	mtx       sync.Mutex
+	firstSeen map[string]time.Time
+
+	// order holds the tracked blocks, in the order they were first
+	// announced.
+	order []string
+}
+
+func newTipTracker() *tipTracker {
+	return &tipTracker{firstSeen: make(map[string]time.Time)}
+}
+
+// observe records that a peer announced the block with the given hash at
+// now, and returns when the block was first announced. A nil tracker
+// returns now.
+func (t *tipTracker) observe(hash string, now time.Time) time.Time {
+	if t == nil {
+		return now
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	firstSeen, ok := t.firstSeen[hash]
+	if ok {
+		return firstSeen
+	}
+	if len(t.order) >= maxTrackedTips {
+		delete(t.firstSeen, t.order[0])
+		t.order = t.order[1:]
+	}
+	t.firstSeen[hash] = now
+	t.order = append(t.order, hash)
+	return now
+}
+
+// lagging returns whether the block with the given hash, announced by a
+// peer at now as its tip, was first announced long enough before for the
+// peer to be lagging.
+func (t *tipTracker) lagging(hash string, now time.Time) bool {
+	return now.Sub(t.observe(hash, now)) > laggingTipAge
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracerName = "github.com/karlsen-network/dnsseeder"
+
+	// tracingShutdownTimeout is how long shutdown waits for pending spans
+	// to be exported.
+	tracingShutdownTimeout = 5 * time.Second
+)
+
+// tracer creates the spans of the crawl and DNS paths. Until tracing is
+// started, the global tracer provider is a no-op and so are its spans.
+var tracer = otel.Tracer(tracerName)
+
+// startTracing exports spans to the OTLP/gRPC collector at the given
+// endpoint, and returns a function that flushes pending spans and stops
+// the exporter.
+func startTracing(endpoint string, insecure bool, sampleRatio float64, network string) (func(), error) {
+	options := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(endpoint)}
+	if insecure {
+		options = append(options, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(context.Background(), options...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create OTLP exporter")
+	}
+
+	res := resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceNameKey.String("dnsseeder"),
+		attribute.String("karlsen.network", network))
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))))
+	otel.SetTracerProvider(provider)
+
+	log.Infof("Exporting traces to %s", endpoint)
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+		defer cancel()
+
+		err := provider.Shutdown(ctx)
+		if err != nil {
+			log.Warnf("Failed to flush traces: %v", err)
+		}
+	}, nil
+}
+
+// endSpan ends span, marking it as failed if err is not nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/karlsen-network/karlsend/domain/dagconfig"
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans routes the spans of the test to a recorder, and restores the
+// previous tracer when it finishes. The tracer is replaced directly, since
+// the global one only follows the first provider that is set.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	previous := tracer
+	recorder := tracetest.NewSpanRecorder()
+	tracer = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer(tracerName)
+	t.Cleanup(func() { tracer = previous })
+	return recorder
+}
+
+func TestEndSpan(t *testing.T) {
+	recorder := recordSpans(t)
+
+	_, span := tracer.Start(context.Background(), "ok")
+	endSpan(span, nil)
+	_, span = tracer.Start(context.Background(), "failed")
+	endSpan(span, errors.New("refused"))
+
+	ended := recorder.Ended()
+	if len(ended) != 2 {
+		t.Fatalf("expected 2 ended spans, got %d", len(ended))
+	}
+	if ended[0].Status().Code != codes.Unset || len(ended[0].Events()) != 0 {
+		t.Errorf("expected the successful span to carry no error, got %v", ended[0].Status())
+	}
+	if ended[1].Status().Code != codes.Error || ended[1].Status().Description != "refused" {
+		t.Errorf("expected the failed span to be marked as an error, got %v", ended[1].Status())
+	}
+	if len(ended[1].Events()) != 1 || ended[1].Events()[0].Name != "exception" {
+		t.Errorf("expected the error to be recorded on the failed span, got %v", ended[1].Events())
+	}
+}
+
+func TestDNSRequestSpans(t *testing.T) {
+	recorder := recordSpans(t)
+
+	m := newTestManager(t, &dagconfig.MainnetParams, 0)
+	network := &seederNetwork{amgr: m, zones: []ZoneConfig{{Host: "seed.example.org", Nameserver: "ns.example.org"}}}
+	d := NewDNSServer([]*seederNetwork{network}, "", nil, nil)
+
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP: %s", err)
+	}
+	defer server.Close()
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP: %s", err)
+	}
+	defer client.Close()
+	clientAddr := client.LocalAddr().(*net.UDPAddr)
+
+	query := new(dns.Msg)
+	query.SetQuestion("seed.example.org.", dns.TypeNS)
+	b, err := query.Pack()
+	if err != nil {
+		t.Fatalf("Pack: %s", err)
+	}
+	wg.Add(1)
+	d.handleDNSRequest(clientAddr, server, b)
+
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = client.Read(make([]byte, 512))
+	if err != nil {
+		t.Fatalf("expected an answer to the NS query: %s", err)
+	}
+	names := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		names[span.Name()] = span
+	}
+	for _, name := range []string{"dns.request", "dns.parse", "dns.lookup", "dns.write"} {
+		span, ok := names[name]
+		if !ok {
+			t.Errorf("expected a %s span, got %v", name, names)
+			continue
+		}
+		if span.Status().Code == codes.Error {
+			t.Errorf("expected %s to succeed, got %v", name, span.Status())
+		}
+		if name != "dns.request" && span.Parent().SpanID() != names["dns.request"].SpanContext().SpanID() {
+			t.Errorf("expected %s to be a child of dns.request", name)
+		}
+	}
+
+	// A query outside the zones fails in parsing, and so does its request.
+	query.SetQuestion("example.com.", dns.TypeA)
+	b, err = query.Pack()
+	if err != nil {
+		t.Fatalf("Pack: %s", err)
+	}
+	before := len(recorder.Ended())
+	wg.Add(1)
+	d.handleDNSRequest(clientAddr, server, b)
+	ended := recorder.Ended()[before:]
+	if len(ended) != 2 || ended[0].Name() != "dns.parse" || ended[1].Name() != "dns.request" {
+		t.Fatalf("expected a parse and a request span, got %v", ended)
+	}
+	for _, span := range ended {
+		if span.Status().Code != codes.Error {
+			t.Errorf("expected %s to fail, got %v", span.Name(), span.Status())
+		}
+	}
+}
+
+func TestStartTracing(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	stop, err := startTracing("127.0.0.1:4317", true, 0, "karlsen-mainnet")
+	if err != nil {
+		t.Fatalf("startTracing: %s", err)
+	}
+	if _, ok := otel.GetTracerProvider().(*sdktrace.TracerProvider); !ok {
+		t.Errorf("expected startTracing to install an SDK tracer provider")
+	}
+	stop()
+}
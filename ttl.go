@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// Client classes answers are given a TTL for.
+const (
+	ttlClassDefault  = "default"
+	ttlClassResolver = "resolver"
+	ttlClassECS      = "ecs"
+)
+
+var (
+	publicResolversMtx sync.RWMutex
+	publicResolvers    []*net.IPNet
+)
+
+// setPublicResolvers replaces the networks of the big public resolvers.
+func setPublicResolvers(networks []*net.IPNet) {
+	publicResolversMtx.Lock()
+	defer publicResolversMtx.Unlock()
+	publicResolvers = networks
+}
+
+// isPublicResolver returns whether ip is in the networks of the big public
+// resolvers.
+func isPublicResolver(ip net.IP) bool {
+	publicResolversMtx.RLock()
+	defer publicResolversMtx.RUnlock()
+	for _, network := range publicResolvers {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePublicResolvers parses a comma separated list of the addresses or
+// CIDR networks of the big public resolvers.
+func parsePublicResolvers(list string) ([]*net.IPNet, error) {
+	if list == "" {
+		return nil, nil
+	}
+
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		ipNet, err := parseIPNet(strings.TrimSpace(entry))
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid public resolver")
+		}
+		networks = append(networks, ipNet)
+	}
+	return networks, nil
+}
+
+// hasClientSubnet returns whether query carries an EDNS client subnet
+// option, as sent by resolvers caching answers for each client subnet.
+func hasClientSubnet(query *dns.Msg) bool {
+	opt := query.IsEdns0()
+	if opt == nil {
+		return false
+	}
+	for _, option := range opt.Option {
+		if _, ok := option.(*dns.EDNS0_SUBNET); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// scopeClientSubnet sets the scope of the EDNS client subnet option of
+// response, copied from the query, to the prefix the query carried, so
+// resolvers only share the answer within that subnet, as RFC 7871
+// describes.
+func scopeClientSubnet(response *dns.Msg) {
+	opt := response.IsEdns0()
+	if opt == nil {
+		return
+	}
+	for _, option := range opt.Option {
+		if subnet, ok := option.(*dns.EDNS0_SUBNET); ok {
+			subnet.SourceScope = subnet.SourceNetmask
+		}
+	}
+}
+
+// answerTTL returns the class of the client that sent query from ip, and the
+// TTL of the address records answering it. Answers cached for a client
+// subnet are only shared by that subnet, so --ecsttl comes first, then
+// --resolverttl for the big public resolvers, whose caches are shared by
+// many clients, and --ttl for everyone else, such as stub resolvers.
+func answerTTL(ip net.IP, query *dns.Msg) (string, uint32) {
+	cfg := ActiveConfig()
+	if cfg == nil {
+		return ttlClassDefault, defaultDNSTTL
+	}
+	switch {
+	case cfg.ECSTTL > 0 && hasClientSubnet(query):
+		return ttlClassECS, cfg.ECSTTL
+	case cfg.ResolverTTL > 0 && isPublicResolver(ip):
+		return ttlClassResolver, cfg.ResolverTTL
+	}
+	return ttlClassDefault, cfg.DNSTTL
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/karlsen-network/karlsend/domain/dagconfig"
+	"github.com/miekg/dns"
+)
+
+func TestAnswerTTL(t *testing.T) {
+	resolvers, err := parsePublicResolvers("192.0.2.0/24, 2001:db8:53::1")
+	if err != nil {
+		t.Fatalf("parsePublicResolvers: %s", err)
+	}
+	setPublicResolvers(resolvers)
+	defer setPublicResolvers(nil)
+	setTestConfig(t, &ConfigFlags{DNSTTL: 30, ResolverTTL: 300, ECSTTL: 60})
+
+	query := new(dns.Msg)
+	query.SetQuestion("seed.example.org.", dns.TypeA)
+	ecsQuery := query.Copy()
+	ecsQuery.SetEdns0(1232, false)
+	opt := ecsQuery.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24,
+		Address: net.ParseIP("198.51.100.0")})
+	plainEDNSQuery := query.Copy()
+	plainEDNSQuery.SetEdns0(1232, false)
+
+	tests := []struct {
+		ip    string
+		query *dns.Msg
+		class string
+		ttl   uint32
+	}{
+		{"203.0.113.1", query, ttlClassDefault, 30},
+		{"203.0.113.1", plainEDNSQuery, ttlClassDefault, 30},
+		{"192.0.2.53", query, ttlClassResolver, 300},
+		{"2001:db8:53::1", query, ttlClassResolver, 300},
+		{"192.0.2.53", ecsQuery, ttlClassECS, 60},
+		{"203.0.113.1", ecsQuery, ttlClassECS, 60},
+	}
+	for _, test := range tests {
+		class, ttl := answerTTL(net.ParseIP(test.ip), test.query)
+		if class != test.class || ttl != test.ttl {
+			t.Errorf("%s: expected class %s and TTL %d, got %s and %d", test.ip, test.class, test.ttl, class, ttl)
+		}
+	}
+
+	// Classes without their own TTL get that of the next one.
+	setTestConfig(t, &ConfigFlags{DNSTTL: 30, ResolverTTL: 300})
+	if class, ttl := answerTTL(net.ParseIP("192.0.2.53"), ecsQuery); class != ttlClassResolver || ttl != 300 {
+		t.Errorf("expected the resolver TTL without an ECS TTL, got %s and %d", class, ttl)
+	}
+
+	if _, err := parsePublicResolvers("192.0.2.0/24,nope"); err == nil {
+		t.Errorf("expected an invalid public resolver to be refused")
+	}
+}
+
+func TestClientSubnetScope(t *testing.T) {
+	setTestConfig(t, &ConfigFlags{DNSTTL: 30, ECSTTL: 60})
+
+	authority, err := dns.NewRR("seed.example.org. 86400 IN NS ns.example.org.")
+	if err != nil {
+		t.Fatalf("NewRR: %s", err)
+	}
+	m := newTestManager(t, &dagconfig.MainnetParams, 42111)
+	zone := &dnsZone{hostname: "seed.example.org.", authority: authority, amgr: m}
+	d := &DNSServer{}
+	client := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 53}
+
+	query := new(dns.Msg)
+	query.SetQuestion("seed.example.org.", dns.TypeA)
+	query.SetEdns0(1232, false)
+	opt := query.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24,
+		Address: net.ParseIP("198.51.100.0")})
+	packed, err := d.buildDNSResponse(client, zone, query, true, nil, false, "A")
+	if err != nil {
+		t.Fatalf("buildDNSResponse: %s", err)
+	}
+	response := new(dns.Msg)
+	err = response.Unpack(packed)
+	if err != nil {
+		t.Fatalf("Unpack: %s", err)
+	}
+	subnet, ok := response.IsEdns0().Option[0].(*dns.EDNS0_SUBNET)
+	if !ok || subnet.SourceScope != 24 {
+		t.Errorf("expected the client subnet to be scoped to its /24, got %+v", response.IsEdns0().Option)
+	}
+}
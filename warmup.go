@@ -0,0 +1,81 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// warmupInitialRate is the number of new crawl connections per second at
+// the start of the warm-up.
+const warmupInitialRate = 1.0
+
+// crawlWarmup ramps up the crawl after a restart: the rate of new crawl
+// connections grows linearly over its duration, across all networks, and
+// the peers that were good before the restart are crawled before the
+// others, so the good pool recovers first and every deploy isn't followed
+// by a crawl of the whole database at once.
+type crawlWarmup struct {
+	start       time.Time
+	end         time.Time
+	targetRate  float64
+	mtx         sync.Mutex
+	connections tokenBucket
+}
+
+// warmup is the warm-up of the running seeder, or nil if it is disabled.
+var warmup *crawlWarmup
+
+// newCrawlWarmup returns a warm-up starting at now, ramping up to
+// targetRate new connections per second over duration, or nil if duration
+// is not positive.
+func newCrawlWarmup(now time.Time, duration time.Duration, targetRate float64) *crawlWarmup {
+	if duration <= 0 {
+		return nil
+	}
+	if targetRate < warmupInitialRate {
+		targetRate = warmupInitialRate
+	}
+	return &crawlWarmup{
+		start:       now,
+		end:         now.Add(duration),
+		targetRate:  targetRate,
+		connections: tokenBucket{tokens: 1, updated: now},
+	}
+}
+
+// active returns whether the warm-up is still under way at now. A nil
+// warm-up is never active.
+func (w *crawlWarmup) active(now time.Time) bool {
+	return w != nil && now.Before(w.end)
+}
+
+// rate returns the number of new connections per second allowed at now,
+// during the warm-up.
+func (w *crawlWarmup) rate(now time.Time) float64 {
+	progress := now.Sub(w.start).Seconds() / w.end.Sub(w.start).Seconds()
+	if progress < 0 {
+		progress = 0
+	}
+	return warmupInitialRate + (w.targetRate-warmupInitialRate)*progress
+}
+
+// wait blocks until the warm-up allows a new connection, and counts it.
+// Connections are spread evenly, without bursts. It returns immediately
+// once the warm-up is over, or if there is none.
+func (w *crawlWarmup) wait() {
+	for {
+		now := time.Now()
+		if !w.active(now) {
+			return
+		}
+		rate := w.rate(now)
+		w.mtx.Lock()
+		ok := w.connections.take(now, rate, 1)
+		tokens := w.connections.tokens
+		w.mtx.Unlock()
+		if ok {
+			return
+		}
+		time.Sleep(time.Duration((1 - tokens) / rate * float64(time.Second)))
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/karlsen-network/karlsend/app/appmessage"
+	"github.com/karlsen-network/karlsend/domain/dagconfig"
+)
+
+func TestCrawlWarmup(t *testing.T) {
+	now := time.Now()
+	if newCrawlWarmup(now, 0, 20) != nil {
+		t.Fatalf("expected no warm-up without a duration")
+	}
+
+	w := newCrawlWarmup(now, 10*time.Minute, 21)
+	if !w.active(now) || w.active(now.Add(10*time.Minute)) {
+		t.Errorf("expected the warm-up to last 10 minutes")
+	}
+	for _, test := range []struct {
+		elapsed  time.Duration
+		expected float64
+	}{
+		{0, 1},
+		{5 * time.Minute, 11},
+		{10 * time.Minute, 21},
+	} {
+		if rate := w.rate(now.Add(test.elapsed)); rate != test.expected {
+			t.Errorf("expected a rate of %g after %s, got %g", test.expected, test.elapsed, rate)
+		}
+	}
+
+	// The nodes that were good are crawled first during the warm-up.
+	m := newTestManager(t, &dagconfig.MainnetParams, 0)
+	stale := now.AddDate(-1, 0, 0)
+	for i := 1; i <= defaultMaxAddresses; i++ {
+		ip := net.IPv4(203, 0, 113, byte(i))
+		m.nodes[ip.String()] = &Node{Addr: appmessage.NewNetAddressIPPort(ip, 1313)}
+	}
+	for _, ip := range []string{"198.51.100.1", "198.51.100.2"} {
+		m.nodes[ip] = &Node{Addr: appmessage.NewNetAddressIPPort(net.ParseIP(ip), 1313), LastSuccess: stale}
+	}
+	m.nodes["198.51.100.2"].Demoted = true
+
+	warmup = newCrawlWarmup(now, time.Hour, 20)
+	defer func() { warmup = nil }()
+	for round := 0; round < 10; round++ {
+		addrs := m.Addresses()
+		if len(addrs) != defaultMaxAddresses || !addrs[0].IP.Equal(net.ParseIP("198.51.100.1")) {
+			t.Fatalf("expected the formerly good node first in a full round, got %v", addrs)
+		}
+	}
+}